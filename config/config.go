@@ -0,0 +1,244 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"Go-GoSAFE.converter/crs"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds all settings of the converter.
+// They are read from an optional YAML file first and then from GOSAFE_* environment variables,
+// so the same binary can be deployed against different databases.
+type Config struct {
+	// Store is the graph storage backend, neo4j or memory
+	Store string `yaml:"store"`
+	// DB is the Neo4j REST endpoint, credentials are kept separately from the URL
+	DB struct {
+		URL      string `yaml:"url"`
+		User     string `yaml:"user"`
+		Password string `yaml:"password"`
+	} `yaml:"db"`
+	// Listen is the address the API listens on
+	Listen string `yaml:"listen"`
+	// TLS certificate and key, the API is served over HTTPS when both are set
+	TLS struct {
+		Cert string `yaml:"cert"`
+		Key  string `yaml:"key"`
+	} `yaml:"tls"`
+	// DefaultEpsg is used when neither the RailML file nor the import request say which CRS the coordinates are in
+	DefaultEpsg string `yaml:"default_epsg"`
+	// MaxUploadSize is the largest accepted request body in bytes
+	MaxUploadSize int64 `yaml:"max_upload_size"`
+	// LogLevel is one of debug, info, warn, error
+	LogLevel string `yaml:"log_level"`
+	// ImportWorkers is the number of asynchronous imports that run at the same time
+	ImportWorkers int `yaml:"import_workers"`
+	// JobDir keeps the state of asynchronous imports, so that they survive a restart, and their uploaded files until they are imported
+	JobDir string `yaml:"job_dir"`
+	// JobRetention is how long finished asynchronous imports are kept, e.g. 168h
+	JobRetention time.Duration `yaml:"job_retention"`
+	// LengthTolerance is how far in metres the pos of a track end may be from the geodesic length of the track
+	LengthTolerance float64 `yaml:"length_tolerance"`
+	// SchemaDir holds the railML.org XSDs files are validated against, a directory per version, e.g. 2.2/railML.xsd.
+	// Without it files can't be validated.
+	SchemaDir string `yaml:"schema_dir"`
+}
+
+var settings = Default()
+
+// Default returns the settings used when nothing else is configured.
+func Default() *Config {
+	c := &Config{
+		Store:           "neo4j",
+		Listen:          ":6060",
+		MaxUploadSize:   512 << 20, // 512 MB
+		LogLevel:        "info",
+		ImportWorkers:   2,
+		JobDir:          filepath.Join(os.TempDir(), "gosafe-jobs"),
+		JobRetention:    7 * 24 * time.Hour,
+		LengthTolerance: 10,
+	}
+	c.DB.URL = "http://localhost:7474/db/data"
+	return c
+}
+
+// Load reads the configuration. Defaults are overridden by the YAML file at path, if path isn't empty,
+// and then by environment variables. If path is empty, GOSAFE_CONFIG is used as the file path.
+// The loaded configuration is validated and becomes the one returned by Get.
+func Load(path string) error {
+	c := Default()
+
+	if path == "" {
+		path = os.Getenv("GOSAFE_CONFIG")
+	}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := yaml.UnmarshalStrict(data, c); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	if err := c.readEnv(); err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	settings = c
+	return nil
+}
+
+// Get returns the current configuration.
+func Get() *Config {
+	return settings
+}
+
+// readEnv overrides settings with the environment variables that are set
+func (c *Config) readEnv() error {
+	vars := map[string]*string{
+		"GOSAFE_STORE":        &c.Store,
+		"GOSAFE_DB_URL":       &c.DB.URL,
+		"GOSAFE_DB_USER":      &c.DB.User,
+		"GOSAFE_DB_PASSWORD":  &c.DB.Password,
+		"GOSAFE_LISTEN":       &c.Listen,
+		"GOSAFE_TLS_CERT":     &c.TLS.Cert,
+		"GOSAFE_TLS_KEY":      &c.TLS.Key,
+		"GOSAFE_DEFAULT_EPSG": &c.DefaultEpsg,
+		"GOSAFE_LOG_LEVEL":    &c.LogLevel,
+		"GOSAFE_JOB_DIR":      &c.JobDir,
+		"GOSAFE_SCHEMA_DIR":   &c.SchemaDir,
+	}
+	for k, v := range vars {
+		if e, ok := os.LookupEnv(k); ok {
+			*v = e
+		}
+	}
+
+	if e, ok := os.LookupEnv("GOSAFE_MAX_UPLOAD_SIZE"); ok {
+		size, err := strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			return fmt.Errorf("GOSAFE_MAX_UPLOAD_SIZE must be a number of bytes, got '%s'", e)
+		}
+		c.MaxUploadSize = size
+	}
+	if e, ok := os.LookupEnv("GOSAFE_IMPORT_WORKERS"); ok {
+		n, err := strconv.Atoi(e)
+		if err != nil {
+			return fmt.Errorf("GOSAFE_IMPORT_WORKERS must be a number, got '%s'", e)
+		}
+		c.ImportWorkers = n
+	}
+	if e, ok := os.LookupEnv("GOSAFE_JOB_RETENTION"); ok {
+		d, err := time.ParseDuration(e)
+		if err != nil {
+			return fmt.Errorf("GOSAFE_JOB_RETENTION must be a duration, e.g. 168h, got '%s'", e)
+		}
+		c.JobRetention = d
+	}
+	if e, ok := os.LookupEnv("GOSAFE_LENGTH_TOLERANCE"); ok {
+		t, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			return fmt.Errorf("GOSAFE_LENGTH_TOLERANCE must be a number of metres, got '%s'", e)
+		}
+		c.LengthTolerance = t
+	}
+	return nil
+}
+
+// Validate checks that all settings make sense together.
+func (c *Config) Validate() error {
+	switch c.Store {
+	case "neo4j":
+		u, err := url.Parse(c.DB.URL)
+		if err != nil {
+			return fmt.Errorf("invalid db url: %v", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("db url must be http or https, got '%s'", c.DB.URL)
+		}
+		if u.User != nil {
+			return fmt.Errorf("db url must not contain credentials, set the db user and password instead")
+		}
+	case "memory":
+	default:
+		return fmt.Errorf("store must be neo4j or memory, got '%s'", c.Store)
+	}
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("invalid listen address: %v", err)
+	}
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		return fmt.Errorf("tls needs both a cert and a key")
+	}
+	for _, f := range []string{c.TLS.Cert, c.TLS.Key} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+	}
+
+	if c.DefaultEpsg != "" {
+		if _, err := crs.Lookup(c.DefaultEpsg); err != nil {
+			return fmt.Errorf("default epsg: %v", err)
+		}
+	}
+
+	if c.MaxUploadSize <= 0 {
+		return fmt.Errorf("max upload size must be positive, got %d", c.MaxUploadSize)
+	}
+
+	if c.ImportWorkers <= 0 {
+		return fmt.Errorf("import workers must be positive, got %d", c.ImportWorkers)
+	}
+	if c.JobDir == "" {
+		return fmt.Errorf("job dir must not be empty")
+	}
+	if c.JobRetention <= 0 {
+		return fmt.Errorf("job retention must be positive, got %s", c.JobRetention)
+	}
+	if c.LengthTolerance < 0 || math.IsNaN(c.LengthTolerance) {
+		return fmt.Errorf("length tolerance must not be negative, got %v", c.LengthTolerance)
+	}
+	if c.SchemaDir != "" {
+		fi, err := os.Stat(c.SchemaDir)
+		if err != nil {
+			return fmt.Errorf("schema dir: %v", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("schema dir %s isn't a directory", c.SchemaDir)
+		}
+	}
+
+	if _, ok := levels[c.LogLevel]; !ok {
+		return fmt.Errorf("log level must be one of debug, info, warn, error, got '%s'", c.LogLevel)
+	}
+
+	return nil
+}
+
+// DBURL returns the database URL together with the credentials.
+func (c *Config) DBURL() string {
+	u, err := url.Parse(c.DB.URL)
+	if err != nil || c.DB.User == "" {
+		return c.DB.URL
+	}
+	u.User = url.UserPassword(c.DB.User, c.DB.Password)
+	return u.String()
+}
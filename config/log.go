@@ -0,0 +1,36 @@
+package config
+
+import (
+	"log"
+)
+
+// log levels, from the most verbose
+var levels = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+}
+
+// Enabled reports whether messages of the given level are logged with the configured log level.
+func Enabled(level string) bool {
+	return levels[level] >= levels[settings.LogLevel]
+}
+
+func logf(level string, format string, v ...interface{}) {
+	if Enabled(level) {
+		log.Printf("["+level+"] "+format, v...)
+	}
+}
+
+// Debugf logs a debug message
+func Debugf(format string, v ...interface{}) { logf("debug", format, v...) }
+
+// Infof logs an informational message
+func Infof(format string, v ...interface{}) { logf("info", format, v...) }
+
+// Warnf logs a warning
+func Warnf(format string, v ...interface{}) { logf("warn", format, v...) }
+
+// Errorf logs an error
+func Errorf(format string, v ...interface{}) { logf("error", format, v...) }
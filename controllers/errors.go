@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"Go-GoSAFE.converter/graph"
+	"Go-GoSAFE.converter/schema"
+	"Go-GoSAFE.converter/store"
+	"Go-GoSAFE.converter/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// problem is a JSON problem response as described in RFC 7807,
+// extended with the RailML element (and its line) that caused the failure.
+type problem struct {
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Status  int    `json:"status"`
+	Detail  string `json:"detail"`
+	Element string `json:"element,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Line    int    `json:"line,omitempty"`
+
+	Violations []schema.Violation `json:"violations,omitempty"` // of the railML schema
+}
+
+// badRequestError is returned for missing or malformed request parameters
+type badRequestError struct {
+	msg string
+}
+
+func (e *badRequestError) Error() string {
+	return e.msg
+}
+
+// tooLargeError is returned when the request body exceeds the configured maximum upload size
+type tooLargeError struct {
+	max int64
+}
+
+func (e *tooLargeError) Error() string {
+	return fmt.Sprintf("the request body is larger than %d bytes", e.max)
+}
+
+// invalidRailmlError is returned when the uploaded file isn't well-formed XML
+type invalidRailmlError struct {
+	err  error
+	line int
+}
+
+func (e *invalidRailmlError) Error() string {
+	return "invalid RailML: " + e.err.Error()
+}
+
+// newInvalidRailmlError wraps an XML parser error, keeping the line it occurred at
+func newInvalidRailmlError(err error) error {
+	ie := &invalidRailmlError{err: err}
+	if se, ok := err.(*xml.SyntaxError); ok {
+		ie.line = se.Line
+	}
+	return ie
+}
+
+// schemaViolationError is returned when the uploaded file violates the railML schema and is validated strictly
+type schemaViolationError struct {
+	violations []schema.Violation
+}
+
+func (e *schemaViolationError) Error() string {
+	if len(e.violations) == 1 {
+		return "the RailML file violates the railML schema in 1 place"
+	}
+	return fmt.Sprintf("the RailML file violates the railML schema in %d places", len(e.violations))
+}
+
+// abortWithError maps err to its HTTP status and responds with a problem
+func abortWithError(c *gin.Context, err error) {
+	p := problem{Type: "about:blank", Status: http.StatusInternalServerError, Detail: err.Error()}
+
+	switch e := err.(type) {
+	case *badRequestError:
+		p.Status = http.StatusBadRequest
+	case *tooLargeError:
+		p.Status = http.StatusRequestEntityTooLarge
+	case *invalidRailmlError:
+		p.Status = http.StatusUnprocessableEntity
+		p.Line = e.line
+	case *schemaViolationError:
+		p.Status = http.StatusUnprocessableEntity
+		p.Line = e.violations[0].Line
+		p.Violations = e.violations
+	case *schema.UnsupportedVersionError:
+		p.Status = http.StatusUnprocessableEntity
+	case *schema.NoSchemasError:
+		p.Status = http.StatusNotImplemented
+	case *utils.ElementError:
+		p.Status = http.StatusUnprocessableEntity
+		p.Detail = e.Err.Error()
+		p.Element = e.Path
+		p.ID = e.ID
+		p.Line = e.Line
+	case *graph.RouteError:
+		p.Status = http.StatusUnprocessableEntity
+	case *graph.GeometryError:
+		p.Status = http.StatusUnprocessableEntity
+	case *utils.CRSError:
+		p.Status = http.StatusBadRequest
+	case *store.NotFoundError:
+		p.Status = http.StatusNotFound
+	case *store.ConflictError:
+		p.Status = http.StatusConflict
+	case *store.UnavailableError:
+		p.Status = http.StatusServiceUnavailable
+	}
+	p.Title = http.StatusText(p.Status)
+
+	body, _ := json.Marshal(p)
+	c.Data(p.Status, "application/problem+json", body)
+	c.Abort()
+}
+
+// LimitUploadSize rejects request bodies larger than max bytes. Bodies of unknown length are cut off at max bytes,
+// handlers read them with readForm to notice.
+func LimitUploadSize(max int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > max {
+			abortWithError(c, &tooLargeError{max})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max) // for bodies of unknown length
+		c.Next()
+	}
+}
+
+// readForm reads the form of the request before any of its fields are looked at, which would take a form that
+// can't be read as an empty one. A body larger than LimitUploadSize allows fails with a tooLargeError.
+func readForm(c *gin.Context) error {
+	err := c.Request.ParseForm() // for url-encoded forms, ParseMultipartForm only tells that it isn't multipart
+	if err == nil {
+		if _, err = c.MultipartForm(); err == http.ErrNotMultipart {
+			err = nil
+		}
+	}
+	var tl *http.MaxBytesError
+	if errors.As(err, &tl) {
+		return &tooLargeError{tl.Limit}
+	}
+	if err != nil {
+		return &badRequestError{"cannot read the form: " + err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Go-GoSAFE.converter/graph"
+	"Go-GoSAFE.converter/schema"
+	"Go-GoSAFE.converter/store"
+	"Go-GoSAFE.converter/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestAbortWithError(t *testing.T) {
+	violations := []schema.Violation{{Line: 7, Column: 3, Element: "/railml/foo", Detail: "element foo isn't allowed here"}}
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		want   problem // the fields besides status, title and type
+	}{
+		{"bad request", &badRequestError{"missing line name"}, http.StatusBadRequest,
+			problem{Detail: "missing line name"}},
+		{"too large", &tooLargeError{1024}, http.StatusRequestEntityTooLarge,
+			problem{Detail: "the request body is larger than 1024 bytes"}},
+		{"invalid railml", newInvalidRailmlError(&xml.SyntaxError{Msg: "unexpected EOF", Line: 12}), http.StatusUnprocessableEntity,
+			problem{Detail: "invalid RailML: XML syntax error on line 12: unexpected EOF", Line: 12}},
+		{"schema violation", &schemaViolationError{violations}, http.StatusUnprocessableEntity,
+			problem{Detail: "the RailML file violates the railML schema in 1 place", Line: 7, Violations: violations}},
+		{"unsupported version", &schema.UnsupportedVersionError{Version: "1.0"}, http.StatusUnprocessableEntity,
+			problem{Detail: (&schema.UnsupportedVersionError{Version: "1.0"}).Error()}},
+		{"no schemas", &schema.NoSchemasError{}, http.StatusNotImplemented, problem{Detail: (&schema.NoSchemasError{}).Error()}},
+		{"element", &utils.ElementError{Path: "/railml/infrastructure/tracks/track", ID: "tr1", Line: 3, Err: errors.New("missing trackBegin")},
+			http.StatusUnprocessableEntity,
+			problem{Detail: "missing trackBegin", Element: "/railml/infrastructure/tracks/track", ID: "tr1", Line: 3}},
+		{"route", &graph.RouteError{Msg: "no route from a to b"}, http.StatusUnprocessableEntity,
+			problem{Detail: "no route from a to b"}},
+		{"geometry", &graph.GeometryError{Msg: "the track has no geometry"}, http.StatusUnprocessableEntity,
+			problem{Detail: "the track has no geometry"}},
+		{"crs", &utils.CRSError{Epsg: "9999", Err: errors.New("unknown")}, http.StatusBadRequest,
+			problem{Detail: "cannot transform coordinates to or from epsg:9999: unknown"}},
+		{"not found", &store.NotFoundError{Label: "Line", ID: "L1"}, http.StatusNotFound,
+			problem{Detail: "Line 'L1' not found"}},
+		{"conflict", &store.ConflictError{Msg: "line L1 exists"}, http.StatusConflict,
+			problem{Detail: "line L1 exists"}},
+		{"unavailable", &store.UnavailableError{Err: errors.New("connection refused")}, http.StatusServiceUnavailable,
+			problem{Detail: "graph database unavailable: connection refused"}},
+		{"anything else", errors.New("boom"), http.StatusInternalServerError,
+			problem{Detail: "boom"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		abortWithError(c, tt.err)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: content type %s", tt.name, ct)
+		}
+		if !c.IsAborted() {
+			t.Errorf("%s: the context isn't aborted", tt.name)
+		}
+		var got problem
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := tt.want
+		want.Type, want.Status, want.Title = "about:blank", tt.status, http.StatusText(tt.status)
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want)
+		if !bytes.Equal(gotJSON, wantJSON) {
+			t.Errorf("%s: the problem is\n%s\nwant\n%s", tt.name, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestLimitUploadSize(t *testing.T) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	f, _ := w.CreateFormFile("file", "line.xml")
+	f.Write([]byte(`<railml xmlns="http://www.railml.org/schemas/2013">` + strings.Repeat(" ", 4096) + `</railml>`))
+	w.WriteField("line", "L1")
+	w.Close()
+
+	r := gin.New()
+	r.Use(LimitUploadSize(1024))
+	r.POST("/import", ImportRailml)
+	r.POST("/validate", ValidateRailml)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   []byte
+		length int64 // -1 for a chunked body
+		status int
+	}{
+		{"import with its length", "/import", body.Bytes(), int64(body.Len()), http.StatusRequestEntityTooLarge},
+		{"import chunked", "/import", body.Bytes(), -1, http.StatusRequestEntityTooLarge},
+		{"validate with its length", "/validate", body.Bytes(), int64(body.Len()), http.StatusRequestEntityTooLarge},
+		{"validate chunked", "/validate", body.Bytes(), -1, http.StatusRequestEntityTooLarge},
+		{"validate without a file", "/validate", nil, -1, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(tt.body))
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		req.ContentLength = tt.length
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
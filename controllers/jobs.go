@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"Go-GoSAFE.converter/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+/**
+* @api {GET} /api/v1/jobs/:id
+* @apiDescription Tells how far an asynchronous import is
+* @apiGroup Jobs
+* @apiName GetJob
+* @apiParam {string} id A job id, as returned by POST /api/v1/import/railml?async=true
+* @apiSuccess (200) {json} job The state (queued, running, done, failed or cancelled), the tracks converted so far
+* out of the tracks in the file, the elapsed seconds and the errors that made the job fail
+* @apiError (404) {json} problem There is no such job, or it finished longer than the job retention ago
+ */
+func GetJob(c *gin.Context) {
+	job, err := jobs.Get(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"job": job,
+	})
+}
+
+/**
+* @api {DELETE} /api/v1/jobs/:id
+* @apiDescription Cancels an asynchronous import. A queued job is cancelled right away,
+* a running one as soon as it notices, before anything is written
+* @apiGroup Jobs
+* @apiName CancelJob
+* @apiParam {string} id A job id
+* @apiSuccess (200) {json} job The cancelled job
+* @apiSuccess (202) {json} job The running job, that is being cancelled
+* @apiError (404) {json} problem There is no such job
+* @apiError (409) {json} problem The job is already finished
+ */
+func CancelJob(c *gin.Context) {
+	job, err := jobs.Cancel(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	status := 200
+	if job.State == jobs.Running {
+		status = 202
+	}
+	c.JSON(status, gin.H{
+		"job": job,
+	})
+}
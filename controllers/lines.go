@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"Go-GoSAFE.converter/config"
+	"Go-GoSAFE.converter/export"
+	"Go-GoSAFE.converter/graph"
+	"Go-GoSAFE.converter/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+/**
+* @api {GET} /api/v1/lines
+* @apiDescription Lists all imported lines
+* @apiGroup Lines
+* @apiName ListLines
+* @apiSuccess (200) {json} lines Every line with its id, import metadata and number of tracks
+* @apiError (503) {json} problem The graph database can't be reached
+ */
+func ListLines(c *gin.Context) {
+	summaries, err := graph.SummarizeAll(config.GetStore())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"lines": summaries,
+	})
+}
+
+/**
+* @api {GET} /api/v1/lines/:id
+* @apiDescription Describes a single line
+* @apiGroup Lines
+* @apiName GetLine
+* @apiParam {string} id A line name
+* @apiSuccess (200) {json} line The line with its import metadata and the number of tracks and other elements by their kind
+* @apiError (404) {json} problem There is no such line
+* @apiError (503) {json} problem The graph database can't be reached
+ */
+func GetLine(c *gin.Context) {
+	s := config.GetStore()
+
+	ln, err := findLine(s, c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	ls, err := graph.Summarize(s, ln)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"line": ls,
+	})
+}
+
+/**
+* @api {GET} /api/v1/lines/:id/geojson
+* @apiDescription Exports a line as a GeoJSON FeatureCollection in wgs84: tracks are LineStrings, switches, crossings,
+* track and OCS elements, track begins and ends and ocps are Points with all their RailML attributes as properties
+* @apiGroup Lines
+* @apiName GetLineGeoJSON
+* @apiParam {string} id A line name
+* @apiParam {string} [label] Only nodes with the given labels, e.g. Track,Signal. Can be repeated
+* @apiParam {string} [bbox] Only features that intersect the box minLon,minLat,maxLon,maxLat
+* @apiSuccess (200) {json} FeatureCollection The features of the line
+* @apiError (400) {json} problem Malformed bbox
+* @apiError (404) {json} problem There is no such line
+* @apiError (503) {json} problem The graph database can't be reached
+ */
+func GetLineGeoJSON(c *gin.Context) {
+	f := export.GeoJSONFilter{}
+	for _, l := range c.QueryArray("label") {
+		for _, lb := range strings.Split(l, ",") {
+			if lb = strings.TrimSpace(lb); lb != "" {
+				f.Labels = append(f.Labels, lb)
+			}
+		}
+	}
+	if bbox := c.Query("bbox"); bbox != "" {
+		b, err := parseBBox(bbox)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		f.BBox = b
+	}
+
+	fc, err := export.ExportGeoJSON(config.GetStore(), c.Param("id"), f)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	body, err := json.Marshal(fc)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Data(200, "application/geo+json", body)
+}
+
+// parseBBox parses a minLon,minLat,maxLon,maxLat box
+func parseBBox(bbox string) (*[4]float64, error) {
+	s := strings.Split(bbox, ",")
+	if len(s) != 4 {
+		return nil, &badRequestError{"bbox must be minLon,minLat,maxLon,maxLat, got '" + bbox + "'"}
+	}
+	b := [4]float64{}
+	for i := range s {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s[i]), 64)
+		if err != nil {
+			return nil, &badRequestError{"bbox must be minLon,minLat,maxLon,maxLat, got '" + bbox + "'"}
+		}
+		b[i] = v
+	}
+	if b[0] > b[2] || b[1] > b[3] {
+		return nil, &badRequestError{"bbox minimum must not be greater than its maximum, got '" + bbox + "'"}
+	}
+	return &b, nil
+}
+
+/**
+* @api {GET} /api/v1/lines/:id/route
+* @apiDescription Finds the shortest route between two elements of a line on its topology layer. The route leaves and
+* reaches elements with a dir of up or down in their running direction and passes switches from their stem only
+* @apiGroup Lines
+* @apiName GetLineRoute
+* @apiParam {string} id A line name
+* @apiParam {string} from The id of the element to start at, e.g. a signal, a buffer stop, a switch or a trackBegin
+* @apiParam {string} to The id of the element to end at
+* @apiSuccess (200) {json} route The length of the route in metres, the tracks it runs on with the pos it runs from and to,
+* the switches it passes with the legs they are entered and left by and the elements it passes, in order
+* @apiError (400) {json} problem Missing from or to
+* @apiError (404) {json} problem There is no such line or element
+* @apiError (422) {json} problem An element has no pos or there is no route between the elements
+* @apiError (503) {json} problem The graph database can't be reached
+ */
+func GetLineRoute(c *gin.Context) {
+	from, to := c.Query("from"), c.Query("to")
+	if from == "" || to == "" {
+		abortWithError(c, &badRequestError{"from and to are required"})
+		return
+	}
+
+	s := config.GetStore()
+	ln, err := findLine(s, c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	r, err := graph.FindRoute(s, ln, from, to)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"route": r,
+	})
+}
+
+/**
+* @api {GET} /api/v1/lines/:id/locate
+* @apiDescription Snaps a point onto the nearest track of a line and tells its pos there. Positions are measured along
+* the geometry of the track, from the pos of its begin at the first point to the pos of its end at the last one
+* @apiGroup Lines
+* @apiName LocateOnLine
+* @apiParam {string} id A line name
+* @apiParam {string} coord The point as wgs84 lon,lat
+* @apiParam {string} [track] Snaps the point onto this track instead of the nearest one
+* @apiSuccess (200) {json} location The track, the pos, the point of the track at the pos and its distance from the given point in metres
+* @apiError (400) {json} problem Missing or malformed coord
+* @apiError (404) {json} problem There is no such line or track
+* @apiError (422) {json} problem No track of the line has a geometry and a pos at its begin and end
+* @apiError (503) {json} problem The graph database can't be reached
+ */
+func LocateOnLine(c *gin.Context) {
+	coord := c.Query("coord")
+	p := strings.Split(coord, ",")
+	if len(p) != 2 {
+		abortWithError(c, &badRequestError{"coord must be lon,lat, got '" + coord + "'"})
+		return
+	}
+	lonLat := [2]float64{}
+	for i := range p {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p[i]), 64)
+		if err != nil || (i == 0 && math.Abs(v) > 180) || (i == 1 && math.Abs(v) > 90) {
+			abortWithError(c, &badRequestError{"coord must be lon,lat, got '" + coord + "'"})
+			return
+		}
+		lonLat[i] = v
+	}
+
+	s := config.GetStore()
+	ln, err := findLine(s, c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	l, err := graph.Locate(s, ln, lonLat[0], lonLat[1], c.Query("track"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"location": l,
+	})
+}
+
+/**
+* @api {GET} /api/v1/lines/:id/diagnostics
+* @apiDescription Checks the consistency of a line: connections that refer to connections that don't exist or don't refer
+* back, tracks without a trackBegin or trackEnd, ids used more than once, elements whose pos is beyond their track and tracks
+* that aren't connected to the rest of the line, tracks whose geodesic length differs from the pos of their begin and end and
+* track ends and mileage changes whose absPos doesn't fit the mileage of their track. The same check runs when a line is imported
+* @apiGroup Lines
+* @apiName GetLineDiagnostics
+* @apiParam {string} id A line name
+* @apiParam {number} [tolerance] Metres a length or absPos may be off, defaults to the configured length_tolerance
+* @apiSuccess (200) {json} diagnostics The findings, each with its severity (error or warning), kind, element, id, track and
+* detail, the errors first
+* @apiError (400) {json} problem The tolerance is not a number or negative
+* @apiError (404) {json} problem There is no such line
+* @apiError (503) {json} problem The graph database can't be reached
+ */
+func GetLineDiagnostics(c *gin.Context) {
+	s := config.GetStore()
+
+	tolerance := config.Get().LengthTolerance
+	if t := c.Query("tolerance"); t != "" {
+		var err error
+		if tolerance, err = strconv.ParseFloat(t, 64); err != nil || tolerance < 0 || math.IsNaN(tolerance) {
+			abortWithError(c, &badRequestError{"tolerance must be a number of metres, got '" + t + "'"})
+			return
+		}
+	}
+
+	ln, err := findLine(s, c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	findings, err := graph.Diagnose(s, ln, tolerance)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"diagnostics": findings,
+	})
+}
+
+// logFindings tells how many problems the check of an imported line found
+func logFindings(lineId string, findings []graph.Finding) {
+	errs := 0
+	for _, f := range findings {
+		if f.Severity == graph.SeverityError {
+			errs++
+		}
+	}
+	if len(findings) > 0 {
+		config.Warnf("line '%s' has %d errors and %d warnings, see its diagnostics", lineId, errs, len(findings)-errs)
+	}
+}
+
+/**
+* @api {DELETE} /api/v1/lines/:id
+* @apiDescription Deletes a line together with its tracks, their elements and its infrastructure attributes
+* @apiGroup Lines
+* @apiName DeleteLine
+* @apiParam {string} id A line name
+* @apiSuccess (200) {json} object Response message with the number of deleted nodes
+* @apiError (404) {json} problem There is no such line
+* @apiError (503) {json} problem The graph database can't be reached
+ */
+func DeleteLine(c *gin.Context) {
+	s := config.GetStore()
+
+	lineId := c.Param("id")
+	lines, err := s.FindNodes("Line", store.Props{"id": lineId})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if len(lines) == 0 {
+		abortWithError(c, &store.NotFoundError{Label: "Line", ID: lineId})
+		return
+	}
+
+	deleted := 0
+	for _, ln := range lines {
+		n, err := s.DeleteSubgraph(ln.ID, graph.Owns...)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		deleted += n
+		graph.ForgetTopology(s, ln.ID)
+	}
+
+	c.JSON(200, gin.H{
+		"response": gin.H{"status": "ok", "deleted nodes": deleted},
+	})
+}
+
+// findLine returns the line with the given id
+func findLine(s store.GraphStore, lineId string) (store.Node, error) {
+	lines, err := s.FindNodes("Line", store.Props{"id": lineId})
+	if err != nil {
+		return store.Node{}, err
+	}
+	if len(lines) == 0 {
+		return store.Node{}, &store.NotFoundError{Label: "Line", ID: lineId}
+	}
+	return lines[0], nil
+}
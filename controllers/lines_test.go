@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Go-GoSAFE.converter/config"
+	"Go-GoSAFE.converter/graph"
+
+	"github.com/gin-gonic/gin"
+)
+
+// twoTracks is a line with two tracks, a signal on the first one
+const twoTracks = `<railml><infrastructure id="inf1"><tracks>
+	<track id="tr1"><trackTopology><trackBegin id="tb1" pos="0"><openEnd id="oe1"/></trackBegin>
+		<trackEnd id="te1" pos="100"><openEnd id="oe2"/></trackEnd></trackTopology>
+		<ocsElements><signals><signal id="s1" pos="20"/></signals></ocsElements></track>
+	<track id="tr2"><trackTopology><trackBegin id="tb2" pos="0"><openEnd id="oe3"/></trackBegin>
+		<trackEnd id="te2" pos="50"><openEnd id="oe4"/></trackEnd></trackTopology></track>
+	</tracks></infrastructure></railml>`
+
+// oneTrack is a line with a single track
+const oneTrack = `<railml><infrastructure id="inf2"><tracks>
+	<track id="tr1"><trackTopology><trackBegin id="tb1" pos="0"><openEnd id="oe1"/></trackBegin>
+		<trackEnd id="te1" pos="100"><openEnd id="oe2"/></trackEnd></trackTopology></track>
+	</tracks></infrastructure></railml>`
+
+// storeLines makes the converter use a new memory store with the given lines, by their id
+func storeLines(t *testing.T, lines map[string]string) {
+	config.CreateMemoryStore()
+	g := graph.GraphUtils{}
+	for id, file := range lines {
+		o := graph.ImportOptions{Line: id, Epsg: "4326", Mode: "fail", Workers: 1}
+		if _, err := g.ImportLine(context.Background(), config.GetStore(), strings.NewReader(file), o); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serve sends a request to the routes of the lines and decodes the JSON it is answered with into v
+func serve(t *testing.T, method string, path string, v interface{}) int {
+	r := gin.New()
+	r.GET("/lines", ListLines)
+	r.GET("/lines/:id", GetLine)
+	r.DELETE("/lines/:id", DeleteLine)
+	r.GET("/lines/:id/diagnostics", GetLineDiagnostics)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s %s: %v in %s", method, path, err, w.Body)
+	}
+	return w.Code
+}
+
+func TestListLines(t *testing.T) {
+	storeLines(t, nil)
+	var empty struct{ Lines []graph.LineSummary }
+	if status := serve(t, "GET", "/lines", &empty); status != http.StatusOK || empty.Lines == nil || len(empty.Lines) != 0 {
+		t.Errorf("an empty store lists %d %v, want 200 and no lines", status, empty.Lines)
+	}
+
+	storeLines(t, map[string]string{"L1": twoTracks, "L2": oneTrack})
+	var list struct{ Lines []graph.LineSummary }
+	if status := serve(t, "GET", "/lines", &list); status != http.StatusOK {
+		t.Fatalf("lists with %d", status)
+	}
+	tracks := map[string]int{}
+	for _, ls := range list.Lines {
+		tracks[ls.ID] = ls.Tracks
+		if ls.Elements != nil {
+			t.Errorf("the list counts the elements of %s: %v", ls.ID, ls.Elements)
+		}
+		if ls.Metadata["epsg"] != "4326" {
+			t.Errorf("%s is listed with the metadata %v", ls.ID, ls.Metadata)
+		}
+	}
+	if len(tracks) != 2 || tracks["L1"] != 2 || tracks["L2"] != 1 {
+		t.Errorf("the lines are listed with the tracks %v, want 2 of L1 and 1 of L2", tracks)
+	}
+}
+
+func TestGetLine(t *testing.T) {
+	storeLines(t, map[string]string{"L1": twoTracks, "L2": oneTrack})
+
+	var got struct{ Line graph.LineSummary }
+	if status := serve(t, "GET", "/lines/L1", &got); status != http.StatusOK {
+		t.Fatalf("gets L1 with %d", status)
+	}
+	if got.Line.ID != "L1" || got.Line.Tracks != 2 || got.Line.Elements["Track"] != 2 || got.Line.Elements["Signal"] != 1 ||
+		got.Line.Elements["OpenEnd"] != 4 {
+		t.Errorf("L1 is %+v", got.Line)
+	}
+
+	var p problem
+	if status := serve(t, "GET", "/lines/L9", &p); status != http.StatusNotFound || p.Status != http.StatusNotFound {
+		t.Errorf("a missing line is answered with %d %+v", status, p)
+	}
+}
+
+func TestDeleteLine(t *testing.T) {
+	storeLines(t, map[string]string{"L1": twoTracks, "L2": oneTrack})
+
+	var got struct {
+		Response struct {
+			Status  string
+			Deleted int `json:"deleted nodes"`
+		}
+	}
+	if status := serve(t, "DELETE", "/lines/L1", &got); status != http.StatusOK || got.Response.Status != "ok" || got.Response.Deleted == 0 {
+		t.Fatalf("deletes L1 with %d %+v", status, got)
+	}
+
+	var p problem
+	if status := serve(t, "GET", "/lines/L1", &p); status != http.StatusNotFound {
+		t.Errorf("the deleted line is answered with %d", status)
+	}
+	var list struct{ Lines []graph.LineSummary }
+	serve(t, "GET", "/lines", &list)
+	if len(list.Lines) != 1 || list.Lines[0].ID != "L2" || list.Lines[0].Tracks != 1 {
+		t.Errorf("after deleting L1 the lines are %+v, want L2 with its track", list.Lines)
+	}
+	// the tracks of L2 have the same ids as those of L1, they mustn't be deleted with it
+	var l2 struct{ Line graph.LineSummary }
+	serve(t, "GET", "/lines/L2", &l2)
+	if l2.Line.Elements["Track"] != 1 || l2.Line.Elements["OpenEnd"] != 2 {
+		t.Errorf("after deleting L1, L2 is %+v", l2.Line)
+	}
+
+	if status := serve(t, "DELETE", "/lines/L1", &p); status != http.StatusNotFound {
+		t.Errorf("deleting a missing line is answered with %d", status)
+	}
+}
+
+func TestGetLineDiagnostics(t *testing.T) {
+	// the absPos of the end of L2 is 5 m off its mileage
+	mileage := strings.NewReplacer(`id="tb1" pos="0"`, `id="tb1" pos="0" absPos="0" absDir="up"`,
+		`id="te1" pos="100"`, `id="te1" pos="100" absPos="105"`).Replace(oneTrack)
+	storeLines(t, map[string]string{"L1": twoTracks, "L2": mileage})
+
+	var got struct{ Diagnostics []graph.Finding }
+	if status := serve(t, "GET", "/lines/L1/diagnostics", &got); status != http.StatusOK {
+		t.Fatalf("gets the diagnostics of L1 with %d", status)
+	}
+	if len(got.Diagnostics) != 1 || got.Diagnostics[0].Kind != "isolatedNetwork" || got.Diagnostics[0].ID != "tr2" ||
+		got.Diagnostics[0].Severity != graph.SeverityWarning {
+		t.Errorf("the diagnostics of L1 are %+v, want tr2 isolated", got.Diagnostics)
+	}
+
+	tests := []struct {
+		tolerance string
+		findings  int
+	}{
+		{"", 0}, // length_tolerance, 10 m
+		{"5", 0},
+		{"4.5", 1},
+		{"0", 1},
+	}
+	for _, tt := range tests {
+		var got struct{ Diagnostics []graph.Finding }
+		status := serve(t, "GET", "/lines/L2/diagnostics?tolerance="+tt.tolerance, &got)
+		if status != http.StatusOK || len(got.Diagnostics) != tt.findings {
+			t.Errorf("a tolerance of '%s' is answered with %d %+v, want %d findings", tt.tolerance, status, got.Diagnostics, tt.findings)
+		}
+		for _, f := range got.Diagnostics {
+			if f.Kind != "absPosMismatch" || f.ID != "te1" {
+				t.Errorf("a tolerance of '%s' finds %+v, want the absPos of te1", tt.tolerance, f)
+			}
+		}
+	}
+
+	for _, tolerance := range []string{"lots", "-1", "NaN"} {
+		var p problem
+		if status := serve(t, "GET", "/lines/L2/diagnostics?tolerance="+tolerance, &p); status != http.StatusBadRequest || p.Status != http.StatusBadRequest {
+			t.Errorf("a tolerance of '%s' is answered with %d %+v, want 400", tolerance, status, p)
+		}
+	}
+	var p problem
+	if status := serve(t, "GET", "/lines/L9/diagnostics", &p); status != http.StatusNotFound {
+		t.Errorf("the diagnostics of a missing line are answered with %d", status)
+	}
+}
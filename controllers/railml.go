@@ -1,99 +1,284 @@
 package controllers
 
 import (
-	"io/ioutil"
+	"encoding/xml"
+	"io"
 	"strconv"
 
 	"Go-GoSAFE.converter/config"
+	"Go-GoSAFE.converter/crs"
 	"Go-GoSAFE.converter/export"
 	"Go-GoSAFE.converter/graph"
+	"Go-GoSAFE.converter/jobs"
+	"Go-GoSAFE.converter/schema"
+	"Go-GoSAFE.converter/store"
 
-	"github.com/beevik/etree"
 	"github.com/gin-gonic/gin"
-	"github.com/jmcvetta/neoism"
 )
 
 /**
 * @api {POST} /api/v1/import/railml
-* @apiDescription Converts a RailML file to neo4j graph
+* @apiDescription Converts a RailML file to neo4j graph, including its tracks, infrastructure attributes and operational and control points
 * @apiGroup Railml
 * @apiName ConvertRailml
 * @apiParam {string} line A line name
-* @apiParam {string} epsg CRS EPSG number, should match geooCoords CRS
+* @apiParam {string} [epsg] CRS EPSG number of geoCoords that have no epsgCode, if the file has no
+* <epsgCode default="..."/> in its infraAttributes either; defaults to the configured default_epsg
 * @apiParam {file} file A valid RailML file that contains the Infrastructure subschema
-* @apiSuccess (200) {json} object Response message with the number of extracted tracks
+* @apiParam {string="fail","replace","merge"} [mode=fail] What to do if the line already exists:
+* fail with 409, replace the whole line, or merge the file into it (tracks and elements are upserted by id)
+* @apiParam {string="strict","warn"} [validate] Validates the file against the railML.org schema first, as /api/v1/validate/railml does:
+* strict rejects a file that violates it, warn imports it anyway and returns the violations as warnings
+* @apiParam {boolean} [fill=false] Fills in missing pos values of track begins and ends from the geodesic length of the track,
+* and of other elements from their absPos or a geoCoord at most length_tolerance metres beside the track, and missing
+* absPos values from the pos, following the absPos and absDir of the track begin and its mileage changes. Filled in values are marked with posDerived or absPosDerived
+* @apiParam {boolean} [async=false] Query parameter, queues the import as a job instead of waiting for it, see /api/v1/jobs/:id;
+* the job validates the file before importing it
+* @apiSuccess (200) {json} object Response message with the number of extracted tracks, the diagnostics of the imported line,
+* see /api/v1/lines/:id/diagnostics, and the warnings if validate is warn
+* @apiSuccess (202) {json} job The queued job, its URL is in the Location header
+* @apiError (400) {json} problem Missing or malformed form fields, or an unsupported epsg
+* @apiError (409) {json} problem The line already exists and the mode is fail, or two elements of the same kind have
+* the same id
+* @apiError (413) {json} problem The request body is larger than max_upload_size
+* @apiError (422) {json} problem The file is not valid RailML, or the CRS of a geoCoord is unknown or unsupported;
+* the problem names the failing element and its line. With validate=strict, the violations of the schema are listed
+* @apiError (501) {json} problem The file is to be validated, but no schemas are configured, see schema_dir
+* @apiError (503) {json} problem The graph database can't be reached
  */
 func ImportRailml(c *gin.Context) {
 
+	if err := readForm(c); err != nil {
+		abortWithError(c, err)
+		return
+	}
 	epsg := c.PostForm("epsg")
+	if epsg == "" {
+		epsg = config.Get().DefaultEpsg
+	}
 	lineName := c.PostForm("line")
-	file, _ := c.FormFile("file")
-	xmlFile, _ := file.Open()
-	byteValue, _ := ioutil.ReadAll(xmlFile)
-	doc := etree.NewDocument()
-	if err := doc.ReadFromBytes(byteValue); err != nil {
-		panic(err)
+	if lineName == "" {
+		abortWithError(c, &badRequestError{"missing line name"})
+		return
+	}
+	if epsg != "" {
+		if _, err := crs.Lookup(epsg); err != nil {
+			abortWithError(c, &badRequestError{err.Error()})
+			return
+		}
+	}
+	mode := c.DefaultPostForm("mode", "fail")
+	if mode != "fail" && mode != "replace" && mode != "merge" {
+		abortWithError(c, &badRequestError{"mode must be fail, replace or merge, got '" + mode + "'"})
+		return
+	}
+	validate := c.PostForm("validate")
+	if validate != "" && validate != "strict" && validate != "warn" {
+		abortWithError(c, &badRequestError{"validate must be strict or warn, got '" + validate + "'"})
+		return
+	}
+	fill, err := strconv.ParseBool(c.DefaultPostForm("fill", "false"))
+	if err != nil {
+		abortWithError(c, &badRequestError{"fill must be true or false, got '" + c.PostForm("fill") + "'"})
+		return
+	}
+	async, err := strconv.ParseBool(c.DefaultQuery("async", "false"))
+	if err != nil {
+		abortWithError(c, &badRequestError{"async must be true or false, got '" + c.Query("async") + "'"})
+		return
 	}
 
-	graphUtils := graph.GraphUtils{}
-	db := config.GetDBConnection()
-
-	ln, _ := db.CreateNode(neoism.Props{"id": lineName})
-	ln.AddLabel("Line")
+	s := config.GetStore()
+	// checked before the upload is read, the import checks again
+	existing, err := s.FindNodes("Line", store.Props{"id": lineName})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if len(existing) > 0 && mode == "fail" {
+		abortWithError(c, &store.ConflictError{Msg: "line '" + lineName + "' already exists, use the replace or merge mode to update it"})
+		return
+	}
 
-	counter := 0
+	file, err := c.FormFile("file")
+	if err != nil {
+		abortWithError(c, &badRequestError{"missing RailML file: " + err.Error()})
+		return
+	}
+	xmlFile, err := file.Open()
+	if err != nil {
+		abortWithError(c, &badRequestError{"cannot open RailML file: " + err.Error()})
+		return
+	}
+	defer xmlFile.Close()
 
-	for _, t := range doc.FindElements("//track") {
+	o := graph.ImportOptions{Line: lineName, Epsg: epsg, Mode: mode, Source: file.Filename,
+		LengthTolerance: config.Get().LengthTolerance, FillPositions: fill}
+	if validate != "" && len(schema.Versions()) == 0 {
+		abortWithError(c, &schema.NoSchemasError{})
+		return
+	}
+	if async {
+		job, err := jobs.Submit(o, validate, xmlFile)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.Header("Location", "/api/v1/jobs/"+job.ID)
+		c.JSON(202, gin.H{
+			"job": job,
+		})
+		return
+	}
 
-		st := graphUtils.TrackToGraph(t, db, epsg, ln)
-		_ = st
+	var warnings []schema.Violation
+	if validate != "" {
+		violations, err := validateRailml(xmlFile)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if len(violations) > 0 && validate == "strict" {
+			abortWithError(c, &schemaViolationError{violations})
+			return
+		}
+		warnings = violations
+	}
 
-		counter++
+	var findings []graph.Finding
+	o.Diagnostics = func(f []graph.Finding) {
+		findings = f
+		logFindings(lineName, f)
+	}
+	percent := 0
+	o.Progress = func(read int64, tracks int) {
+		if file.Size > 0 && read*100/file.Size >= int64(percent+10) {
+			percent = int(read * 100 / file.Size / 10 * 10)
+			config.Infof("importing line '%s': %d%% of %s read, %d tracks", lineName, percent, file.Filename, tracks)
+		}
 	}
+	graphUtils := graph.GraphUtils{}
+	counter, err := graphUtils.ImportLine(c.Request.Context(), s, xmlFile, o)
+	if err != nil {
+		if se, ok := err.(*xml.SyntaxError); ok {
+			err = newInvalidRailmlError(se)
+		}
+		abortWithError(c, err)
+		return
+	}
+
+	x := map[string]string{"status": "ok", "mode": mode, "number of tracks": strconv.Itoa(counter)}
 
-	for _, a := range doc.FindElements("//infraAttrGroups") {
-		ag, _ := db.CreateNode(neoism.Props{})
-		ag.AddLabel("InfraAttrGroup")
-		ln.Relate("HAS_ATTR_GROUP", ag.Id(), neoism.Props{})
-		st := graphUtils.InfraAttributesToGraph(a, db, ag)
-		_ = st
+	h := gin.H{
+		"response":    x,
+		"diagnostics": findings,
+	}
+	if validate == "warn" {
+		if warnings == nil {
+			warnings = []schema.Violation{}
+		}
+		h["warnings"] = warnings
 	}
-	// Create relationships between connection nodes
+	c.JSON(200, h)
+}
 
-	connect := `MATCH ()-[:BEGINS|ENDS]-(s:Connection),(e:Connection) WHERE s.id=e.ref AND not ((s)--(e)) MERGE (s)-[r:CONNECTS]->(e)`
-	cq := neoism.CypherQuery{Statement: connect}
+/**
+* @api {POST} /api/v1/validate/railml
+* @apiDescription Validates a RailML file without importing it, against the railML.org schema of its version,
+* which is read from the configured schema_dir
+* @apiGroup Railml
+* @apiName ValidateRailml
+* @apiParam {file} file A RailML file of a version whose schema is in schema_dir, files without a version are taken as 2.2
+* @apiSuccess (200) {json} object Whether the file is valid and its violations, with their line and column
+* @apiError (400) {json} problem The file is missing
+* @apiError (413) {json} problem The request body is larger than max_upload_size
+* @apiError (422) {json} problem The file is not well-formed XML, or of a version there is no schema of
+* @apiError (501) {json} problem No schemas are configured, see schema_dir
+ */
+func ValidateRailml(c *gin.Context) {
 
-	e := db.Cypher(&cq)
-	_ = e
+	if err := readForm(c); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		abortWithError(c, &badRequestError{"missing RailML file: " + err.Error()})
+		return
+	}
+	xmlFile, err := file.Open()
+	if err != nil {
+		abortWithError(c, &badRequestError{"cannot open RailML file: " + err.Error()})
+		return
+	}
+	defer xmlFile.Close()
 
-	x := map[string]string{"status": "ok", "number of tracks": strconv.Itoa(counter)}
+	violations, err := validateRailml(xmlFile)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if violations == nil {
+		violations = []schema.Violation{}
+	}
 
 	c.JSON(200, gin.H{
-		"response": x,
+		"valid":      len(violations) == 0,
+		"violations": violations,
 	})
 }
 
+// validateRailml validates the uploaded file and rewinds it for the import
+func validateRailml(f io.ReadSeeker) ([]schema.Violation, error) {
+	violations, err := schema.ValidateRailML(f)
+	if se, ok := err.(*xml.SyntaxError); ok {
+		return nil, newInvalidRailmlError(se)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return violations, nil
+}
+
 /**
 * @api {POST} /api/v1/export/railml
 * @apiDescription Export a subgraph to the railml file
 * @apiGroup Railml
 * @apiName ExportRailml
 * @apiParam {string} line A line name
+* @apiParam {string} [epsg] CRS EPSG number of the exported geoCoords, defaults to the CRS the line was imported with
 * @apiSuccess (200) {XML} RailML A valid RailML document that describes the given line
+* @apiError (400) {json} problem Missing line name, or a malformed or unsupported epsg
+* @apiError (404) {json} problem There is no such line
+* @apiError (503) {json} problem The graph database can't be reached
  */
 func ExportRailml(c *gin.Context) {
 
+	if err := readForm(c); err != nil {
+		abortWithError(c, err)
+		return
+	}
 	lineId := c.PostForm("line")
+	if lineId == "" {
+		abortWithError(c, &badRequestError{"missing line name"})
+		return
+	}
 
-	rm := export.ExportLine(lineId)
-	/*
-		output, err := xml.MarshalIndent(rm, "  ", "    ")
-		if err != nil {
-			fmt.Printf("error: %v\n", err)
+	epsg := c.PostForm("epsg")
+	if epsg != "" {
+		if _, err := crs.Lookup(epsg); err != nil {
+			abortWithError(c, &badRequestError{err.Error()})
+			return
 		}
+	}
 
-		os.Stdout.Write(output)
-	*/
-
+	rm, err := export.ExportLine(config.GetStore(), lineId, epsg)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
 	c.XML(200, rm)
 }
@@ -0,0 +1,176 @@
+// Package crs transforms coordinates between the coordinate reference systems RailML files use, in pure Go.
+package crs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// CRS is a coordinate reference system identified by its EPSG code.
+// Geographic CRSs take longitude and latitude in degrees, projected CRSs easting and northing in metres.
+type CRS struct {
+	Epsg       string
+	Name       string
+	datum      *datum
+	projection projection // nil for geographic CRSs
+	northEast  bool
+}
+
+// Geographic reports whether coordinates of the CRS are longitudes and latitudes.
+func (c *CRS) Geographic() bool {
+	return c.projection == nil
+}
+
+// NorthEast reports whether the EPSG definition of the CRS puts the latitude or northing first,
+// as EPSG:4326 does. Transform always takes and returns the longitude or easting first.
+func (c *CRS) NorthEast() bool {
+	return c.northEast
+}
+
+// projection maps longitudes and latitudes in radians on the datum ellipsoid to metres and back.
+type projection interface {
+	forward(lon, lat float64) (x, y float64)
+	inverse(x, y float64) (lon, lat float64)
+}
+
+// UnsupportedError tells that there is no CRS with the given EPSG code.
+type UnsupportedError struct {
+	Epsg string
+}
+
+func (e *UnsupportedError) Error() string {
+	return "unknown or unsupported EPSG code " + e.Epsg
+}
+
+// Lookup returns the CRS with the given EPSG code. Supported are 4326 WGS84, 3857 (900913, 3785) Web Mercator,
+// 32601-32660 and 32701-32760 WGS84 / UTM, 4258 ETRS89, 25828-25838 ETRS89 / UTM, 2180 PUWG 1992,
+// 2176-2179 PUWG 2000 and 31466-31469 DHDN / Gauss-Krüger zones 2-5.
+func Lookup(epsg string) (*CRS, error) {
+	code, err := strconv.Atoi(epsg)
+	if err != nil {
+		return nil, &UnsupportedError{Epsg: epsg}
+	}
+
+	switch {
+	case code == 4326:
+		return &CRS{Epsg: epsg, Name: "WGS 84", datum: wgs84, northEast: true}, nil
+	case code == 4258:
+		return &CRS{Epsg: epsg, Name: "ETRS89", datum: etrs89, northEast: true}, nil
+	case code == 3857 || code == 900913 || code == 3785:
+		return &CRS{Epsg: epsg, Name: "WGS 84 / Pseudo-Mercator", datum: wgs84, projection: webMercator{}}, nil
+	case code >= 32601 && code <= 32660:
+		zone := code - 32600
+		return &CRS{Epsg: epsg, Name: fmt.Sprintf("WGS 84 / UTM zone %dN", zone), datum: wgs84, projection: utm(wgs84.ellipsoid, zone, false)}, nil
+	case code >= 32701 && code <= 32760:
+		zone := code - 32700
+		return &CRS{Epsg: epsg, Name: fmt.Sprintf("WGS 84 / UTM zone %dS", zone), datum: wgs84, projection: utm(wgs84.ellipsoid, zone, true)}, nil
+	case code >= 25828 && code <= 25838:
+		zone := code - 25800
+		return &CRS{Epsg: epsg, Name: fmt.Sprintf("ETRS89 / UTM zone %dN", zone), datum: etrs89, projection: utm(etrs89.ellipsoid, zone, false)}, nil
+	case code == 2180:
+		return &CRS{Epsg: epsg, Name: "ETRS89 / Poland CS92", datum: etrs89, northEast: true,
+			projection: newTransverseMercator(etrs89.ellipsoid, 19, 0.9993, 500000, -5300000)}, nil
+	case code >= 2176 && code <= 2179:
+		zone := code - 2171 // 5-8
+		return &CRS{Epsg: epsg, Name: fmt.Sprintf("ETRS89 / Poland CS2000 zone %d", zone), datum: etrs89, northEast: true,
+			projection: newTransverseMercator(etrs89.ellipsoid, float64(zone*3), 0.999923, float64(zone)*1e6+500000, 0)}, nil
+	case code >= 31466 && code <= 31469:
+		zone := code - 31464 // 2-5
+		return &CRS{Epsg: epsg, Name: fmt.Sprintf("DHDN / 3-degree Gauss-Kruger zone %d", zone), datum: dhdn, northEast: true,
+			projection: newTransverseMercator(dhdn.ellipsoid, float64(zone*3), 1, float64(zone)*1e6+500000, 0)}, nil
+	}
+	return nil, &UnsupportedError{Epsg: epsg}
+}
+
+// Transformer transforms coordinates from one CRS to another. It is safe for concurrent use.
+type Transformer struct {
+	From *CRS
+	To   *CRS
+}
+
+var transformers = struct {
+	sync.RWMutex
+	m map[string]*Transformer
+}{m: map[string]*Transformer{}}
+
+// NewTransformer returns the transformer between the CRSs with the given EPSG codes.
+// Transformers are cached, so asking for the same one again is cheap.
+func NewTransformer(from string, to string) (*Transformer, error) {
+	key := from + ">" + to
+	transformers.RLock()
+	t, ok := transformers.m[key]
+	transformers.RUnlock()
+	if ok {
+		return t, nil
+	}
+
+	f, err := Lookup(from)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := Lookup(to)
+	if err != nil {
+		return nil, err
+	}
+	t = &Transformer{From: f, To: tc}
+
+	transformers.Lock()
+	transformers.m[key] = t
+	transformers.Unlock()
+	return t, nil
+}
+
+// Identity reports whether the transformer leaves coordinates as they are.
+func (t *Transformer) Identity() bool {
+	return t.From.Epsg == t.To.Epsg
+}
+
+// Transform transforms a single coordinate, longitude or easting first. Heights are taken as 0 for the datum shift.
+func (t *Transformer) Transform(x float64, y float64) (float64, float64, error) {
+	if t.From.Geographic() && (math.Abs(y) > 90 || math.Abs(x) > 360) {
+		return 0, 0, fmt.Errorf("%g %g is out of range for geographic epsg:%s", x, y, t.From.Epsg)
+	}
+	if t.Identity() {
+		return x, y, nil
+	}
+
+	var lon, lat float64
+	if t.From.Geographic() {
+		lon, lat = x*deg, y*deg
+	} else {
+		lon, lat = t.From.projection.inverse(x, y)
+	}
+
+	if t.From.datum != t.To.datum {
+		lon, lat = t.From.datum.toWGS84(lon, lat)
+		lon, lat = t.To.datum.fromWGS84(lon, lat)
+	}
+
+	var xt, yt float64
+	if t.To.Geographic() {
+		xt, yt = lon/deg, lat/deg
+	} else {
+		xt, yt = t.To.projection.forward(lon, lat)
+	}
+	if math.IsNaN(xt) || math.IsNaN(yt) || math.IsInf(xt, 0) || math.IsInf(yt, 0) {
+		return 0, 0, fmt.Errorf("%g %g can't be transformed from epsg:%s to epsg:%s", x, y, t.From.Epsg, t.To.Epsg)
+	}
+	return xt, yt, nil
+}
+
+const deg = math.Pi / 180
+
+// webMercator is the spherical Mercator projection of web maps, on WGS84 coordinates
+type webMercator struct{}
+
+const webMercatorRadius = 6378137
+
+func (webMercator) forward(lon, lat float64) (float64, float64) {
+	return webMercatorRadius * lon, webMercatorRadius * math.Log(math.Tan(math.Pi/4+lat/2))
+}
+
+func (webMercator) inverse(x, y float64) (float64, float64) {
+	return x / webMercatorRadius, 2*math.Atan(math.Exp(y/webMercatorRadius)) - math.Pi/2
+}
@@ -0,0 +1,228 @@
+package crs
+
+import (
+	"math"
+	"testing"
+)
+
+// dms returns an angle given in degrees, minutes and seconds in degrees
+func dms(d, m, s float64) float64 {
+	if d < 0 {
+		return d - m/60 - s/3600
+	}
+	return d + m/60 + s/3600
+}
+
+// meridianArc integrates the distance from the equator to the latitude along a meridian of the ellipsoid
+func meridianArc(e ellipsoid, lat float64) float64 {
+	e2 := e.e2()
+	f := func(phi float64) float64 {
+		s := math.Sin(phi)
+		return e.a * (1 - e2) / math.Pow(1-e2*s*s, 1.5)
+	}
+	const n = 2000 // Simpson's rule
+	h := lat * deg / n
+	sum := f(0) + f(lat*deg)
+	for i := 1; i < n; i++ {
+		w := 2.0
+		if i%2 == 1 {
+			w = 4
+		}
+		sum += w * f(float64(i)*h)
+	}
+	return sum * h / 3
+}
+
+func TestTransverseMercator(t *testing.T) {
+	// EPSG Guidance Note 7-2, the example of the Transverse Mercator projection: OSGB 1936 / British National Grid,
+	// whose latitude of origin is 49°N. The projection here starts at the equator, so its northings are moved by the
+	// one of the origin.
+	airy := ellipsoid{a: 6377563.396, f: 1 / 299.3249646}
+	bng := newTransverseMercator(airy, -2, 0.9996012717, 400000, 0)
+	_, origin := bng.forward(-2*deg, 49*deg)
+	fn := -100000 - origin
+
+	x, y := bng.forward(dms(0, 30, 0)*deg, dms(50, 30, 0)*deg)
+	if math.Abs(x-577274.99) > 0.01 || math.Abs(y+fn-69740.49) > 0.01 {
+		t.Errorf("50°30'N 0°30'E is %.3f %.3f, want 577274.99 69740.49", x, y+fn)
+	}
+	lon, lat := bng.inverse(577274.99, 69740.49-fn)
+	if math.Abs(lon/deg-0.5) > 1e-7 || math.Abs(lat/deg-50.5) > 1e-7 {
+		t.Errorf("577274.99 69740.49 is %.9f %.9f, want 0.5 50.5", lon/deg, lat/deg)
+	}
+
+	// on the central meridian the northing is the scaled meridian arc
+	tests := []struct {
+		epsg string
+		lon  float64 // the central meridian
+		lats []float64
+	}{
+		{"32633", 15, []float64{1, 45, 80}},
+		{"25834", 21, []float64{50, 70}},
+		{"2180", 19, []float64{50, 54}},
+		{"2177", 18, []float64{50, 54}},
+		{"31468", 12, []float64{48, 52}},
+	}
+	for _, tt := range tests {
+		c, err := Lookup(tt.epsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tm := c.projection.(*transverseMercator)
+		for _, lat := range tt.lats {
+			x, y := tm.forward(tt.lon*deg, lat*deg)
+			want := tm.fn + tm.k0*meridianArc(c.datum.ellipsoid, lat)
+			if math.Abs(x-tm.fe) > 1e-6 || math.Abs(y-want) > 0.001 {
+				t.Errorf("epsg:%s: %v°N on the central meridian is %.4f %.4f, want %.4f %.4f", tt.epsg, lat, x, y, tm.fe, want)
+			}
+		}
+	}
+}
+
+func TestHelmert(t *testing.T) {
+	// EPSG Guidance Note 7-2, the example of the position vector transformation, WGS 72 to WGS 84
+	h := &helmert{0, 0, 4.5, 0, 0, 0.554, 0.219}
+	x, y, z := h.apply(3657660.66, 255768.55, 5201382.11, 1)
+	if math.Abs(x-3657660.78) > 0.01 || math.Abs(y-255778.43) > 0.01 || math.Abs(z-5201387.75) > 0.01 {
+		t.Errorf("the transformed point is %.3f %.3f %.3f, want 3657660.78 255778.43 5201387.75", x, y, z)
+	}
+	x, y, z = h.apply(x, y, z, -1)
+	if math.Abs(x-3657660.66) > 0.001 || math.Abs(y-255768.55) > 0.001 || math.Abs(z-5201382.11) > 0.001 {
+		t.Errorf("the point transformed back is %.4f %.4f %.4f, want 3657660.66 255768.55 5201382.11", x, y, z)
+	}
+}
+
+func TestGeocentric(t *testing.T) {
+	// EPSG Guidance Note 7-2, the example of the geographic/geocentric conversion on WGS 84. The point is 73 m above
+	// the ellipsoid, geocentric takes it at height 0, so it is moved down along the normal.
+	lon, lat, height := dms(2, 7, 46.38)*deg, dms(53, 48, 33.82)*deg, 73.0
+	wx := 3771793.968 - height*math.Cos(lat)*math.Cos(lon)
+	wy := 140253.342 - height*math.Cos(lat)*math.Sin(lon)
+	wz := 5124304.349 - height*math.Sin(lat)
+
+	x, y, z := geocentric(wgs84Ellipsoid, lon, lat)
+	if math.Abs(x-wx) > 0.001 || math.Abs(y-wy) > 0.001 || math.Abs(z-wz) > 0.001 {
+		t.Errorf("the point is %.4f %.4f %.4f, want %.4f %.4f %.4f", x, y, z, wx, wy, wz)
+	}
+	glon, glat := geographic(wgs84Ellipsoid, wx, wy, wz)
+	if math.Abs(glon-lon) > 1e-10 || math.Abs(glat-lat) > 1e-10 {
+		t.Errorf("the point is %.10f %.10f, want %.10f %.10f", glon/deg, glat/deg, lon/deg, lat/deg)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		from, to string
+		x, y     float64
+		wx, wy   float64
+		tol      float64
+	}{
+		// EPSG Guidance Note 7-2, the example of Popular Visualisation Pseudo Mercator
+		{"4326", "3857", dms(-100, 20, 0), dms(24, 22, 54.433), -11169055.58, 2800000.00, 0.01},
+		{"3857", "4326", -11169055.58, 2800000.00, dms(-100, 20, 0), dms(24, 22, 54.433), 1e-7},
+		// the central meridian and the equator of UTM zones
+		{"4326", "32633", 15, 0, 500000, 0, 1e-6},
+		{"4326", "32733", 15, 0, 500000, 10000000, 1e-6},
+		{"4258", "25833", 15, 0, 500000, 0, 1e-6},
+		// ETRS89 and WGS 84 are the same within a metre
+		{"4258", "4326", 19, 50, 19, 50, 1e-12},
+	}
+	for _, tt := range tests {
+		tr, err := NewTransformer(tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, y, err := tr.Transform(tt.x, tt.y)
+		if err != nil {
+			t.Errorf("epsg:%s to epsg:%s: %v", tt.from, tt.to, err)
+			continue
+		}
+		if math.Abs(x-tt.wx) > tt.tol || math.Abs(y-tt.wy) > tt.tol {
+			t.Errorf("%v %v from epsg:%s to epsg:%s is %.9f %.9f, want %.9f %.9f", tt.x, tt.y, tt.from, tt.to, x, y, tt.wx, tt.wy)
+		}
+	}
+}
+
+func TestTransformRoundTrip(t *testing.T) {
+	// a point in each CRS family, as longitude and latitude, transformed there and back
+	tests := []struct {
+		epsg     string
+		lon, lat float64
+		tol      float64 // degrees
+	}{
+		{"3857", 19.94, 50.06, 1e-9},
+		{"32634", 19.94, 50.06, 1e-9},
+		{"32733", 15.3, -4.3, 1e-9},
+		{"25834", 19.94, 50.06, 1e-9},
+		{"2180", 19.94, 50.06, 1e-9},
+		{"2178", 21.0, 52.23, 1e-9},
+		// the reverse of the Helmert transformation is exact to about a centimetre
+		{"31468", 12.1, 51.3, 2e-7},
+		{"31467", 9.0, 48.8, 2e-7},
+	}
+	for _, tt := range tests {
+		there, err := NewTransformer("4326", tt.epsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := NewTransformer(tt.epsg, "4326")
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, y, err := there.Transform(tt.lon, tt.lat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lon, lat, err := back.Transform(x, y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(lon-tt.lon) > tt.tol || math.Abs(lat-tt.lat) > tt.tol {
+			t.Errorf("epsg:%s: %v %v is %.10f %.10f after the round trip", tt.epsg, tt.lon, tt.lat, lon, lat)
+		}
+	}
+}
+
+func TestDHDN(t *testing.T) {
+	// EPSG:1777 moves points in Germany by about a hundred metres, mostly to the south west going to WGS 84
+	tests := []struct{ lon, lat float64 }{{6.8, 51.2}, {9.0, 48.8}, {12.1, 51.3}, {13.4, 52.5}}
+	for _, tt := range tests {
+		lon, lat := dhdn.toWGS84(tt.lon*deg, tt.lat*deg)
+		d := Distance(tt.lon, tt.lat, lon/deg, lat/deg)
+		if d < 50 || d > 200 || lat >= tt.lat*deg || lon >= tt.lon*deg {
+			t.Errorf("DHDN %v %v is moved %.1f m to WGS 84 %.6f %.6f", tt.lon, tt.lat, d, lon/deg, lat/deg)
+		}
+		blon, blat := dhdn.fromWGS84(lon, lat)
+		if math.Abs(blon/deg-tt.lon) > 2e-7 || math.Abs(blat/deg-tt.lat) > 2e-7 {
+			t.Errorf("DHDN %v %v is %.9f %.9f after the round trip", tt.lon, tt.lat, blon/deg, blat/deg)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lon1, lat1, lon2, lat2 float64
+		want, tol              float64
+	}{
+		// Geoscience Australia, the example of Vincenty's inverse formula on GRS80, whose flattening differs from the
+		// one of WGS 84 by far too little to matter: Flinders Peak to Buninyong
+		{"Flinders Peak to Buninyong", dms(144, 25, 29.5244), dms(-37, 57, 3.7203), dms(143, 55, 35.3839), dms(-37, 39, 10.1561), 54972.271, 0.001},
+		// the quadrant of the WGS 84 meridian
+		{"equator to pole", 0, 0, 0, 90, 10001965.729, 0.001},
+		// a degree of the equator
+		{"along the equator", 0, 0, 1, 0, 6378137 * deg, 1e-6},
+		{"the same point", 19, 50, 19, 50, 0, 0},
+		// the formula doesn't converge, the great circle distance is returned, close to half the meridian
+		{"nearly antipodal", 0, 0, 179.7, 0.3, 20003931, 50000},
+	}
+	for _, tt := range tests {
+		got := Distance(tt.lon1, tt.lat1, tt.lon2, tt.lat2)
+		if math.IsNaN(got) || math.Abs(got-tt.want) > tt.tol {
+			t.Errorf("%s: %.4f m, want %.4f m", tt.name, got, tt.want)
+		}
+		if back := Distance(tt.lon2, tt.lat2, tt.lon1, tt.lat1); math.Abs(back-got) > 1e-6 {
+			t.Errorf("%s: %.4f m, but %.4f m the other way round", tt.name, got, back)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package crs
+
+import "math"
+
+type ellipsoid struct {
+	a float64 // semi-major axis in metres
+	f float64 // flattening
+}
+
+func (e ellipsoid) e2() float64 {
+	return e.f * (2 - e.f)
+}
+
+var (
+	wgs84Ellipsoid  = ellipsoid{a: 6378137, f: 1 / 298.257223563}
+	grs80Ellipsoid  = ellipsoid{a: 6378137, f: 1 / 298.257222101}
+	besselEllipsoid = ellipsoid{a: 6377397.155, f: 1 / 299.1528128}
+)
+
+// helmert is a 7 parameter transformation to WGS84 (position vector convention),
+// translations in metres, rotations in arc seconds and the scale in ppm
+type helmert struct {
+	tx, ty, tz float64
+	rx, ry, rz float64
+	s          float64
+}
+
+// datum is an ellipsoid placed on the earth, a nil shift means it is the same as WGS84 within a metre
+type datum struct {
+	ellipsoid ellipsoid
+	shift     *helmert
+}
+
+var (
+	wgs84  = &datum{ellipsoid: wgs84Ellipsoid}
+	etrs89 = &datum{ellipsoid: grs80Ellipsoid}
+	// EPSG:1777, DHDN to WGS 84 (2) for Germany
+	dhdn = &datum{ellipsoid: besselEllipsoid, shift: &helmert{598.1, 73.7, 418.2, 0.202, 0.045, -2.455, 6.7}}
+)
+
+// toWGS84 moves a longitude and latitude in radians from the datum to WGS84
+func (d *datum) toWGS84(lon, lat float64) (float64, float64) {
+	if d.shift == nil {
+		return lon, lat
+	}
+	x, y, z := geocentric(d.ellipsoid, lon, lat)
+	x, y, z = d.shift.apply(x, y, z, 1)
+	return geographic(wgs84Ellipsoid, x, y, z)
+}
+
+// fromWGS84 moves a WGS84 longitude and latitude in radians to the datum
+func (d *datum) fromWGS84(lon, lat float64) (float64, float64) {
+	if d.shift == nil {
+		return lon, lat
+	}
+	x, y, z := geocentric(wgs84Ellipsoid, lon, lat)
+	x, y, z = d.shift.apply(x, y, z, -1)
+	return geographic(d.ellipsoid, x, y, z)
+}
+
+// apply transforms geocentric coordinates, with sign -1 it reverses the transformation
+// (exact enough for the small rotations datums have)
+func (h *helmert) apply(x, y, z float64, sign float64) (float64, float64, float64) {
+	const arcsec = math.Pi / (180 * 3600)
+	rx, ry, rz := sign*h.rx*arcsec, sign*h.ry*arcsec, sign*h.rz*arcsec
+	m := 1 + sign*h.s*1e-6
+	return sign*h.tx + m*(x-rz*y+ry*z),
+		sign*h.ty + m*(rz*x+y-rx*z),
+		sign*h.tz + m*(-ry*x+rx*y+z)
+}
+
+// geocentric converts a longitude and latitude in radians at height 0 to earth-centred coordinates
+func geocentric(e ellipsoid, lon, lat float64) (float64, float64, float64) {
+	e2 := e.e2()
+	sinLat := math.Sin(lat)
+	n := e.a / math.Sqrt(1-e2*sinLat*sinLat)
+	return n * math.Cos(lat) * math.Cos(lon),
+		n * math.Cos(lat) * math.Sin(lon),
+		n * (1 - e2) * sinLat
+}
+
+// geographic converts earth-centred coordinates to a longitude and latitude in radians, the height is dropped
+func geographic(e ellipsoid, x, y, z float64) (float64, float64) {
+	e2 := e.e2()
+	p := math.Hypot(x, y)
+	lon := math.Atan2(y, x)
+	lat := math.Atan2(z, p*(1-e2))
+	for i := 0; i < 10; i++ {
+		sinLat := math.Sin(lat)
+		n := e.a / math.Sqrt(1-e2*sinLat*sinLat)
+		h := p/math.Cos(lat) - n
+		next := math.Atan2(z, p*(1-e2*n/(n+h)))
+		if math.Abs(next-lat) < 1e-12 {
+			return lon, next
+		}
+		lat = next
+	}
+	return lon, lat
+}
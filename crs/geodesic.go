@@ -0,0 +1,53 @@
+package crs
+
+import "math"
+
+// Distance returns the length in metres of the geodesic between two WGS84 longitudes and latitudes in degrees,
+// worked out with Vincenty's inverse formula on the WGS84 ellipsoid. For the nearly antipodal points the formula
+// doesn't converge for, the great circle distance on a sphere of the mean radius is returned.
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	if lon1 == lon2 && lat1 == lat2 {
+		return 0
+	}
+	a, f := wgs84Ellipsoid.a, wgs84Ellipsoid.f
+	b := a * (1 - f)
+	l := (lon2 - lon1) * math.Pi / 180
+	u1 := math.Atan((1 - f) * math.Tan(lat1*math.Pi/180))
+	u2 := math.Atan((1 - f) * math.Tan(lat2*math.Pi/180))
+	sinU1, cosU1 := math.Sincos(u1)
+	sinU2, cosU2 := math.Sincos(u2)
+
+	lambda := l
+	for i := 0; i < 200; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma := math.Sqrt(math.Pow(cosU2*sinLambda, 2) + math.Pow(cosU1*sinU2-sinU1*cosU2*cosLambda, 2))
+		if sinSigma == 0 {
+			return 0 // coincident points
+		}
+		cosSigma := sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma := math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha := 1 - sinAlpha*sinAlpha
+		cos2SigmaM := 0.0 // on the equator
+		if cos2Alpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+		c := f / 16 * cos2Alpha * (4 + f*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = l + (1-c)*f*sinAlpha*(sigma+c*sinSigma*(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < 1e-12 {
+			u2 := cos2Alpha * (a*a - b*b) / (b * b)
+			ka := 1 + u2/16384*(4096+u2*(-768+u2*(320-175*u2)))
+			kb := u2 / 1024 * (256 + u2*(-128+u2*(74-47*u2)))
+			deltaSigma := kb * sinSigma * (cos2SigmaM + kb/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+				kb/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+			return b * ka * (sigma - deltaSigma)
+		}
+	}
+
+	// haversine
+	const r = 6371008.8
+	phi1, phi2 := lat1*math.Pi/180, lat2*math.Pi/180
+	h := math.Pow(math.Sin((phi2-phi1)/2), 2) + math.Cos(phi1)*math.Cos(phi2)*math.Pow(math.Sin(l/2), 2)
+	return 2 * r * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,103 @@
+package crs
+
+import "math"
+
+// transverseMercator is the Gauss-Krüger projection, computed with the 6th order Krüger series
+// (Karney, Transverse Mercator with an accuracy of a few nanometers, 2011). It is used by UTM, PUWG and the German GK zones.
+type transverseMercator struct {
+	e       float64 // eccentricity
+	lon0    float64 // central meridian in radians
+	k0      float64 // scale on the central meridian
+	fe, fn  float64 // false easting and northing
+	a       float64 // rectifying radius
+	alpha   [6]float64
+	beta    [6]float64
+	e2ratio float64 // 1 - e²
+}
+
+func newTransverseMercator(el ellipsoid, lon0 float64, k0 float64, fe float64, fn float64) *transverseMercator {
+	n := el.f / (2 - el.f)
+	n2, n3 := n*n, n*n*n
+	n4, n5, n6 := n3*n, n3*n2, n3*n3
+
+	return &transverseMercator{
+		e:    math.Sqrt(el.e2()),
+		lon0: lon0 * deg,
+		k0:   k0,
+		fe:   fe,
+		fn:   fn,
+		a:    el.a / (1 + n) * (1 + n2/4 + n4/64 + n6/256),
+		alpha: [6]float64{
+			n/2 - 2*n2/3 + 5*n3/16 + 41*n4/180 - 127*n5/288 + 7891*n6/37800,
+			13*n2/48 - 3*n3/5 + 557*n4/1440 + 281*n5/630 - 1983433*n6/1935360,
+			61*n3/240 - 103*n4/140 + 15061*n5/26880 + 167603*n6/181440,
+			49561*n4/161280 - 179*n5/168 + 6601661*n6/7257600,
+			34729*n5/80640 - 3418889*n6/1995840,
+			212378941 * n6 / 319334400,
+		},
+		beta: [6]float64{
+			n/2 - 2*n2/3 + 37*n3/96 - n4/360 - 81*n5/512 + 96199*n6/604800,
+			n2/48 + n3/15 - 437*n4/1440 + 46*n5/105 - 1118711*n6/3870720,
+			17*n3/480 - 37*n4/840 - 209*n5/4480 + 5569*n6/90720,
+			4397*n4/161280 - 11*n5/504 - 830251*n6/7257600,
+			4583*n5/161280 - 108847*n6/3991680,
+			20648693 * n6 / 638668800,
+		},
+		e2ratio: 1 - el.e2(),
+	}
+}
+
+// utm returns the projection of the given UTM zone
+func utm(el ellipsoid, zone int, south bool) *transverseMercator {
+	fn := 0.0
+	if south {
+		fn = 10000000
+	}
+	return newTransverseMercator(el, float64(zone*6-183), 0.9996, 500000, fn)
+}
+
+func (tm *transverseMercator) forward(lon, lat float64) (float64, float64) {
+	l := lon - tm.lon0
+	sinLat := math.Sin(lat)
+	t := math.Sinh(math.Atanh(sinLat) - tm.e*math.Atanh(tm.e*sinLat)) // tangent of the conformal latitude
+	xi1 := math.Atan2(t, math.Cos(l))
+	eta1 := math.Atanh(math.Sin(l) / math.Sqrt(1+t*t))
+
+	xi, eta := xi1, eta1
+	for j, a := range tm.alpha {
+		k := float64(2 * (j + 1))
+		xi += a * math.Sin(k*xi1) * math.Cosh(k*eta1)
+		eta += a * math.Cos(k*xi1) * math.Sinh(k*eta1)
+	}
+	return tm.fe + tm.k0*tm.a*eta, tm.fn + tm.k0*tm.a*xi
+}
+
+func (tm *transverseMercator) inverse(x, y float64) (float64, float64) {
+	xi := (y - tm.fn) / (tm.k0 * tm.a)
+	eta := (x - tm.fe) / (tm.k0 * tm.a)
+
+	xi1, eta1 := xi, eta
+	for j, b := range tm.beta {
+		k := float64(2 * (j + 1))
+		xi1 -= b * math.Sin(k*xi) * math.Cosh(k*eta)
+		eta1 -= b * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+
+	sinhEta1 := math.Sinh(eta1)
+	cosXi1 := math.Cos(xi1)
+	tau1 := math.Sin(xi1) / math.Hypot(sinhEta1, cosXi1) // tangent of the conformal latitude
+	lon := tm.lon0 + math.Atan2(sinhEta1, cosXi1)
+
+	// Newton's method for the tangent of the latitude
+	tau := tau1
+	for i := 0; i < 10; i++ {
+		sigma := math.Sinh(tm.e * math.Atanh(tm.e*tau/math.Sqrt(1+tau*tau)))
+		taui := tau*math.Sqrt(1+sigma*sigma) - sigma*math.Sqrt(1+tau*tau)
+		dtau := (tau1 - taui) / math.Sqrt(1+taui*taui) * (1 + tm.e2ratio*tau*tau) / (tm.e2ratio * math.Sqrt(1+tau*tau))
+		tau += dtau
+		if math.Abs(dtau) < 1e-12 {
+			break
+		}
+	}
+	return lon, math.Atan(tau)
+}
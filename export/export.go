@@ -5,26 +5,60 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
-	"Go-GoSAFE.converter/config"
-
-	"github.com/jmcvetta/neoism"
+	"Go-GoSAFE.converter/graph"
+	"Go-GoSAFE.converter/store"
+	"Go-GoSAFE.converter/utils"
 )
 
+// Extra holds the attributes that have no field of their own, e.g. from railML extensions,
+// so that they are exported as they were imported
+type Extra struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+// Unknown holds the child elements that have no field of their own, e.g. from railML extensions. It is embedded
+// after the known child elements, so that they are written after them, as the schema orders them.
+type Unknown struct {
+	Fragments string `xml:",innerxml"`
+}
+
+type GeoCoord struct {
+	XMLName  xml.Name `xml:"geoCoord"`
+	Coord    string   `xml:"coord,attr"`
+	EpsgCode string   `xml:"epsgCode,attr,omitempty"`
+}
+
+type GeoMapping struct {
+	XMLName  xml.Name `xml:"geoMapping"`
+	Id       string   `xml:"id,attr"`
+	GeoCoord *GeoCoord
+}
+
+type GeoMappings struct {
+	XMLName    xml.Name `xml:"geoMappings"`
+	GeoMapping []GeoMapping
+}
+
 type BufferStop struct {
 	XMLName     xml.Name `xml:"bufferStop"`
 	Id          string   `xml:"id,attr"`
 	Code        string   `xml:"code,attr,omitempty"`
 	Name        string   `xml:"name,attr,omitempty"`
 	Description string   `xml:"description,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type Connection struct {
 	XMLName xml.Name `xml:"connection"`
 	Id      string   `xml:"id,attr"`
 	Ref     string   `xml:"ref,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type OpenEnd struct {
@@ -33,24 +67,31 @@ type OpenEnd struct {
 	Code        string   `xml:"code,attr,omitempty"`
 	Name        string   `xml:"name,attr,omitempty"`
 	Description string   `xml:"description,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type MacroscopicNode struct {
 	XMLName       xml.Name `xml:"macroscopicNode"`
 	OcpRef        string   `xml:"ocpRef,attr"`
 	FlowDirection string   `xml:"flowDirection,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type TrackEdge struct {
-	XMLName         xml.Name
-	Id              string `xml:"id,attr"`
-	Pos             string `xml:"pos,attr,omitempty"`
-	AbsPos          string `xml:"absPos,attr,omitempty"`
-	AbsDir          string `xml:"absDir,attr,omitempty"`
+	XMLName xml.Name
+	Id      string `xml:"id,attr"`
+	Pos     string `xml:"pos,attr,omitempty"`
+	AbsPos  string `xml:"absPos,attr,omitempty"`
+	AbsDir  string `xml:"absDir,attr,omitempty"`
+	Extra
 	BufferStop      []BufferStop
 	Connection      []Connection
 	OpenEnd         []OpenEnd
 	MacroscopicNode []MacroscopicNode
+	GeoCoord        *GeoCoord
+	Unknown
 }
 
 type SwitchOrCrossing struct { // exactly the same attrs
@@ -69,19 +110,77 @@ type SwitchOrCrossing struct { // exactly the same attrs
 	Model               string `xml:"model,attr,omitempty"`
 	Length              string `xml:"length,attr,omitempty"`
 	Type                string `xml:"type,attr,omitempty"`
-	Connection          Connection
+	Extra
+	GeoCoord   *GeoCoord
+	Connection []Connection
+	Unknown
 }
 
 type Connections struct {
-	XMLName     xml.Name `xml:"connections"`
+	XMLName xml.Name `xml:"connections"`
+	Extra
 	Connections []SwitchOrCrossing
+	Unknown
+}
+
+type MileageChange struct {
+	XMLName        xml.Name `xml:"mileageChange"`
+	Id             string   `xml:"id,attr"`
+	Code           string   `xml:"code,attr,omitempty"`
+	Name           string   `xml:"name,attr,omitempty"`
+	Description    string   `xml:"description,attr,omitempty"`
+	Pos            string   `xml:"pos,attr,omitempty"`
+	AbsPos         string   `xml:"absPos,attr,omitempty"`
+	Dir            string   `xml:"dir,attr,omitempty"`
+	AbsPosIn       string   `xml:"absPosIn,attr,omitempty"`
+	AbsPosInOffset string   `xml:"absPosInOffset,attr,omitempty"`
+	AbsDir         string   `xml:"absDir,attr,omitempty"`
+	Type           string   `xml:"type,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
+}
+
+type MileageChanges struct {
+	XMLName xml.Name `xml:"mileageChanges"`
+	Extra
+	MileageChange []MileageChange
+	Unknown
+}
+
+type CrossSection struct {
+	XMLName     xml.Name `xml:"crossSection"`
+	Id          string   `xml:"id,attr"`
+	Code        string   `xml:"code,attr,omitempty"`
+	Name        string   `xml:"name,attr,omitempty"`
+	Description string   `xml:"description,attr,omitempty"`
+	Pos         string   `xml:"pos,attr,omitempty"`
+	AbsPos      string   `xml:"absPos,attr,omitempty"`
+	Dir         string   `xml:"dir,attr,omitempty"`
+	OcpRef      string   `xml:"ocpRef,attr,omitempty"`
+	OcpTrackID  string   `xml:"ocpTrackID,attr,omitempty"`
+	Type        string   `xml:"type,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
+}
+
+type CrossSections struct {
+	XMLName xml.Name `xml:"crossSections"`
+	Extra
+	CrossSection []CrossSection
+	Unknown
 }
 
 type TrackTopology struct {
-	XMLName     xml.Name `xml:"trackTopology"`
-	TrackBegin  TrackEdge
-	TrackEnd    TrackEdge
-	Connections Connections
+	XMLName xml.Name `xml:"trackTopology"`
+	Extra
+	TrackBegin     TrackEdge
+	TrackEnd       TrackEdge
+	MileageChanges *MileageChanges
+	Connections    Connections
+	CrossSections  *CrossSections
+	Unknown
 }
 
 type AxleWeightChange struct {
@@ -95,11 +194,16 @@ type AxleWeightChange struct {
 	Dir         string   `xml:"dir,attr,omitempty"`
 	Value       string   `xml:"value,attr,omitempty"`
 	Meterload   string   `xml:"meterload,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type AxleWeightChanges struct {
-	XMLName          xml.Name `xml:"axleWeightChanges"`
+	XMLName xml.Name `xml:"axleWeightChanges"`
+	Extra
 	AxleWeightChange []AxleWeightChange
+	Unknown
 }
 
 type Brigde struct {
@@ -114,11 +218,16 @@ type Brigde struct {
 	Length      string   `xml:"length,attr,omitempty"`
 	Meterload   string   `xml:"meterload,attr,omitempty"`
 	Kind        string   `xml:"kind,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type Bridges struct {
 	XMLName xml.Name `xml:"bridges"` // and yes, this is a normal bri-D-G-es container
-	Brigde  []Brigde
+	Extra
+	Brigde []Brigde
+	Unknown
 }
 
 type ClearanceGaugeChange struct {
@@ -130,11 +239,16 @@ type ClearanceGaugeChange struct {
 	Pos         string   `xml:"pos,attr,omitempty"`
 	AbsPos      string   `xml:"absPos,attr,omitempty"`
 	Dir         string   `xml:"dir,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type ClearanceGaugeChanges struct {
-	XMLName              xml.Name `xml:"clearanceGaugeChanges"`
+	XMLName xml.Name `xml:"clearanceGaugeChanges"`
+	Extra
 	ClearanceGaugeChange []ClearanceGaugeChange
+	Unknown
 }
 
 type ElectrificationChange struct {
@@ -151,11 +265,16 @@ type ElectrificationChange struct {
 	Frequency       string   `xml:"frequency,attr,omitempty"`
 	VMax            string   `xml:"vMax,attr,omitempty"`
 	IsolatedSection string   `xml:"isolatedSection,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type ElectrificationChanges struct {
-	XMLName               xml.Name `xml:"electrificationChanges"`
+	XMLName xml.Name `xml:"electrificationChanges"`
+	Extra
 	ElectrificationChange []ElectrificationChange
+	Unknown
 }
 
 type GaugeChange struct {
@@ -168,11 +287,16 @@ type GaugeChange struct {
 	AbsPos      string   `xml:"absPos,attr,omitempty"`
 	Dir         string   `xml:"dir,attr,omitempty"`
 	Value       string   `xml:"value,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type GaugeChanges struct {
-	XMLName     xml.Name `xml:"gaugeChanges"`
+	XMLName xml.Name `xml:"gaugeChanges"`
+	Extra
 	GaugeChange []GaugeChange
+	Unknown
 }
 
 type GradientChange struct {
@@ -187,11 +311,16 @@ type GradientChange struct {
 	Slope            string   `xml:"slope,attr,omitempty"`
 	TransitionLenght string   `xml:"transitionLenght,attr,omitempty"`
 	TransitionRadius string   `xml:"transitionRadius,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type GradientChanges struct {
-	XMLName        xml.Name `xml:"gradientChanges"`
+	XMLName xml.Name `xml:"gradientChanges"`
+	Extra
 	GradientChange []GradientChange
+	Unknown
 }
 
 type LevelCrossing struct {
@@ -208,11 +337,16 @@ type LevelCrossing struct {
 	Length        string   `xml:"length,attr,omitempty"`
 	Angle         string   `xml:"angle,attr,omitempty"`
 	Protection    string   `xml:"protection,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type LevelCrossings struct {
-	XMLName       xml.Name `xml:"levelCrossings"`
+	XMLName xml.Name `xml:"levelCrossings"`
+	Extra
 	LevelCrossing []LevelCrossing
+	Unknown
 }
 
 type OperationModeChange struct {
@@ -225,13 +359,18 @@ type OperationModeChange struct {
 	AbsPos            string   `xml:"absPos,attr,omitempty"`
 	Dir               string   `xml:"dir,attr,omitempty"`
 	ModeLegislative   string   `xml:"modeLegislative,attr,omitempty"`
-	ModeExecutive     string   `xml:"modeEvecutive,attr,omitempty"`
-	ClearanceManaging string   `xml:"clereanceMamaging,attr,omitempty"`
+	ModeExecutive     string   `xml:"modeExecutive,attr,omitempty"`
+	ClearanceManaging string   `xml:"clearanceManaging,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type OperationModeChanges struct {
-	XMLName             xml.Name `xml:"operationModeChanges"`
+	XMLName xml.Name `xml:"operationModeChanges"`
+	Extra
 	OperationModeChange []OperationModeChange
+	Unknown
 }
 
 type OwnerChange struct {
@@ -245,11 +384,16 @@ type OwnerChange struct {
 	Dir                      string   `xml:"dir,attr,omitempty"`
 	OwnerName                string   `xml:"ownerName,attr,omitempty"`
 	InfrastructureManagerRef string   `xml:"infrastructureManagerRef,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type OwnerChanges struct {
-	XMLName     xml.Name `xml:"ownerChanges"`
+	XMLName xml.Name `xml:"ownerChanges"`
+	Extra
 	OwnerChange []OwnerChange
+	Unknown
 }
 
 type PlatformEdge struct {
@@ -266,11 +410,16 @@ type PlatformEdge struct {
 	Height                string   `xml:"height,attr,omitempty"`
 	Side                  string   `xml:"side,attr,omitempty"`
 	ParentPlatformEdgeRef string   `xml:"parentPlatformEdgeRef,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type PlatformEdges struct {
-	XMLName      xml.Name `xml:"platformEdges"`
+	XMLName xml.Name `xml:"platformEdges"`
+	Extra
 	PlatformEdge []PlatformEdge
+	Unknown
 }
 
 type PowerTransmissionChange struct {
@@ -284,11 +433,16 @@ type PowerTransmissionChange struct {
 	Dir         string   `xml:"dir,attr,omitempty"`
 	Type        string   `xml:"type,attr,omitempty"`
 	Style       string   `xml:"style,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type PowerTransmissionChanges struct {
-	XMLName                 xml.Name `xml:"powerTransmissionChanges"`
+	XMLName xml.Name `xml:"powerTransmissionChanges"`
+	Extra
 	PowerTransmissionChange []PowerTransmissionChange
+	Unknown
 }
 
 type RadiusChange struct {
@@ -303,11 +457,16 @@ type RadiusChange struct {
 	Radius                     string   `xml:"radius,attr,omitempty"`
 	Superelevation             string   `xml:"superelevation,attr,omitempty"`
 	GeometryElementDescription string   `xml:"geometryElementDescription,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type RadiusChanges struct {
-	XMLName      xml.Name `xml:"radiusChanges"`
+	XMLName xml.Name `xml:"radiusChanges"`
+	Extra
 	RadiusChange []RadiusChange
+	Unknown
 }
 
 type ServiceSection struct {
@@ -331,11 +490,16 @@ type ServiceSection struct {
 	Fueling                 string   `xml:"fueling,attr,omitempty"`
 	Parking                 string   `xml:"parking,attr,omitempty"`
 	Preheating              string   `xml:"preheating,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type ServiceSections struct {
-	XMLName        xml.Name `xml:"serviceSections"`
+	XMLName xml.Name `xml:"serviceSections"`
+	Extra
 	ServiceSection []ServiceSection
+	Unknown
 }
 
 type SpeedChange struct {
@@ -353,11 +517,16 @@ type SpeedChange struct {
 	TrainRelation string   `xml:"trainRelation,attr,omitempty"`
 	MandatoryStop string   `xml:"mandatoryStop,attr,omitempty"`
 	Signalised    string   `xml:"signalised,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type SpeedChanges struct {
-	XMLName     xml.Name `xml:"speedChanges"`
+	XMLName xml.Name `xml:"speedChanges"`
+	Extra
 	SpeedChange []SpeedChange
+	Unknown
 }
 
 type TrackCondition struct {
@@ -371,11 +540,16 @@ type TrackCondition struct {
 	Dir         string   `xml:"dir,attr,omitempty"`
 	Length      string   `xml:"length,attr,omitempty"`
 	Type        string   `xml:"type,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type TrackConditions struct {
-	XMLName        xml.Name `xml:"trackConditions"`
+	XMLName xml.Name `xml:"trackConditions"`
+	Extra
 	TrackCondition []TrackCondition
+	Unknown
 }
 
 type TrainProtectionChange struct {
@@ -389,11 +563,16 @@ type TrainProtectionChange struct {
 	Dir         string   `xml:"dir,attr,omitempty"`
 	Medium      string   `xml:"medium,attr,omitempty"`
 	Monitoring  string   `xml:"monitoring,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type TrainProtectionChanges struct {
-	XMLName               xml.Name `xml:"trainProtectionChanges"`
+	XMLName xml.Name `xml:"trainProtectionChanges"`
+	Extra
 	TrainProtectionChange []TrainProtectionChange
+	Unknown
 }
 
 type Tunnel struct {
@@ -408,15 +587,21 @@ type Tunnel struct {
 	Length       string   `xml:"length,attr,omitempty"`
 	CrossSection string   `xml:"crossSection,attr,omitempty"`
 	Kind         string   `xml:"kind,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type Tunnels struct {
 	XMLName xml.Name `xml:"tunnels"`
-	Tunnel  []Tunnel
+	Extra
+	Tunnel []Tunnel
+	Unknown
 }
 
 type TrackElements struct {
-	XMLName                  xml.Name           `xml:"trackElements"`
+	XMLName xml.Name `xml:"trackElements"`
+	Extra
 	AxleWeightChanges        *AxleWeightChanges // pointer to the struct means that if there is no object by default (empty parent), must by created by passing a reference
 	Bridges                  *Bridges
 	ClearanceGaugeChanges    *ClearanceGaugeChanges
@@ -434,6 +619,8 @@ type TrackElements struct {
 	TrackConditions          *TrackConditions
 	TrainProtectionChanges   *TrainProtectionChanges
 	Tunnels                  *Tunnels
+	GeoMappings              *GeoMappings
+	Unknown
 }
 
 type Signal struct {
@@ -453,14 +640,65 @@ type Signal struct {
 	RuleCode      string   `xml:"ruleCode,attr,omitempty"`
 	TrackDist     string   `xml:"trackDist,attr,omitempty"`
 	Height        string   `xml:"height,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type Signals struct {
 	XMLName xml.Name `xml:"signals"`
-	Signal  []Signal
+	Extra
+	Signal []Signal
+	Unknown
+}
+
+type TrainDetector struct {
+	XMLName            xml.Name `xml:"trainDetector"`
+	Id                 string   `xml:"id,attr"`
+	Code               string   `xml:"code,attr,omitempty"`
+	Name               string   `xml:"name,attr,omitempty"`
+	Description        string   `xml:"description,attr,omitempty"`
+	Pos                string   `xml:"pos,attr,omitempty"`
+	AbsPos             string   `xml:"absPos,attr,omitempty"`
+	Dir                string   `xml:"dir,attr,omitempty"`
+	OcpStationRef      string   `xml:"ocpStationRef,attr,omitempty"`
+	ControllerRef      string   `xml:"controllerRef,attr,omitempty"`
+	DetectionObject    string   `xml:"detectionObject,attr,omitempty"`
+	Medium             string   `xml:"medium,attr,omitempty"`
+	PostProcessing     string   `xml:"postProcessing,attr,omitempty"`
+	Type               string   `xml:"type,attr,omitempty"`
+	AxleCounting       string   `xml:"axleCounting,attr,omitempty"`
+	DirectionDetection string   `xml:"directionDetection,attr,omitempty"`
+	Model              string   `xml:"model,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
+}
+
+type TrackCircuitBorder struct {
+	XMLName       xml.Name `xml:"trackCircuitBorder"`
+	Id            string   `xml:"id,attr"`
+	Code          string   `xml:"code,attr,omitempty"`
+	Name          string   `xml:"name,attr,omitempty"`
+	Description   string   `xml:"description,attr,omitempty"`
+	Pos           string   `xml:"pos,attr,omitempty"`
+	AbsPos        string   `xml:"absPos,attr,omitempty"`
+	Dir           string   `xml:"dir,attr,omitempty"`
+	OcpStationRef string   `xml:"ocpStationRef,attr,omitempty"`
+	ControllerRef string   `xml:"controllerRef,attr,omitempty"`
+	InsulatedRail string   `xml:"insulatedRail,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
-// TrainDetectionElements skipped for now
+type TrainDetectionElements struct {
+	XMLName xml.Name `xml:"trainDetectionElements"`
+	Extra
+	TrainDetector      []TrainDetector
+	TrackCircuitBorder []TrackCircuitBorder
+	Unknown
+}
 
 type Balise struct {
 	XMLName                xml.Name `xml:"balise"`
@@ -478,11 +716,16 @@ type Balise struct {
 	LinkReactionDescending string   `xml:"linkReactionDescending,attr,omitempty"`
 	StaticTelegram         string   `xml:"staticTelegram,attr,omitempty"`
 	Ndx                    string   `xml:"ndx,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type Balises struct {
 	XMLName xml.Name `xml:"balises"`
-	Balise  []Balise
+	Extra
+	Balise []Balise
+	Unknown
 }
 
 type TrainProtectionElement struct {
@@ -498,11 +741,16 @@ type TrainProtectionElement struct {
 	System                string   `xml:"system,attr,omitempty"`
 	TrainProtectionSystem string   `xml:"trainProtectionSystem,attr,omitempty"`
 	Model                 string   `xml:"model,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type TrainProtectionElements struct {
-	XMLName                xml.Name `xml:"trainProtectionElements"`
+	XMLName xml.Name `xml:"trainProtectionElements"`
+	Extra
 	TrainProtectionElement []TrainProtectionElement
+	Unknown
 }
 
 type StopPost struct {
@@ -518,16 +766,21 @@ type StopPost struct {
 	TrainRelation     string   `xml:"trainRelation,attr,omitempty"`
 	PlatformEdgeRef   string   `xml:"platformEdgeRef,attr,omitempty"`
 	TrainLength       string   `xml:"trainLength,attr,omitempty"`
-	AxleCount         string   `xml:"axleCoount,attr,omitempty"`
+	AxleCount         string   `xml:"axleCount,attr,omitempty"`
 	WagonCount        string   `xml:"wagonCount,attr,omitempty"`
 	VerbalConstraints string   `xml:"verbalConstraints,attr,omitempty"`
 	Virtual           string   `xml:"virtual,attr,omitempty"`
 	OcpRef            string   `xml:"ocpRef,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type StopPosts struct {
-	XMLName  xml.Name `xml:"stopPosts"`
+	XMLName xml.Name `xml:"stopPosts"`
+	Extra
 	StopPost []StopPost
+	Unknown
 }
 
 type Derailer struct {
@@ -543,11 +796,16 @@ type Derailer struct {
 	DerailSide  string   `xml:"derailSide,attr,omitempty"`
 	Kind        string   `xml:"kind,attr,omitempty"`
 	Model       string   `xml:"model,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type Derailers struct {
-	XMLName  xml.Name `xml:"derailers"`
+	XMLName xml.Name `xml:"derailers"`
+	Extra
 	Derailer []Derailer
+	Unknown
 }
 
 type TrainRadioChange struct {
@@ -566,29 +824,39 @@ type TrainRadioChange struct {
 	TextMessageService   string   `xml:"textMessageService,attr,omitempty"`
 	DirectMode           string   `xml:"directMode,attr,omitempty"`
 	PublicNetworkRoaming string   `xml:"publicNetworkRoaming,attr,omitempty"`
+	Extra
+	GeoCoord *GeoCoord
+	Unknown
 }
 
 type TrainRadioChanges struct {
-	XMLName          xml.Name `xml:"trainRadioChanges"`
+	XMLName xml.Name `xml:"trainRadioChanges"`
+	Extra
 	TrainRadioChange []TrainRadioChange
+	Unknown
 }
 
 type OcsElements struct {
-	XMLName                 xml.Name `xml:"ocsElements"`
+	XMLName xml.Name `xml:"ocsElements"`
+	Extra
 	Signals                 *Signals
+	TrainDetectionElements  *TrainDetectionElements
 	Balises                 *Balises
 	TrainProtectionElements *TrainProtectionElements
 	StopPosts               *StopPosts
 	Derailers               *Derailers
 	TrainRadioChanges       *TrainRadioChanges
+	Unknown
 }
 
 type Track struct {
-	XMLName       xml.Name `xml:"track"`
-	Id            string   `xml:"id,attr"`
+	XMLName xml.Name `xml:"track"`
+	Id      string   `xml:"id,attr"`
+	Extra
 	TrackTopology TrackTopology
 	TrackElements TrackElements
 	OcsElements   OcsElements
+	Unknown
 }
 
 type Tracks struct {
@@ -600,6 +868,8 @@ type AxleWeight struct {
 	XMLName   xml.Name `xml:"axleWeight"`
 	Value     string   `xml:"value,attr,omitempty"`
 	Meterload string   `xml:"meterload,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type Electrification struct {
@@ -607,41 +877,55 @@ type Electrification struct {
 	Type      string   `xml:"type,attr,omitempty"`
 	Voltage   string   `xml:"voltage,attr,omitempty"`
 	Frequency string   `xml:"frequency,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type EpsgCode struct {
 	XMLName     xml.Name `xml:"epsgCode"`
 	Default     string   `xml:"default,attr,omitempty"`
 	ExtraHeight string   `xml:"extraHeight,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type Gauge struct {
 	XMLName xml.Name `xml:"gauge"`
 	Value   string   `xml:"value,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type ClearanceGauge struct {
 	XMLName xml.Name `xml:"clearanceGauge"`
 	Code    string   `xml:"code,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type OperationMode struct {
 	XMLName           xml.Name `xml:"operationMode"`
 	ModeLegislative   string   `xml:"modeLegislative,attr,omitempty"`
 	ModeExecutive     string   `xml:"modeExecutive,attr,omitempty"`
-	ClearanceManaging string   `xml:"clereanceManaging,attr,omitempty"`
+	ClearanceManaging string   `xml:"clearanceManaging,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type Owner struct {
 	XMLName                  xml.Name `xml:"owner"`
 	OwnerName                string   `xml:"ownerName,attr,omitempty"`
 	InfrastructureManagerRef string   `xml:"infrastructureManagerRef,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type PowerTransmission struct {
 	XMLName xml.Name `xml:"powerTransmission"`
 	Type    string   `xml:"type,attr,omitempty"`
 	Style   string   `xml:"style,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type Speed struct {
@@ -651,11 +935,15 @@ type Speed struct {
 	ProfileRef        string   `xml:"profileRef,attr,omitempty"`
 	Status            string   `xml:"status,attr,omitempty"`
 	VMax              string   `xml:"vMax,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type Speeds struct {
 	XMLName xml.Name `xml:"speeds"`
-	Speed   []Speed
+	Extra
+	Speed []Speed
+	Unknown
 }
 
 type TrainRadio struct {
@@ -667,28 +955,42 @@ type TrainRadio struct {
 	TextMessageService   string   `xml:"textMessageService,attr,omitempty"`
 	DirectMode           string   `xml:"directMode,attr,omitempty"`
 	PublicNetworkRoaming string   `xml:"publicNetworkRoaming,attr,omitempty"`
+	Extra
+	Unknown
 }
 
 type TrainProtection struct {
 	XMLName    xml.Name `xml:"trainProtection"`
 	Medium     string   `xml:"medium,attr,omitempty"`
 	Monitoring string   `xml:"monitoring,attr,omitempty"`
+	Extra
+	Unknown
+}
+
+// Element is an XML element with any attributes and child elements, for sections without a fixed structure
+type Element struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []Element
 }
 
 type InfraAttributes struct {
-	XMLName           xml.Name `xml:"infraAttributes"`
-	Id                string   `xml:"id,attr"`
-	Speeds            *Speeds
-	AxleWeight        *AxleWeight
-	Electrification   *Electrification
-	EpsgCode          *EpsgCode
-	Gauge             *Gauge
-	ClearanceGauge    *ClearanceGauge
-	OperationMode     *OperationMode
-	Owner             *Owner
-	PowerTransmission *PowerTransmission
-	TrainRadio        *TrainRadio
-	TrainProtection   *TrainProtection
+	XMLName xml.Name `xml:"infraAttributes"`
+	Id      string   `xml:"id,attr"`
+	Extra
+	GeneralInfraAttributes *Element
+	Speeds                 *Speeds
+	AxleWeight             *AxleWeight
+	Electrification        *Electrification
+	EpsgCode               *EpsgCode
+	Gauge                  *Gauge
+	ClearanceGauge         *ClearanceGauge
+	OperationMode          *OperationMode
+	Owner                  *Owner
+	PowerTransmission      *PowerTransmission
+	TrainRadio             *TrainRadio
+	TrainProtection        *TrainProtection
+	Unknown
 }
 
 type InfraAttrGroups struct {
@@ -696,110 +998,248 @@ type InfraAttrGroups struct {
 	InfraAttributes []InfraAttributes
 }
 
+type PropOperational struct {
+	XMLName              xml.Name `xml:"propOperational"`
+	EnsuresTrainSequence string   `xml:"ensuresTrainSequence,attr,omitempty"`
+	OrderChangeable      string   `xml:"orderChangeable,attr,omitempty"`
+	OperationalType      string   `xml:"operationalType,attr,omitempty"`
+	TrafficType          string   `xml:"trafficType,attr,omitempty"`
+	Extra
+	Unknown
+}
+
+type PropService struct {
+	XMLName          xml.Name `xml:"propService"`
+	Passenger        string   `xml:"passenger,attr,omitempty"`
+	Service          string   `xml:"service,attr,omitempty"`
+	Ship             string   `xml:"ship,attr,omitempty"`
+	Bus              string   `xml:"bus,attr,omitempty"`
+	GoodsLoading     string   `xml:"goodsLoading,attr,omitempty"`
+	GoodsSiding      string   `xml:"goodsSiding,attr,omitempty"`
+	GoodsIntermodal  string   `xml:"goodsIntermodal,attr,omitempty"`
+	GoodsMarshalling string   `xml:"goodsMarshalling,attr,omitempty"`
+	Extra
+	Unknown
+}
+
+type Ocp struct {
+	XMLName      xml.Name `xml:"ocp"`
+	Id           string   `xml:"id,attr"`
+	Code         string   `xml:"code,attr,omitempty"`
+	Name         string   `xml:"name,attr,omitempty"`
+	Description  string   `xml:"description,attr,omitempty"`
+	Type         string   `xml:"type,attr,omitempty"`
+	ParentOcpRef string   `xml:"parentOcpRef,attr,omitempty"`
+	Extra
+	PropOperational *PropOperational
+	PropService     *PropService
+	GeoCoord        *GeoCoord
+	Unknown
+}
+
+type OperationControlPoints struct {
+	XMLName xml.Name `xml:"operationControlPoints"`
+	Ocp     []Ocp
+}
+
 type Infrastructure struct {
-	XMLName         xml.Name `xml:"infrastructure"`
-	Id              string   `xml:"id,attr"`
-	Name            string   `xml:"name,attr,omitempty"`
-	InfraAttrGroups []InfraAttrGroups
-	Tracks          Tracks
+	XMLName xml.Name `xml:"infrastructure"`
+	Id      string   `xml:"id,attr"`
+	Name    string   `xml:"name,attr,omitempty"`
+	Extra
+	InfraAttrGroups        []InfraAttrGroups
+	Tracks                 Tracks
+	OperationControlPoints *OperationControlPoints
+	Unknown
 }
 
 type Metadata struct {
 	XMLName xml.Name `xml:"metadata"`
-	Source  string   `xml:"dc:source"`
-	Creator string   `xml:"dc:creator"`
-	Date    string   `xml:"dc:date"`
+	Dc      string   `xml:"xmlns:dc,attr,omitempty"`
+	Source  string   `xml:"dc:source,omitempty"`
+	Creator string   `xml:"dc:creator,omitempty"`
+	Date    string   `xml:"dc:date,omitempty"`
+	Extra
+	Unknown
 }
 
 type Railml struct {
-	XMLName        xml.Name `xml:"railml"`
-	Version        string   `xml:"version,attr"`
-	Xmlns          string   `xml:"xmlns,attr"`
-	Xsi            string   `xml:"xmlns:xsi,attr"`
-	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	XMLName        xml.Name   `xml:"railml"`
+	Version        string     `xml:"version,attr"`
+	Xmlns          string     `xml:"xmlns,attr"`
+	Xsi            string     `xml:"xmlns:xsi,attr"`
+	SchemaLocation string     `xml:"xsi:schemaLocation,attr,omitempty"`
+	Namespaces     []xml.Attr `xml:",any,attr"` // of railML extensions used in the imported file
 	Metadata       Metadata
 	Infrastructure Infrastructure
 }
 
-func ExportLine(lineId string) interface{} {
-	db := config.GetDBConnection()
-	query := "MATCH (n:Line {id:{lineId}})-[:HAS_TRACK]-(t) RETURN t.id"
-	tid := []struct {
-		ID string `json:"t.id"`
-	}{}
-	cq := neoism.CypherQuery{
-		Statement:  query,
-		Parameters: neoism.Props{"lineId": lineId},
-		Result:     &tid,
+// ExportLine exports the stored line to railML, with the coordinates in the given CRS.
+// If epsg is empty, the CRS the line was imported with is used.
+func ExportLine(s store.GraphStore, lineId string, epsg string) (Railml, error) {
+	lines, err := s.FindNodes("Line", store.Props{"id": lineId})
+	if err != nil {
+		return Railml{}, err
+	}
+	if len(lines) == 0 {
+		return Railml{}, &store.NotFoundError{Label: "Line", ID: lineId}
 	}
 
-	err := db.Cypher(&cq)
-	if err != nil {
-		panic(err)
+	if epsg == "" {
+		epsg, _ = lines[0].Props["epsg"].(string)
+	}
+	if epsg == "" {
+		epsg = "4326"
 	}
 
 	ts := Tracks{}
-	for _, t := range tid {
-		v := ExportTrack(t.ID, lineId)
-		ts.Tracks = append(ts.Tracks, v)
+	iag := []InfraAttrGroups{}
+	var ocps *OperationControlPoints
+	var ns []xml.Attr
+	for _, ln := range lines {
+		ns = append(ns, namespaces(ln)...)
+		tracks, err := s.Neighbours(ln.ID, "HAS_TRACK")
+		if err != nil {
+			return Railml{}, err
+		}
+		for _, t := range tracks {
+			v, err := ExportTrack(s, t.Node)
+			if err != nil {
+				return Railml{}, err
+			}
+			ts.Tracks = append(ts.Tracks, v)
 
+		}
+		ia, err := ExportInfraAttrs(s, ln)
+		if err != nil {
+			return Railml{}, err
+		}
+		iag = append(iag, ia...)
+		o, err := ExportOcps(s, ln)
+		if err != nil {
+			return Railml{}, err
+		}
+		if len(o) > 0 {
+			if ocps == nil {
+				ocps = &OperationControlPoints{}
+			}
+			ocps.Ocp = append(ocps.Ocp, o...)
+		}
 	}
-	iag := ExportInfraAttrs(lineId)
-	in := Infrastructure{
-		Id:              lineId + "-" + time.Now().Format("20060102150405"), // UNIX timestamp format
-		Name:            lineId,
-		InfraAttrGroups: iag,
-		Tracks:          ts,
+	// the <infrastructure /> and the <metadata /> of the imported file are kept, lines without them get new ones
+	in := Infrastructure{}
+	if kept := keptProps(lines[0], utils.Infrastructure); len(kept) > 0 {
+		createElementFromProps(kept, &in)
+	} else {
+		in.Id = lineId + "-" + time.Now().Format("20060102150405") // UNIX timestamp format
+		in.Name = lineId
 	}
-	meta := Metadata{
-		Source:  "GoSAFE Converter v0.1",
-		Creator: "Damian Harasymczuk harasymczuk_at_contecht.eu",
-		Date:    time.Now().Format("2006-01-02 15:04:05"),
+	in.InfraAttrGroups = iag
+	in.Tracks = ts
+	in.OperationControlPoints = ocps
+	meta := Metadata{}
+	if kept := keptProps(lines[0], utils.Metadata); len(kept) > 0 {
+		createElementFromProps(kept, &meta)
+	} else {
+		meta = Metadata{
+			Dc:      "http://purl.org/dc/elements/1.1/",
+			Source:  "GoSAFE Converter v0.1",
+			Creator: "Damian Harasymczuk harasymczuk_at_contecht.eu",
+			Date:    time.Now().Format("2006-01-02 15:04:05"),
+		}
 	}
+	version, xmlns, location := rootAttributes(lines[0])
 	rm := Railml{
-		Version:        "2.2",
-		Xmlns:          "http://www.railml.org/schemas/2013",
+		Version:        version,
+		Xmlns:          xmlns,
 		Xsi:            "http://www.w3.org/2001/XMLSchema-instance",
-		SchemaLocation: "http://www.railml.org/schemas/2013 http://schemas.railml.org/2013/railML-2.2/railML.xsd",
+		SchemaLocation: location,
+		Namespaces:     ns,
 		Metadata:       meta,
 		Infrastructure: in,
 	}
+	if err := reproject(&rm, epsg); err != nil {
+		return Railml{}, err
+	}
 
-	return rm
+	return rm, nil
 }
 
-type UnmarshalledTrack struct {
-	Track        neoism.Node         `json:"t"`
-	Relationship neoism.Relationship `json:"r"`
-	Node         neoism.Node         `json:"n"`
-	Label        []string            `json:"labels(n)"`
-	Connection   neoism.Node         `json:"c"`
+// keptProps returns the properties of the line that start with the prefix, without it, e.g. utils.Infrastructure
+func keptProps(ln store.Node, prefix string) store.Props {
+	props := store.Props{}
+	for k, v := range ln.Props {
+		if strings.HasPrefix(k, prefix) {
+			props[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+	return props
 }
 
-func ExportTrack(id string, line string) Track {
-	db := config.GetDBConnection()
-	query := "MATCH (l:Line {id:{lineId}})-[]-(t:Track {id:{trackId}})-[r:BEGINS|ENDS|HAS_TRACK_ELEMENT|HAS_OCS_ELEMENT|HAS_SWITCH|HAS_CROSSING]-(n)-[:HAS_CONNECTION*0..1]-(c) RETURN t,r,n,labels(n),c"
-	track := []UnmarshalledTrack{}
-	cq := neoism.CypherQuery{
-		Statement:  query,
-		Parameters: neoism.Props{"trackId": id, "lineId": line},
-		Result:     &track,
+// rootAttributes returns the railML version, namespace and schema location the line was imported with,
+// those of railML 2.2 if the file had none of them
+func rootAttributes(ln store.Node) (version, xmlns, location string) {
+	version, _ = ln.Props["railmlVersion"].(string)
+	xmlns, _ = ln.Props["xmlns"].(string)
+	location, _ = ln.Props["xsi:schemaLocation"].(string)
+	if version == "" && xmlns == "" && location == "" {
+		return "2.2", "http://www.railml.org/schemas/2013", "http://www.railml.org/schemas/2013 http://schemas.railml.org/2013/railML-2.2/railML.xsd"
+	}
+	if version == "" {
+		version = "2.2"
+	}
+	if xmlns == "" {
+		xmlns = "http://www.railml.org/schemas/2013"
 	}
+	return version, xmlns, location
+}
 
-	e := db.Cypher(&cq)
-	_ = e
+// namespaces returns the namespace declarations that were kept from the root of the imported file,
+// except for those every export declares anyway
+func namespaces(ln store.Node) []xml.Attr {
+	keys := []string{}
+	for k := range ln.Props {
+		if strings.HasPrefix(k, "xmlns:") && k != "xmlns:xsi" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	ns := []xml.Attr{}
+	for _, k := range keys {
+		if v, ok := ln.Props[k].(string); ok {
+			ns = append(ns, xml.Attr{Name: xml.Name{Local: k}, Value: v})
+		}
+	}
+	return ns
+}
+
+// TrackNeighbour is a node related to the track, for switches and crossings together with their <connection />s
+type TrackNeighbour struct {
+	store.Neighbour
+	Connections []store.Node
+}
+
+func ExportTrack(s store.GraphStore, tn store.Node) (Track, error) {
+	nb, err := s.Neighbours(tn.ID, "BEGINS", "ENDS", "HAS_TRACK_ELEMENT", "HAS_OCS_ELEMENT", "HAS_SWITCH", "HAS_CROSSING", "HAS_MILEAGE_CHANGE", "HAS_CROSS_SECTION")
+	if err != nil {
+		return Track{}, err
+	}
 
 	xtb := TrackEdge{XMLName: xml.Name{Local: "trackBegin"}}
 	xte := TrackEdge{XMLName: xml.Name{Local: "trackEnd"}}
 	xc := Connections{}
+	var xmc *MileageChanges
+	var xcs *CrossSections
 	xtel := TrackElements{}
 	xoel := OcsElements{}
 
-	for _, t := range track {
-		if len(t.Label) < 1 {
+	for _, n := range nb {
+		if len(n.Node.Labels) < 1 {
 			continue
 		}
-		lb := t.Label[0]
+		t := TrackNeighbour{Neighbour: n}
+		lb := t.Node.Labels[0]
 		ty := t.Relationship.Type
 		switch ty {
 		case "BEGINS":
@@ -811,83 +1251,75 @@ func ExportTrack(id string, line string) Track {
 		case "HAS_OCS_ELEMENT":
 			createOcsElement(lb, &xoel, t)
 		case "HAS_SWITCH", "HAS_CROSSING":
-			if t.Node.Data["id"] != t.Connection.Data["id"] { // weird behaviour query returns track-switch-connection and track-switch-switch - to investigate
-				createConnection(lb, &xc, t)
+			cs, err := s.Neighbours(t.Node.ID, "HAS_CONNECTION")
+			if err != nil {
+				return Track{}, err
 			}
+			sort.SliceStable(cs, func(i, j int) bool {
+				return index(cs[i].Relationship) < index(cs[j].Relationship)
+			})
+			for _, c := range cs {
+				t.Connections = append(t.Connections, c.Node)
+			}
+			createConnection(lb, &xc, t)
+		case "HAS_MILEAGE_CHANGE":
+			if xmc == nil {
+				xmc = &MileageChanges{}
+			}
+			nmc := &MileageChange{}
+			xmc.MileageChange = append(
+				xmc.MileageChange,
+				*createElementFromNode(&t.Node, nmc).(*MileageChange),
+			)
+		case "HAS_CROSS_SECTION":
+			if xcs == nil {
+				xcs = &CrossSections{}
+			}
+			ncs := &CrossSection{}
+			xcs.CrossSection = append(
+				xcs.CrossSection,
+				*createElementFromNode(&t.Node, ncs).(*CrossSection),
+			)
 		}
 	}
-	xtt := TrackTopology{TrackBegin: xtb, TrackEnd: xte, Connections: xc}
-	xt := Track{Id: id, TrackTopology: xtt, TrackElements: xtel, OcsElements: xoel}
-
-	return xt
-}
-
-// <infraAttrGroups />
-type UnmarshalledGroup struct {
-	ID int `json:"ID(g)"`
-}
-
-// <infraAttributes />
-type UnmarshalledInfraAttrs struct {
-	AttrGroup neoism.Node `json:"a"`
-}
-
-// <speeds />, <gauge /> etc.
-type UnmarshalledAttrsGroup struct {
-	Node  neoism.Node `json:"n"`
-	Label []string    `json:"labels(n)"`
-	ID    int         `json:"ID(n)"`
-}
+	xtt := TrackTopology{TrackBegin: xtb, TrackEnd: xte, MileageChanges: xmc, Connections: xc, CrossSections: xcs}
+	xt := createElementFromNode(&tn, &Track{TrackTopology: xtt, TrackElements: xtel, OcsElements: xoel}).(*Track)
+	xt.TrackElements.GeoMappings = createGeoMappings(tn, xt.Id)
+	setContainerFragments(tn, &xt.TrackTopology)
+	setContainerFragments(tn, &xt.TrackElements)
+	setContainerFragments(tn, &xt.OcsElements)
 
-// <speed />
-type UnmarshalledSpeeds struct {
-	Node neoism.Node `json:"s"`
+	return *xt, nil
 }
 
 // INFRA ATTR GROUPS
-func ExportInfraAttrs(id string) []InfraAttrGroups {
-	db := config.GetDBConnection()
-	query := "MATCH (l:Line {id:{lineId}})-[r:HAS_ATTR_GROUP]-(g:InfraAttrGroup) RETURN ID(g)"
-	ug := []UnmarshalledGroup{}
-	cq := neoism.CypherQuery{
-		Statement:  query,
-		Parameters: neoism.Props{"lineId": id},
-		Result:     &ug,
+func ExportInfraAttrs(s store.GraphStore, ln store.Node) ([]InfraAttrGroups, error) {
+	ug, err := s.Neighbours(ln.ID, "HAS_ATTR_GROUP") // <infraAttrGroups />
+	if err != nil {
+		return nil, err
 	}
 
-	e := db.Cypher(&cq)
-	_ = e
-
 	xiags := []InfraAttrGroups{} // <infraAttrGroups /> - no idea how much times this section can occur, so it is an array
 	for _, i := range ug {
-		xiag := InfraAttrGroups{}                                                                                   // <infraAttrGroups />
-		query2 := "MATCH (g:InfraAttrGroup)-[r:HAS_INFRA_ATTRS]-(a:InfraAttributes) WHERE ID(g)={groupId} RETURN a" // get all <infraAttributes/> from the given <infraAttrGroups/>
-		uia := []UnmarshalledInfraAttrs{}
-		cq2 := neoism.CypherQuery{
-			Statement:  query2,
-			Parameters: neoism.Props{"groupId": i.ID},
-			Result:     &uia,
+		xiag := InfraAttrGroups{}                              // <infraAttrGroups />
+		uia, err := s.Neighbours(i.Node.ID, "HAS_INFRA_ATTRS") // get all <infraAttributes/> from the given <infraAttrGroups/>
+		if err != nil {
+			return nil, err
 		}
 
-		e := db.Cypher(&cq2)
-		_ = e
-
 		for _, ia := range uia {
 			xia := &InfraAttributes{} // <infraAttributes/>
 
-			iaid := ia.AttrGroup.Data["id"]
-			query3 := "MATCH (g:InfraAttrGroup)-[]-(a:InfraAttributes {id:{iaid}})-[r:INFRA_ATTR]-(n) WHERE ID(g)={groupId}  RETURN n, ID(n), labels(n)" // get all groups from the given <infraAttributes/>
-			uag := []UnmarshalledAttrsGroup{}
-			cq3 := neoism.CypherQuery{
-				Statement:  query3,
-				Parameters: neoism.Props{"iaid": iaid, "groupId": i.ID},
-				Result:     &uag,
+			uag, err := s.Neighbours(ia.Node.ID, "INFRA_ATTR") // get all groups from the given <infraAttributes/>
+			if err != nil {
+				return nil, err
 			}
-			e := db.Cypher(&cq3)
-			_ = e
 
 			for _, j := range uag { // iterate over array of groups
-				switch j.Label[0] { // create group ex. <axleWeight/>, <gauge/> and only the <speeds/> has childs
+				if len(j.Node.Labels) < 1 {
+					continue
+				}
+				switch j.Node.Labels[0] { // create group ex. <axleWeight/>, <gauge/> and only the <speeds/> has childs
 				case "AxleWeight":
 					aw := &AxleWeight{}
 					xia.AxleWeight = createElementFromNode(&j.Node, aw).(*AxleWeight)
@@ -913,16 +1345,11 @@ func ExportInfraAttrs(id string) []InfraAttrGroups {
 					pt := &PowerTransmission{}
 					xia.PowerTransmission = createElementFromNode(&j.Node, pt).(*PowerTransmission)
 				case "Speeds":
-					ns := &Speeds{}                                                            // <speeds/>
-					query4 := "MATCH (n:Speeds)-[r:HAS_SPEED]-(s) WHERE ID(n)={sid}  RETURN s" // grabs all <speed/> from the given <speeds/>
-					us := []UnmarshalledSpeeds{}
-					cq4 := neoism.CypherQuery{
-						Statement:  query4,
-						Parameters: neoism.Props{"sid": j.ID},
-						Result:     &us,
+					ns := &Speeds{}                                 // <speeds/>
+					us, err := s.Neighbours(j.Node.ID, "HAS_SPEED") // grabs all <speed/> from the given <speeds/>
+					if err != nil {
+						return nil, err
 					}
-					e := db.Cypher(&cq4)
-					_ = e
 					for _, k := range us {
 						ss := &Speed{} // <speed/>
 						ns.Speed = append(
@@ -937,35 +1364,65 @@ func ExportInfraAttrs(id string) []InfraAttrGroups {
 				case "TrainProtection":
 					tp := &TrainProtection{}
 					xia.TrainProtection = createElementFromNode(&j.Node, tp).(*TrainProtection)
+				case "GeneralInfraAttributes":
+					gia, err := createNestedElement(s, j.Node) // <generalInfraAttributes/> with all its descendants
+					if err != nil {
+						return nil, err
+					}
+					xia.GeneralInfraAttributes = gia
 				}
 			}
 
 			xiag.InfraAttributes = append(
 				xiag.InfraAttributes,
-				*createElementFromNode(&ia.AttrGroup, xia).(*InfraAttributes),
+				*createElementFromNode(&ia.Node, xia).(*InfraAttributes),
 			)
 
 		}
 		xiags = append(xiags, xiag)
 	}
-	return xiags
+	return xiags, nil
 }
 
-// TRACK TOPOLOGIES
-func createTrackEdge(lb string, xteg *TrackEdge, t UnmarshalledTrack) {
-	rel := t.Relationship.Data.(map[string]interface{})
-	if id := rel["id"]; id != nil {
-		xteg.Id = id.(string)
-	}
-	if pos := rel["pos"]; pos != nil {
-		xteg.Pos = pos.(string)
-	}
-	if apos := rel["absPos"]; apos != nil {
-		xteg.AbsPos = apos.(string)
+// OPERATION CONTROL POINTS
+func ExportOcps(s store.GraphStore, ln store.Node) ([]Ocp, error) {
+	uo, err := s.Neighbours(ln.ID, "HAS_OCP") // all <ocp/> of the line
+	if err != nil {
+		return nil, err
 	}
-	if adir := rel["absDir"]; adir != nil {
-		xteg.AbsDir = adir.(string)
+
+	xocps := []Ocp{}
+	for _, o := range uo {
+		xo := &Ocp{}
+
+		up, err := s.Neighbours(o.Node.ID, "OCP_PROP") // <propOperational/> and <propService/>
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range up {
+			if len(p.Node.Labels) < 1 {
+				continue
+			}
+			switch p.Node.Labels[0] {
+			case "PropOperational":
+				po := &PropOperational{}
+				xo.PropOperational = createElementFromNode(&p.Node, po).(*PropOperational)
+			case "PropService":
+				ps := &PropService{}
+				xo.PropService = createElementFromNode(&p.Node, ps).(*PropService)
+			}
+		}
+
+		xocps = append(xocps, *createElementFromNode(&o.Node, xo).(*Ocp))
 	}
+	return xocps, nil
+}
+
+// TRACK TOPOLOGIES
+func createTrackEdge(lb string, xteg *TrackEdge, t TrackNeighbour) {
+	// <trackBegin/> and <trackEnd/> attributes are kept in the relationship, the <geoCoord/> in the node
+	createElementFromProps(t.Relationship.Props, xteg)
+	xteg.GeoCoord = createGeoCoord(t.Node.Props)
 	switch lb {
 	case "BufferStop":
 		nbs := &BufferStop{}
@@ -995,7 +1452,7 @@ func createTrackEdge(lb string, xteg *TrackEdge, t UnmarshalledTrack) {
 }
 
 // createConnection creates an XML connection node inside switch or crossing parent
-func createConnection(lb string, xc *Connections, t UnmarshalledTrack) {
+func createConnection(lb string, xc *Connections, t TrackNeighbour) {
 	nsc := &SwitchOrCrossing{}
 	switch lb {
 	case "Switch":
@@ -1004,8 +1461,10 @@ func createConnection(lb string, xc *Connections, t UnmarshalledTrack) {
 	case "Crossing":
 		nsc.XMLName = xml.Name{Local: "crossing"}
 	}
-	nco := &Connection{}
-	nsc.Connection = *createElementFromNode(&t.Connection, nco).(*Connection)
+	for i := range t.Connections {
+		nco := &Connection{}
+		nsc.Connection = append(nsc.Connection, *createElementFromNode(&t.Connections[i], nco).(*Connection))
+	}
 	xc.Connections = append(
 		xc.Connections,
 		*createElementFromNode(&t.Node, nsc).(*SwitchOrCrossing),
@@ -1013,7 +1472,7 @@ func createConnection(lb string, xc *Connections, t UnmarshalledTrack) {
 }
 
 // TRACK ELEMENTS
-func createTrackElement(lb string, xtel *TrackElements, t UnmarshalledTrack) {
+func createTrackElement(lb string, xtel *TrackElements, t TrackNeighbour) {
 	switch lb {
 	case "AxleWeightChange":
 		if xtel.AxleWeightChanges == nil {
@@ -1024,7 +1483,7 @@ func createTrackElement(lb string, xtel *TrackElements, t UnmarshalledTrack) {
 			xtel.AxleWeightChanges.AxleWeightChange,
 			*createElementFromNode(&t.Node, nawc).(*AxleWeightChange),
 		)
-	case "Brigde":
+	case "Bridge":
 		if xtel.Bridges == nil {
 			xtel.Bridges = &Bridges{}
 		}
@@ -1172,7 +1631,7 @@ func createTrackElement(lb string, xtel *TrackElements, t UnmarshalledTrack) {
 }
 
 // OCS Elements
-func createOcsElement(lb string, xoel *OcsElements, t UnmarshalledTrack) {
+func createOcsElement(lb string, xoel *OcsElements, t TrackNeighbour) {
 	switch lb {
 	case "Signal":
 		if xoel.Signals == nil {
@@ -1183,6 +1642,24 @@ func createOcsElement(lb string, xoel *OcsElements, t UnmarshalledTrack) {
 			xoel.Signals.Signal,
 			*createElementFromNode(&t.Node, ns).(*Signal),
 		)
+	case "TrainDetector":
+		if xoel.TrainDetectionElements == nil {
+			xoel.TrainDetectionElements = &TrainDetectionElements{}
+		}
+		ntd := &TrainDetector{}
+		xoel.TrainDetectionElements.TrainDetector = append(
+			xoel.TrainDetectionElements.TrainDetector,
+			*createElementFromNode(&t.Node, ntd).(*TrainDetector),
+		)
+	case "TrackCircuitBorder":
+		if xoel.TrainDetectionElements == nil {
+			xoel.TrainDetectionElements = &TrainDetectionElements{}
+		}
+		ntcb := &TrackCircuitBorder{}
+		xoel.TrainDetectionElements.TrackCircuitBorder = append(
+			xoel.TrainDetectionElements.TrackCircuitBorder,
+			*createElementFromNode(&t.Node, ntcb).(*TrackCircuitBorder),
+		)
 	case "Balise":
 		if xoel.Balises == nil {
 			xoel.Balises = &Balises{}
@@ -1210,7 +1687,7 @@ func createOcsElement(lb string, xoel *OcsElements, t UnmarshalledTrack) {
 			xoel.StopPosts.StopPost,
 			*createElementFromNode(&t.Node, nsp).(*StopPost),
 		)
-	case "Derailers":
+	case "Derailer":
 		if xoel.Derailers == nil {
 			xoel.Derailers = &Derailers{}
 		}
@@ -1231,18 +1708,250 @@ func createOcsElement(lb string, xoel *OcsElements, t UnmarshalledTrack) {
 	}
 }
 
-// createElementFromNode converts a neoism.Node to the interface that can be passed as a struct - *nif.(*StructType)
-func createElementFromNode(n *neoism.Node, nif interface{}) interface{} {
-	for k, v := range n.Data {
-		capitalized := strings.Title(k)
-		err := setField(nif, capitalized, v.(string))
+// createNestedElement converts a node and all its HAS_NESTED descendants to XML elements, children keep their original order
+func createNestedElement(s store.GraphStore, n store.Node) (*Element, error) {
+	if len(n.Labels) < 1 {
+		return nil, fmt.Errorf("node %d has no label", n.ID)
+	}
+	lb := n.Labels[0]
+	e := &Element{XMLName: xml.Name{Local: strings.ToLower(lb[:1]) + lb[1:]}}
+
+	keys := []string{}
+	for k := range n.Props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v, ok := n.Props[k].(string); ok {
+			e.Attrs = append(e.Attrs, xml.Attr{Name: xml.Name{Local: k}, Value: v})
+		}
+	}
+
+	nb, err := s.Neighbours(n.ID, "HAS_NESTED")
+	if err != nil {
+		return nil, err
+	}
+	children := []store.Neighbour{}
+	for _, c := range nb {
+		if c.Relationship.Start == n.ID { // not the parent
+			children = append(children, c)
+		}
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		return index(children[i].Relationship) < index(children[j].Relationship)
+	})
+	for _, c := range children {
+		ce, err := createNestedElement(s, c.Node)
 		if err != nil {
+			return nil, err
+		}
+		e.Children = append(e.Children, *ce)
+	}
+	return e, nil
+}
+
+// index returns the position of a nested element among its siblings, numbers read back from the database are floats
+func index(r store.Relationship) float64 {
+	switch i := r.Props["index"].(type) {
+	case int:
+		return float64(i)
+	case int64:
+		return float64(i)
+	case float64:
+		return i
+	}
+	return 0
+}
+
+// internalProps are node properties the converter adds itself, they aren't railML attributes
+var internalProps = map[string]bool{
+	"geometry":         true,
+	graph.LineKey:      true,
+	store.BBoxProperty: true,
+}
+
+// createElementFromNode converts a store.Node to the interface that can be passed as a struct - *nif.(*StructType)
+func createElementFromNode(n *store.Node, nif interface{}) interface{} {
+	return createElementFromProps(n.Props, nif)
+}
+
+// createElementFromProps sets the struct fields from the properties.
+// Properties without a field go to the Extra field of the struct and the kept child elements to the Unknown field,
+// if it has them.
+func createElementFromProps(props store.Props, nif interface{}) interface{} {
+	keys := []string{}
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // extra attributes are always written in the same order
+
+	extra, unknown := Extra{}, Unknown{}
+	for _, k := range keys {
+		s, ok := props[k].(string)
+		if !ok || internalProps[k] {
 			continue
 		}
+		if k == utils.Fragments {
+			unknown.Fragments = s
+			continue
+		}
+		if strings.HasPrefix(k, "#") { // kept by the converter for itself, see utils.Fragments
+			continue
+		}
+		capitalized := strings.Title(k)
+		err := setField(nif, capitalized, s)
+		if err != nil {
+			extra.Attrs = append(extra.Attrs, xml.Attr{Name: xml.Name{Local: k}, Value: s})
+		}
+	}
+
+	if ev := reflect.ValueOf(nif).Elem().FieldByName("Extra"); ev.IsValid() && ev.Type() == reflect.TypeOf(extra) {
+		ev.Set(reflect.ValueOf(extra))
+	}
+	if uv := reflect.ValueOf(nif).Elem().FieldByName("Unknown"); uv.IsValid() && uv.Type() == reflect.TypeOf(unknown) {
+		uv.Set(reflect.ValueOf(unknown))
+	}
+	if gv := reflect.ValueOf(nif).Elem().FieldByName("GeoCoord"); gv.IsValid() && gv.Type() == reflect.TypeOf(&GeoCoord{}) {
+		if gc := createGeoCoord(props); gc != nil {
+			gv.Set(reflect.ValueOf(gc))
+		}
 	}
 	return nif
 }
 
+// setContainerFragments sets the kept child elements of a container of the track and of the containers in it,
+// see utils.ContainerFragments. Containers that are only there because of them are created.
+func setContainerFragments(tn store.Node, c interface{}) {
+	v := reflect.ValueOf(c).Elem()
+	if f, ok := tn.Props[utils.ContainerFragments(xmlTag(v.Type()))].(string); ok {
+		v.FieldByName("Unknown").FieldByName("Fragments").SetString(f)
+	}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		t := f.Type()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || !isContainer(t) {
+			continue
+		}
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				if _, ok := tn.Props[utils.ContainerFragments(xmlTag(t))]; !ok {
+					continue
+				}
+				f.Set(reflect.New(t))
+			}
+			setContainerFragments(tn, f.Interface())
+			continue
+		}
+		setContainerFragments(tn, f.Addr().Interface())
+	}
+}
+
+// xmlTag returns the tag of the XMLName of a struct, empty if it has none
+func xmlTag(t reflect.Type) string {
+	f, ok := t.FieldByName("XMLName")
+	if !ok {
+		return ""
+	}
+	return strings.Split(f.Tag.Get("xml"), ",")[0]
+}
+
+// isContainer reports whether a struct is a container with a tag of its own that can keep child elements
+func isContainer(t reflect.Type) bool {
+	_, ok := t.FieldByName("Unknown")
+	return ok && xmlTag(t) != ""
+}
+
+// createGeoCoord creates a wgs84 <geoCoord/> from the POINT geometry in props, nil if there is none
+// or it was derived from the pos of the element
+func createGeoCoord(props store.Props) *GeoCoord {
+	wkt, ok := props["geometry"].(string)
+	if !ok || props[graph.GeometryDerived] == true {
+		return nil
+	}
+	kind, coords, err := utils.ParseWKT(wkt)
+	if err != nil || kind != "POINT" || len(coords) != 1 {
+		return nil
+	}
+	return &GeoCoord{Coord: coords[0], EpsgCode: "4326"}
+}
+
+// createGeoMappings creates wgs84 <geoMappings/> from the LINESTRING geometry of a track, nil if there is none
+func createGeoMappings(tn store.Node, trackId string) *GeoMappings {
+	wkt, ok := tn.Props["geometry"].(string)
+	if !ok {
+		return nil
+	}
+	kind, coords, err := utils.ParseWKT(wkt)
+	if err != nil || kind != "LINESTRING" {
+		return nil
+	}
+	ids := []string{}
+	if s, ok := tn.Props[utils.GeoMappingIds].(string); ok {
+		ids = strings.Fields(s)
+	}
+	gms := &GeoMappings{}
+	for i, c := range coords {
+		id := fmt.Sprintf("%s_gm%d", trackId, i+1)
+		if len(ids) == len(coords) { // the ids of the imported file, unless the geometry changed since
+			id = ids[i]
+		}
+		gms.GeoMapping = append(gms.GeoMapping, GeoMapping{
+			Id:       id,
+			GeoCoord: &GeoCoord{Coord: c, EpsgCode: "4326"},
+		})
+	}
+	return gms
+}
+
+// reproject transforms all <geoCoord/> of the document from wgs84 to the given CRS
+func reproject(rm *Railml, epsg string) error {
+	var gcs []*GeoCoord
+	collectGeoCoords(reflect.ValueOf(rm), &gcs)
+	if len(gcs) == 0 {
+		return nil
+	}
+
+	coords := make([]string, len(gcs))
+	for i, gc := range gcs {
+		coords[i] = gc.Coord
+	}
+	transformed, err := utils.FromWGS84(coords, epsg)
+	if err != nil {
+		return err
+	}
+	for i, gc := range gcs {
+		gc.Coord = transformed[i]
+		gc.EpsgCode = epsg
+	}
+	return nil
+}
+
+// collectGeoCoords finds all <geoCoord/> in the given part of the document
+func collectGeoCoords(v reflect.Value, gcs *[]*GeoCoord) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if gc, ok := v.Interface().(*GeoCoord); ok {
+			*gcs = append(*gcs, gc)
+			return
+		}
+		collectGeoCoords(v.Elem(), gcs)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			collectGeoCoords(v.Field(i), gcs)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			collectGeoCoords(v.Index(i), gcs)
+		}
+	}
+}
+
 // setField is a simple helper, sets a value in the given struct by its name (string)
 func setField(v interface{}, name string, value string) error {
 	rv := reflect.ValueOf(v)
@@ -1251,10 +1960,11 @@ func setField(v interface{}, name string, value string) error {
 	}
 	rv = rv.Elem()
 
-	fv := rv.FieldByName(name)
-	if !fv.IsValid() {
+	sf, ok := rv.Type().FieldByName(name)
+	if !ok || len(sf.Index) > 1 { // fields of Extra are not attributes
 		return fmt.Errorf("not a field name: %s", name)
 	}
+	fv := rv.FieldByIndex(sf.Index)
 
 	if !fv.CanSet() {
 		return fmt.Errorf("cannot set field %s", name)
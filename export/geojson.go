@@ -0,0 +1,221 @@
+package export
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"Go-GoSAFE.converter/store"
+	"Go-GoSAFE.converter/utils"
+)
+
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Geometry   Geometry               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoJSONFilter selects the features of a GeoJSON export
+type GeoJSONFilter struct {
+	Labels []string    // only nodes with one of the labels, e.g. Track or Signal, all if empty
+	BBox   *[4]float64 // only features that intersect the min x, min y, max x, max y box (wgs84), all if nil
+}
+
+// ExportGeoJSON exports the stored line as a GeoJSON FeatureCollection: tracks are LineStrings,
+// their switches, crossings, elements, begins and ends and the ocps of the line are Points.
+// Nodes without a geometry are left out. All railML attributes go to the properties,
+// together with the label of the node and the id of its track.
+func ExportGeoJSON(s store.GraphStore, lineId string, f GeoJSONFilter) (FeatureCollection, error) {
+	lines, err := s.FindNodes("Line", store.Props{"id": lineId})
+	if err != nil {
+		return FeatureCollection{}, err
+	}
+	if len(lines) == 0 {
+		return FeatureCollection{}, &store.NotFoundError{Label: "Line", ID: lineId}
+	}
+
+	fc := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	for _, ln := range lines {
+		// the store selects the nodes by label and box, the exact intersection is checked below
+		nodes, err := s.Filter(ln.ID, store.NodeFilter{Labels: f.Labels, Has: "geometry", BBox: f.BBox}, geoJSONRels...)
+		if err != nil {
+			return FeatureCollection{}, err
+		}
+		// keep the features of a track together, the ocps of the line come last
+		var tracks []string
+		byTrack := map[string][]Feature{}
+		var ocps []Feature
+		for _, n := range nodes {
+			trackId, _ := n.ParentID.(string)
+			switch n.Relationship.Type {
+			case "HAS_TRACK":
+				trackId, _ = n.Node.Props["id"].(string)
+			case "HAS_OCP":
+				trackId = ""
+			}
+			ft, ok, err := createFeature(n.Node, trackId, f)
+			if err != nil {
+				return FeatureCollection{}, err
+			}
+			if !ok {
+				continue
+			}
+			if n.Relationship.Type == "HAS_OCP" {
+				ocps = append(ocps, ft)
+				continue
+			}
+			if _, ok := byTrack[trackId]; !ok {
+				tracks = append(tracks, trackId)
+			}
+			byTrack[trackId] = append(byTrack[trackId], ft)
+		}
+		for _, t := range tracks {
+			fc.Features = append(fc.Features, byTrack[t]...)
+		}
+		fc.Features = append(fc.Features, ocps...)
+	}
+
+	return fc, nil
+}
+
+// geoJSONRels lead from a line to the nodes that are exported as features
+var geoJSONRels = []string{"HAS_TRACK", "BEGINS", "ENDS", "HAS_SWITCH", "HAS_CROSSING", "HAS_MILEAGE_CHANGE",
+	"HAS_CROSS_SECTION", "HAS_TRACK_ELEMENT", "HAS_OCS_ELEMENT", "HAS_OCP"}
+
+// createFeature converts the node to a feature, ok is false if it has no geometry or the filter leaves it out
+func createFeature(n store.Node, trackId string, f GeoJSONFilter) (Feature, bool, error) {
+	if len(n.Labels) < 1 || !hasAnyLabel(n, f.Labels) {
+		return Feature{}, false, nil
+	}
+	wkt, ok := n.Props["geometry"].(string)
+	if !ok {
+		return Feature{}, false, nil
+	}
+	kind, coords, err := utils.ParseWKT(wkt)
+	if err != nil {
+		return Feature{}, false, fmt.Errorf("%s %v: %v", n.Labels[0], n.Props["id"], err)
+	}
+	points := make([][]float64, len(coords))
+	for i, c := range coords {
+		if points[i], err = parseCoord(c); err != nil {
+			return Feature{}, false, fmt.Errorf("%s %v: %v", n.Labels[0], n.Props["id"], err)
+		}
+	}
+	if f.BBox != nil && !intersects(points, *f.BBox) {
+		return Feature{}, false, nil
+	}
+
+	ft := Feature{Type: "Feature", Properties: map[string]interface{}{}}
+	if kind == "POINT" {
+		ft.Geometry = Geometry{Type: "Point", Coordinates: points[0]}
+	} else {
+		ft.Geometry = Geometry{Type: "LineString", Coordinates: points}
+	}
+	for k, v := range n.Props {
+		if internalProps[k] || strings.HasPrefix(k, "#") { // see utils.Fragments
+			continue
+		}
+		ft.Properties[k] = v
+	}
+	ft.ID, _ = n.Props["id"].(string)
+	ft.Properties["label"] = n.Labels[0]
+	if trackId != "" && !n.HasLabel("Track") {
+		ft.Properties["track"] = trackId
+	}
+	return ft, true, nil
+}
+
+// hasAnyLabel reports whether the node has one of the labels, or labels is empty
+func hasAnyLabel(n store.Node, labels []string) bool {
+	if len(labels) == 0 {
+		return true
+	}
+	for _, l := range labels {
+		if n.HasLabel(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCoord parses a "x y" coord
+func parseCoord(c string) ([]float64, error) {
+	s := strings.Fields(c)
+	if len(s) < 2 {
+		return nil, fmt.Errorf("invalid coord %q, expected two numbers", c)
+	}
+	p := make([]float64, len(s))
+	for i := range s {
+		v, err := strconv.ParseFloat(s[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid coord %q: %v", c, err)
+		}
+		p[i] = v
+	}
+	return p, nil
+}
+
+// intersects reports whether the point or the line touches the min x, min y, max x, max y box
+func intersects(points [][]float64, box [4]float64) bool {
+	if len(points) == 1 {
+		return inside(points[0], box)
+	}
+	for i := 1; i < len(points); i++ {
+		if clips(points[i-1], points[i], box) {
+			return true
+		}
+	}
+	return false
+}
+
+func inside(p []float64, box [4]float64) bool {
+	return p[0] >= box[0] && p[1] >= box[1] && p[0] <= box[2] && p[1] <= box[3]
+}
+
+// clips reports whether the segment from a to b crosses the box (Liang-Barsky)
+func clips(a []float64, b []float64, box [4]float64) bool {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t0, t1 := 0.0, 1.0
+	for _, e := range [][2]float64{
+		{-dx, a[0] - box[0]},
+		{dx, box[2] - a[0]},
+		{-dy, a[1] - box[1]},
+		{dy, box[3] - a[1]},
+	} {
+		p, q := e[0], e[1]
+		if p == 0 {
+			if q < 0 {
+				return false // parallel to the edge and outside
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			if t > t1 {
+				return false
+			}
+			if t > t0 {
+				t0 = t
+			}
+		} else {
+			if t < t0 {
+				return false
+			}
+			if t < t1 {
+				t1 = t
+			}
+		}
+	}
+	return true
+}
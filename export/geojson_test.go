@@ -0,0 +1,185 @@
+package export
+
+import (
+	"testing"
+
+	"Go-GoSAFE.converter/store"
+)
+
+func TestClips(t *testing.T) {
+	box := [4]float64{0, 0, 10, 10}
+	tests := []struct {
+		name string
+		a, b []float64
+		want bool
+	}{
+		{"inside", []float64{1, 1}, []float64{2, 2}, true},
+		{"crosses", []float64{-5, 5}, []float64{15, 5}, true},
+		{"enters", []float64{-5, 5}, []float64{5, 5}, true},
+		{"diagonal through a corner", []float64{-1, 1}, []float64{1, -1}, true},
+		{"touches an edge", []float64{10, -5}, []float64{10, 15}, true},
+		{"left of the box", []float64{-5, -5}, []float64{-1, 15}, false},
+		{"above the box", []float64{-5, 11}, []float64{15, 11}, false},
+		{"passes a corner", []float64{-2, 9}, []float64{8, 19}, false},
+		{"touches a corner", []float64{-1, 9}, []float64{9, 19}, true},
+		{"parallel outside", []float64{11, 0}, []float64{11, 10}, false},
+		{"stops short", []float64{-5, 5}, []float64{-1, 5}, false},
+	}
+	for _, tt := range tests {
+		if got := clips(tt.a, tt.b, box); got != tt.want {
+			t.Errorf("%s: clips(%v, %v) = %v, want %v", tt.name, tt.a, tt.b, got, tt.want)
+		}
+		if got := clips(tt.b, tt.a, box); got != tt.want {
+			t.Errorf("%s reversed: clips(%v, %v) = %v, want %v", tt.name, tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestIntersects(t *testing.T) {
+	box := [4]float64{0, 0, 10, 10}
+	tests := []struct {
+		name   string
+		points [][]float64
+		want   bool
+	}{
+		{"point inside", [][]float64{{5, 5}}, true},
+		{"point on the edge", [][]float64{{0, 10}}, true},
+		{"point outside", [][]float64{{5, 11}}, false},
+		{"line with a segment through the box", [][]float64{{-5, -5}, {-5, 5}, {15, 5}}, true},
+		{"line around the box", [][]float64{{-1, -1}, {-1, 11}, {11, 11}, {11, -1}}, false},
+	}
+	for _, tt := range tests {
+		if got := intersects(tt.points, box); got != tt.want {
+			t.Errorf("%s: intersects(%v) = %v, want %v", tt.name, tt.points, got, tt.want)
+		}
+	}
+}
+
+// geoLine stores a line with a track from 50 19 to 50 19.01, a signal and a switch on it and an ocp
+func geoLine(t *testing.T) *store.MemoryStore {
+	s := store.NewMemoryStore()
+	ln, _ := s.CreateNode(store.Props{"id": "L1"}, "Line")
+	node := func(from int, rel string, props store.Props, box []float64, label string) int {
+		if box != nil {
+			props[store.BBoxProperty] = box
+		}
+		n, err := s.CreateNode(props, label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Relate(from, rel, n.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		return n.ID
+	}
+	tr := node(ln.ID, "HAS_TRACK", store.Props{"id": "tr1", "geometry": "LINESTRING (50 19, 50 19.01)"}, []float64{50, 19, 50, 19.01}, "Track")
+	node(tr, "HAS_TRACK_ELEMENT", store.Props{"id": "sig1", "geometry": "POINT (50 19.002)"}, []float64{50, 19.002, 50, 19.002}, "Signal")
+	node(tr, "HAS_SWITCH", store.Props{"id": "sw1", "geometry": "POINT (50 19.008)"}, nil, "Switch") // stored without a box
+	node(tr, "HAS_TRACK_ELEMENT", store.Props{"id": "sig2"}, nil, "Signal")                          // no geometry
+	node(ln.ID, "HAS_OCP", store.Props{"id": "ocp1", "geometry": "POINT (50.5 19.5)"}, []float64{50.5, 19.5, 50.5, 19.5}, "Ocp")
+	return s
+}
+
+func featureIds(fc FeatureCollection) []string {
+	ids := []string{}
+	for _, f := range fc.Features {
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
+func TestExportGeoJSON(t *testing.T) {
+	s := geoLine(t)
+	tests := []struct {
+		name string
+		f    GeoJSONFilter
+		want []string
+	}{
+		{"all", GeoJSONFilter{}, []string{"tr1", "sig1", "sw1", "ocp1"}},
+		{"tracks", GeoJSONFilter{Labels: []string{"Track"}}, []string{"tr1"}},
+		{"signals and ocps", GeoJSONFilter{Labels: []string{"Signal", "Ocp"}}, []string{"sig1", "ocp1"}},
+		{"unknown label", GeoJSONFilter{Labels: []string{"Balise"}}, []string{}},
+		{"box around the signal", GeoJSONFilter{BBox: &[4]float64{49.9, 19.001, 50.1, 19.003}}, []string{"tr1", "sig1"}},
+		{"box around the ocp", GeoJSONFilter{BBox: &[4]float64{50.4, 19.4, 50.6, 19.6}}, []string{"ocp1"}},
+		{"box without a box", GeoJSONFilter{BBox: &[4]float64{49.9, 19.007, 50.1, 19.009}}, []string{"tr1", "sw1"}},
+		{"label and box", GeoJSONFilter{Labels: []string{"Signal"}, BBox: &[4]float64{49.9, 19.007, 50.1, 19.009}}, []string{}},
+	}
+	for _, tt := range tests {
+		fc, err := ExportGeoJSON(s, "L1", tt.f)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := featureIds(fc); !equalIds(got, tt.want) {
+			t.Errorf("%s: features %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExportGeoJSONBBoxWithoutGeometry(t *testing.T) {
+	s := geoLine(t)
+	// a box left behind on a node whose geometry is gone doesn't make it a feature
+	lines, _ := s.FindNodes("Line", store.Props{"id": "L1"})
+	n, _ := s.CreateNode(store.Props{"id": "sig3", store.BBoxProperty: []float64{50, 19.005, 50, 19.005}}, "Signal")
+	if _, err := s.Relate(lines[0].ID, "HAS_OCP", n.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	everything := &[4]float64{-180, -90, 180, 90}
+	for _, labels := range [][]string{nil, {"Signal"}} {
+		fc, err := ExportGeoJSON(s, "L1", GeoJSONFilter{Labels: labels, BBox: everything})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range featureIds(fc) {
+			if id == "sig2" || id == "sig3" {
+				t.Errorf("labels %v: exported %s, which has no geometry, for a box around everything", labels, id)
+			}
+		}
+	}
+
+	// nor does the box let createFeature pass nodes without one that the store didn't leave out
+	for _, props := range []store.Props{{"id": "sig2"}, {"id": "sig3", store.BBoxProperty: []float64{50, 19.005, 50, 19.005}}} {
+		if _, ok, err := createFeature(store.Node{Labels: []string{"Signal"}, Props: props}, "tr1", GeoJSONFilter{BBox: everything}); ok || err != nil {
+			t.Errorf("%v is a feature (%v), want it left out", props, err)
+		}
+	}
+}
+
+func TestExportGeoJSONTrack(t *testing.T) {
+	fc, err := ExportGeoJSON(geoLine(t), "L1", GeoJSONFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fc.Features {
+		want := "tr1"
+		if f.ID == "tr1" || f.ID == "ocp1" {
+			want = ""
+		}
+		if got, _ := f.Properties["track"].(string); got != want {
+			t.Errorf("%s: track %q, want %q", f.ID, got, want)
+		}
+		if _, ok := f.Properties[store.BBoxProperty]; ok {
+			t.Errorf("%s: exported the %s", f.ID, store.BBoxProperty)
+		}
+	}
+}
+
+func TestExportGeoJSONNotFound(t *testing.T) {
+	if _, err := ExportGeoJSON(store.NewMemoryStore(), "L1", GeoJSONFilter{}); err == nil {
+		t.Error("expected an error for a missing line")
+	} else if _, ok := err.(*store.NotFoundError); !ok {
+		t.Errorf("expected a NotFoundError, got %T", err)
+	}
+}
+
+func equalIds(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
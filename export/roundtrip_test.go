@@ -0,0 +1,509 @@
+package export
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"Go-GoSAFE.converter/crs"
+	"Go-GoSAFE.converter/graph"
+	"Go-GoSAFE.converter/store"
+)
+
+// roundTrip is a railML 2.3 file with the elements the converter doesn't know, which it keeps as they are
+const roundTrip = `<?xml version="1.0" encoding="UTF-8"?>
+<railml xmlns="http://www.railml.org/schemas/2016" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:ext="http://example.com/ext" xmlns:dc="http://purl.org/dc/elements/1.1/" xsi:schemaLocation="http://www.railml.org/schemas/2016 http://schemas.railml.org/2016/railML-2.3/railML.xsd" version="2.3">
+  <metadata>
+    <dc:title>Line 1</dc:title>
+    <dc:date>2020-05-04</dc:date>
+  </metadata>
+  <infrastructure id="inf1" name="Line 1" xml:lang="pl">
+    <infraAttrGroups>
+      <infraAttributes id="ia1">
+        <gauge value="1435"/>
+        <ext:axleLoad value="22.5"/>
+        <speeds><speed trainCategory="all" vMax="120"><ext:note text="temporary"/></speed><ext:speedSource name="register"/></speeds>
+      </infraAttributes>
+    </infraAttrGroups>
+    <tracks>
+      <track id="tr1" name="Track 1">
+        <trackTopology>
+          <trackBegin id="tb1" pos="0">
+            <bufferStop id="bs1"/>
+            <macroscopicNode ocpRef="ocp1"/>
+            <geoCoord coord="19.0 50.0"/>
+          </trackBegin>
+          <trackEnd id="te1" pos="1000">
+            <openEnd id="oe1"><ext:note text="end"/></openEnd>
+          </trackEnd>
+          <connections>
+            <switch id="sw1" pos="500"><connection id="c5" ref="c6" orientation="outgoing" course="left"/></switch>
+            <ext:junction id="j1"/>
+          </connections>
+          <ext:topologyNote text="surveyed"/>
+        </trackTopology>
+        <trackElements>
+          <bridges>
+            <brigde id="br1" pos="300" length="20"/>
+            <nameGroup name="Vistula bridges"/>
+          </bridges>
+          <ext:catenaries><ext:catenary id="cat1" pos="0"/></ext:catenaries>
+          <geoMappings>
+            <geoMapping id="gmA"><geoCoord coord="19.0 50.0"/></geoMapping>
+            <geoMapping id="gmB"><geoCoord coord="19.01 50.0"/></geoMapping>
+          </geoMappings>
+        </trackElements>
+        <ocsElements>
+          <signals>
+            <signal id="s1" pos="200" dir="up"/>
+            <nameGroup name="entry signals"/>
+          </signals>
+          <trainDetectionElements>
+            <trainDetector id="td1" pos="60"/>
+            <nameGroup name="axle counters"/>
+          </trainDetectionElements>
+          <ext:markers><ext:marker id="m1" pos="10"/></ext:markers>
+        </ocsElements>
+      </track>
+    </tracks>
+  </infrastructure>
+</railml>`
+
+// volatile is the date of the metadata an export without the metadata of its file gets
+var volatile = regexp.MustCompile(`<dc:date>[^<]*</dc:date>`)
+
+// importXML imports the file as the line L1 into an empty store, with epsg as the CRS of geoCoords without one
+func importXML(t *testing.T, file string, epsg string) store.GraphStore {
+	s := store.NewMemoryStore()
+	g := graph.GraphUtils{}
+	if _, err := g.ImportLine(context.Background(), s, strings.NewReader(file), graph.ImportOptions{Line: "L1", Epsg: epsg, Mode: "fail", Workers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// stored returns the properties of the nodes with the label by their id
+func stored(t *testing.T, s store.GraphStore, label string) map[string]store.Props {
+	nodes, err := s.FindNodes(label, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	props := map[string]store.Props{}
+	for _, n := range nodes {
+		id, _ := n.Props["id"].(string)
+		props[id] = n.Props
+	}
+	return props
+}
+
+// exportXML imports the file as the line L1 into an empty store and exports it again
+func exportXML(t *testing.T, file string) string {
+	return exportStore(t, importXML(t, file, "4326"), "4326")
+}
+
+// exportStore exports the line L1 of the store with its geometry in the CRS of the EPSG code
+func exportStore(t *testing.T, s store.GraphStore, epsg string) string {
+	rm, err := ExportLine(s, "L1", epsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := xml.Marshal(rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(x)
+}
+
+func TestRoundTrip(t *testing.T) {
+	first := exportXML(t, roundTrip)
+	second := exportXML(t, first)
+	if first != second {
+		t.Errorf("the export of the export differs:\n%s\n%s", first, second)
+	}
+
+	kept := []string{
+		`<metadata><dc:title>Line 1</dc:title><dc:date>2020-05-04</dc:date></metadata>`,
+		`<infrastructure id="inf1" name="Line 1" xml:lang="pl">`,
+		`version="2.3"`,
+		`xmlns="http://www.railml.org/schemas/2016"`,
+		`xsi:schemaLocation="http://www.railml.org/schemas/2016 http://schemas.railml.org/2016/railML-2.3/railML.xsd"`,
+		`<ext:axleLoad value="22.5"`,       // an unknown container of <infraAttributes />
+		`<ext:note text="temporary"`,       // an unknown child of <speed />
+		`<ext:speedSource name="register"`, // and of <speeds />
+		`<macroscopicNode ocpRef="ocp1"`,   // a second element of <trackBegin />
+		`<ext:note text="end"`,             // an unknown child of the element of <trackEnd />
+		`<ext:junction id="j1"`,            // an unknown element of a known container
+		`<ext:topologyNote text="surveyed"`,
+		`<brigde id="br1"`,
+		`<nameGroup name="Vistula bridges"`,
+		`<ext:catenary id="cat1"`, // an unknown container of <trackElements />
+		`<geoMapping id="gmA">`,
+		`<geoMapping id="gmB">`,
+		`<nameGroup name="entry signals"`,
+		`<nameGroup name="axle counters"`,
+		`<ext:marker id="m1"`, // an unknown container of <ocsElements />
+	}
+	for _, k := range kept {
+		if strings.Count(first, k) != 1 {
+			t.Errorf("%s is exported %d times, want once", k, strings.Count(first, k))
+		}
+	}
+	for _, p := range []string{"#fragments", "#geoMappingIds"} {
+		if strings.Contains(first, p) {
+			t.Errorf("exported the property %s", p)
+		}
+	}
+}
+
+func TestRoundTripDefaults(t *testing.T) {
+	x := exportXML(t, `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>
+		<trackBegin id="tb1" pos="0"><openEnd id="oe1"/></trackBegin><trackEnd id="te1" pos="10"><openEnd id="oe2"/></trackEnd>
+		</trackTopology></track></tracks></infrastructure></railml>`)
+	want := `<railml version="2.2" xmlns="http://www.railml.org/schemas/2013" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.railml.org/schemas/2013 http://schemas.railml.org/2013/railML-2.2/railML.xsd">`
+	if !strings.HasPrefix(x, want) {
+		t.Errorf("a file without a version is exported as %.200s, want railML 2.2", x)
+	}
+	if !strings.Contains(x, "<dc:source>") || !strings.Contains(x, `<infrastructure id="inf1">`) {
+		t.Errorf("a file without metadata is exported as %.400s, want new metadata and its infrastructure", x)
+	}
+}
+
+func TestRoundTripConnections(t *testing.T) {
+	file := `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>
+		<trackBegin id="tb1" pos="0"><openEnd id="oe1"/></trackBegin><trackEnd id="te1" pos="100"><openEnd id="oe2"/></trackEnd>
+		<connections>
+			<switch id="sw1" pos="20">
+				<connection id="c1" ref="c11" orientation="outgoing" course="left"/>
+				<connection id="c2" ref="c12" orientation="outgoing" course="right"/>
+			</switch>
+			<crossing id="cr1" pos="60" type="doubleSwitchCrossing">
+				<connection id="c3" ref="c13" orientation="outgoing" course="left"/>
+				<connection id="c4" ref="c14" orientation="incoming" course="right"/>
+				<connection id="c5" ref="c15" orientation="outgoing" course="straight"/>
+			</crossing>
+		</connections>
+		</trackTopology></track></tracks></infrastructure></railml>`
+	first := exportXML(t, file)
+	second := exportXML(t, first)
+	if volatile.ReplaceAllString(first, "") != volatile.ReplaceAllString(second, "") {
+		t.Errorf("the export of the export differs:\n%s\n%s", first, second)
+	}
+
+	want := []string{
+		`<switch id="sw1" pos="20"><connection id="c1" ref="c11" course="left" orientation="outgoing"></connection>` +
+			`<connection id="c2" ref="c12" course="right" orientation="outgoing"></connection></switch>`,
+		`<connection id="c3" ref="c13" course="left" orientation="outgoing"></connection>` +
+			`<connection id="c4" ref="c14" course="right" orientation="incoming"></connection>` +
+			`<connection id="c5" ref="c15" course="straight" orientation="outgoing"></connection></crossing>`,
+	}
+	for _, w := range want {
+		if !strings.Contains(first, w) {
+			t.Errorf("the export doesn't contain %s:\n%s", w, first)
+		}
+	}
+}
+
+// TestRoundTripChildOrder checks that the kept child elements are exported after the known ones,
+// as the schema orders them, wherever they were in the imported file
+func TestRoundTripChildOrder(t *testing.T) {
+	file := `<railml xmlns:ext="http://example.com/ext"><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>
+		<trackBegin id="tb1" pos="0"><ext:note text="begin"/><bufferStop id="bs1"/><geoCoord coord="50.0 19.0"/></trackBegin>
+		<trackEnd id="te1" pos="100"><openEnd id="oe1"/></trackEnd>
+		</trackTopology>
+		<ocsElements><signals><nameGroup name="entry signals"/>
+			<signal id="s1" pos="20"><ext:note text="signal"/><geoCoord coord="50.0 19.001"/></signal>
+		</signals></ocsElements>
+		</track></tracks></infrastructure></railml>`
+	x := exportXML(t, file)
+
+	tests := []struct {
+		element string
+		known   []string
+		kept    string
+	}{
+		{`<trackBegin id="tb1"`, []string{"<bufferStop", "<geoCoord"}, `<ext:note text="begin"`},
+		{`<signal id="s1"`, []string{"<geoCoord"}, `<ext:note text="signal"`},
+		{`<signals>`, []string{`<signal id="s1"`}, `<nameGroup name="entry signals"`},
+	}
+	for _, tt := range tests {
+		start := strings.Index(x, tt.element)
+		if start < 0 {
+			t.Errorf("%s isn't exported:\n%s", tt.element, x)
+			continue
+		}
+		e := x[start:]
+		kept := strings.Index(e, tt.kept)
+		if kept < 0 {
+			t.Errorf("%s isn't exported in %s:\n%s", tt.kept, tt.element, x)
+			continue
+		}
+		for _, k := range tt.known {
+			if i := strings.Index(e, k); i < 0 || i > kept {
+				t.Errorf("%s comes after %s in %s, want the known child first:\n%s", k, tt.kept, tt.element, x)
+			}
+		}
+	}
+}
+
+func TestRoundTripOcps(t *testing.T) {
+	file := `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>
+		<trackBegin id="tb1" pos="0"><macroscopicNode ocpRef="ocp1"/></trackBegin>
+		<trackEnd id="te1" pos="100"><openEnd id="oe1"/></trackEnd>
+		<connections><switch id="sw1" pos="50" ocpStationRef="ocp2"><connection id="c1" ref="c2" orientation="outgoing" course="left"/></switch></connections>
+		</trackTopology></track></tracks>
+		<operationControlPoints>
+			<ocp id="ocp1" name="Kraków Główny" type="station">
+				<geoCoord coord="50.067 19.945"/>
+				<propOperational operationalType="station" trafficType="mixed"/>
+				<propService passenger="true" goodsLoading="false"/>
+				<designator register="PL" entry="KR"/>
+			</ocp>
+			<ocp id="ocp2" name="Kraków Zabłocie" parentOcpRef="ocp1"><propOperational operationalType="stoppingPoint"/></ocp>
+		</operationControlPoints></infrastructure></railml>`
+	first := exportXML(t, file)
+	second := exportXML(t, first)
+	if volatile.ReplaceAllString(first, "") != volatile.ReplaceAllString(second, "") {
+		t.Errorf("the export of the export differs:\n%s\n%s", first, second)
+	}
+
+	want := []string{
+		`<operationControlPoints><ocp id="ocp1" name="Kraków Główny" type="station">` +
+			`<propOperational operationalType="station" trafficType="mixed"></propOperational>` +
+			`<propService passenger="true" goodsLoading="false"></propService>` +
+			`<geoCoord coord="50.067 19.945" epsgCode="4326"></geoCoord>` +
+			`<designator register="PL" entry="KR"/></ocp>`,
+		`<ocp id="ocp2" name="Kraków Zabłocie" parentOcpRef="ocp1"><propOperational operationalType="stoppingPoint"></propOperational></ocp>`,
+		`<macroscopicNode ocpRef="ocp1">`,
+		`<switch id="sw1" pos="50" ocpStationRef="ocp2">`,
+	}
+	for _, w := range want {
+		if !strings.Contains(first, w) {
+			t.Errorf("the export doesn't contain %s:\n%s", w, first)
+		}
+	}
+}
+
+func TestRoundTripTrainDetection(t *testing.T) {
+	file := `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>
+		<trackBegin id="tb1" pos="0" absPos="1000"><openEnd id="oe1"/><geoCoord coord="50.0 19.0"/></trackBegin>
+		<trackEnd id="te1" pos="100" absPos="1100"><openEnd id="oe2"/><geoCoord coord="50.0 19.001"/></trackEnd>
+		</trackTopology>
+		<ocsElements><trainDetectionElements>
+			<trainDetector id="td1" pos="10" absPos="1010" dir="up" ocpStationRef="ocp1" detectionObject="axle" medium="magnetic"
+				axleCounting="true" directionDetection="true" model="ZP 43"><geoCoord coord="50.0 19.0001"/></trainDetector>
+			<trackCircuitBorder id="tcb1" pos="60" dir="both" insulatedRail="left"/>
+			<trainDetector id="td2" pos="90" type="trackCircuit"/>
+		</trainDetectionElements></ocsElements>
+		</track></tracks></infrastructure></railml>`
+	s := importXML(t, file, "4326")
+
+	detectors := stored(t, s, "TrainDetector")
+	if len(detectors) != 2 {
+		t.Fatalf("the train detectors are stored as %v", detectors)
+	}
+	if td := detectors["td1"]; td["pos"] != "10" || td["absPos"] != "1010" || td["dir"] != "up" || td["ocpStationRef"] != "ocp1" ||
+		td["detectionObject"] != "axle" || td["medium"] != "magnetic" || td["axleCounting"] != "true" ||
+		td["directionDetection"] != "true" || td["model"] != "ZP 43" || td["geometry"] != "POINT(19.0001 50)" {
+		t.Errorf("td1 is stored as %v", td)
+	}
+	if td := detectors["td2"]; td["pos"] != "90" || td["type"] != "trackCircuit" {
+		t.Errorf("td2 is stored as %v", td)
+	}
+	borders := stored(t, s, "TrackCircuitBorder")
+	if tcb := borders["tcb1"]; len(borders) != 1 || tcb["pos"] != "60" || tcb["dir"] != "both" || tcb["insulatedRail"] != "left" {
+		t.Errorf("the track circuit borders are stored as %v", borders)
+	}
+
+	first := exportStore(t, s, "4326")
+	second := exportXML(t, first)
+	if volatile.ReplaceAllString(first, "") != volatile.ReplaceAllString(second, "") {
+		t.Errorf("the export of the export differs:\n%s\n%s", first, second)
+	}
+	// the detectors come before the borders, as the schema orders them
+	want := `<trainDetectionElements>` +
+		`<trainDetector id="td1" pos="10" absPos="1010" dir="up" ocpStationRef="ocp1" detectionObject="axle" medium="magnetic" ` +
+		`axleCounting="true" directionDetection="true" model="ZP 43"><geoCoord coord="50 19.0001" epsgCode="4326"></geoCoord></trainDetector>` +
+		`<trainDetector id="td2" pos="90" type="trackCircuit">`
+	if !strings.Contains(first, want) {
+		t.Errorf("the export doesn't contain %s:\n%s", want, first)
+	}
+	want = `<trackCircuitBorder id="tcb1" pos="60" dir="both" insulatedRail="left">`
+	if !strings.Contains(first, want) || strings.Index(first, want) < strings.Index(first, `<trainDetector id="td2"`) {
+		t.Errorf("the export doesn't contain %s after the detectors:\n%s", want, first)
+	}
+}
+
+func TestRoundTripTopologyChanges(t *testing.T) {
+	file := `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>
+		<trackBegin id="tb1" pos="0" absPos="1000"><openEnd id="oe1"/></trackBegin>
+		<trackEnd id="te1" pos="200" absPos="2150"><openEnd id="oe2"/></trackEnd>
+		<mileageChanges>
+			<mileageChange id="mc1" pos="100" absPosIn="1100" absPos="2050" absDir="raising" type="overlapping"/>
+			<mileageChange id="mc2" pos="150" absPosIn="2100" absPosInOffset="0.5" absPos="2100" absDir="raising" dir="up"/>
+		</mileageChanges>
+		<connections/>
+		<crossSections>
+			<crossSection id="cs1" pos="50" absPos="1050" name="border" ocpRef="ocp1" ocpTrackID="1" type="station"/>
+			<crossSection id="cs2" pos="175" dir="down"><geoCoord coord="50.0 19.002"/></crossSection>
+		</crossSections>
+		</trackTopology></track></tracks></infrastructure></railml>`
+	s := importXML(t, file, "4326")
+
+	changes := stored(t, s, "MileageChange")
+	if mc := changes["mc1"]; len(changes) != 2 || mc["pos"] != "100" || mc["absPosIn"] != "1100" || mc["absPos"] != "2050" ||
+		mc["absDir"] != "raising" || mc["type"] != "overlapping" {
+		t.Errorf("the mileage changes are stored as %v", changes)
+	}
+	if mc := changes["mc2"]; mc["absPosInOffset"] != "0.5" || mc["dir"] != "up" {
+		t.Errorf("mc2 is stored as %v", mc)
+	}
+	sections := stored(t, s, "CrossSection")
+	if cs := sections["cs1"]; len(sections) != 2 || cs["pos"] != "50" || cs["absPos"] != "1050" || cs["name"] != "border" ||
+		cs["ocpRef"] != "ocp1" || cs["ocpTrackID"] != "1" || cs["type"] != "station" {
+		t.Errorf("the cross sections are stored as %v", sections)
+	}
+	if cs := sections["cs2"]; cs["dir"] != "down" || cs["geometry"] != "POINT(19.002 50)" {
+		t.Errorf("cs2 is stored as %v", cs)
+	}
+
+	first := exportStore(t, s, "4326")
+	second := exportXML(t, first)
+	if volatile.ReplaceAllString(first, "") != volatile.ReplaceAllString(second, "") {
+		t.Errorf("the export of the export differs:\n%s\n%s", first, second)
+	}
+	// the mileage changes come before the connections and the cross sections after them, as the schema orders them
+	want := []string{
+		`</trackEnd><mileageChanges>` +
+			`<mileageChange id="mc1" pos="100" absPos="2050" absPosIn="1100" absDir="raising" type="overlapping"></mileageChange>` +
+			`<mileageChange id="mc2" pos="150" absPos="2100" dir="up" absPosIn="2100" absPosInOffset="0.5" absDir="raising"></mileageChange>` +
+			`</mileageChanges><connections>`,
+		`</connections><crossSections>` +
+			`<crossSection id="cs1" name="border" pos="50" absPos="1050" ocpRef="ocp1" ocpTrackID="1" type="station"></crossSection>` +
+			`<crossSection id="cs2" pos="175" dir="down"><geoCoord coord="50 19.002" epsgCode="4326"></geoCoord></crossSection>` +
+			`</crossSections></trackTopology>`,
+	}
+	for _, w := range want {
+		if !strings.Contains(first, w) {
+			t.Errorf("the export doesn't contain %s:\n%s", w, first)
+		}
+	}
+}
+
+func TestRoundTripGeneralInfraAttributes(t *testing.T) {
+	file := `<railml><infrastructure id="inf1"><infraAttrGroups><infraAttributes id="ia1">
+		<generalInfraAttributes>
+			<generalInfraAttribute infrastructureStatus="operational" trackType="main">
+				<additionalName name="Main line" xml:lang="en"/>
+				<additionalName name="Linia główna" xml:lang="pl"/>
+				<validity from="2020-01-01"><period to="2030-12-31" weekdays="1111100"/></validity>
+			</generalInfraAttribute>
+			<generalInfraAttribute infrastructureStatus="planned"/>
+		</generalInfraAttributes>
+		<gauge value="1435"/>
+		</infraAttributes></infraAttrGroups>
+		<tracks><track id="tr1"><trackTopology>
+		<trackBegin id="tb1" pos="0"><openEnd id="oe1"/></trackBegin><trackEnd id="te1" pos="100"><openEnd id="oe2"/></trackEnd>
+		</trackTopology></track></tracks></infrastructure></railml>`
+	s := importXML(t, file, "4326")
+
+	general := stored(t, s, "GeneralInfraAttributes")
+	if len(general) != 1 {
+		t.Fatalf("the generalInfraAttributes are stored as %v", general)
+	}
+	attrs, err := s.FindNodes("GeneralInfraAttribute", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) != 2 {
+		t.Errorf("the generalInfraAttribute elements are stored as %v", attrs)
+	}
+	if periods := stored(t, s, "Period"); len(periods) != 1 || periods[""]["weekdays"] != "1111100" {
+		t.Errorf("the period at the third level is stored as %v", periods)
+	}
+
+	first := exportStore(t, s, "4326")
+	second := exportXML(t, first)
+	if volatile.ReplaceAllString(first, "") != volatile.ReplaceAllString(second, "") {
+		t.Errorf("the export of the export differs:\n%s\n%s", first, second)
+	}
+	// the children keep their order, the attributes are sorted by name and generalInfraAttributes comes first
+	want := `<infraAttributes id="ia1"><generalInfraAttributes>` +
+		`<generalInfraAttribute infrastructureStatus="operational" trackType="main">` +
+		`<additionalName name="Main line" xml:lang="en"></additionalName>` +
+		`<additionalName name="Linia główna" xml:lang="pl"></additionalName>` +
+		`<validity from="2020-01-01"><period to="2030-12-31" weekdays="1111100"></period></validity>` +
+		`</generalInfraAttribute>` +
+		`<generalInfraAttribute infrastructureStatus="planned"></generalInfraAttribute>` +
+		`</generalInfraAttributes><gauge value="1435"`
+	if !strings.Contains(first, want) {
+		t.Errorf("the export doesn't contain %s:\n%s", want, first)
+	}
+}
+
+// crsLine is a line with geoCoords in wgs84, of the ends of its track, of its geoMappings and of an ocp
+const crsLine = `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>
+	<trackBegin id="tb1" pos="0"><openEnd id="oe1"/><geoCoord coord="50.0 19.0"/></trackBegin>
+	<trackEnd id="te1" pos="717"><openEnd id="oe2"/><geoCoord coord="50.0 19.01"/></trackEnd>
+	</trackTopology><trackElements><geoMappings>
+		<geoMapping id="gm1"><geoCoord coord="50.0 19.0"/></geoMapping>
+		<geoMapping id="gm2"><geoCoord coord="50.0 19.01"/></geoMapping>
+	</geoMappings></trackElements></track></tracks>
+	<operationControlPoints><ocp id="ocp1"><geoCoord coord="50.067 19.945"/></ocp></operationControlPoints>
+	</infrastructure></railml>`
+
+func TestRoundTripCRS(t *testing.T) {
+	s := importXML(t, crsLine, "4326")
+	x := exportStore(t, s, "2180")
+
+	tr, err := crs.NewTransformer("4326", "2180")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// PUWG 1992 has the northing first, in metres to the millimetre
+	puwg := func(lon, lat float64) string {
+		e, n, err := tr.Transform(lon, lat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf(`<geoCoord coord="%.3f %.3f" epsgCode="2180">`, n, e)
+	}
+	if p := puwg(19, 50); !strings.Contains(p, " 500000.000\"") {
+		t.Fatalf("the central meridian of PUWG 1992 is transformed to %s", p)
+	}
+	want := map[string]int{
+		puwg(19, 50):         2, // the begin of the track and its first geoMapping
+		puwg(19.01, 50):      2,
+		puwg(19.945, 50.067): 1,
+	}
+	for w, n := range want {
+		if strings.Count(x, w) != n {
+			t.Errorf("the export has %s %d times, want %d:\n%s", w, strings.Count(x, w), n, x)
+		}
+	}
+	if n := strings.Count(x, "<geoCoord "); n != 5 || strings.Count(x, `epsgCode="2180"`) != n {
+		t.Errorf("the export has %d geoCoords, want 5 all in epsg:2180:\n%s", n, x)
+	}
+
+	// the export of the export has the same coordinates
+	if again := exportStore(t, importXML(t, x, "4326"), "2180"); volatile.ReplaceAllString(again, "") != volatile.ReplaceAllString(x, "") {
+		t.Errorf("the export of the export differs:\n%s\n%s", x, again)
+	}
+}
+
+func TestRoundTripDefaultCRS(t *testing.T) {
+	// the geoCoords are written in the CRS the line was imported with
+	s := importXML(t, crsLine, "4326")
+	x := exportStore(t, s, "")
+	if n := strings.Count(x, "<geoCoord "); n != 5 || strings.Count(x, `epsgCode="4326"`) != n || !strings.Contains(x, `<geoCoord coord="50.067 19.945" epsgCode="4326">`) {
+		t.Errorf("a line imported in epsg:4326 is exported as\n%s", x)
+	}
+
+	puwg := exportStore(t, s, "2180")
+	s = importXML(t, strings.Replace(puwg, `epsgCode="2180"`, "", -1), "2180")
+	if x := exportStore(t, s, ""); volatile.ReplaceAllString(x, "") != volatile.ReplaceAllString(puwg, "") {
+		t.Errorf("a line imported in epsg:2180 is exported as\n%s\nwant\n%s", x, puwg)
+	}
+}
@@ -0,0 +1,410 @@
+package graph
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"Go-GoSAFE.converter/store"
+)
+
+// severities of findings
+const (
+	SeverityError   = "error"   // the topology of the line is broken, e.g. routes can't pass
+	SeverityWarning = "warning" // the line is usable, but likely not what the file meant
+)
+
+// Finding is a problem Diagnose found in a line.
+type Finding struct {
+	Severity string `json:"severity"`
+	Kind     string `json:"kind"`    // see Diagnose
+	Element  string `json:"element"` // RailML element, e.g. connection
+	ID       string `json:"id"`
+	Track    string `json:"track,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+// diagnosed is an element with an id Diagnose checks
+type diagnosed struct {
+	element string
+	id      string
+	track   string
+	ref     string
+}
+
+// Diagnose checks the consistency of the given line. It finds
+//   - danglingRef: a <connection /> that refers to a connection the line doesn't have
+//   - notMutual: a connection whose connection doesn't refer back to it
+//   - missingBegin, missingEnd: a track without <trackBegin /> or <trackEnd />
+//   - duplicateId: an id that more than one element of the line has. Imports fail with a ConflictError on elements
+//     of the same kind with the same id, see KeyLine, so what it finds on them are elements of different kinds that
+//     share an id, which railML doesn't allow either
+//   - posBeyondTrack: an element whose pos is before the begin of its track or after its end
+//   - isolatedNetwork: tracks that aren't connected to the largest network of tracks of the line
+//   - lengthMismatch: a track whose geodesic length differs from the distance of its begin and end by more than
+//     tolerance metres
+//   - absPosMismatch: a track end or a mileage change whose absPos or absPosIn differs from the mileage worked out
+//     from the begin of its track by more than tolerance metres, see mileage
+//
+// Findings are ordered by their severity, the errors first, and then by their track.
+func Diagnose(s store.GraphStore, ln store.Node, tolerance float64) ([]Finding, error) {
+	// the line is read at once, the checks look at it in memory
+	m, err := Load(s, ln)
+	if err != nil {
+		return nil, err
+	}
+	d := newDiagnosis(tolerance)
+	if err := d.line(m, ln); err != nil {
+		return nil, err
+	}
+
+	// networks, the tracks joined by LINKED relationships of the topology layer
+	tracks, err := m.Neighbours(ln.ID, "HAS_TRACK")
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tracks {
+		tns, err := m.Neighbours(t.Node.ID, HasTopologyNode)
+		if err != nil {
+			return nil, err
+		}
+		for _, tn := range tns {
+			nb, err := m.Neighbours(tn.Node.ID, Linked)
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range nb {
+				a, _ := tn.Node.Props["track"].(string)
+				b, _ := n.Node.Props["track"].(string)
+				d.link(a, b)
+			}
+		}
+	}
+	return d.result(), nil
+}
+
+// diagnosis collects the findings of Diagnose part by part, so that a line can also be checked while it is imported
+// chunk by chunk. Of the parts checked, it only keeps the ids the checks that span the whole line need.
+type diagnosis struct {
+	tolerance   float64
+	findings    []Finding
+	connections []diagnosed
+	trackIds    []string
+	elements    map[string][]diagnosed // id -> the elements that have it
+	ids         []string               // in the order they were first seen
+	parent      map[string]string      // track id -> a track of the same network
+}
+
+func newDiagnosis(tolerance float64) *diagnosis {
+	return &diagnosis{tolerance: tolerance, elements: map[string][]diagnosed{}, parent: map[string]string{}}
+}
+
+func (d *diagnosis) add(severity, kind string, e diagnosed, detail string, args ...interface{}) {
+	d.findings = append(d.findings, Finding{Severity: severity, Kind: kind, Element: e.element, ID: e.id, Track: e.track, Detail: fmt.Sprintf(detail, args...)})
+}
+
+// str reads a string property, empty if it is missing
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// line checks the tracks of the given line in s, and notes the ids of all its elements and its connections.
+// s can hold the whole line or a chunk of it.
+func (d *diagnosis) line(s store.GraphStore, ln store.Node) error {
+	tracks, err := s.Neighbours(ln.ID, "HAS_TRACK")
+	if err != nil {
+		return err
+	}
+	ends := map[int][]diagnosed{} // track -> its trackBegin and trackEnd, whose ids are kept by the relationships
+	for _, t := range tracks {
+		if err := d.track(s, t, ends); err != nil {
+			return err
+		}
+	}
+
+	nodes, err := Subgraph(s, ln)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes[1:] {
+		if id, ok := n.Props["id"].(string); ok && !n.HasLabel(TopologyNodeLabel) {
+			d.element(diagnosed{element: elementName(n), id: id})
+		}
+		for _, e := range ends[n.ID] {
+			d.element(e)
+		}
+	}
+	return nil
+}
+
+// element notes an element with an id
+func (d *diagnosis) element(e diagnosed) {
+	if len(d.elements[e.id]) == 0 {
+		d.ids = append(d.ids, e.id)
+	}
+	d.elements[e.id] = append(d.elements[e.id], e)
+}
+
+// track checks a track and notes its connections, and its begin and end in ends
+func (d *diagnosis) track(s store.GraphStore, t store.Neighbour, ends map[int][]diagnosed) error {
+	track := str(t.Node.Props["id"])
+	d.trackIds = append(d.trackIds, track)
+	if id, ok := t.Node.Props["id"].(string); ok {
+		if _, seen := d.parent[id]; !seen {
+			d.parent[id] = id
+		}
+	}
+	nb, err := s.Neighbours(t.Node.ID, "BEGINS", "ENDS", "HAS_SWITCH", "HAS_CROSSING", "HAS_MILEAGE_CHANGE", "HAS_CROSS_SECTION", "HAS_TRACK_ELEMENT", "HAS_OCS_ELEMENT")
+	if err != nil {
+		return err
+	}
+
+	var begin, end *store.Neighbour
+	for i, n := range nb {
+		if n.Relationship.Start != t.Node.ID {
+			continue
+		}
+		switch n.Relationship.Type {
+		case "BEGINS":
+			begin = &nb[i]
+		case "ENDS":
+			end = &nb[i]
+		}
+		if id, ok := n.Relationship.Props["id"].(string); ok {
+			e := diagnosed{element: map[string]string{"BEGINS": "trackBegin", "ENDS": "trackEnd"}[n.Relationship.Type], id: id}
+			ends[t.Node.ID] = append(ends[t.Node.ID], e)
+		}
+	}
+	// a track without <trackBegin /> still has a BEGINS, but nothing of the trackBegin is kept by it
+	if _, ok := relProp(begin, "id"); !ok {
+		d.add(SeverityError, "missingBegin", diagnosed{element: "track", id: track, track: track}, "track '%s' has no trackBegin", track)
+	}
+	if _, ok := relProp(end, "id"); !ok {
+		d.add(SeverityError, "missingEnd", diagnosed{element: "track", id: track, track: track}, "track '%s' has no trackEnd", track)
+	}
+	b, _ := relProp(begin, "pos")
+	e, _ := relProp(end, "pos")
+	from, hasFrom := number(b)
+	to, hasTo := number(e)
+	if hasFrom && hasTo && to < from {
+		from, to = to, from
+	}
+	if l, ok := number(t.Node.Props["geodesicLength"]); ok && hasFrom && hasTo {
+		if diff := math.Abs(l - (to - from)); diff > d.tolerance {
+			d.add(SeverityWarning, "lengthMismatch", diagnosed{element: "track", id: track, track: track},
+				"track '%s' runs from pos %v to %v, but its geometry is %v m long, %v m off", track, from, to, l, millimetres(diff))
+		}
+	}
+
+	for _, n := range nb {
+		if n.Relationship.Start != t.Node.ID {
+			continue
+		}
+		switch n.Relationship.Type {
+		case "BEGINS", "ENDS":
+			continue // see below
+		case "HAS_SWITCH", "HAS_CROSSING":
+			cs, err := s.Neighbours(n.Node.ID, "HAS_CONNECTION")
+			if err != nil {
+				return err
+			}
+			for _, c := range cs {
+				d.connections = append(d.connections, diagnosed{element: "connection", id: str(c.Node.Props["id"]), track: track, ref: str(c.Node.Props["ref"])})
+			}
+		}
+
+		pos, ok := number(n.Node.Props["pos"])
+		if !ok || !hasFrom || !hasTo || (pos >= from && pos <= to) {
+			continue
+		}
+		e := diagnosed{element: elementName(n.Node), id: str(n.Node.Props["id"]), track: track}
+		d.add(SeverityWarning, "posBeyondTrack", e, "%s '%s' is at pos %v, but track '%s' runs from %v to %v", e.element, e.id, pos, track, from, to)
+	}
+	var changes []store.Props
+	for _, n := range nb {
+		if n.Relationship.Start == t.Node.ID && n.Relationship.Type == "HAS_MILEAGE_CHANGE" {
+			changes = append(changes, n.Node.Props)
+		}
+	}
+	for _, f := range mileageFindings(begin, end, changes, d.tolerance) {
+		f.track = track
+		d.add(SeverityWarning, "absPosMismatch", f.diagnosed, "%s", f.detail)
+	}
+
+	for _, n := range []*store.Neighbour{begin, end} {
+		if n != nil && n.Node.HasLabel("Connection") {
+			d.connections = append(d.connections, diagnosed{element: "connection", id: str(n.Node.Props["id"]), track: track, ref: str(n.Node.Props["ref"])})
+		}
+	}
+	return nil
+}
+
+// link notes that the tracks a and b are connected, tracks of other lines are left alone
+func (d *diagnosis) link(a, b string) {
+	if _, ok := d.parent[a]; !ok {
+		return
+	}
+	if _, ok := d.parent[b]; !ok {
+		return
+	}
+	d.parent[d.find(a)] = d.find(b)
+}
+
+// find returns the track that stands for the network of track t
+func (d *diagnosis) find(t string) string {
+	if d.parent[t] == t {
+		return t
+	}
+	d.parent[t] = d.find(d.parent[t])
+	return d.parent[t]
+}
+
+// result runs the checks that span the whole line and returns all findings
+func (d *diagnosis) result() []Finding {
+	// connections
+	byID := map[string]diagnosed{}
+	for _, c := range d.connections {
+		if _, ok := byID[c.id]; !ok && c.id != "" {
+			byID[c.id] = c
+		}
+	}
+	for _, c := range d.connections {
+		if c.ref == "" {
+			continue
+		}
+		other, ok := byID[c.ref]
+		if !ok {
+			d.add(SeverityError, "danglingRef", c, "connection '%s' refers to connection '%s', which doesn't exist", c.id, c.ref)
+			continue
+		}
+		if other.ref != c.id {
+			d.add(SeverityWarning, "notMutual", c, "connection '%s' refers to connection '%s', which refers to '%s'", c.id, c.ref, other.ref)
+		}
+	}
+
+	// ids
+	for _, id := range d.ids {
+		if es := d.elements[id]; len(es) > 1 {
+			var names []string
+			for _, e := range es {
+				names = append(names, e.element)
+			}
+			d.add(SeverityError, "duplicateId", es[0], "id '%s' is used %d times, by %s", id, len(es), strings.Join(names, ", "))
+		}
+	}
+
+	// networks
+	nws := d.networks()
+	for i := 1; i < len(nws); i++ {
+		names := nws[i]
+		if len(names) > 10 {
+			names = append(names[:10:10], "...")
+		}
+		e := diagnosed{element: "track", id: nws[i][0], track: nws[i][0]}
+		if len(nws[i]) == 1 {
+			d.add(SeverityWarning, "isolatedNetwork", e, "track '%s' isn't connected to the largest network, of %d tracks", e.id, len(nws[0]))
+			continue
+		}
+		d.add(SeverityWarning, "isolatedNetwork", e, "%d tracks (%s) aren't connected to the largest network, of %d tracks", len(nws[i]), strings.Join(names, ", "), len(nws[0]))
+	}
+
+	findings := append([]Finding{}, d.findings...)
+	rank := map[string]int{SeverityError: 0, SeverityWarning: 1}
+	order := map[string]int{}
+	for i, t := range d.trackIds {
+		if _, ok := order[t]; !ok {
+			order[t] = i
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if rank[findings[i].Severity] != rank[findings[j].Severity] {
+			return rank[findings[i].Severity] < rank[findings[j].Severity]
+		}
+		return order[findings[i].Track] < order[findings[j].Track]
+	})
+	return findings
+}
+
+// networks returns the ids of the tracks of each network, the largest network first
+func (d *diagnosis) networks() [][]string {
+	index := map[string]int{}
+	var networks [][]string
+	seen := map[string]bool{}
+	for _, id := range d.trackIds {
+		if _, ok := d.parent[id]; !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		root := d.find(id)
+		i, ok := index[root]
+		if !ok {
+			i = len(networks)
+			index[root] = i
+			networks = append(networks, nil)
+		}
+		networks[i] = append(networks[i], id)
+	}
+	sort.SliceStable(networks, func(i, j int) bool { return len(networks[i]) > len(networks[j]) })
+	return networks
+}
+
+// mileageFinding is an absPos that doesn't fit the mileage of its track
+type mileageFinding struct {
+	diagnosed
+	detail string
+}
+
+// mileageFindings compares the absPos of the end of a track and the absPosIn of its mileage changes with its mileage
+func mileageFindings(begin *store.Neighbour, end *store.Neighbour, changes []store.Props, tolerance float64) []mileageFinding {
+	b, e := store.Props{}, store.Props{}
+	if begin != nil {
+		b = begin.Relationship.Props
+	}
+	if end != nil {
+		e = end.Relationship.Props
+	}
+	m := newMileage(b, e, changes)
+	if m == nil {
+		return nil
+	}
+
+	var findings []mileageFinding
+	for _, sg := range m.segments[1:] {
+		if math.IsNaN(sg.in) {
+			continue
+		}
+		if want := m.absBefore(sg.pos); math.Abs(sg.in-want) > tolerance {
+			findings = append(findings, mileageFinding{diagnosed{element: "mileageChange", id: sg.id},
+				fmt.Sprintf("mileageChange '%s' at pos %v has an absPosIn of %v, but the mileage before it is %v", sg.id, sg.pos, sg.in, millimetres(want))})
+		}
+	}
+	pos, hasPos := number(e["pos"])
+	abs, hasAbs := number(e["absPos"])
+	if hasPos && hasAbs && e[AbsPosDerived] != true {
+		if want := m.abs(pos); math.Abs(abs-want) > tolerance {
+			id, _ := e["id"].(string)
+			findings = append(findings, mileageFinding{diagnosed{element: "trackEnd", id: id},
+				fmt.Sprintf("trackEnd '%s' at pos %v has an absPos of %v, but the mileage there is %v", id, pos, abs, millimetres(want))})
+		}
+	}
+	return findings
+}
+
+// relProp reads a property of the relationship to a track begin or end, which may be missing
+func relProp(n *store.Neighbour, key string) (interface{}, bool) {
+	if n == nil {
+		return nil, false
+	}
+	v, ok := n.Relationship.Props[key]
+	return v, ok
+}
+
+// elementName is the RailML element of a node, e.g. speedChange for a SpeedChange
+func elementName(n store.Node) string {
+	if len(n.Labels) == 0 || n.Labels[0] == "" {
+		return ""
+	}
+	return strings.ToLower(n.Labels[0][:1]) + n.Labels[0][1:]
+}
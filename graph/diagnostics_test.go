@@ -0,0 +1,188 @@
+package graph
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"Go-GoSAFE.converter/crs"
+	"Go-GoSAFE.converter/store"
+)
+
+func TestImportDuplicateIds(t *testing.T) {
+	g := GraphUtils{}
+	var findings []Finding
+	o := ImportOptions{Line: "L1", Epsg: "4326", Mode: "fail", Workers: 1, Diagnostics: func(f []Finding) { findings = f }}
+
+	// a signal and a switch with the same id are imported, and found
+	file := twoTracks(`<signal id="x1" pos="10"/>`, ``)
+	file = strings.Replace(file, `</trackEnd>`, `</trackEnd><connections><switch id="x1" pos="50"/></connections>`, 1)
+	if _, err := g.ImportLine(context.Background(), store.NewMemoryStore(), strings.NewReader(file), o); err != nil {
+		t.Fatal(err)
+	}
+	var duplicates []Finding
+	for _, f := range findings {
+		if f.Kind == "duplicateId" {
+			duplicates = append(duplicates, f)
+		}
+	}
+	if len(duplicates) != 1 || duplicates[0].ID != "x1" || duplicates[0].Severity != SeverityError {
+		t.Errorf("findings %v, want a duplicateId error for x1", findings)
+	}
+
+	// two signals with the same id fail the import
+	file = twoTracks(`<signal id="x1" pos="10"/>`, `<signal id="x1" pos="20"/>`)
+	_, err := g.ImportLine(context.Background(), store.NewMemoryStore(), strings.NewReader(file), o)
+	if _, ok := err.(*store.ConflictError); !ok {
+		t.Errorf("two signals with the same id: %v, want a ConflictError", err)
+	}
+}
+
+// diagnose imports the file as line L1 and returns its findings of the given kind, without their details
+func diagnose(t *testing.T, file string, tolerance float64, kind string) []Finding {
+	s := store.NewMemoryStore()
+	importLine(t, s, "L1", "fail", file)
+	findings, err := Diagnose(s, lineNode(t, s, "L1"), tolerance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []Finding
+	for _, f := range findings {
+		if f.Kind == kind || kind == "" {
+			f.Detail = ""
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+func TestDiagnose(t *testing.T) {
+	// tr2 goes on from the end of tr1
+	connected := strings.NewReplacer(
+		`<openEnd id="tr1o2"/>`, `<connection id="c1" ref="c2"/>`,
+		`<openEnd id="tr2o1"/>`, `<connection id="c2" ref="c1"/>`,
+	).Replace(twoTracks(``, ``))
+
+	tests := []struct {
+		name string
+		file string
+		kind string
+		want []Finding
+	}{
+		{"a dangling ref", strings.Replace(connected, `ref="c2"`, `ref="c9"`, 1), "danglingRef",
+			[]Finding{{Severity: SeverityError, Kind: "danglingRef", Element: "connection", ID: "c1", Track: "tr1"}}},
+		{"a ref that isn't mutual", strings.Replace(connected, `<openEnd id="tr2o2"/>`, `<connection id="c3" ref="c1"/>`, 1), "notMutual",
+			[]Finding{{Severity: SeverityWarning, Kind: "notMutual", Element: "connection", ID: "c3", Track: "tr2"}}},
+		{"mutual refs", connected, "notMutual", nil},
+		{"a track without a begin", strings.Replace(connected, `<trackBegin id="tr1b" pos="0"><openEnd id="tr1o1"/></trackBegin>`, ``, 1), "missingBegin",
+			[]Finding{{Severity: SeverityError, Kind: "missingBegin", Element: "track", ID: "tr1", Track: "tr1"}}},
+		{"a track without an end", strings.Replace(connected, `<trackEnd id="tr2e" pos="100"><openEnd id="tr2o2"/></trackEnd>`, ``, 1), "missingEnd",
+			[]Finding{{Severity: SeverityError, Kind: "missingEnd", Element: "track", ID: "tr2", Track: "tr2"}}},
+		{"a pos beyond the track", twoTracks(`<signal id="s1" pos="150"/><signal id="s2" pos="100"/>`, `<signal id="s3" pos="-5"/>`), "posBeyondTrack",
+			[]Finding{
+				{Severity: SeverityWarning, Kind: "posBeyondTrack", Element: "signal", ID: "s1", Track: "tr1"},
+				{Severity: SeverityWarning, Kind: "posBeyondTrack", Element: "signal", ID: "s3", Track: "tr2"},
+			}},
+		{"an isolated track", twoTracks(``, ``), "isolatedNetwork",
+			[]Finding{{Severity: SeverityWarning, Kind: "isolatedNetwork", Element: "track", ID: "tr2", Track: "tr2"}}},
+		{"connected tracks", connected, "", nil},
+	}
+	for _, tt := range tests {
+		if got := diagnose(t, tt.file, 10, tt.kind); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: found %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiagnoseOrder(t *testing.T) {
+	// a warning on tr1 and on tr2, and an error on tr2
+	file := twoTracks(`<signal id="s1" pos="150"/>`, ``)
+	file = strings.Replace(file, `<trackEnd id="tr2e" pos="100"><openEnd id="tr2o2"/></trackEnd>`, ``, 1)
+	want := []Finding{
+		{Severity: SeverityError, Kind: "missingEnd", Element: "track", ID: "tr2", Track: "tr2"},
+		{Severity: SeverityWarning, Kind: "posBeyondTrack", Element: "signal", ID: "s1", Track: "tr1"},
+		{Severity: SeverityWarning, Kind: "isolatedNetwork", Element: "track", ID: "tr2", Track: "tr2"},
+	}
+	if got := diagnose(t, file, 10, ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("found %v, want %v", got, want)
+	}
+}
+
+// meridian is a line of one track along the meridian 19°E from 50°N to 50.01°N, whose end is at the given pos
+func meridian(end float64) string {
+	return `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>` +
+		`<trackBegin id="tb1" pos="0"><openEnd id="oe1"/></trackBegin>` +
+		`<trackEnd id="te1" pos="` + strconv.FormatFloat(end, 'f', 3, 64) + `"><openEnd id="oe2"/></trackEnd>` +
+		`</trackTopology><trackElements><geoMappings>` +
+		`<geoMapping><geoCoord coord="50.000 19.0"/></geoMapping><geoMapping><geoCoord coord="50.010 19.0"/></geoMapping>` +
+		`</geoMappings></trackElements></track></tracks></infrastructure></railml>`
+}
+
+func TestDiagnoseLengthMismatch(t *testing.T) {
+	length := crs.Distance(19, 50, 19, 50.01)
+	mismatch := []Finding{{Severity: SeverityWarning, Kind: "lengthMismatch", Element: "track", ID: "tr1", Track: "tr1"}}
+	tests := []struct {
+		name string
+		end  float64
+		want []Finding
+	}{
+		{"the geodesic length", length, nil},
+		{"5 m longer", length + 5, nil},
+		{"5 m shorter", length - 5, nil},
+		{"15 m longer", length + 15, mismatch},
+		{"15 m shorter", length - 15, mismatch},
+	}
+	for _, tt := range tests {
+		if got := diagnose(t, meridian(tt.end), 10, "lengthMismatch"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("a track end at %s: found %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := diagnose(t, meridian(length+15), 20, "lengthMismatch"); got != nil {
+		t.Errorf("a track end 15 m off with a tolerance of 20 m: found %v", got)
+	}
+}
+
+func TestDiagnoseAbsPosMismatch(t *testing.T) {
+	// the mileage runs up from 1000 and after the change at 300 down from 5000, so the end at 1000 is at 4300
+	line := func(absPosIn, absPos string) string {
+		return `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>` +
+			`<trackBegin id="tb1" pos="0" absPos="1000" absDir="up"><openEnd id="oe1"/></trackBegin>` +
+			`<trackEnd id="te1" pos="1000" absPos="` + absPos + `"><openEnd id="oe2"/></trackEnd>` +
+			`<mileageChanges><mileageChange id="mc1" pos="300" absPosIn="` + absPosIn + `" absPos="5000" absDir="down"/></mileageChanges>` +
+			`</trackTopology></track></tracks></infrastructure></railml>`
+	}
+	end := Finding{Severity: SeverityWarning, Kind: "absPosMismatch", Element: "trackEnd", ID: "te1", Track: "tr1"}
+	change := Finding{Severity: SeverityWarning, Kind: "absPosMismatch", Element: "mileageChange", ID: "mc1", Track: "tr1"}
+	tests := []struct {
+		name            string
+		absPosIn, endAt string
+		want            []Finding
+	}{
+		{"the mileage", "1300", "4300", nil},
+		{"within the tolerance", "1305", "4295", nil},
+		{"an end where it would be without the change of absDir", "1300", "5700", []Finding{end}},
+		{"an absPosIn past the change", "1350", "4300", []Finding{change}},
+		{"an absPosIn of the mileage after the change", "5000", "4300", []Finding{change}},
+	}
+	for _, tt := range tests {
+		if got := diagnose(t, line(tt.absPosIn, tt.endAt), 10, "absPosMismatch"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: found %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMileageFindingsDerived(t *testing.T) {
+	begin := &store.Neighbour{Relationship: store.Relationship{Type: "BEGINS", Props: store.Props{"pos": "0", "absPos": "1000", "absDir": "up"}}}
+	end := &store.Neighbour{Relationship: store.Relationship{Type: "ENDS", Props: store.Props{"id": "te1", "pos": "1000", "absPos": "3000"}}}
+	if got := mileageFindings(begin, end, nil, 10); len(got) != 1 || got[0].id != "te1" {
+		t.Errorf("an absPos 1000 m off is found as %v, want te1", got)
+	}
+
+	// an absPos filled in by an import is left alone, whatever it is
+	end.Relationship.Props[AbsPosDerived] = true
+	if got := mileageFindings(begin, end, nil, 10); len(got) != 0 {
+		t.Errorf("a derived absPos is found as %v", got)
+	}
+}
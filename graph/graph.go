@@ -4,128 +4,377 @@ import (
 	"reflect"
 	"strings"
 
+	"Go-GoSAFE.converter/store"
 	"Go-GoSAFE.converter/utils"
 
 	"github.com/beevik/etree"
-	"github.com/jmcvetta/neoism"
 )
 
 type GraphUtils struct{}
 
-func (g *GraphUtils) TrackToGraph(t *etree.Element, db *neoism.Database, epsg string, ln *neoism.Node) string {
+// TrackToGraph converts a track of the line. With fill, missing pos and absPos values of its elements are filled in,
+// see positioner, from geoCoords at most tolerance metres from the track.
+func (g *GraphUtils) TrackToGraph(t *etree.Element, s store.GraphStore, epsg string, ln *store.Node, fill bool, tolerance float64) error {
 
 	elementsUtils := utils.ElementsUtils{}
 	// TRACK
-	tr := elementsUtils.GetTrackProperties(t, epsg)
-	tn, _ := db.CreateNode(tr)
-	tn.AddLabel("Track")
-
-	ln.Relate("HAS_TRACK", tn.Id(), neoism.Props{})
+	tr, err := elementsUtils.GetTrackProperties(t, epsg)
+	if err != nil {
+		return err
+	}
 	// TRACK TOPOLOGIES
-	tt := elementsUtils.GetTrackTopologies(t, epsg)
+	tt, err := elementsUtils.GetTrackTopologies(t, epsg)
+	if err != nil {
+		return err
+	}
+	var changes []store.Props
+	for _, mc := range tt.MileageChanges {
+		changes = append(changes, mc)
+	}
+	// the geodesic length of the track is kept by it, so it has to be measured before the track is created
+	p := newPositioner(tr, tt.Begin.Container, tt.End.Container, changes, fill, tolerance)
+
+	tn, err := s.CreateNode(tr, "Track")
+	if err != nil {
+		return err
+	}
 
-	tb, _ := db.CreateNode(tt.Begin.Properties)
-	tb.AddLabel(tt.Begin.Label)
-	tn.Relate("BEGINS", tb.Id(), tt.Begin.Container)
+	if _, err := s.Relate(ln.ID, "HAS_TRACK", tn.ID, store.Props{}); err != nil {
+		return err
+	}
+	// elements with a pos but no geoCoord get a geometry interpolated along the track, see positioner
+	p.place(tt.Begin.Container, tt.Begin.Properties)
+	p.place(tt.End.Container, tt.End.Properties)
 
-	te, _ := db.CreateNode(tt.End.Properties)
-	te.AddLabel(tt.End.Label)
-	tn.Relate("ENDS", te.Id(), tt.End.Container)
+	if err := createRelated(s, tn, "BEGINS", tt.Begin.Container, tt.Begin.Properties, tt.Begin.Label); err != nil {
+		return err
+	}
+	if err := createRelated(s, tn, "ENDS", tt.End.Container, tt.End.Properties, tt.End.Label); err != nil {
+		return err
+	}
 
 	for _, sw := range tt.Switch {
-		swn, _ := db.CreateNode(sw.Properties)
-		con, _ := db.CreateNode(sw.Connection)
-		swn.AddLabel("Switch")
-		con.AddLabel("Connection")
-		tn.Relate("HAS_SWITCH", swn.Id(), neoism.Props{})
-		swn.Relate("HAS_CONNECTION", con.Id(), neoism.Props{})
+		p.place(sw.Properties, sw.Properties)
+		if err := createConnected(s, tn, "HAS_SWITCH", sw.Properties, "Switch", sw.Connections); err != nil {
+			return err
+		}
 	}
 
 	for _, cr := range tt.Crossing {
-		crn, _ := db.CreateNode(cr.Properties)
-		con, _ := db.CreateNode(cr.Connection)
-		crn.AddLabel("Crossing")
-		con.AddLabel("Connection")
-		tn.Relate("HAS_CROSSING", crn.Id(), neoism.Props{})
-		crn.Relate("HAS_CONNECTION", con.Id(), neoism.Props{})
+		p.place(cr.Properties, cr.Properties)
+		if err := createConnected(s, tn, "HAS_CROSSING", cr.Properties, "Crossing", cr.Connections); err != nil {
+			return err
+		}
+	}
+
+	for _, mc := range tt.MileageChanges {
+		// the absPos of a mileage change is where the mileage jumps to, it can't be worked out
+		p.ref.derive(mc, mc["pos"])
+		if err := createRelated(s, tn, "HAS_MILEAGE_CHANGE", store.Props{}, mc, "MileageChange"); err != nil {
+			return err
+		}
+	}
+
+	for _, cs := range tt.CrossSection {
+		p.place(cs, cs)
+		if err := createRelated(s, tn, "HAS_CROSS_SECTION", store.Props{}, cs, "CrossSection"); err != nil {
+			return err
+		}
 	}
 	// TRACK ELEMENTS
-	tre := elementsUtils.GetTrackElements(t, epsg)
+	tre, err := elementsUtils.GetTrackElements(t, epsg)
+	if err != nil {
+		return err
+	}
 
 	st := reflect.ValueOf(&tre).Elem()
 	for i := 0; i < st.NumField(); i++ {
-		a := st.Field(i).Interface().([]neoism.Props)
+		a := st.Field(i).Interface().([]store.Props)
 		lb := strings.TrimSuffix(st.Type().Field(i).Name, "s")
 
 		for _, e := range a {
-			en, _ := db.CreateNode(e)
-			en.AddLabel(lb)
-			tn.Relate("HAS_TRACK_ELEMENT", en.Id(), neoism.Props{})
+			p.place(e, e)
+			if err := createRelated(s, tn, "HAS_TRACK_ELEMENT", store.Props{}, e, lb); err != nil {
+				return err
+			}
 		}
 	}
 
 	// OCS ELEMENTS
-	oe := elementsUtils.GetOCSElements(t, epsg)
+	oe, err := elementsUtils.GetOCSElements(t, epsg)
+	if err != nil {
+		return err
+	}
 
 	so := reflect.ValueOf(&oe).Elem()
 	for i := 0; i < so.NumField(); i++ {
-		a := so.Field(i).Interface().([]neoism.Props)
+		a := so.Field(i).Interface().([]store.Props)
 		lb := strings.TrimSuffix(so.Type().Field(i).Name, "s")
 
 		for _, e := range a {
-			on, _ := db.CreateNode(e)
-			on.AddLabel(lb)
-			tn.Relate("HAS_OCS_ELEMENT", on.Id(), neoism.Props{})
+			p.place(e, e)
+			if err := createRelated(s, tn, "HAS_OCS_ELEMENT", store.Props{}, e, lb); err != nil {
+				return err
+			}
 		}
 	}
 
-	return "ok"
+	return nil
 }
 
-func (g *GraphUtils) InfraAttributesToGraph(a *etree.Element, db *neoism.Database, ag *neoism.Node) string {
+func (g *GraphUtils) InfraAttributesToGraph(a *etree.Element, s store.GraphStore, ag *store.Node) error {
 	elementsUtils := utils.ElementsUtils{}
 
 	elements := a.SelectElements("infraAttributes")
 	if elements == nil {
-		return "no attributes"
+		return nil
 	}
 	for _, element := range elements {
-		ia := elementsUtils.GetInfraAttributes(element)
-		at, _ := db.CreateNode(neoism.Props{"id": ia.ID})
-		at.AddLabel("InfraAttributes")
-		ag.Relate("HAS_INFRA_ATTRS", at.Id(), neoism.Props{})
+		ia, err := elementsUtils.GetInfraAttributes(element)
+		if err != nil {
+			return err
+		}
+		props := store.Props{"id": ia.ID}
+		if ia.Fragments != "" {
+			props[utils.Fragments] = ia.Fragments
+		}
+		at, err := s.CreateNode(props, "InfraAttributes")
+		if err != nil {
+			return err
+		}
+		if _, err := s.Relate(ag.ID, "HAS_INFRA_ATTRS", at.ID, store.Props{}); err != nil {
+			return err
+		}
 
 		sa := reflect.ValueOf(&ia).Elem()
 		for i := 0; i < sa.NumField(); i++ {
 			name := sa.Type().Field(i).Name
-			if name == "ID" {
+			if name == "ID" || name == "Fragments" || name == "SpeedsFragments" {
+				continue
+			}
+			if name == "GeneralInfraAttributes" {
+				if ia.GeneralInfraAttributes == nil {
+					continue
+				}
+				if err := createNested(s, at, "INFRA_ATTR", store.Props{}, *ia.GeneralInfraAttributes); err != nil {
+					return err
+				}
 				continue
 			}
 			if name == "Speeds" {
-				a := sa.Field(i).Interface().([]neoism.Props)
-				if len(a) == 0 {
+				a := sa.Field(i).Interface().([]store.Props)
+				if len(a) == 0 && ia.SpeedsFragments == "" {
 					continue
 				}
-				nss, _ := db.CreateNode(neoism.Props{})
-				nss.AddLabel(name)
-				at.Relate("INFRA_ATTR", nss.Id(), neoism.Props{})
+				sp := store.Props{}
+				if ia.SpeedsFragments != "" {
+					sp[utils.Fragments] = ia.SpeedsFragments
+				}
+				nss, err := s.CreateNode(sp, name)
+				if err != nil {
+					return err
+				}
+				if _, err := s.Relate(at.ID, "INFRA_ATTR", nss.ID, store.Props{}); err != nil {
+					return err
+				}
 				for _, e := range a {
-					ns, _ := db.CreateNode(e)
-					ns.AddLabel("Speed")
-					nss.Relate("HAS_SPEED", ns.Id(), neoism.Props{})
+					if err := createRelated(s, nss, "HAS_SPEED", store.Props{}, e, "Speed"); err != nil {
+						return err
+					}
 				}
 				continue
 			}
 
-			p := sa.Field(i).Interface().(neoism.Props)
+			p := sa.Field(i).Interface().(store.Props)
 			if len(p) == 0 {
 				continue
 			}
-			a, _ := db.CreateNode(p)
-			a.AddLabel(name)
-			at.Relate("INFRA_ATTR", a.Id(), neoism.Props{})
+			if err := createRelated(s, at, "INFRA_ATTR", store.Props{}, p, name); err != nil {
+				return err
+			}
+		}
+
+	}
+	return nil
+}
+
+// OcpToGraph creates an <ocp /> of the given line together with its <propOperational /> and <propService />.
+func (g *GraphUtils) OcpToGraph(o *etree.Element, s store.GraphStore, epsg string, ln *store.Node) error {
+	elementsUtils := utils.ElementsUtils{}
+
+	ocp, err := elementsUtils.GetOcp(o, epsg)
+	if err != nil {
+		return err
+	}
+	on, err := s.CreateNode(ocp.Properties, "Ocp")
+	if err != nil {
+		return err
+	}
+	if _, err := s.Relate(ln.ID, "HAS_OCP", on.ID, store.Props{}); err != nil {
+		return err
+	}
+
+	if ocp.PropOperational != nil {
+		if err := createRelated(s, on, "OCP_PROP", store.Props{}, ocp.PropOperational, "PropOperational"); err != nil {
+			return err
+		}
+	}
+	if ocp.PropService != nil {
+		if err := createRelated(s, on, "OCP_PROP", store.Props{}, ocp.PropService, "PropService"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineLinks keeps what the relationships between the tracks and ocps of a line are made from, while the line is
+// written chunk by chunk, see ImportLine. Only store ids and RailML ids are kept.
+type lineLinks struct {
+	edges     []idRef               // connections at track begins and ends, with their id
+	referrers map[interface{}][]int // connection id -> connections that refer to it
+	ocps      map[interface{}]int   // ocp id -> ocp
+	ocpRefs   []idRef               // nodes with an ocpRef or ocpStationRef
+}
+
+// idRef is a stored node and a RailML id it has or refers to
+type idRef struct {
+	id   int
+	ref  interface{}
+	attr string
+}
+
+func newLineLinks() *lineLinks {
+	return &lineLinks{referrers: map[interface{}][]int{}, ocps: map[interface{}]int{}}
+}
+
+// add notes the connections, ocps and references to ocps of the line ln in s, a chunk of the line that has been
+// written, sid maps the ids in s to the store ids.
+func (l *lineLinks) add(s store.GraphStore, ln store.Node, sid func(id int) int) error {
+	tracks, err := s.Neighbours(ln.ID, "HAS_TRACK")
+	if err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		nb, err := s.Neighbours(t.Node.ID, "BEGINS", "ENDS", "HAS_SWITCH", "HAS_CROSSING")
+		if err != nil {
+			return err
+		}
+		var connections []store.Node // all connections, including those of switches and crossings
+		for _, n := range nb {
+			switch n.Relationship.Type {
+			case "BEGINS", "ENDS":
+				if n.Node.HasLabel("Connection") {
+					l.edges = append(l.edges, idRef{id: sid(n.Node.ID), ref: n.Node.Props["id"]})
+					connections = append(connections, n.Node)
+				}
+			default:
+				cs, err := s.Neighbours(n.Node.ID, "HAS_CONNECTION")
+				if err != nil {
+					return err
+				}
+				for _, c := range cs {
+					connections = append(connections, c.Node)
+				}
+			}
+		}
+		for _, c := range connections {
+			if ref, ok := c.Props["ref"]; ok {
+				l.referrers[ref] = append(l.referrers[ref], sid(c.ID))
+			}
 		}
+	}
 
+	ocps, err := s.Neighbours(ln.ID, "HAS_OCP")
+	if err != nil {
+		return err
+	}
+	for _, o := range ocps {
+		l.ocps[o.Node.Props["id"]] = sid(o.Node.ID)
+	}
+	nodes, err := Subgraph(s, ln)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes[1:] {
+		for _, attr := range []string{"ocpRef", "ocpStationRef"} {
+			if ref, ok := n.Props[attr]; ok {
+				l.ocpRefs = append(l.ocpRefs, idRef{id: sid(n.ID), ref: ref, attr: attr})
+			}
+		}
+	}
+	return nil
+}
+
+// rels returns the relationships between the connections and to the ocps of the line:
+// every connection at a track begin or end gets a CONNECTS relationship to each connection that refers to it,
+// and every node with an ocpRef or ocpStationRef attribute, like a <macroscopicNode />, a <switch /> or a <signal />,
+// a REFERS_TO_OCP relationship to the <ocp /> it refers to, which keeps the name of the attribute.
+// References to ocps that aren't part of the line are left alone.
+func (l *lineLinks) rels() []storedRel {
+	var rels []storedRel
+	related := map[[2]int]bool{}
+	relate := func(start int, relType string, end int, props store.Props) {
+		if related[[2]int{start, end}] || related[[2]int{end, start}] {
+			return
+		}
+		related[[2]int{start, end}] = true
+		rels = append(rels, storedRel{start: start, relType: relType, end: end, props: props})
+	}
+	for _, e := range l.edges {
+		for _, c := range l.referrers[e.ref] {
+			relate(e.id, "CONNECTS", c, store.Props{})
+		}
+	}
+	for _, r := range l.ocpRefs {
+		if o, ok := l.ocps[r.ref]; ok {
+			relate(r.id, "REFERS_TO_OCP", o, store.Props{"attr": r.attr})
+		}
+	}
+	return rels
+}
+
+// createRelated creates a node with the given label and relates it to n
+func createRelated(s store.GraphStore, n *store.Node, relType string, relProps store.Props, props store.Props, label string) error {
+	c, err := s.CreateNode(props, label)
+	if err != nil {
+		return err
+	}
+	_, err = s.Relate(n.ID, relType, c.ID, relProps)
+	return err
+}
+
+// createNested creates a node for the nested element, related to n, and a node for each of its descendants.
+// Children are related to their parent with HAS_NESTED, the relationship keeps their position among the siblings.
+func createNested(s store.GraphStore, n *store.Node, relType string, relProps store.Props, e utils.Nested) error {
+	c, err := s.CreateNode(e.Properties, e.Label)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Relate(n.ID, relType, c.ID, relProps); err != nil {
+		return err
+	}
+	for i, child := range e.Children {
+		if err := createNested(s, c, "HAS_NESTED", store.Props{"index": i}, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createConnected creates a switch or a crossing related to the track together with its <connection />s,
+// the index of each connection keeps their order
+func createConnected(s store.GraphStore, tn *store.Node, relType string, props store.Props, label string, connections []store.Props) error {
+	n, err := s.CreateNode(props, label)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Relate(tn.ID, relType, n.ID, store.Props{}); err != nil {
+		return err
+	}
+	for i, c := range connections {
+		if err := createRelated(s, n, "HAS_CONNECTION", store.Props{"index": i}, c, "Connection"); err != nil {
+			return err
+		}
 	}
-	return "ok"
+	return nil
 }
@@ -0,0 +1,98 @@
+package graph
+
+import (
+	"testing"
+
+	"Go-GoSAFE.converter/store"
+)
+
+// ocpLine is a line with two ocps, elements of its track refer to them and to an ocp of another line
+const ocpLine = `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>
+	<trackBegin id="tb1" pos="0"><macroscopicNode ocpRef="ocp1"/></trackBegin>
+	<trackEnd id="te1" pos="100"><macroscopicNode ocpRef="elsewhere"/></trackEnd>
+	<connections><switch id="sw1" pos="50" ocpStationRef="ocp2"><connection id="c1" ref="c2" orientation="outgoing" course="left"/></switch></connections>
+	</trackTopology><ocsElements><signals><signal id="s1" pos="20" ocpStationRef="ocp1"/></signals></ocsElements></track></tracks>
+	<operationControlPoints>
+		<ocp id="ocp1" name="Kraków Główny" type="station"><geoCoord coord="50.067 19.945"/>
+			<propOperational operationalType="station"/><propService passenger="true"/></ocp>
+		<ocp id="ocp2" name="Kraków Zabłocie" parentOcpRef="ocp1"/>
+	</operationControlPoints></infrastructure></railml>`
+
+func TestOcpToGraph(t *testing.T) {
+	s := store.NewMemoryStore()
+	importLine(t, s, "L1", "fail", ocpLine)
+
+	lines, _ := s.FindNodes("Line", store.Props{"id": "L1"})
+	nb, err := s.Neighbours(lines[0].ID, "HAS_OCP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ocps := map[string]store.Node{}
+	for _, n := range nb {
+		ocps[n.Node.Props["id"].(string)] = n.Node
+	}
+	if len(ocps) != 2 {
+		t.Fatalf("the line has the ocps %v, want ocp1 and ocp2", ocps)
+	}
+	o := ocps["ocp1"]
+	if o.Props["name"] != "Kraków Główny" || o.Props["type"] != "station" || o.Props["geometry"] != "POINT(19.945 50.067)" {
+		t.Errorf("ocp1 is stored as %v", o.Props)
+	}
+	if ocps["ocp2"].Props["parentOcpRef"] != "ocp1" {
+		t.Errorf("ocp2 is stored as %v", ocps["ocp2"].Props)
+	}
+
+	props, err := s.Neighbours(o.ID, "OCP_PROP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]store.Props{}
+	for _, p := range props {
+		found[p.Node.Labels[0]] = p.Node.Props
+	}
+	if found["PropOperational"]["operationalType"] != "station" || found["PropService"]["passenger"] != "true" || len(found) != 2 {
+		t.Errorf("ocp1 has the properties %v", found)
+	}
+}
+
+func TestRefersToOcp(t *testing.T) {
+	s := store.NewMemoryStore()
+	importLine(t, s, "L1", "fail", ocpLine)
+
+	// the macroscopicNode that refers to an ocp of another line isn't related to anything
+	refs := map[string]string{} // label, id and attribute of the referring element -> ocp id
+	ocps, err := s.FindNodes("Ocp", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range ocps {
+		nb, err := s.Neighbours(o.ID, "REFERS_TO_OCP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range nb {
+			if n.Relationship.End != o.ID {
+				t.Errorf("REFERS_TO_OCP leads from %s to %v", o.Props["id"], n.Node.Props)
+				continue
+			}
+			from := n.Node.Labels[0]
+			if id, ok := n.Node.Props["id"].(string); ok {
+				from += " " + id
+			}
+			refs[from+" "+n.Relationship.Props["attr"].(string)] = o.Props["id"].(string)
+		}
+	}
+	want := map[string]string{
+		"MacroscopicNode ocpRef":   "ocp1",
+		"Switch sw1 ocpStationRef": "ocp2",
+		"Signal s1 ocpStationRef":  "ocp1",
+	}
+	if len(refs) != len(want) {
+		t.Errorf("the ocps are referred to by %v, want %v", refs, want)
+	}
+	for k, v := range want {
+		if refs[k] != v {
+			t.Errorf("%s refers to %q, want %s", k, refs[k], v)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package graph
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"time"
+
+	"Go-GoSAFE.converter/store"
+	"Go-GoSAFE.converter/utils"
+
+	"github.com/beevik/etree"
+)
+
+// ImportOptions tells ImportLine which line a RailML file becomes and how.
+type ImportOptions struct {
+	Line   string
+	Epsg   string // CRS of geoCoords the file doesn't declare one for, may be empty
+	Mode   string // fail, replace or merge, see the import modes
+	Source string // file name of the RailML file
+	// Progress is called after every converted track, infraAttrGroups and ocp
+	// with the number of bytes of the file read so far and the number of converted tracks.
+	Progress func(read int64, tracks int)
+	// Workers is the number of tracks converted at the same time, the number of CPUs if it is 0
+	Workers int
+	// Diagnostics is called with the findings of Diagnose for the converted line, before it is committed
+	Diagnostics func(findings []Finding)
+	// LengthTolerance is the tolerance of Diagnose in metres, and how far geoCoords FillPositions takes a pos from
+	// may be from their track
+	LengthTolerance float64
+	// FillPositions fills in missing pos and absPos values, see positioner
+	FillPositions bool
+}
+
+// flushSize is the number of nodes a chunk of a line is written at, see ImportLine
+var flushSize = 10000
+
+// ImportLine converts a RailML file into a line and writes it to the store.
+// The file is streamed, each track is converted as soon as it has been read and isn't kept.
+// The line is written in chunks of whole tracks, infraAttrGroups and ocps of about flushSize nodes, all of them in a
+// single transaction, so a failure can't leave half of it in the graph. What relates parts of different chunks, like
+// connections between tracks, is kept by id and written with the last chunk, see lineWriter.
+// Tracks are converted concurrently, see trackPool, everything else in document order.
+// It returns the number of imported tracks. Malformed XML fails with an *xml.SyntaxError,
+// elements that can't be converted with an *utils.ElementError and a cancelled ctx with its error.
+func (g *GraphUtils) ImportLine(ctx context.Context, s store.GraphStore, r io.Reader, o ImportOptions) (int, error) {
+	existing, err := s.FindNodes("Line", store.Props{"id": o.Line})
+	if err != nil {
+		return 0, err
+	}
+	if len(existing) > 0 && o.Mode == "fail" {
+		return 0, &store.ConflictError{Msg: "line '" + o.Line + "' already exists, use the replace or merge mode to update it"}
+	}
+
+	// ids are unique within the line, KeyLine checks them and a constraint for each kind of element backs it up
+	if err := Constrain(s); err != nil {
+		return 0, err
+	}
+
+	workers := o.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	pool := newTrackPool(ctx, workers)
+	defer pool.wait() // after cancel, so that no conversion outlives the import
+	defer cancel()
+
+	tx, err := s.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() // nothing happens once it is committed
+
+	w := newLineWriter(tx, o.Line)
+	if o.Diagnostics != nil {
+		w.diagnosis = newDiagnosis(o.LengthTolerance)
+	}
+	if len(existing) > 0 {
+		switch o.Mode {
+		case "replace": // the old line is deleted in the same transaction the new one is written in
+			b := store.NewBatch()
+			for _, e := range existing {
+				b.DeleteTree(e.ID, Owns...)
+			}
+			if _, err := tx.Write(b); err != nil {
+				return 0, err
+			}
+		case "merge":
+			if w.merger, err = newMerger(s, existing[0]); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	elementsUtils := utils.ElementsUtils{}
+	// geoCoords without an epsgCode of their own are in the CRS the file declares, the epsg of the request is the last resort
+	crsDefaults := utils.NewCRSDefaults(o.Epsg)
+
+	lp := store.Props{
+		"id":         o.Line,
+		"importedAt": time.Now().UTC().Format(time.RFC3339),
+		"source":     o.Source,
+	}
+	// the line node is created once the infraAttrGroups, which come first in RailML, told the CRS of the file,
+	// with the attributes of the <infrastructure /> the element e is in
+	line := func(e *etree.Element) (*store.Node, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if w.ln == nil {
+			if epsg := crsDefaults.Default(); epsg != "" {
+				lp["epsg"] = epsg
+			}
+			if e != nil {
+				for k, v := range elementsUtils.GetInfrastructureAttributes(e) {
+					lp[k] = v
+				}
+			}
+			if err := w.start(lp); err != nil {
+				return nil, err
+			}
+		}
+		return w.ln, nil
+	}
+
+	counter := 0
+	// waits for the tracks that are being converted, to keep the document order for everything after them
+	flush := func() error {
+		if w.ln == nil {
+			return nil
+		}
+		n, err := pool.add(w.b, w.ln, true)
+		counter += n
+		return err
+	}
+	stream := utils.ElementStream{
+		Root: func(root *etree.Element) error {
+			for k, v := range elementsUtils.GetNamespaces(root) { // so that extension elements can be exported again
+				lp[k] = v
+			}
+			for k, v := range elementsUtils.GetRootAttributes(root) {
+				lp[k] = v
+			}
+			return nil
+		},
+		Handlers: map[string]utils.Handler{
+			"railml/metadata": func(m *etree.Element, lines utils.Lines) error { // comes before the <infrastructure />
+				props, err := elementsUtils.GetMetadata(m)
+				if err != nil {
+					return err
+				}
+				for k, v := range props {
+					lp[k] = v
+				}
+				return nil
+			},
+			"track": func(t *etree.Element, lines utils.Lines) error {
+				ln, err := line(t)
+				if err != nil {
+					return err
+				}
+				pool.convert(g, t, lines, crsDefaults.ForTrack(t), o.FillPositions, o.LengthTolerance)
+				n, err := pool.add(w.b, ln, false)
+				counter += n
+				if err != nil {
+					return err
+				}
+				return w.flush(false)
+			},
+			"infraAttrGroups": func(a *etree.Element, lines utils.Lines) error {
+				if err := flush(); err != nil {
+					return err
+				}
+				crsDefaults.Add(a)
+				ln, err := line(a)
+				if err != nil {
+					return err
+				}
+				ag, err := w.b.CreateNode(store.Props{}, "InfraAttrGroup")
+				if err != nil {
+					return err
+				}
+				if _, err := w.b.Relate(ln.ID, "HAS_ATTR_GROUP", ag.ID, store.Props{}); err != nil {
+					return err
+				}
+				if err := g.InfraAttributesToGraph(a, w.b, ag); err != nil {
+					return err
+				}
+				return w.flush(false)
+			},
+			"operationControlPoints/ocp": func(ocp *etree.Element, lines utils.Lines) error {
+				if err := flush(); err != nil {
+					return err
+				}
+				ln, err := line(ocp)
+				if err != nil {
+					return err
+				}
+				if err := g.OcpToGraph(ocp, w.b, crsDefaults.Default(), ln); err != nil {
+					return err
+				}
+				return w.flush(false)
+			},
+		},
+		Progress: func(read int64) {
+			if o.Progress != nil {
+				o.Progress(read, counter)
+			}
+		},
+	}
+	if err := stream.Read(r); err != nil {
+		return 0, err
+	}
+	if _, err := line(nil); err != nil {
+		return 0, err
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	if err := w.flush(true); err != nil {
+		return 0, err
+	}
+	if err := w.finish(); err != nil {
+		return 0, err
+	}
+	if o.Diagnostics != nil {
+		o.Diagnostics(w.diagnosis.result())
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	for _, e := range existing {
+		ForgetTopology(s, e.ID)
+	}
+	return counter, nil
+}
+
+// lineWriter writes a line to a transaction in chunks. A chunk is a batch with whole tracks, infraAttrGroups and ocps,
+// together with the topology layer of its tracks. Once it has flushSize nodes it is written, and only what is needed
+// to relate it to the other chunks, to check it and to merge it is kept.
+type lineWriter struct {
+	tx        store.Tx
+	line      string
+	b         *store.Batch // the chunk
+	ln        *store.Node  // the line node in b, nil before the line is started
+	lineID    int          // the store id of the line node, once the first chunk has been written
+	written   bool
+	keys      map[string]map[string]bool // see KeyLine
+	topology  *topologyBuilder
+	links     *lineLinks
+	diagnosis *diagnosis // nil if the line isn't checked
+	merger    *merger    // nil unless the line is merged into a stored one
+}
+
+func newLineWriter(tx store.Tx, line string) *lineWriter {
+	return &lineWriter{
+		tx:       tx,
+		line:     line,
+		b:        store.NewBatch(),
+		keys:     map[string]map[string]bool{},
+		topology: newTopologyBuilder(),
+		links:    newLineLinks(),
+	}
+}
+
+// start creates the line node with the given properties in the first chunk
+func (w *lineWriter) start(props store.Props) error {
+	var err error
+	w.ln, err = w.b.CreateNode(props, "Line")
+	return err
+}
+
+// flush writes the chunk if it has flushSize nodes, or with all, and starts the next one
+func (w *lineWriter) flush(all bool) error {
+	if w.ln == nil || !all && w.b.Len() < flushSize {
+		return nil
+	}
+	b, ln := w.b, *w.ln
+
+	if err := boundGeometries(b, ln); err != nil {
+		return err
+	}
+	tracks, err := b.Neighbours(ln.ID, "HAS_TRACK")
+	if err != nil {
+		return err
+	}
+	if err := w.topology.addTracks(b, tracks); err != nil {
+		return err
+	}
+	if err := KeyLine(b, ln, w.line, w.keys); err != nil {
+		return err
+	}
+	if w.diagnosis != nil {
+		if err := w.diagnosis.line(b, ln); err != nil {
+			return err
+		}
+	}
+
+	wb := b
+	var merged map[int]int
+	if w.merger != nil {
+		if wb, merged, err = w.merger.chunk(b, ln); err != nil {
+			return err
+		}
+	}
+	ids, err := w.tx.Write(wb)
+	if err != nil {
+		return err
+	}
+	sid := func(id int) int {
+		if merged != nil {
+			return ids[merged[id]]
+		}
+		return ids[id]
+	}
+	w.lineID, w.written = sid(ln.ID), true
+	w.topology.stored(sid)
+	if err := w.links.add(b, ln, sid); err != nil {
+		return err
+	}
+
+	w.b = store.NewBatch()
+	w.ln, err = w.b.Existing(store.Node{ID: w.lineID, Labels: ln.Labels, Props: ln.Props}, nil)
+	return err
+}
+
+// finish writes the relationships between the chunks: the connections, the references to ocps and the links
+// of the topology layer
+func (w *lineWriter) finish() error {
+	rels := w.links.rels()
+	linked, tracks := w.topology.links()
+	rels = append(rels, linked...)
+	if w.diagnosis != nil {
+		for _, t := range tracks {
+			w.diagnosis.link(t[0], t[1])
+		}
+	}
+
+	b := store.NewBatch()
+	if w.merger != nil {
+		if err := w.merger.links(b, rels); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range rels {
+			start, err := b.Existing(store.Node{ID: r.start}, nil)
+			if err != nil {
+				return err
+			}
+			end, err := b.Existing(store.Node{ID: r.end}, nil)
+			if err != nil {
+				return err
+			}
+			if _, err := b.Relate(start.ID, r.relType, end.ID, r.props); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := w.tx.Write(b)
+	return err
+}
+
+// trackPool converts tracks on a bounded number of goroutines, each track into a batch of its own.
+// The batches are added to the batch of the line in document order, so that the line is the same
+// as if the tracks had been converted one after the other.
+type trackPool struct {
+	ctx     context.Context
+	slots   chan struct{} // one for each running conversion
+	pending []*convertedTrack
+}
+
+// convertedTrack is a track that is being converted or waits to be added to the line
+type convertedTrack struct {
+	b    *store.Batch
+	ln   *store.Node // stands in for the line node in b
+	err  error
+	done chan struct{}
+}
+
+func newTrackPool(ctx context.Context, workers int) *trackPool {
+	return &trackPool{ctx: ctx, slots: make(chan struct{}, workers)}
+}
+
+// convert starts converting the track as soon as a goroutine is free
+func (p *trackPool) convert(g *GraphUtils, t *etree.Element, lines utils.Lines, epsg string, fill bool, tolerance float64) {
+	ct := &convertedTrack{b: store.NewBatch(), done: make(chan struct{})}
+	p.pending = append(p.pending, ct)
+
+	p.slots <- struct{}{}
+	go func() {
+		defer func() {
+			<-p.slots
+			close(ct.done)
+		}()
+		if ct.err = p.ctx.Err(); ct.err != nil {
+			return
+		}
+		if ct.ln, ct.err = ct.b.CreateNode(store.Props{}, "Line"); ct.err != nil {
+			return
+		}
+		ct.err = g.TrackToGraph(t, ct.b, epsg, ct.ln, fill, tolerance)
+		if ee, ok := ct.err.(*utils.ElementError); ok {
+			ee.Locate(lines)
+		}
+	}()
+}
+
+// add adds the converted tracks at the head of the queue to the batch of the line. With wait, it waits for all of them,
+// else it stops at the first one that isn't done yet, unless too many are waiting.
+// It returns the number of added tracks and the error of the first track that failed.
+func (p *trackPool) add(b *store.Batch, ln *store.Node, wait bool) (int, error) {
+	n := 0
+	for len(p.pending) > 0 {
+		ct := p.pending[0]
+		if wait || len(p.pending) > 2*cap(p.slots) {
+			<-ct.done
+		} else {
+			select {
+			case <-ct.done:
+			default:
+				return n, nil
+			}
+		}
+		p.pending = p.pending[1:]
+
+		if ct.err != nil {
+			return n, ct.err
+		}
+		if err := b.Add(ct.b, map[int]int{ct.ln.ID: ln.ID}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// wait waits until no track is being converted anymore
+func (p *trackPool) wait() {
+	for _, ct := range p.pending {
+		<-ct.done
+	}
+}
@@ -0,0 +1,211 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"Go-GoSAFE.converter/store"
+	"Go-GoSAFE.converter/utils"
+
+	"github.com/beevik/etree"
+)
+
+// chain is a line of n tracks, each going on from the end of the one before. The track with the index bad, if any,
+// has a malformed geoCoord.
+func chain(n int, bad int) string {
+	var b []string
+	b = append(b, `<railml><infrastructure id="inf1"><tracks>`)
+	for i := 0; i < n; i++ {
+		id := strconv.Itoa(i)
+		begin := `<openEnd id="oe` + id + `b"/>`
+		if i > 0 {
+			begin = `<connection id="c` + id + `b" ref="c` + strconv.Itoa(i-1) + `e"/>`
+		}
+		end := `<openEnd id="oe` + id + `e"/>`
+		if i < n-1 {
+			end = `<connection id="c` + id + `e" ref="c` + strconv.Itoa(i+1) + `b"/>`
+		}
+		lat := strconv.FormatFloat(50+float64(i)/100, 'f', 2, 64)
+		coord := lat + ` 19.0`
+		if i == bad {
+			coord = lat + `;19.0`
+		}
+		b = append(b, `<track id="tr`+id+`"><trackTopology>`+
+			`<trackBegin id="tb`+id+`" pos="0">`+begin+`</trackBegin>`+
+			`<trackEnd id="te`+id+`" pos="1000">`+end+`</trackEnd>`+
+			`</trackTopology><trackElements><geoMappings>`+
+			`<geoMapping><geoCoord coord="`+coord+`"/></geoMapping>`+
+			`<geoMapping><geoCoord coord="`+strconv.FormatFloat(50+float64(i+1)/100, 'f', 2, 64)+` 19.0"/></geoMapping>`+
+			`</geoMappings></trackElements><ocsElements><signals>`+
+			`<signal id="s`+id+`a" pos="100" dir="up"/><signal id="s`+id+`b" pos="600"/>`+
+			`</signals></ocsElements></track>`)
+	}
+	b = append(b, `</tracks></infrastructure></railml>`)
+	return strings.Join(b, "\n")
+}
+
+// imported returns the line with the given id as it is stored, without the time of its import
+func imported(t *testing.T, s store.GraphStore, line string) *store.Subgraph {
+	lines, err := s.FindNodes("Line", store.Props{"id": line})
+	if err != nil || len(lines) != 1 {
+		t.Fatalf("%d lines %s: %v", len(lines), line, err)
+	}
+	g, err := s.Subgraph(lines[0].ID, Owns...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(g.Nodes[0].Props, "importedAt")
+	return g
+}
+
+func TestTrackPool(t *testing.T) {
+	file := chain(60, -1)
+	var want *store.Subgraph
+	for _, workers := range []int{1, 2, 8} {
+		s := store.NewMemoryStore()
+		g := GraphUtils{}
+		n, err := g.ImportLine(context.Background(), s, strings.NewReader(file), ImportOptions{Line: "L1", Epsg: "4326", Mode: "fail", Workers: workers})
+		if err != nil {
+			t.Fatalf("%d workers: %v", workers, err)
+		}
+		if n != 60 {
+			t.Errorf("%d workers imported %d tracks, want 60", workers, n)
+		}
+		// the tracks are added in document order, so the line is the same node by node
+		got := imported(t, s, "L1")
+		if want == nil {
+			want = got
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("the line imported with %d workers differs from the one imported with 1", workers)
+		}
+	}
+}
+
+func TestTrackPoolError(t *testing.T) {
+	for _, bad := range []int{0, 37, 59} {
+		s := store.NewMemoryStore()
+		g := GraphUtils{}
+		_, err := g.ImportLine(context.Background(), s, strings.NewReader(chain(60, bad)), ImportOptions{Line: "L1", Epsg: "4326", Mode: "fail", Workers: 8})
+		ee, ok := err.(*utils.ElementError)
+		if !ok {
+			t.Errorf("a malformed track %d: %v, want an ElementError", bad, err)
+			continue
+		}
+		// whichever worker converts it, the error tells the line of the track, bad+2 after the one of the <railml />
+		if ee.Line != bad+2 || !strings.HasSuffix(ee.Path, "/geoCoord") || !strings.Contains(ee.Error(), `invalid coord "`) {
+			t.Errorf("a malformed track %d: %v", bad, ee)
+		}
+		if lines, _ := s.FindNodes("Line", store.Props{"id": "L1"}); len(lines) > 0 {
+			t.Errorf("a malformed track %d: the line was written", bad)
+		}
+	}
+}
+
+func TestTrackPoolCancel(t *testing.T) {
+	g := GraphUtils{}
+
+	// cancelled before the import starts
+	s := store.NewMemoryStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.ImportLine(ctx, s, strings.NewReader(chain(10, -1)), ImportOptions{Line: "L1", Epsg: "4326", Mode: "fail", Workers: 4}); err != context.Canceled {
+		t.Errorf("a cancelled import: %v, want %v", err, context.Canceled)
+	}
+
+	// and while the tracks are converted
+	s = store.NewMemoryStore()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	progress := func(read int64, tracks int) {
+		if tracks >= 5 {
+			cancel()
+		}
+	}
+	o := ImportOptions{Line: "L1", Epsg: "4326", Mode: "fail", Workers: 4, Progress: progress}
+	if _, err := g.ImportLine(ctx, s, strings.NewReader(chain(200, -1)), o); err != context.Canceled {
+		t.Errorf("an import cancelled on the way: %v, want %v", err, context.Canceled)
+	}
+	if lines, _ := s.FindNodes("Line", store.Props{"id": "L1"}); len(lines) > 0 {
+		t.Error("the cancelled import wrote its line")
+	}
+
+	// a pool whose context is done converts nothing
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(chain(1, -1)); err != nil {
+		t.Fatal(err)
+	}
+	p := newTrackPool(ctx, 2)
+	p.convert(&g, doc.FindElement("//track"), utils.Lines{}, "4326", false, 10)
+	if n, err := p.add(store.NewBatch(), &store.Node{}, true); n != 0 || err != context.Canceled {
+		t.Errorf("a cancelled pool added %d tracks: %v", n, err)
+	}
+}
+
+// failingStore is a MemoryStore whose transactions fail their write with the index failAt
+type failingStore struct {
+	*store.MemoryStore
+	failAt int
+	writes int // of the last transaction
+}
+
+func (s *failingStore) Begin() (store.Tx, error) {
+	tx, err := s.MemoryStore.Begin()
+	s.writes = 0
+	return &failingTx{Tx: tx, s: s}, err
+}
+
+type failingTx struct {
+	store.Tx
+	s *failingStore
+}
+
+func (t *failingTx) Write(b *store.Batch) (map[int]int, error) {
+	t.s.writes++
+	if t.s.writes > t.s.failAt {
+		t.Tx.Rollback()
+		return nil, errors.New("the database went away")
+	}
+	return t.Tx.Write(b)
+}
+
+// TestImportLineRollback fails the transaction of an import after some chunks of the line have been written to it,
+// nothing of the line may be stored then, and a line the import replaces must be left as it was
+func TestImportLineRollback(t *testing.T) {
+	defer func(size int) { flushSize = size }(flushSize)
+	flushSize = 20
+
+	s := &failingStore{MemoryStore: store.NewMemoryStore(), failAt: 1000}
+	g := GraphUtils{}
+	o := ImportOptions{Line: "L1", Epsg: "4326", Mode: "fail", Workers: 1}
+	if _, err := g.ImportLine(context.Background(), s, strings.NewReader(chain(2, -1)), o); err != nil {
+		t.Fatal(err)
+	}
+	before := imported(t, s, "L1")
+
+	for _, mode := range []string{"fail", "replace"} {
+		o.Line, o.Mode = "L2", mode
+		if mode == "replace" {
+			o.Line = "L1"
+		}
+		s.failAt = 3
+		if _, err := g.ImportLine(context.Background(), s, strings.NewReader(chain(20, -1)), o); err == nil || err.Error() != "the database went away" {
+			t.Errorf("%s: the import with a failing write: %v", mode, err)
+		}
+		if s.writes <= s.failAt {
+			t.Fatalf("%s: the import wrote %d times, want more than %d", mode, s.writes, s.failAt)
+		}
+		if tracks, _ := s.FindNodes("Track", nil); len(tracks) != 2 {
+			t.Errorf("%s: there are %d tracks after the failed import, want the 2 of L1", mode, len(tracks))
+		}
+		if lines, _ := s.FindNodes("Line", store.Props{"id": "L2"}); len(lines) > 0 {
+			t.Errorf("%s: L2 was stored", mode)
+		}
+		if after := imported(t, s, "L1"); !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: L1 changed", mode)
+		}
+	}
+}
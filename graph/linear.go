@@ -0,0 +1,294 @@
+package graph
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"Go-GoSAFE.converter/crs"
+	"Go-GoSAFE.converter/store"
+	"Go-GoSAFE.converter/utils"
+)
+
+// GeometryDerived is the property that marks a geometry the converter interpolated from the pos of an element,
+// as opposed to one read from its <geoCoord />. Derived geometries aren't exported as geoCoords.
+const GeometryDerived = "geometryDerived"
+
+// polyline is the LINESTRING geometry of a track, with the geodesic length from its first point to each point
+type polyline struct {
+	points  [][]float64 // wgs84 longitude, latitude and maybe the height
+	lengths []float64   // in metres
+}
+
+// parsePolyline reads a LINESTRING geometry as created by the import
+func parsePolyline(wkt string) (*polyline, error) {
+	kind, coords, err := utils.ParseWKT(wkt)
+	if err != nil {
+		return nil, err
+	}
+	if kind != "LINESTRING" {
+		return nil, fmt.Errorf("expected a LINESTRING, got %s", kind)
+	}
+	l := &polyline{}
+	for _, c := range coords {
+		fs := strings.Fields(c)
+		if len(fs) < 2 {
+			return nil, fmt.Errorf("invalid coord %q", c)
+		}
+		p := make([]float64, len(fs))
+		for i := range fs {
+			if p[i], err = strconv.ParseFloat(fs[i], 64); err != nil {
+				return nil, fmt.Errorf("invalid coord %q", c)
+			}
+		}
+		length := 0.0
+		if n := len(l.points); n > 0 {
+			prev := l.points[n-1]
+			length = l.lengths[n-1] + crs.Distance(prev[0], prev[1], p[0], p[1])
+		}
+		l.points = append(l.points, p)
+		l.lengths = append(l.lengths, length)
+	}
+	if len(l.points) == 0 {
+		return nil, fmt.Errorf("empty LINESTRING")
+	}
+	return l, nil
+}
+
+// length is the geodesic length of the line in metres
+func (l *polyline) length() float64 {
+	return l.lengths[len(l.lengths)-1]
+}
+
+// at returns the point the given distance along the line from its first point,
+// within a segment the point is interpolated linearly
+func (l *polyline) at(d float64) []float64 {
+	if d <= 0 || len(l.points) == 1 {
+		return l.points[0]
+	}
+	for i := 1; i < len(l.points); i++ {
+		if d > l.lengths[i] && i < len(l.points)-1 {
+			continue
+		}
+		seg := l.lengths[i] - l.lengths[i-1]
+		t := 1.0
+		if seg > 0 {
+			t = math.Min(1, (d-l.lengths[i-1])/seg)
+		}
+		return interpolate(l.points[i-1], l.points[i], t)
+	}
+	return l.points[len(l.points)-1]
+}
+
+// metresPerDegree is the length of a degree of latitude, on the mean radius of the earth
+const metresPerDegree = 6371008.8 * math.Pi / 180
+
+// snap finds the point of the line nearest to the given longitude and latitude. It returns its distance along the line,
+// the point and how far the given point is from it, in metres.
+func (l *polyline) snap(lon, lat float64) (float64, []float64, float64) {
+	if len(l.points) == 1 {
+		p := l.points[0]
+		return 0, p, crs.Distance(lon, lat, p[0], p[1])
+	}
+	// segments are short enough to be projected to a plane around the point
+	kx := math.Cos(lat*math.Pi/180) * metresPerDegree
+	best, bestD, bestOff := []float64(nil), 0.0, math.Inf(1)
+	for i := 1; i < len(l.points); i++ {
+		a, b := l.points[i-1], l.points[i]
+		ax, ay := (a[0]-lon)*kx, (a[1]-lat)*metresPerDegree
+		bx, by := (b[0]-lon)*kx, (b[1]-lat)*metresPerDegree
+		dx, dy := bx-ax, by-ay
+		t := 0.0
+		if d2 := dx*dx + dy*dy; d2 > 0 {
+			t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/d2))
+		}
+		p := interpolate(a, b, t)
+		if off := crs.Distance(lon, lat, p[0], p[1]); off < bestOff {
+			best, bestOff = p, off
+			bestD = l.lengths[i-1] + t*(l.lengths[i]-l.lengths[i-1])
+		}
+	}
+	return bestD, best, bestOff
+}
+
+// beyond reports whether the nearest point of the line to the given longitude and latitude is one of its ends
+// because the point lies before the first or after the last point of the line, not beside it
+func (l *polyline) beyond(lon, lat float64) bool {
+	n := len(l.points)
+	if n < 2 {
+		return true
+	}
+	kx := math.Cos(lat*math.Pi/180) * metresPerDegree
+	// the projection of the point onto the first and the last segment
+	outside := func(a, b []float64) bool {
+		px, py := (lon-a[0])*kx, (lat-a[1])*metresPerDegree
+		dx, dy := (b[0]-a[0])*kx, (b[1]-a[1])*metresPerDegree
+		return px*dx+py*dy < 0
+	}
+	return outside(l.points[0], l.points[1]) || outside(l.points[n-1], l.points[n-2])
+}
+
+// interpolate returns the point at t between a and b
+func interpolate(a []float64, b []float64, t float64) []float64 {
+	p := make([]float64, len(a))
+	for i := range a {
+		if i < len(b) {
+			p[i] = a[i] + t*(b[i]-a[i])
+		} else {
+			p[i] = a[i]
+		}
+	}
+	return p
+}
+
+// pointWKT writes a point like the import does for wgs84 coords
+func pointWKT(p []float64) string {
+	kind := "POINT"
+	s := []string{strconv.FormatFloat(p[0], 'f', 6, 64), strconv.FormatFloat(p[1], 'f', 6, 64)}
+	if len(p) > 2 {
+		kind += " Z "
+		s = append(s, strconv.FormatFloat(p[2], 'f', -1, 64))
+	}
+	return kind + "(" + strings.Join(s, " ") + ")"
+}
+
+// linearRef maps positions on a track to points of its geometry and back. The begin of the track is at the first point
+// of its LINESTRING, the end at the last one and every pos in between is as far along the line as it is between them.
+type linearRef struct {
+	line     *polyline
+	from, to float64 // pos of the begin and the end
+}
+
+// newLinearRef creates the linear reference of a track, nil if the track has no geometry or its begin or end no pos
+func newLinearRef(geometry interface{}, begin interface{}, end interface{}) *linearRef {
+	wkt, ok := geometry.(string)
+	if !ok {
+		return nil
+	}
+	from, ok := number(begin)
+	if !ok {
+		return nil
+	}
+	to, ok := number(end)
+	if !ok || to == from {
+		return nil
+	}
+	line, err := parsePolyline(wkt)
+	if err != nil {
+		return nil
+	}
+	return &linearRef{line: line, from: from, to: to}
+}
+
+// point returns the point at the given pos, ok is false if the pos is beyond the track
+func (r *linearRef) point(pos float64) ([]float64, bool) {
+	t := (pos - r.from) / (r.to - r.from)
+	if t < 0 || t > 1 {
+		return nil, false
+	}
+	return r.line.at(t * r.line.length()), true
+}
+
+// pos returns the pos of the point of the track nearest to the given longitude and latitude,
+// together with that point and how far the given point is from it, in metres
+func (r *linearRef) pos(lon, lat float64) (float64, []float64, float64) {
+	d, p, off := r.line.snap(lon, lat)
+	t := 0.0
+	if l := r.line.length(); l > 0 {
+		t = d / l
+	}
+	return r.from + t*(r.to-r.from), p, off
+}
+
+// derive sets the geometry of an element that has a pos but no geometry of its own, marked with GeometryDerived
+func (r *linearRef) derive(props store.Props, pos interface{}) {
+	if r == nil {
+		return
+	}
+	if _, ok := props["geometry"]; ok {
+		return
+	}
+	p, ok := number(pos)
+	if !ok {
+		return
+	}
+	if pt, ok := r.point(p); ok {
+		props["geometry"] = pointWKT(pt)
+		props[GeometryDerived] = true
+	}
+}
+
+// Location is where a point is on a track of a line, see Locate.
+type Location struct {
+	Track    string     `json:"track"`
+	Pos      float64    `json:"pos"`
+	Coord    [2]float64 `json:"coord"`    // wgs84 longitude and latitude of the point of the track at pos
+	Distance float64    `json:"distance"` // from the given point in metres
+}
+
+// GeometryError is returned when a line has no track a point can be snapped onto.
+type GeometryError struct {
+	Msg string
+}
+
+func (e *GeometryError) Error() string {
+	return e.Msg
+}
+
+// Locate snaps the given wgs84 longitude and latitude onto the nearest track of the line, or onto the given track,
+// and returns the pos there. Only tracks with a geometry and a pos at their begin and end are taken into account.
+// The tracks are read with their begins and ends at once, they are looked at in memory.
+func Locate(s store.GraphStore, ln store.Node, lon float64, lat float64, track string) (Location, error) {
+	g, err := s.Subgraph(ln.ID, "HAS_TRACK", "BEGINS", "ENDS")
+	if err != nil {
+		return Location{}, err
+	}
+	m := store.NewMemoryStoreFrom(g)
+	tracks, err := m.Neighbours(ln.ID, "HAS_TRACK")
+	if err != nil {
+		return Location{}, err
+	}
+	var best *Location
+	found := false
+	for _, t := range tracks {
+		id, _ := t.Node.Props["id"].(string)
+		if track != "" && id != track {
+			continue
+		}
+		found = true
+		nb, err := m.Neighbours(t.Node.ID, "BEGINS", "ENDS")
+		if err != nil {
+			return Location{}, err
+		}
+		var begin, end interface{}
+		for _, n := range nb {
+			switch n.Relationship.Type {
+			case "BEGINS":
+				begin = n.Relationship.Props["pos"]
+			case "ENDS":
+				end = n.Relationship.Props["pos"]
+			}
+		}
+		r := newLinearRef(t.Node.Props["geometry"], begin, end)
+		if r == nil {
+			continue
+		}
+		pos, p, off := r.pos(lon, lat)
+		if best == nil || off < best.Distance {
+			best = &Location{Track: id, Pos: millimetres(pos), Coord: [2]float64{p[0], p[1]}, Distance: millimetres(off)}
+		}
+	}
+	if track != "" && !found {
+		return Location{}, &store.NotFoundError{Label: "Track", ID: track}
+	}
+	if best == nil {
+		return Location{}, &GeometryError{Msg: "there is no track with a geometry and a pos at its begin and end to locate the point on"}
+	}
+	return *best, nil
+}
+
+// millimetres rounds metres to millimetres
+func millimetres(m float64) float64 {
+	return math.Floor(m*1000+0.5) / 1000
+}
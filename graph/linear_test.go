@@ -0,0 +1,107 @@
+package graph
+
+import (
+	"math"
+	"testing"
+
+	"Go-GoSAFE.converter/crs"
+	"Go-GoSAFE.converter/store"
+)
+
+// mapped is a line of two tracks along the meridians 19°E and 19.01°E, 1000 long from 50°N to 50.01°N, whose geometry
+// has a point in between
+func mapped(first string, second string) string {
+	track := func(id string, lon string, elements string) string {
+		mapping := ""
+		for _, lat := range []string{"50.000", "50.004", "50.010"} {
+			mapping += `<geoMapping><geoCoord coord="` + lat + ` ` + lon + `"/></geoMapping>`
+		}
+		return `<track id="` + id + `"><trackTopology>` +
+			`<trackBegin id="` + id + `b" pos="0"><openEnd id="` + id + `o1"/></trackBegin>` +
+			`<trackEnd id="` + id + `e" pos="1000"><openEnd id="` + id + `o2"/></trackEnd>` +
+			`</trackTopology><trackElements><geoMappings>` + mapping + `</geoMappings></trackElements>` +
+			`<ocsElements><signals>` + elements + `</signals></ocsElements></track>`
+	}
+	return `<railml><infrastructure id="inf1"><tracks>` + track("tr1", "19.00", first) + track("tr2", "19.01", second) +
+		`</tracks></infrastructure></railml>`
+}
+
+// signal returns the node of the signal with the given id
+func signal(t *testing.T, s store.GraphStore, id string) store.Node {
+	signals, err := s.FindNodes("Signal", store.Props{"id": id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signals) != 1 {
+		t.Fatalf("%d signals %s, want 1", len(signals), id)
+	}
+	return signals[0]
+}
+
+func TestDeriveGeometry(t *testing.T) {
+	s := store.NewMemoryStore()
+	importLine(t, s, "L1", "fail", mapped(`<signal id="s1" pos="250"/><signal id="s2" pos="400"/><signal id="s3" pos="700"/>`+
+		`<signal id="s4" pos="1200"/><signal id="s5"/><signal id="s6" pos="500"><geoCoord coord="50.005 19.0005"/></signal>`, ``))
+
+	tests := []struct {
+		id       string
+		geometry interface{}
+		derived  interface{}
+	}{
+		{"s1", "POINT(19.000000 50.002500)", true},
+		{"s2", "POINT(19.000000 50.004000)", true}, // at the point in between
+		{"s3", "POINT(19.000000 50.007000)", true},
+		{"s4", nil, nil}, // beyond the end of the track
+		{"s5", nil, nil}, // without a pos
+		{"s6", "POINT(19.0005 50.005)", nil},
+	}
+	for _, tt := range tests {
+		n := signal(t, s, tt.id)
+		if n.Props["geometry"] != tt.geometry || n.Props[GeometryDerived] != tt.derived {
+			t.Errorf("%s has the geometry %v, derived %v, want %v, %v", tt.id, n.Props["geometry"], n.Props[GeometryDerived], tt.geometry, tt.derived)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	s := store.NewMemoryStore()
+	importLine(t, s, "L1", "fail", mapped(``, ``))
+	ln := lineNode(t, s, "L1")
+
+	tests := []struct {
+		lon, lat float64
+		track    string
+		want     Location
+	}{
+		{19.001, 50.007, "", Location{Track: "tr1", Pos: 700, Coord: [2]float64{19, 50.007}}},
+		{19.009, 50.002, "", Location{Track: "tr2", Pos: 200, Coord: [2]float64{19.01, 50.002}}},
+		{19.001, 50.007, "tr2", Location{Track: "tr2", Pos: 700, Coord: [2]float64{19.01, 50.007}}},
+		// beyond the begin, the begin is nearest
+		{19.0, 49.99, "", Location{Track: "tr1", Pos: 0, Coord: [2]float64{19, 50}}},
+	}
+	for _, tt := range tests {
+		got, err := Locate(s, ln, tt.lon, tt.lat, tt.track)
+		if err != nil {
+			t.Errorf("%v %v: %v", tt.lon, tt.lat, err)
+			continue
+		}
+		distance := crs.Distance(tt.lon, tt.lat, tt.want.Coord[0], tt.want.Coord[1])
+		if got.Track != tt.want.Track || math.Abs(got.Pos-tt.want.Pos) > 0.01 || math.Abs(got.Distance-distance) > 0.01 ||
+			math.Abs(got.Coord[0]-tt.want.Coord[0]) > 1e-7 || math.Abs(got.Coord[1]-tt.want.Coord[1]) > 1e-7 {
+			t.Errorf("%v %v on %q is %+v, want %+v %.3f m away", tt.lon, tt.lat, tt.track, got, tt.want, distance)
+		}
+	}
+
+	if _, err := Locate(s, ln, 19, 50, "tr9"); err == nil {
+		t.Error("located a point on a track that doesn't exist")
+	} else if _, ok := err.(*store.NotFoundError); !ok {
+		t.Errorf("a track that doesn't exist: %v, want a NotFoundError", err)
+	}
+
+	importLine(t, s, "L2", "fail", network)
+	if _, err := Locate(s, lineNode(t, s, "L2"), 19, 50, ""); err == nil {
+		t.Error("located a point on a line without geometries")
+	} else if _, ok := err.(*GeometryError); !ok {
+		t.Errorf("a line without geometries: %v, want a GeometryError", err)
+	}
+}
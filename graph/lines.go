@@ -0,0 +1,243 @@
+package graph
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"Go-GoSAFE.converter/store"
+	"Go-GoSAFE.converter/utils"
+)
+
+// Owns lists the relationship types that lead from a node to the nodes it is made of,
+// e.g. from a line to its tracks and from a track to its elements.
+// Deleting or merging a node follows these, other relationships (like CONNECTS or REFERS_TO_OCP) only refer to other nodes.
+var Owns = []string{
+	"HAS_TRACK",
+	"HAS_ATTR_GROUP",
+	"HAS_OCP",
+	"OCP_PROP",
+	"BEGINS",
+	"ENDS",
+	"HAS_SWITCH",
+	"HAS_CROSSING",
+	"HAS_MILEAGE_CHANGE",
+	"HAS_CROSS_SECTION",
+	"HAS_CONNECTION",
+	"HAS_TRACK_ELEMENT",
+	"HAS_OCS_ELEMENT",
+	"HAS_INFRA_ATTRS",
+	"INFRA_ATTR",
+	"HAS_SPEED",
+	"HAS_NESTED",
+	HasTopologyNode,
+}
+
+// Children returns the nodes owned directly by the node with the given id.
+func Children(s store.GraphStore, id int) ([]store.Neighbour, error) {
+	nb, err := s.Neighbours(id, Owns...)
+	if err != nil {
+		return nil, err
+	}
+	children := []store.Neighbour{}
+	for _, n := range nb {
+		if n.Relationship.Start == id {
+			children = append(children, n)
+		}
+	}
+	return children, nil
+}
+
+// Subgraph returns the given node and all nodes it owns, directly or not, the given node first.
+// They are read at once, see store.GraphStore.Subgraph.
+func Subgraph(s store.GraphStore, root store.Node) ([]store.Node, error) {
+	g, err := s.Subgraph(root.ID, Owns...)
+	if err != nil {
+		return nil, err
+	}
+	return g.Nodes, nil
+}
+
+// Load reads the given node and all nodes it owns at once into memory, with the relationships between them.
+// The returned store keeps their ids, so code that looks at a line node by node can run on it instead of
+// making a round trip to the store for each node.
+func Load(s store.GraphStore, root store.Node) (*store.MemoryStore, error) {
+	g, err := s.Subgraph(root.ID, Owns...)
+	if err != nil {
+		return nil, err
+	}
+	return store.NewMemoryStoreFrom(g), nil
+}
+
+// LineKey is the property that keeps the line and the RailML id of an element, e.g. L1/sw1. Uniqueness constraints
+// on it make sure no line has two elements of the same kind with the same id, while other lines can use the id again.
+const LineKey = "lineKey"
+
+// KeyedLabels are the kinds of elements a line is made of that have a uniqueness constraint on their LineKey,
+// see Constrain. The elements of a <generalInfraAttributes /> can have any tag, KeyLine alone checks them.
+var KeyedLabels = keyedLabels()
+
+func keyedLabels() []string {
+	labels := []string{"Track", "Connection", "BufferStop", "OpenEnd", "MacroscopicNode", "Switch", "Crossing",
+		"MileageChange", "CrossSection", "InfraAttributes", "Speed", "Ocp", "PropOperational", "PropService"}
+	for _, t := range []reflect.Type{reflect.TypeOf(utils.TrackElements{}), reflect.TypeOf(utils.OCSElements{})} {
+		for i := 0; i < t.NumField(); i++ {
+			labels = append(labels, strings.TrimSuffix(t.Field(i).Name, "s")) // as in TrackToGraph
+		}
+	}
+	ia := reflect.TypeOf(utils.InfraAttributes{})
+	for i := 0; i < ia.NumField(); i++ {
+		if ia.Field(i).Type.Kind() == reflect.Map { // as in InfraAttributesToGraph
+			labels = append(labels, ia.Field(i).Name)
+		}
+	}
+	return labels
+}
+
+// Constrain creates the uniqueness constraints on the id of lines, a line can be imported only once, see the import
+// modes, and on the LineKey of the KeyedLabels. Neo4j can't create one while a transaction that writes nodes of its
+// label is open, so it has to be called before a line is written.
+// Constraints that have been created already aren't created again. It returns the first error, but tries them all,
+// so that a store that can't be reached yet creates them all once it can.
+func Constrain(s store.GraphStore) error {
+	err := s.Unique("Line", "id")
+	for _, l := range KeyedLabels {
+		if uerr := s.Unique(l, LineKey); err == nil {
+			err = uerr
+		}
+	}
+	return err
+}
+
+// KeyLine sets the LineKey of every element of the given line in the batch that has an id. keys holds the keys by
+// label of the parts of the line keyed before, if it is imported chunk by chunk, and gets those of the batch.
+// Elements of the same kind with the same id fail with a ConflictError.
+func KeyLine(b *store.Batch, ln store.Node, line string, keys map[string]map[string]bool) error {
+	nodes, err := Subgraph(b, ln)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes[1:] {
+		id, ok := n.Props["id"].(string)
+		if !ok || len(n.Labels) == 0 || n.HasLabel(TopologyNodeLabel) {
+			continue // the topology layer is derived from the elements
+		}
+		key := line + "/" + id
+		for _, l := range n.Labels {
+			if keys[l] == nil {
+				keys[l] = map[string]bool{}
+			}
+			if keys[l][key] {
+				return &store.ConflictError{Msg: "line '" + line + "' has more than one " + l + " with id '" + id + "'"}
+			}
+			keys[l][key] = true
+		}
+		n.Props[LineKey] = key
+		if err := b.SetProps(n.ID, n.Props); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boundGeometries sets the store.BBoxProperty of every node of the given line in the batch that has a geometry,
+// so that the store can select them by where they are. Geometries that can't be read are left as they are.
+func boundGeometries(b *store.Batch, ln store.Node) error {
+	nodes, err := Subgraph(b, ln)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes[1:] {
+		wkt, ok := n.Props["geometry"].(string)
+		if !ok {
+			continue
+		}
+		box, ok := geometryBBox(wkt)
+		if !ok {
+			continue
+		}
+		n.Props[store.BBoxProperty] = box
+		if err := b.SetProps(n.ID, n.Props); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// geometryBBox returns the min x, min y, max x, max y box of a WKT POINT or LINESTRING
+func geometryBBox(wkt string) ([]float64, bool) {
+	_, coords, err := utils.ParseWKT(wkt)
+	if err != nil {
+		return nil, false
+	}
+	box := []float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, c := range coords {
+		xy := strings.Fields(c)
+		if len(xy) < 2 {
+			return nil, false
+		}
+		for i := 0; i < 2; i++ {
+			v, err := strconv.ParseFloat(xy[i], 64)
+			if err != nil {
+				return nil, false
+			}
+			box[i] = math.Min(box[i], v)
+			box[i+2] = math.Max(box[i+2], v)
+		}
+	}
+	return box, len(coords) > 0
+}
+
+// LineSummary describes a stored line.
+type LineSummary struct {
+	ID       string                 `json:"id"`
+	Metadata map[string]interface{} `json:"metadata"`           // all other properties of the line node, e.g. when it was imported
+	Tracks   int                    `json:"tracks"`             // number of tracks
+	Elements map[string]int         `json:"elements,omitempty"` // label -> number of nodes the line is made of
+}
+
+// summary describes the line by its properties, without counting anything
+func summary(ln store.Node) LineSummary {
+	ls := LineSummary{Metadata: map[string]interface{}{}}
+	for k, v := range ln.Props {
+		if k == "id" {
+			ls.ID, _ = v.(string)
+		} else if !strings.HasPrefix(k, "#") { // kept for the export, see utils.Metadata
+			ls.Metadata[k] = v
+		}
+	}
+	return ls
+}
+
+// SummarizeAll describes all stored lines with the number of their tracks, read at once however many lines there are.
+func SummarizeAll(s store.GraphStore) ([]LineSummary, error) {
+	lines, err := s.CountRelated("Line", "HAS_TRACK")
+	if err != nil {
+		return nil, err
+	}
+	summaries := []LineSummary{}
+	for _, ln := range lines {
+		ls := summary(ln.Node)
+		ls.Tracks = ln.Count
+		summaries = append(summaries, ls)
+	}
+	return summaries, nil
+}
+
+// Summarize describes the given line, with all the nodes it is made of counted by their label.
+func Summarize(s store.GraphStore, ln store.Node) (LineSummary, error) {
+	ls := summary(ln)
+	nodes, err := Subgraph(s, ln)
+	if err != nil {
+		return ls, err
+	}
+	ls.Elements = map[string]int{}
+	for _, n := range nodes[1:] {
+		for _, l := range n.Labels {
+			ls.Elements[l]++
+		}
+	}
+	ls.Tracks = ls.Elements["Track"]
+	return ls, nil
+}
@@ -0,0 +1,263 @@
+package graph
+
+import (
+	"reflect"
+
+	"Go-GoSAFE.converter/store"
+)
+
+// merger upserts a freshly converted line into an already stored line, chunk by chunk as it is imported.
+// Starting at the line node, the nodes of a chunk are matched with the stored ones by relationship type,
+// label and RailML id. Elements the new line has somewhere else, e.g. a signal that moved to another track,
+// are matched by their label and LineKey and moved. Matched nodes get the new properties, the other nodes of
+// the chunk are created and stored nodes that are missing from the new line are kept.
+type merger struct {
+	old      *store.MemoryStore // the stored line, read at once, everything below looks at it in memory
+	existing store.Node
+	children []store.Neighbour                // of the stored line node
+	keyed    map[string]map[string]store.Node // label -> LineKey -> stored element
+	used     map[int]bool                     // children of the stored line node that have been matched
+	matched  map[int]bool                     // store ids of matched nodes
+	topology []int                            // store ids of matched topology nodes
+	kept     map[int]bool                     // stored relationships that are part of the new line
+}
+
+func newMerger(s store.GraphStore, existing store.Node) (*merger, error) {
+	old, err := Load(s, existing)
+	if err != nil {
+		return nil, err
+	}
+	children, err := Children(old, existing.ID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := Subgraph(old, existing)
+	if err != nil {
+		return nil, err
+	}
+	keyed := map[string]map[string]store.Node{}
+	for _, n := range nodes[1:] {
+		key, ok := n.Props[LineKey].(string)
+		if !ok {
+			continue
+		}
+		for _, l := range n.Labels {
+			if keyed[l] == nil {
+				keyed[l] = map[string]store.Node{}
+			}
+			keyed[l][key] = n
+		}
+	}
+	return &merger{
+		old:      old,
+		existing: existing,
+		children: children,
+		keyed:    keyed,
+		used:     map[int]bool{},
+		matched:  map[int]bool{},
+		kept:     map[int]bool{},
+	}, nil
+}
+
+// chunk turns the batch b with a chunk of the new line, whose line node is ln, into a batch that merges it into the
+// stored line. It returns the merging batch and the ids the nodes of b have in it.
+func (m *merger) chunk(b *store.Batch, ln store.Node) (*store.Batch, map[int]int, error) {
+	mb := store.NewBatch()
+	ids := map[int]int{}    // batch node id -> merged batch node id
+	stored := map[int]int{} // batch node id -> store id, for matched nodes
+	var rels []store.Relationship
+
+	var merge func(n store.Node, sn *store.Node) error
+	merge = func(n store.Node, sn *store.Node) error {
+		var mn *store.Node
+		var err error
+		if sn != nil {
+			mn, err = mb.Existing(*sn, n.Props)
+			stored[n.ID] = sn.ID
+			m.matched[sn.ID] = true
+			if n.HasLabel(TopologyNodeLabel) {
+				m.topology = append(m.topology, sn.ID)
+			}
+		} else {
+			mn, err = mb.CreateNode(n.Props, n.Labels...)
+		}
+		if err != nil {
+			return err
+		}
+		ids[n.ID] = mn.ID
+
+		nb, err := b.Neighbours(n.ID)
+		if err != nil {
+			return err
+		}
+		for _, r := range nb {
+			if r.Relationship.Start == n.ID {
+				rels = append(rels, r.Relationship)
+			}
+		}
+
+		children, err := Children(b, n.ID)
+		if err != nil {
+			return err
+		}
+		var storedChildren []store.Neighbour
+		used := map[int]bool{}
+		if sn != nil && sn.ID == m.existing.ID {
+			storedChildren, used = m.children, m.used // the line is merged in more than one chunk
+		} else if sn != nil {
+			if storedChildren, err = Children(m.old, sn.ID); err != nil {
+				return err
+			}
+		}
+		for _, c := range children {
+			sc := match(c, storedChildren, used)
+			if sc == nil {
+				if sc, err = m.moved(mb, c.Node); err != nil {
+					return err
+				}
+			}
+			if err := merge(c.Node, sc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := merge(ln, &m.existing); err != nil {
+		return nil, nil, err
+	}
+
+	for _, r := range rels {
+		if _, ok := ids[r.End]; !ok {
+			continue // not part of the line
+		}
+		ss, sok := stored[r.Start]
+		se, eok := stored[r.End]
+		if err := m.relate(mb, ids[r.Start], r.Type, ids[r.End], r.Props, ss, se, sok && eok); err != nil {
+			return nil, nil, err
+		}
+	}
+	return mb, ids, nil
+}
+
+// moved returns the stored element with the label and LineKey of the new node n that hasn't been matched yet, as n
+// isn't where it was, and drops the relationship from its stored owner to b. It returns nil if there is none.
+func (m *merger) moved(b *store.Batch, n store.Node) (*store.Node, error) {
+	key, ok := n.Props[LineKey].(string)
+	if !ok || len(n.Labels) != 1 {
+		return nil, nil
+	}
+	sn, ok := m.keyed[n.Labels[0]][key]
+	if !ok || m.matched[sn.ID] {
+		return nil, nil
+	}
+	nb, err := m.old.Neighbours(sn.ID, Owns...)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range nb {
+		if r.Relationship.End == sn.ID {
+			b.DeleteRelationship(r.Relationship.ID)
+		}
+	}
+	return &sn, nil
+}
+
+// links adds the relationships between the chunks of the new line, which are between stored nodes, to b.
+// As the topology layer is built from the new file, stored sections and links it doesn't have anymore are dropped.
+func (m *merger) links(b *store.Batch, rels []storedRel) error {
+	for _, r := range rels {
+		start, err := b.Existing(store.Node{ID: r.start}, nil)
+		if err != nil {
+			return err
+		}
+		end, err := b.Existing(store.Node{ID: r.end}, nil)
+		if err != nil {
+			return err
+		}
+		if err := m.relate(b, start.ID, r.relType, end.ID, r.props, r.start, r.end, m.matched[r.start] && m.matched[r.end]); err != nil {
+			return err
+		}
+	}
+
+	for _, sn := range m.topology {
+		nb, err := m.old.Neighbours(sn, TrackSection, Linked)
+		if err != nil {
+			return err
+		}
+		for _, r := range nb {
+			if !m.kept[r.Relationship.ID] {
+				m.kept[r.Relationship.ID] = true
+				b.DeleteRelationship(r.Relationship.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// relate adds a relationship of the new line to b, between the nodes start and end of b. If both are stored nodes,
+// ss and se, a stored relationship of the same type between them is kept as it is, or replaced if its properties differ.
+func (m *merger) relate(b *store.Batch, start int, relType string, end int, props store.Props, ss int, se int, stored bool) error {
+	if stored {
+		sr, err := storedRelationship(m.old, ss, relType, se)
+		if err != nil {
+			return err
+		}
+		if sr != nil {
+			m.kept[sr.ID] = true
+		}
+		if sr != nil && reflect.DeepEqual(sr.Props, props) {
+			return nil // already stored as it is
+		}
+		if sr != nil {
+			b.DeleteRelationship(sr.ID)
+		}
+	}
+	_, err := b.Relate(start, relType, end, props)
+	return err
+}
+
+// match finds the stored child that corresponds to the new child c and marks it as used.
+// Children are matched by relationship type, label and id. Children without an id, like <speed />,
+// are matched in order with the stored children of the same kind that have no id either.
+func match(c store.Neighbour, stored []store.Neighbour, used map[int]bool) *store.Node {
+	id, hasID := c.Node.Props["id"]
+	for i, sc := range stored {
+		if used[sc.Node.ID] || sc.Relationship.Type != c.Relationship.Type || !sameLabels(sc.Node, c.Node) {
+			continue
+		}
+		sid, sHasID := sc.Node.Props["id"]
+		if hasID != sHasID || hasID && id != sid {
+			continue
+		}
+		used[sc.Node.ID] = true
+		return &stored[i].Node
+	}
+	return nil
+}
+
+// sameLabels reports whether both nodes carry exactly the same labels
+func sameLabels(a store.Node, b store.Node) bool {
+	if len(a.Labels) != len(b.Labels) {
+		return false
+	}
+	for _, l := range b.Labels {
+		if !a.HasLabel(l) {
+			return false
+		}
+	}
+	return true
+}
+
+// storedRelationship returns the stored relationship (start)-[relType]->(end), nil if there is none
+func storedRelationship(s store.GraphStore, start int, relType string, end int) (*store.Relationship, error) {
+	nb, err := s.Neighbours(start, relType)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nb {
+		if n.Relationship.Start == start && n.Relationship.End == end {
+			return &n.Relationship, nil
+		}
+	}
+	return nil, nil
+}
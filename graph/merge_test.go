@@ -0,0 +1,90 @@
+package graph
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"Go-GoSAFE.converter/store"
+)
+
+// importLine imports the file as the given line into the store
+func importLine(t *testing.T, s store.GraphStore, line string, mode string, file string) {
+	g := GraphUtils{}
+	if _, err := g.ImportLine(context.Background(), s, strings.NewReader(file), ImportOptions{Line: line, Epsg: "4326", Mode: mode, Workers: 1}); err != nil {
+		t.Fatalf("%s import of %s: %v", mode, line, err)
+	}
+}
+
+// twoTracks is a line of two tracks, the elements of the first one are given
+func twoTracks(first string, second string) string {
+	track := func(id string, elements string) string {
+		return `<track id="` + id + `"><trackTopology>` +
+			`<trackBegin id="` + id + `b" pos="0"><openEnd id="` + id + `o1"/></trackBegin>` +
+			`<trackEnd id="` + id + `e" pos="100"><openEnd id="` + id + `o2"/></trackEnd>` +
+			`</trackTopology><ocsElements><signals>` + elements + `</signals></ocsElements></track>`
+	}
+	return `<railml><infrastructure id="inf1"><tracks>` + track("tr1", first) + track("tr2", second) + `</tracks></infrastructure></railml>`
+}
+
+// trackOf returns the ids of the tracks the signals with the given id are on
+func trackOf(t *testing.T, s store.GraphStore, id string) []string {
+	signals, err := s.FindNodes("Signal", store.Props{"id": id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tracks []string
+	for _, sig := range signals {
+		nb, err := s.Neighbours(sig.ID, "HAS_OCS_ELEMENT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range nb {
+			tracks = append(tracks, n.Node.Props["id"].(string))
+		}
+	}
+	return tracks
+}
+
+func TestMergeMovedElement(t *testing.T) {
+	s := store.NewMemoryStore()
+	importLine(t, s, "L1", "fail", twoTracks(`<signal id="s1" pos="10" name="old"/><signal id="s2" pos="20"/>`, ``))
+	importLine(t, s, "L1", "merge", twoTracks(`<signal id="s2" pos="20"/>`, `<signal id="s1" pos="30" name="new"/>`))
+
+	if got := trackOf(t, s, "s1"); len(got) != 1 || got[0] != "tr2" {
+		t.Errorf("the moved signal is on the tracks %v, want tr2 alone", got)
+	}
+	if got := trackOf(t, s, "s2"); len(got) != 1 || got[0] != "tr1" {
+		t.Errorf("the signal that stayed is on the tracks %v, want tr1 alone", got)
+	}
+	signals, err := s.FindNodes("Signal", store.Props{"id": "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signals) != 1 || signals[0].Props["name"] != "new" || signals[0].Props["pos"] != "30" {
+		t.Errorf("the moved signal is %v, want the merged one", signals)
+	}
+
+	// and back again, in a line the store keeps the constraints of
+	importLine(t, s, "L1", "merge", twoTracks(`<signal id="s1" pos="10"/>`, ``))
+	if got := trackOf(t, s, "s1"); len(got) != 1 || got[0] != "tr1" {
+		t.Errorf("the signal moved back is on the tracks %v, want tr1 alone", got)
+	}
+}
+
+func TestConstrain(t *testing.T) {
+	s := store.NewMemoryStore()
+	if err := Constrain(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateNode(store.Props{LineKey: "L1/s1"}, "Signal"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.CreateNode(store.Props{LineKey: "L1/s1"}, "Signal")
+	if _, ok := err.(*store.ConflictError); !ok {
+		t.Errorf("a second signal with the same line key: %v, want a ConflictError", err)
+	}
+	if _, err := s.CreateNode(store.Props{LineKey: "L2/s1"}, "Signal"); err != nil {
+		t.Errorf("a signal of another line: %v", err)
+	}
+}
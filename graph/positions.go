@@ -0,0 +1,241 @@
+package graph
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"Go-GoSAFE.converter/store"
+	"Go-GoSAFE.converter/utils"
+)
+
+// properties that mark a pos or absPos the converter filled in, see positioner
+const (
+	PosDerived    = "posDerived"
+	AbsPosDerived = "absPosDerived"
+)
+
+// mileage maps the pos of a track, the distance from its begin, to the absPos, the mileage of the line, and back.
+// The mileage starts at the absPos of the begin and runs up or down the track as its absDir says. At each
+// <mileageChange /> it jumps to the absPos of the change and runs on in the absDir of the change. Without an absDir,
+// the direction is the one that leads to the next absPos that is known, the absPosIn of the next change or the absPos
+// of the end, else the direction before.
+type mileage struct {
+	segments []mileageSegment
+	end      float64 // pos of the end of the track, +Inf if it isn't known
+}
+
+// mileageSegment is a part of a track the mileage runs steadily on, from the pos it begins at to the next one
+type mileageSegment struct {
+	pos float64
+	abs float64
+	dir float64 // 1 if the absPos increases with the pos, else -1
+	in  float64 // the stated absPosIn of the change the segment begins at
+	id  string  // of the change
+}
+
+// newMileage creates the mileage of a track from the attributes of its begin and end and its mileage changes,
+// nil if the begin has no pos or absPos
+func newMileage(begin store.Props, end store.Props, changes []store.Props) *mileage {
+	pos, ok := number(begin["pos"])
+	if !ok {
+		return nil
+	}
+	abs, ok := number(begin["absPos"])
+	if !ok {
+		return nil
+	}
+	m := &mileage{end: math.Inf(1)}
+	if e, ok := number(end["pos"]); ok {
+		m.end = e
+	}
+	m.segments = append(m.segments, mileageSegment{pos: pos, abs: abs, dir: absDir(begin["absDir"]), in: math.NaN()})
+
+	for _, c := range changes {
+		p, ok := number(c["pos"])
+		if !ok {
+			continue
+		}
+		a, ok := number(c["absPos"])
+		if !ok {
+			continue
+		}
+		in, ok := number(c["absPosIn"])
+		if !ok {
+			in = math.NaN()
+		}
+		id, _ := c["id"].(string)
+		m.segments = append(m.segments, mileageSegment{pos: p, abs: a, dir: absDir(c["absDir"]), in: in, id: id})
+	}
+	sort.SliceStable(m.segments[1:], func(i, j int) bool { return m.segments[i+1].pos < m.segments[j+1].pos })
+
+	// the directions that aren't stated lead to the next known absPos
+	endAbs, hasEndAbs := number(end["absPos"])
+	prev := 1.0
+	for i := range m.segments {
+		s := &m.segments[i]
+		if s.dir != 0 {
+			prev = s.dir
+			continue
+		}
+		s.dir = prev
+		if i+1 < len(m.segments) {
+			if next := m.segments[i+1]; !math.IsNaN(next.in) && next.in != s.abs && next.pos != s.pos {
+				s.dir = math.Copysign(1, (next.in-s.abs)/(next.pos-s.pos))
+			}
+		} else if hasEndAbs && endAbs != s.abs && m.end != s.pos && !math.IsInf(m.end, 1) {
+			s.dir = math.Copysign(1, (endAbs-s.abs)/(m.end-s.pos))
+		}
+		prev = s.dir
+	}
+	return m
+}
+
+// absDir reads an absDir, 0 if it is neither up nor down
+func absDir(v interface{}) float64 {
+	switch v {
+	case "up":
+		return 1
+	case "down":
+		return -1
+	}
+	return 0
+}
+
+// segment returns the segment the given pos is on, the one before a change if before is set
+func (m *mileage) segment(pos float64, before bool) mileageSegment {
+	s := m.segments[0]
+	for _, n := range m.segments[1:] {
+		if n.pos > pos || (before && n.pos == pos) {
+			break
+		}
+		s = n
+	}
+	return s
+}
+
+// abs returns the absPos at the given pos
+func (m *mileage) abs(pos float64) float64 {
+	s := m.segment(pos, false)
+	return s.abs + s.dir*(pos-s.pos)
+}
+
+// absBefore returns the absPos at the given pos coming from the begin of the track, before a change at the pos
+func (m *mileage) absBefore(pos float64) float64 {
+	s := m.segment(pos, true)
+	return s.abs + s.dir*(pos-s.pos)
+}
+
+// pos returns the first pos of the track that has the given absPos, ok is false if there is none
+func (m *mileage) pos(abs float64) (float64, bool) {
+	for i, s := range m.segments {
+		to := m.end
+		if i+1 < len(m.segments) {
+			to = m.segments[i+1].pos
+		}
+		p := s.pos + (abs-s.abs)*s.dir
+		if p >= s.pos && p <= to {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// metres writes a length with millimetre precision, without trailing zeros
+func metres(m float64) string {
+	return strconv.FormatFloat(millimetres(m), 'f', -1, 64)
+}
+
+// positioner completes the positions of the elements of a track: with fill, it fills in a missing pos from the absPos
+// or from the geoCoord of the element, and a missing absPos from the pos, see mileage. A geoCoord only gives a pos if
+// it is beside the track, at most tolerance metres from it. Elements with a pos but no geoCoord get a geometry, see linearRef.
+type positioner struct {
+	ref       *linearRef
+	mileage   *mileage
+	fill      bool
+	tolerance float64
+}
+
+// newPositioner measures the geodesic length of a track and, with fill, fills in the pos of its begin and end
+// if they have none. The begin is at 0 then, the end as far from it as the track is long.
+func newPositioner(track store.Props, begin store.Props, end store.Props, changes []store.Props, fill bool, tolerance float64) *positioner {
+	var line *polyline
+	if wkt, ok := track["geometry"].(string); ok {
+		if l, err := parsePolyline(wkt); err == nil {
+			line = l
+			track["geodesicLength"] = millimetres(l.length())
+		}
+	}
+	if fill && len(begin) > 0 {
+		if _, ok := begin["pos"]; !ok {
+			begin["pos"] = "0"
+			begin[PosDerived] = true
+		}
+		from, _ := number(begin["pos"])
+		if _, ok := end["pos"]; !ok && line != nil && len(end) > 0 {
+			end["pos"] = metres(from + line.length())
+			end[PosDerived] = true
+		}
+	}
+
+	p := &positioner{fill: fill, tolerance: tolerance}
+	p.ref = newLinearRef(track["geometry"], begin["pos"], end["pos"])
+	p.mileage = newMileage(begin, end, changes)
+	return p
+}
+
+// place completes the attributes of an element and its node, which are different maps only for track begins and ends
+func (p *positioner) place(attrs store.Props, node store.Props) {
+	if p.fill {
+		if _, ok := attrs["pos"]; !ok {
+			if pos, ok := p.posOf(attrs, node); ok {
+				attrs["pos"] = metres(pos)
+				attrs[PosDerived] = true
+			}
+		}
+		if _, ok := attrs["absPos"]; !ok && p.mileage != nil {
+			if pos, ok := number(attrs["pos"]); ok {
+				attrs["absPos"] = metres(p.mileage.abs(pos))
+				attrs[AbsPosDerived] = true
+			}
+		}
+	}
+	p.ref.derive(node, attrs["pos"])
+}
+
+// posOf works out the pos of an element from its absPos, else from its geoCoord. ok is false if the geoCoord is
+// further from the track than the tolerance or before its begin or after its end.
+func (p *positioner) posOf(attrs store.Props, node store.Props) (float64, bool) {
+	if abs, ok := number(attrs["absPos"]); ok && p.mileage != nil {
+		return p.mileage.pos(abs)
+	}
+	wkt, ok := node["geometry"].(string)
+	if !ok || p.ref == nil || node[GeometryDerived] == true {
+		return 0, false
+	}
+	kind, coords, err := utils.ParseWKT(wkt)
+	if err != nil || kind != "POINT" || len(coords) != 1 {
+		return 0, false
+	}
+	c := strings.Fields(coords[0])
+	if len(c) < 2 {
+		return 0, false
+	}
+	lon, err := strconv.ParseFloat(c[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	lat, err := strconv.ParseFloat(c[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	if p.ref.line.beyond(lon, lat) {
+		return 0, false
+	}
+	pos, _, off := p.ref.pos(lon, lat)
+	if off > p.tolerance {
+		return 0, false
+	}
+	return pos, true
+}
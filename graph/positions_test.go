@@ -0,0 +1,121 @@
+package graph
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"Go-GoSAFE.converter/crs"
+	"Go-GoSAFE.converter/store"
+)
+
+func TestMileage(t *testing.T) {
+	// up from 1000, down from 5000 after the change at 300
+	m := newMileage(store.Props{"pos": "0", "absPos": "1000", "absDir": "up"}, store.Props{"pos": "1000"},
+		[]store.Props{{"id": "mc1", "pos": "300", "absPosIn": "1300", "absPos": "5000", "absDir": "down"}})
+	for pos, want := range map[float64]float64{0: 1000, 100: 1100, 300: 5000, 400: 4900, 1000: 4300} {
+		if got := m.abs(pos); got != want {
+			t.Errorf("the absPos at %v is %v, want %v", pos, got, want)
+		}
+	}
+	if got := m.absBefore(300); got != 1300 {
+		t.Errorf("the absPos before the change is %v, want 1300", got)
+	}
+	for abs, want := range map[float64]float64{1200: 200, 4900: 400, 4300: 1000} {
+		if got, ok := m.pos(abs); !ok || got != want {
+			t.Errorf("the pos of %v is %v, %v, want %v", abs, got, ok, want)
+		}
+	}
+	for _, abs := range []float64{900, 1400, 4200} {
+		if got, ok := m.pos(abs); ok {
+			t.Errorf("the absPos %v is at %v, but isn't on the track", abs, got)
+		}
+	}
+
+	// without an absDir it runs towards the absPos of the end
+	m = newMileage(store.Props{"pos": "0", "absPos": "2000"}, store.Props{"pos": "1000", "absPos": "1000"}, nil)
+	if got := m.abs(250); got != 1750 {
+		t.Errorf("the absPos at 250 running towards the end is %v, want 1750", got)
+	}
+	if m := newMileage(store.Props{"pos": "0"}, store.Props{}, nil); m != nil {
+		t.Error("a track begin without an absPos has a mileage")
+	}
+}
+
+func TestFillPositions(t *testing.T) {
+	// a track along the meridian 19°E from 50°N to 50.01°N without a pos at its begin and end, its mileage runs up from 1000
+	file := `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>` +
+		`<trackBegin id="tb1" absPos="1000" absDir="up"><openEnd id="oe1"/></trackBegin>` +
+		`<trackEnd id="te1"><openEnd id="oe2"/></trackEnd>` +
+		`</trackTopology><trackElements><geoMappings>` +
+		`<geoMapping><geoCoord coord="50.000 19.0"/></geoMapping><geoMapping><geoCoord coord="50.010 19.0"/></geoMapping>` +
+		`</geoMappings></trackElements><ocsElements><signals>` +
+		`<signal id="s1" absPos="1300"/>` +
+		`<signal id="s2"><geoCoord coord="50.005 19.0001"/></signal>` +
+		`<signal id="s3"><geoCoord coord="50.005 19.01"/></signal>` +
+		`<signal id="s4" pos="100"/>` +
+		`<signal id="s5"><geoCoord coord="49.999 19.0"/></signal>` +
+		`</signals></ocsElements></track></tracks></infrastructure></railml>`
+	s := store.NewMemoryStore()
+	g := GraphUtils{}
+	o := ImportOptions{Line: "L1", Epsg: "4326", Mode: "fail", Workers: 1, FillPositions: true, LengthTolerance: 10}
+	if _, err := g.ImportLine(context.Background(), s, strings.NewReader(file), o); err != nil {
+		t.Fatal(err)
+	}
+
+	length := crs.Distance(19, 50, 19, 50.01)
+	number := func(v interface{}) float64 {
+		f, err := strconv.ParseFloat(v.(string), 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	tracks, err := s.FindNodes("Track", store.Props{"id": "tr1"})
+	if err != nil || len(tracks) != 1 {
+		t.Fatalf("%v tracks tr1: %v", tracks, err)
+	}
+	if got := tracks[0].Props["geodesicLength"]; got != millimetres(length) {
+		t.Errorf("the track is %v long, want %v", got, millimetres(length))
+	}
+	ends, err := s.Neighbours(tracks[0].ID, "BEGINS", "ENDS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ends {
+		p := e.Relationship.Props
+		want := map[string]float64{"BEGINS": 0, "ENDS": length}[e.Relationship.Type]
+		if p["pos"] == nil || math.Abs(number(p["pos"])-want) > 0.001 || p[PosDerived] != true {
+			t.Errorf("%s has the pos %v, derived %v, want %.3f", e.Relationship.Type, p["pos"], p[PosDerived], want)
+		}
+	}
+
+	tests := []struct {
+		id                        string
+		pos, absPos               float64 // NaN if there is none
+		posDerived, absPosDerived interface{}
+	}{
+		{"s1", 300, 1300, true, nil},                    // from the absPos
+		{"s2", length / 2, 1000 + length/2, true, true}, // from the geoCoord beside the track
+		{"s3", math.NaN(), math.NaN(), nil, nil},        // too far from the track
+		{"s4", 100, 1100, nil, true},                    // only the absPos is missing
+		{"s5", math.NaN(), math.NaN(), nil, nil},        // before the begin of the track
+	}
+	for _, tt := range tests {
+		n := signal(t, s, tt.id)
+		for _, v := range []struct {
+			key     string
+			want    float64
+			derived string
+			wantD   interface{}
+		}{{"pos", tt.pos, PosDerived, tt.posDerived}, {"absPos", tt.absPos, AbsPosDerived, tt.absPosDerived}} {
+			got, ok := n.Props[v.key]
+			if ok == math.IsNaN(v.want) || (ok && math.Abs(number(got)-v.want) > 0.01) || n.Props[v.derived] != v.wantD {
+				t.Errorf("%s has the %s %v, %s %v, want %.3f, %v", tt.id, v.key, got, v.derived, n.Props[v.derived], v.want, v.wantD)
+			}
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package graph
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"sync"
+
+	"Go-GoSAFE.converter/store"
+)
+
+// Route is the shortest way through the topology layer of a line from one element to another, see topologyBuilder.
+type Route struct {
+	From     RouteElement   `json:"from"`
+	To       RouteElement   `json:"to"`
+	Length   float64        `json:"length"`   // in metres
+	Tracks   []RouteTrack   `json:"tracks"`   // in the order they are run on
+	Switches []RouteSwitch  `json:"switches"` // and crossings, in the order they are passed
+	Elements []RouteElement `json:"elements"` // passed on the way, without the first and the last one
+}
+
+// RouteTrack is a part of a route that runs on a single track.
+type RouteTrack struct {
+	ID     string  `json:"id"`
+	From   float64 `json:"from"` // pos
+	To     float64 `json:"to"`
+	Dir    string  `json:"dir"` // up if pos is increasing, else down
+	Length float64 `json:"length"`
+}
+
+// RouteSwitch is a switch or a crossing a route passes, with the legs it is entered and left by.
+type RouteSwitch struct {
+	ID     string  `json:"id"`
+	Kind   string  `json:"kind"` // switch or crossing
+	Track  string  `json:"track"`
+	Pos    float64 `json:"pos"`
+	Enter  string  `json:"enter"` // stem, continue or branch
+	Leave  string  `json:"leave"`
+	Course string  `json:"course,omitempty"` // of the leg that isn't the stem, e.g. left
+}
+
+// RouteElement is an element placed on a track.
+type RouteElement struct {
+	ID    string  `json:"id"`
+	Label string  `json:"label"`
+	Track string  `json:"track"`
+	Pos   float64 `json:"pos"`
+	Dir   string  `json:"dir,omitempty"`
+
+	node int // id in the store
+}
+
+// RouteError is returned when an element can't be routed from or to, or there is no route between two elements.
+type RouteError struct {
+	Msg string
+}
+
+func (e *RouteError) Error() string {
+	return e.Msg
+}
+
+// topoNode is a TopologyNode with its TRACK_SECTION and LINKED relationships
+type topoNode struct {
+	id     int
+	props  store.Props
+	pos    float64
+	hasPos bool
+	edges  []*topoEdge
+}
+
+// isSwitch reports whether the node can only be passed from its stem, see legs
+func (n *topoNode) isSwitch() bool {
+	_, ok := n.props["orientation"]
+	return ok
+}
+
+// topoEdge is a TRACK_SECTION or a LINKED relationship
+type topoEdge struct {
+	id         int
+	linked     bool
+	track      string
+	start, end *topoNode
+	length     float64
+	startLeg   string
+	endLeg     string
+}
+
+func (e *topoEdge) other(n *topoNode) *topoNode {
+	if e.start == n {
+		return e.end
+	}
+	return e.start
+}
+
+// leg returns the leg of the switch n the edge is
+func (e *topoEdge) leg(n *topoNode) string {
+	if e.start == n {
+		return e.startLeg
+	}
+	return e.endLeg
+}
+
+// passable reports whether n can be passed coming in by one edge and going out by the other.
+// Switches are only passed from their stem to a leg or back.
+func passable(n *topoNode, in *topoEdge, out *topoEdge) bool {
+	if in == out {
+		return false
+	}
+	return !n.isSwitch() || in.leg(n) == StemLeg || out.leg(n) == StemLeg
+}
+
+// topology is the topology layer of a line together with the elements placed on its tracks
+type topology struct {
+	nodes      map[int]*topoNode
+	sections   map[string][]*topoEdge        // track id -> its sections
+	elements   map[string][]RouteElement     // track id -> the elements placed on it
+	byID       map[interface{}]*RouteElement // RailML id -> element
+	unplaced   map[interface{}]string        // RailML id -> label, of elements without pos
+	importedAt string                        // of the line when the layer was read
+}
+
+// topologies keeps the topology layers of the lines routes have been searched on, until the lines are imported again
+// or deleted, see ForgetTopology. A layer isn't changed once it has been read, so routes can share it.
+var topologies = struct {
+	sync.Mutex
+	layers     map[topologyKey]*topology
+	generation int // counts the layers forgotten, so that one read before isn't kept
+}{layers: map[topologyKey]*topology{}}
+
+// topologyKey is the line node of a store a topology layer belongs to
+type topologyKey struct {
+	s    store.GraphStore
+	line int
+}
+
+// cachedTopology returns the topology layer of the given line, reading it only if it isn't kept yet
+func cachedTopology(s store.GraphStore, ln store.Node) (*topology, error) {
+	k := topologyKey{s: s, line: ln.ID}
+	importedAt, _ := ln.Props["importedAt"].(string)
+
+	topologies.Lock()
+	t, ok := topologies.layers[k]
+	generation := topologies.generation
+	topologies.Unlock()
+	if ok && t.importedAt == importedAt { // a line imported by another converter with the same store is read again
+		return t, nil
+	}
+
+	t, err := loadTopology(s, ln)
+	if err != nil {
+		return nil, err
+	}
+	t.importedAt = importedAt
+	topologies.Lock()
+	if topologies.generation == generation {
+		topologies.layers[k] = t
+	}
+	topologies.Unlock()
+	return t, nil
+}
+
+// ForgetTopology drops the kept topology layer of the line with the given line node, after it has been imported
+// again or deleted.
+func ForgetTopology(s store.GraphStore, line int) {
+	topologies.Lock()
+	defer topologies.Unlock()
+
+	delete(topologies.layers, topologyKey{s: s, line: line})
+	topologies.generation++
+}
+
+// loadTopology reads the topology layer of the given line. The tracks, their elements and the layer are read at once,
+// everything else looks at them in memory.
+func loadTopology(s store.GraphStore, ln store.Node) (*topology, error) {
+	t := &topology{
+		nodes:    map[int]*topoNode{},
+		sections: map[string][]*topoEdge{},
+		elements: map[string][]RouteElement{},
+		byID:     map[interface{}]*RouteElement{},
+		unplaced: map[interface{}]string{},
+	}
+	g, err := s.Subgraph(ln.ID, "HAS_TRACK", HasTopologyNode, "BEGINS", "ENDS", "HAS_SWITCH", "HAS_CROSSING",
+		"HAS_MILEAGE_CHANGE", "HAS_CROSS_SECTION", "HAS_TRACK_ELEMENT", "HAS_OCS_ELEMENT")
+	if err != nil {
+		return nil, err
+	}
+	m := store.NewMemoryStoreFrom(g)
+	tracks, err := m.Neighbours(ln.ID, "HAS_TRACK")
+	if err != nil {
+		return nil, err
+	}
+
+	var rels []store.Relationship
+	for _, tr := range tracks {
+		trackId, _ := tr.Node.Props["id"].(string)
+		tns, err := m.Neighbours(tr.Node.ID, HasTopologyNode)
+		if err != nil {
+			return nil, err
+		}
+		for _, tn := range tns {
+			n := &topoNode{id: tn.Node.ID, props: tn.Node.Props}
+			n.pos, n.hasPos = number(tn.Node.Props["pos"])
+			t.nodes[n.id] = n
+			nb, err := m.Neighbours(n.id, TrackSection, Linked)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range nb {
+				if r.Relationship.Start == n.id {
+					rels = append(rels, r.Relationship)
+				}
+			}
+			if kind, _ := tn.Node.Props["kind"].(string); kind == "trackBegin" || kind == "trackEnd" {
+				// a begin or end has no node of its own to be routed from
+				t.place(trackId, tn.Node.ID, tn.Node.Props, map[string]string{"trackBegin": "TrackBegin", "trackEnd": "TrackEnd"}[kind], false)
+			}
+		}
+
+		nb, err := m.Neighbours(tr.Node.ID, "BEGINS", "ENDS", "HAS_SWITCH", "HAS_CROSSING", "HAS_MILEAGE_CHANGE", "HAS_CROSS_SECTION", "HAS_TRACK_ELEMENT", "HAS_OCS_ELEMENT")
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nb {
+			if n.Relationship.Start != tr.Node.ID || len(n.Node.Labels) == 0 || n.Node.HasLabel("Connection") {
+				continue
+			}
+			props := n.Node.Props
+			if n.Relationship.Type == "BEGINS" || n.Relationship.Type == "ENDS" {
+				// buffer stops and the like are where their begin or end is
+				props = store.Props{"pos": n.Relationship.Props["pos"]}
+				copyProps(props, n.Node.Props, "id", "dir")
+			}
+			t.place(trackId, n.Node.ID, props, n.Node.Labels[0], true)
+		}
+	}
+
+	for _, r := range rels {
+		start, end := t.nodes[r.Start], t.nodes[r.End]
+		if start == nil || end == nil {
+			continue // not part of the line
+		}
+		e := &topoEdge{id: r.ID, linked: r.Type == Linked, start: start, end: end}
+		e.track, _ = r.Props["track"].(string)
+		e.startLeg, _ = r.Props["startLeg"].(string)
+		e.endLeg, _ = r.Props["endLeg"].(string)
+		if l, ok := number(r.Props["length"]); ok {
+			e.length = l
+		} else if a, ok := number(start.props["absPos"]); ok {
+			// the length of a section without pos is worked out from absPos, if it has that
+			if b, ok := number(end.props["absPos"]); ok {
+				e.length = math.Abs(b - a)
+			}
+		}
+		start.edges = append(start.edges, e)
+		end.edges = append(end.edges, e)
+		if !e.linked {
+			t.sections[e.track] = append(t.sections[e.track], e)
+		}
+	}
+
+	for track := range t.elements {
+		sort.SliceStable(t.elements[track], func(i, j int) bool { return t.elements[track][i].Pos < t.elements[track][j].Pos })
+	}
+	return t, nil
+}
+
+// place adds an element to the given track, to the elements a route passes too if passed is set
+func (t *topology) place(track string, node int, props store.Props, label string, passed bool) {
+	id, ok := props["id"]
+	if !ok {
+		return
+	}
+	pos, ok := number(props["pos"])
+	if !ok {
+		if _, placed := t.byID[id]; !placed {
+			t.unplaced[id] = label
+		}
+		return
+	}
+	e := RouteElement{Label: label, Track: track, Pos: pos, node: node}
+	e.ID, _ = id.(string)
+	e.Dir, _ = props["dir"].(string)
+	if passed {
+		t.elements[track] = append(t.elements[track], e)
+	}
+	if _, ok := t.byID[id]; !ok {
+		t.byID[id] = &e
+		delete(t.unplaced, id)
+	}
+}
+
+// element finds the element with the given RailML id
+func (t *topology) element(id string) (*RouteElement, error) {
+	if e, ok := t.byID[id]; ok {
+		return e, nil
+	}
+	if label, ok := t.unplaced[id]; ok {
+		return nil, &RouteError{Msg: label + " '" + id + "' has no pos, it can't be placed on its track"}
+	}
+	return nil, &store.NotFoundError{Label: "Element", ID: id}
+}
+
+// on returns the sections of the track the element is on, two if it is where they meet
+func (t *topology) on(e *RouteElement) []*topoEdge {
+	var on []*topoEdge
+	for _, s := range t.sections[e.Track] {
+		if s.start.hasPos && s.end.hasPos && s.start.pos <= e.Pos && e.Pos <= s.end.pos {
+			on = append(on, s)
+		}
+	}
+	return on
+}
+
+// routeState is a node of the topology reached by one of its edges
+type routeState struct {
+	node  *topoNode
+	in    *topoEdge
+	dist  float64
+	prev  int       // the state it was reached from, -1 for the first ones
+	final *topoEdge // the section the last element is on, for the final state
+	self  int       // index of the state
+	index int       // in the queue
+}
+
+// routeQueue is a priority queue of route states ordered by their distance
+type routeQueue []*routeState
+
+func (q routeQueue) Len() int            { return len(q) }
+func (q routeQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q routeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i]; q[i].index = i; q[j].index = j }
+func (q *routeQueue) Push(x interface{}) { s := x.(*routeState); s.index = len(*q); *q = append(*q, s) }
+func (q *routeQueue) Pop() interface{} {
+	old := *q
+	s := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return s
+}
+
+// runs reports whether an element with the given dir can be run past in the direction up (increasing pos) or down
+func runs(dir string, up bool) bool {
+	switch dir {
+	case "up":
+		return up
+	case "down":
+		return !up
+	}
+	return true
+}
+
+// FindRoute finds the shortest route through the topology layer of the given line from the element with the RailML id
+// from to the one with the id to. The route leaves and reaches the elements in their running direction if they have
+// one (dir up or down) and passes switches from their stem to a leg or back only. Lengths are worked out from pos,
+// or from absPos for sections without pos.
+func FindRoute(s store.GraphStore, ln store.Node, from string, to string) (*Route, error) {
+	t, err := cachedTopology(s, ln)
+	if err != nil {
+		return nil, err
+	}
+	fe, err := t.element(from)
+	if err != nil {
+		return nil, err
+	}
+	te, err := t.element(to)
+	if err != nil {
+		return nil, err
+	}
+	fs, ts := t.on(fe), t.on(te)
+	if len(fs) == 0 {
+		return nil, &RouteError{Msg: fe.Label + " '" + from + "' isn't within a section of track '" + fe.Track + "'"}
+	}
+	if len(ts) == 0 {
+		return nil, &RouteError{Msg: te.Label + " '" + to + "' isn't within a section of track '" + te.Track + "'"}
+	}
+
+	// Dijkstra over the nodes reached by an edge, as the edge tells which way a switch can be passed
+	var states []*routeState
+	best := map[[2]int]float64{}
+	q := &routeQueue{}
+	push := func(st *routeState) {
+		if st.final == nil {
+			k := [2]int{st.node.id, st.in.id}
+			if d, ok := best[k]; ok && d <= st.dist {
+				return
+			}
+			best[k] = st.dist
+		}
+		st.self = len(states)
+		states = append(states, st)
+		heap.Push(q, st)
+	}
+
+	for _, sec := range fs {
+		for _, sec2 := range ts {
+			// both on the same section
+			if sec == sec2 && runs(fe.Dir, te.Pos >= fe.Pos) && runs(te.Dir, te.Pos >= fe.Pos) {
+				push(&routeState{node: sec.start, dist: math.Abs(te.Pos - fe.Pos), prev: -1, final: sec})
+			}
+		}
+		if runs(fe.Dir, true) {
+			push(&routeState{node: sec.end, in: sec, dist: sec.end.pos - fe.Pos, prev: -1})
+		}
+		if runs(fe.Dir, false) {
+			push(&routeState{node: sec.start, in: sec, dist: fe.Pos - sec.start.pos, prev: -1})
+		}
+	}
+
+	var found *routeState
+	for q.Len() > 0 {
+		st := heap.Pop(q).(*routeState)
+		if st.final != nil {
+			found = st
+			break
+		}
+		if best[[2]int{st.node.id, st.in.id}] < st.dist {
+			continue // reached by a shorter way since
+		}
+		for _, sec := range ts {
+			if (sec.start != st.node && sec.end != st.node) || !passable(st.node, st.in, sec) || !runs(te.Dir, sec.start == st.node) {
+				continue
+			}
+			push(&routeState{node: st.node, in: st.in, dist: st.dist + math.Abs(te.Pos-st.node.pos), prev: st.self, final: sec})
+		}
+		for _, e := range st.node.edges {
+			if passable(st.node, st.in, e) {
+				push(&routeState{node: e.other(st.node), in: e, dist: st.dist + e.length, prev: st.self})
+			}
+		}
+	}
+	if found == nil {
+		return nil, &RouteError{Msg: "there is no route from '" + from + "' to '" + to + "'"}
+	}
+	return t.route(fe, te, states, found), nil
+}
+
+// route turns the states of a route found into a Route
+func (t *topology) route(fe *RouteElement, te *RouteElement, states []*routeState, found *routeState) *Route {
+	var path []*routeState // from the first state to the final one
+	for st := found; ; st = states[st.prev] {
+		path = append([]*routeState{st}, path...)
+		if st.prev < 0 {
+			break
+		}
+	}
+
+	r := &Route{From: *fe, To: *te, Length: found.dist, Tracks: []RouteTrack{}, Switches: []RouteSwitch{}, Elements: []RouteElement{}}
+	run := func(track string, from float64, to float64, length float64) {
+		if from == to && length == 0 {
+			return
+		}
+		dir := "up"
+		if to < from {
+			dir = "down"
+		}
+		if n := len(r.Tracks); n > 0 && r.Tracks[n-1].ID == track && r.Tracks[n-1].Dir == dir && r.Tracks[n-1].To == from {
+			r.Tracks[n-1].To = to
+			r.Tracks[n-1].Length += length
+			return
+		}
+		r.Tracks = append(r.Tracks, RouteTrack{ID: track, From: from, To: to, Dir: dir, Length: length})
+	}
+
+	if len(path) == 1 && path[0].in == nil { // both on the same section
+		run(fe.Track, fe.Pos, te.Pos, math.Abs(te.Pos-fe.Pos))
+	} else {
+		first := path[0]
+		run(fe.Track, fe.Pos, first.node.pos, math.Abs(first.node.pos-fe.Pos))
+		for i := 1; i < len(path); i++ {
+			st, prev := path[i], path[i-1]
+			out := st.in
+			if st.final != nil {
+				out = st.final
+			}
+			if n := prev.node; n.isSwitch() {
+				sw := RouteSwitch{Pos: n.pos, Enter: prev.in.leg(n), Leave: out.leg(n)}
+				sw.ID, _ = n.props["id"].(string)
+				sw.Kind, _ = n.props["kind"].(string)
+				sw.Track, _ = n.props["track"].(string)
+				for _, leg := range []string{sw.Enter, sw.Leave} {
+					switch leg {
+					case ContinueLeg:
+						sw.Course, _ = n.props["continueCourse"].(string)
+					case BranchLeg:
+						sw.Course, _ = n.props["branchCourse"].(string)
+					}
+				}
+				r.Switches = append(r.Switches, sw)
+			}
+			if st.final != nil {
+				run(te.Track, st.node.pos, te.Pos, math.Abs(te.Pos-st.node.pos))
+			} else if !st.in.linked {
+				run(st.in.track, prev.node.pos, st.node.pos, st.in.length)
+			}
+		}
+	}
+
+	if len(r.Tracks) == 0 { // from and to are at the same place
+		r.Tracks = append(r.Tracks, RouteTrack{ID: fe.Track, From: fe.Pos, To: fe.Pos, Dir: "up"})
+	}
+
+	seen := map[int]bool{fe.node: true, te.node: true}
+	for _, rt := range r.Tracks {
+		lo, hi := math.Min(rt.From, rt.To), math.Max(rt.From, rt.To)
+		var passed []RouteElement
+		for _, e := range t.elements[rt.ID] {
+			if e.Pos >= lo && e.Pos <= hi && !seen[e.node] {
+				seen[e.node] = true
+				passed = append(passed, e)
+			}
+		}
+		if rt.Dir == "down" {
+			for i, j := 0, len(passed)-1; i < j; i, j = i+1, j-1 {
+				passed[i], passed[j] = passed[j], passed[i]
+			}
+		}
+		r.Elements = append(r.Elements, passed...)
+	}
+	return r
+}
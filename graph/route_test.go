@@ -0,0 +1,88 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+
+	"Go-GoSAFE.converter/store"
+)
+
+// lineNode returns the node of the line with the given id
+func lineNode(t *testing.T, s store.GraphStore, id string) store.Node {
+	lines, err := s.FindNodes("Line", store.Props{"id": id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 {
+		t.Fatalf("%d lines %s, want 1", len(lines), id)
+	}
+	return lines[0]
+}
+
+func TestFindRoute(t *testing.T) {
+	s := store.NewMemoryStore()
+	importLine(t, s, "L1", "fail", network)
+	ln := lineNode(t, s, "L1")
+
+	tests := []struct {
+		name     string
+		from, to string
+		length   float64
+		tracks   []RouteTrack
+		switches []RouteSwitch
+		elements []string
+	}{
+		{"from the stem to the leg going on", "s5", "s4", 1200,
+			[]RouteTrack{{"tr1", 50, 1000, "up", 950}, {"tr2", 0, 250, "up", 250}},
+			[]RouteSwitch{{"sw1", "switch", "tr1", 400, StemLeg, ContinueLeg, "straight"}},
+			[]string{"s1", "s2", "sw1"}},
+		{"from the branch to the stem", "s3", "s5", 500,
+			[]RouteTrack{{"tr3", 150, 0, "down", 150}, {"tr1", 400, 50, "down", 350}},
+			[]RouteSwitch{{"sw1", "switch", "tr1", 400, BranchLeg, StemLeg, "left"}},
+			[]string{"sw1", "s2", "s1"}},
+		{"on the same section", "s5", "s1", 50,
+			[]RouteTrack{{"tr1", 50, 100, "up", 50}}, []RouteSwitch{}, []string{}},
+		{"leaving in the running direction", "s2", "s5", 150,
+			[]RouteTrack{{"tr1", 200, 50, "down", 150}}, []RouteSwitch{}, []string{"s1"}},
+	}
+	for _, tt := range tests {
+		r, err := FindRoute(s, ln, tt.from, tt.to)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if r.From.ID != tt.from || r.To.ID != tt.to || r.Length != tt.length {
+			t.Errorf("%s: from %s to %s is %v m long, want from %s to %s, %v m", tt.name, r.From.ID, r.To.ID, r.Length, tt.from, tt.to, tt.length)
+		}
+		if !reflect.DeepEqual(r.Tracks, tt.tracks) {
+			t.Errorf("%s: runs on %+v, want %+v", tt.name, r.Tracks, tt.tracks)
+		}
+		if !reflect.DeepEqual(r.Switches, tt.switches) {
+			t.Errorf("%s: passes the switches %+v, want %+v", tt.name, r.Switches, tt.switches)
+		}
+		elements := []string{}
+		for _, e := range r.Elements {
+			elements = append(elements, e.ID)
+		}
+		if !reflect.DeepEqual(elements, tt.elements) {
+			t.Errorf("%s: passes %v, want %v", tt.name, elements, tt.elements)
+		}
+	}
+
+	for _, tt := range []struct{ name, from, to string }{
+		// the switch can't be passed from one leg to the other
+		{"from the branch to the leg going on", "s3", "s4"},
+		// s1 is left upwards only, s2 is reached downwards only
+		{"against the running direction of the first", "s1", "s5"},
+		{"against the running direction of the last", "s5", "s2"},
+	} {
+		if r, err := FindRoute(s, ln, tt.from, tt.to); err == nil {
+			t.Errorf("%s: found %+v", tt.name, r)
+		} else if _, ok := err.(*RouteError); !ok {
+			t.Errorf("%s: %v, want a RouteError", tt.name, err)
+		}
+	}
+	if _, err := FindRoute(s, ln, "s5", "s9"); err == nil {
+		t.Error("found a route to an element that doesn't exist")
+	}
+}
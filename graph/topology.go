@@ -0,0 +1,273 @@
+package graph
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"Go-GoSAFE.converter/store"
+)
+
+// The topology layer of a line is a graph that routes can be searched on. Every track begin and end, switch and
+// crossing becomes a TopologyNode owned by its track. The nodes of a track are joined in the order of their pos by
+// TRACK_SECTION relationships, from the lower to the higher pos, which keep the track id and the length of the
+// section. Nodes joined by <connection />s, like the end of a track and the begin of the next one or a switch and
+// the track that branches off, are joined by LINKED relationships of length 0.
+//
+// A switch can only be passed from its stem to one of its legs and back, not from one leg to the other. The stem is
+// the section before the switch if its connection is outgoing, the default, and the one after it if it is incoming.
+// The sections and links that meet at a switch keep which part of it they are, as startLeg or endLeg: stem,
+// continue for the track going on, or branch for the connection. The switch node tells the courses of its legs and
+// which of them is the normal position. Crossings are passed along their tracks only, unless they have switches.
+
+// topology relationship types and the label of topology nodes
+const (
+	TopologyNodeLabel = "TopologyNode"
+	HasTopologyNode   = "HAS_TOPOLOGY_NODE"
+	TrackSection      = "TRACK_SECTION"
+	Linked            = "LINKED"
+)
+
+// switch legs
+const (
+	StemLeg     = "stem"
+	ContinueLeg = "continue"
+	BranchLeg   = "branch"
+)
+
+// topologyNode is a node of the topology layer before it is created
+type topologyNode struct {
+	id       int // in the batch of its track, in the store once that has been written
+	track    string
+	pos      float64
+	hasPos   bool
+	incoming bool // a switch whose connection comes in
+	isSwitch bool // switches and crossings with switches
+}
+
+// connectionRef is a connection of a topology node and the connection it refers to
+type connectionRef struct {
+	from *topologyNode
+	ref  interface{}
+}
+
+// storedRel is a relationship between two stored nodes, made once all of them have been written
+type storedRel struct {
+	start   int
+	relType string
+	end     int
+	props   store.Props
+}
+
+// topologyBuilder builds the topology layer of a line track by track, see TRACK_SECTION and LINKED. The nodes and sections
+// of a track are created with it, the links between the tracks once all of them have been written.
+// Only the nodes with connections are kept until then.
+type topologyBuilder struct {
+	owners  map[interface{}]*topologyNode // connection id -> its topology node
+	refs    []connectionRef
+	created []*topologyNode // since the last call of stored
+}
+
+func newTopologyBuilder() *topologyBuilder {
+	return &topologyBuilder{owners: map[interface{}]*topologyNode{}}
+}
+
+// addTracks creates the topology nodes of the given tracks and the sections between them in s.
+func (tp *topologyBuilder) addTracks(s store.GraphStore, tracks []store.Neighbour) error {
+	for _, t := range tracks {
+		nb, err := s.Neighbours(t.Node.ID, "BEGINS", "ENDS", "HAS_SWITCH", "HAS_CROSSING")
+		if err != nil {
+			return err
+		}
+
+		var begin, end *topologyNode
+		var inner []*topologyNode // switches and crossings
+		for _, n := range nb {
+			if n.Relationship.Start != t.Node.ID {
+				continue
+			}
+			props := store.Props{"track": t.Node.Props["id"]}
+			var connections []store.Node
+			switch n.Relationship.Type {
+			case "BEGINS", "ENDS":
+				// the attributes of <trackBegin /> and <trackEnd /> are kept by the relationship
+				copyProps(props, n.Relationship.Props, "id", "pos", "absPos")
+				props["kind"] = map[string]string{"BEGINS": "trackBegin", "ENDS": "trackEnd"}[n.Relationship.Type]
+				copyProps(props, n.Node.Props, "geometry", GeometryDerived)
+				if n.Node.HasLabel("Connection") {
+					connections = append(connections, n.Node)
+				}
+			default:
+				copyProps(props, n.Node.Props, "id", "pos", "absPos", "geometry", GeometryDerived, "type")
+				props["kind"] = map[string]string{"HAS_SWITCH": "switch", "HAS_CROSSING": "crossing"}[n.Relationship.Type]
+				cs, err := s.Neighbours(n.Node.ID, "HAS_CONNECTION")
+				if err != nil {
+					return err
+				}
+				for _, c := range cs {
+					connections = append(connections, c.Node)
+				}
+			}
+
+			tn := &topologyNode{}
+			tn.track, _ = t.Node.Props["id"].(string)
+			tn.pos, tn.hasPos = number(props["pos"])
+			if tn.hasPos {
+				props["pos"] = tn.pos
+			}
+			if n.Relationship.Type == "HAS_SWITCH" || (n.Relationship.Type == "HAS_CROSSING" && props["type"] != "simpleCrossing") {
+				tn.isSwitch = true
+				legs(props, n.Node.Props, connections)
+				tn.incoming = props["orientation"] == "incoming"
+			}
+			delete(props, "type")
+
+			node, err := s.CreateNode(props, TopologyNodeLabel)
+			if err != nil {
+				return err
+			}
+			if _, err := s.Relate(t.Node.ID, HasTopologyNode, node.ID, store.Props{}); err != nil {
+				return err
+			}
+			tn.id = node.ID
+
+			switch n.Relationship.Type {
+			case "BEGINS":
+				begin = tn
+			case "ENDS":
+				end = tn
+			default:
+				if !tn.hasPos {
+					continue // can't be placed on the track
+				}
+				inner = append(inner, tn)
+			}
+			if n.Relationship.Type == "HAS_CROSSING" && !tn.isSwitch {
+				continue
+			}
+			for _, c := range connections {
+				if id, ok := c.Props["id"]; ok {
+					tp.owners[id] = tn
+					tp.created = append(tp.created, tn)
+				}
+				if ref, ok := c.Props["ref"]; ok {
+					tp.refs = append(tp.refs, connectionRef{tn, ref})
+					tp.created = append(tp.created, tn)
+				}
+			}
+		}
+
+		sort.SliceStable(inner, func(i, j int) bool { return inner[i].pos < inner[j].pos })
+		var path []*topologyNode
+		if begin != nil {
+			path = append(path, begin)
+		}
+		path = append(path, inner...)
+		if end != nil {
+			path = append(path, end)
+		}
+		for i := 1; i < len(path); i++ {
+			a, b := path[i-1], path[i]
+			props := store.Props{"track": t.Node.Props["id"]}
+			if a.hasPos && b.hasPos {
+				props["length"] = math.Abs(b.pos - a.pos)
+			}
+			if a.isSwitch { // the section is after the switch
+				props["startLeg"] = map[bool]string{false: ContinueLeg, true: StemLeg}[a.incoming]
+			}
+			if b.isSwitch { // and before this one
+				props["endLeg"] = map[bool]string{false: StemLeg, true: ContinueLeg}[b.incoming]
+			}
+			if _, err := s.Relate(a.id, TrackSection, b.id, props); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stored replaces the ids of the nodes created since the last call with their store ids, sid maps them
+func (tp *topologyBuilder) stored(sid func(id int) int) {
+	done := map[*topologyNode]bool{}
+	for _, tn := range tp.created {
+		if !done[tn] {
+			done[tn] = true
+			tn.id = sid(tn.id)
+		}
+	}
+	tp.created = nil
+}
+
+// links returns the LINKED relationships between the topology nodes of all tracks added, with the tracks they link
+func (tp *topologyBuilder) links() ([]storedRel, [][2]string) {
+	var rels []storedRel
+	var tracks [][2]string
+	linked := map[[2]int]bool{}
+	for _, r := range tp.refs {
+		to, ok := tp.owners[r.ref]
+		if !ok || to == r.from || linked[[2]int{r.from.id, to.id}] || linked[[2]int{to.id, r.from.id}] {
+			continue
+		}
+		linked[[2]int{r.from.id, to.id}] = true
+		props := store.Props{"length": 0.0}
+		if r.from.isSwitch {
+			props["startLeg"] = BranchLeg
+		}
+		if to.isSwitch {
+			props["endLeg"] = BranchLeg
+		}
+		rels = append(rels, storedRel{start: r.from.id, relType: Linked, end: to.id, props: props})
+		tracks = append(tracks, [2]string{r.from.track, to.track})
+	}
+	return rels, tracks
+}
+
+// legs sets the courses of the legs of a switch, which of them is the normal position and how its connection is oriented
+func legs(props store.Props, sw store.Props, connections []store.Node) {
+	props["orientation"] = "outgoing"
+	if c, ok := sw["trackContinueCourse"]; ok {
+		props["continueCourse"] = c
+	}
+	for _, c := range connections {
+		if o, ok := c.Props["orientation"]; ok && o == "incoming" {
+			props["orientation"] = o
+		}
+		if course, ok := c.Props["course"]; ok {
+			props["branchCourse"] = course
+		}
+	}
+	if np, ok := sw["normalPosition"]; ok {
+		props["normalPosition"] = np
+		switch np {
+		case props["continueCourse"]:
+			props["normalLeg"] = ContinueLeg
+		case props["branchCourse"]:
+			props["normalLeg"] = BranchLeg
+		}
+	}
+}
+
+// copyProps copies the given properties from src to dst, if src has them
+func copyProps(dst store.Props, src store.Props, keys ...string) {
+	for _, k := range keys {
+		if v, ok := src[k]; ok {
+			dst[k] = v
+		}
+	}
+}
+
+// number reads a numeric property, which is a string as long as it comes from RailML
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
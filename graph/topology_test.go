@@ -0,0 +1,103 @@
+package graph
+
+import (
+	"testing"
+
+	"Go-GoSAFE.converter/store"
+)
+
+// network is a line of three tracks: tr2 goes on from the end of tr1 and tr3 branches off to the left at the switch
+// sw1 of tr1, whose stem is towards the begin of tr1
+const network = `<railml><infrastructure id="inf1"><tracks>
+<track id="tr1"><trackTopology>
+	<trackBegin id="tb1" pos="0"><openEnd id="oe1"/></trackBegin>
+	<trackEnd id="te1" pos="1000"><connection id="c1" ref="c2"/></trackEnd>
+	<connections><switch id="sw1" pos="400" trackContinueCourse="straight" normalPosition="straight">
+		<connection id="c3" ref="c4" orientation="outgoing" course="left"/>
+	</switch></connections>
+</trackTopology><ocsElements><signals>
+	<signal id="s5" pos="50"/><signal id="s1" pos="100" dir="up"/><signal id="s2" pos="200" dir="down"/>
+</signals></ocsElements></track>
+<track id="tr2"><trackTopology>
+	<trackBegin id="tb2" pos="0"><connection id="c2" ref="c1"/></trackBegin>
+	<trackEnd id="te2" pos="500"><openEnd id="oe2"/></trackEnd>
+</trackTopology><ocsElements><signals><signal id="s4" pos="250"/></signals></ocsElements></track>
+<track id="tr3"><trackTopology>
+	<trackBegin id="tb3" pos="0"><connection id="c4" ref="c3"/></trackBegin>
+	<trackEnd id="te3" pos="300"><openEnd id="oe3"/></trackEnd>
+</trackTopology><ocsElements><signals><signal id="s3" pos="150"/></signals></ocsElements></track>
+</tracks></infrastructure></railml>`
+
+// topologyNodeOf returns the topology node of the element with the given id
+func topologyNodeOf(t *testing.T, s store.GraphStore, id string) store.Node {
+	nodes, err := s.FindNodes(TopologyNodeLabel, store.Props{"id": id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 {
+		t.Fatalf("%d topology nodes of %s, want 1", len(nodes), id)
+	}
+	return nodes[0]
+}
+
+func TestTopology(t *testing.T) {
+	s := store.NewMemoryStore()
+	importLine(t, s, "L1", "fail", network)
+
+	nodes, err := s.FindNodes(TopologyNodeLabel, store.Props{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 7 {
+		t.Errorf("%d topology nodes, want 7: the begin and end of each track and the switch", len(nodes))
+	}
+
+	sw := topologyNodeOf(t, s, "sw1")
+	for k, want := range map[string]interface{}{"kind": "switch", "track": "tr1", "pos": 400.0, "orientation": "outgoing",
+		"continueCourse": "straight", "branchCourse": "left", "normalPosition": "straight", "normalLeg": ContinueLeg} {
+		if sw.Props[k] != want {
+			t.Errorf("the switch has %s %v, want %v", k, sw.Props[k], want)
+		}
+	}
+
+	// the switch is passed from the section before it, its stem, to the one after it or to the track branching off
+	nb, err := s.Neighbours(sw.ID, TrackSection, Linked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type leg struct {
+		relType, to string
+		length      interface{}
+	}
+	got := map[string]leg{}
+	for _, n := range nb {
+		key := "endLeg"
+		if n.Relationship.Start == sw.ID {
+			key = "startLeg"
+		}
+		l, _ := n.Relationship.Props[key].(string)
+		got[l] = leg{n.Relationship.Type, n.Node.Props["id"].(string), n.Relationship.Props["length"]}
+	}
+	want := map[string]leg{
+		StemLeg:     {TrackSection, "tb1", 400.0},
+		ContinueLeg: {TrackSection, "te1", 600.0},
+		BranchLeg:   {Linked, "tb3", 0.0},
+	}
+	if len(got) != len(want) {
+		t.Errorf("the legs of the switch are %v, want %v", got, want)
+	}
+	for l, w := range want {
+		if got[l] != w {
+			t.Errorf("the %s leg of the switch is %v, want %v", l, got[l], w)
+		}
+	}
+
+	// the end of tr1 is linked to the begin of tr2, without legs
+	nb, err = s.Neighbours(topologyNodeOf(t, s, "te1").ID, Linked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nb) != 1 || nb[0].Node.Props["id"] != "tb2" || nb[0].Relationship.Props["startLeg"] != nil || nb[0].Relationship.Props["endLeg"] != nil {
+		t.Errorf("the end of tr1 is linked to %v, want the begin of tr2", nb)
+	}
+}
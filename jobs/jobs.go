@@ -0,0 +1,577 @@
+// Package jobs runs RailML imports in the background, so that large files don't hold HTTP requests open.
+// At most the configured number of imports run at the same time, the others wait in the queue.
+// Jobs are stored as JSON files in the job dir next to their uploads, so they survive a restart whatever the graph store is.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-GoSAFE.converter/config"
+	"Go-GoSAFE.converter/graph"
+	"Go-GoSAFE.converter/schema"
+	"Go-GoSAFE.converter/store"
+	"Go-GoSAFE.converter/utils"
+)
+
+// states of a job
+const (
+	Queued    = "queued"
+	Running   = "running"
+	Done      = "done"
+	Failed    = "failed"
+	Cancelled = "cancelled"
+)
+
+// Error is a problem that made a job fail, or a violation of the railML schema, with the RailML element
+// and line that caused it if there is one.
+type Error struct {
+	Detail  string `json:"detail"`
+	Element string `json:"element,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+// Job is a single import running in the background.
+type Job struct {
+	ID          string     `json:"id"`
+	State       string     `json:"state"`
+	Line        string     `json:"line"`
+	Mode        string     `json:"mode"`
+	Epsg        string     `json:"epsg,omitempty"`
+	Validate    string     `json:"validate,omitempty"` // strict or warn, see ImportRailml
+	Fill        bool       `json:"fill,omitempty"`     // fills in missing pos and absPos values, see ImportRailml
+	Source      string     `json:"source"`
+	Tracks      int        `json:"tracks"`      // converted so far
+	TotalTracks int        `json:"totalTracks"` // in the file, known once the job runs
+	BytesRead   int64      `json:"bytesRead"`
+	Size        int64      `json:"size"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	Elapsed     float64    `json:"elapsedSeconds"` // since the job started running
+	Errors      []Error    `json:"errors,omitempty"`
+	Warnings    []Error    `json:"warnings,omitempty"` // violations of the railML schema, if validate is warn
+	// Diagnostics are the findings of the check of the imported line, see graph.Diagnose
+	Diagnostics []graph.Finding `json:"diagnostics,omitempty"`
+
+	file    string             // the uploaded RailML file, removed when the job is finished
+	cancel  context.CancelFunc // stops the import of a running job
+	version int                // of the state last passed to persist
+}
+
+var (
+	mu    sync.Mutex
+	jobs  = map[string]*Job{}
+	slots chan struct{} // one for each running import
+	once  sync.Once
+
+	saving sync.Mutex         // serializes writing the job files
+	saved  = map[string]int{} // job id -> version of the state in its file
+)
+
+// expireEvery is how often finished jobs are checked for whether they are older than the configured retention
+var expireEvery = time.Hour
+
+// Start restores the jobs stored before a restart. Jobs that were queued or running are queued again,
+// imports write their line in a single transaction so an interrupted one didn't leave anything behind.
+// If the uploaded file of such a job is gone, the job fails.
+// Finished jobs are removed once they are older than the configured retention, see expire.
+func Start() error {
+	dir := config.Get().JobDir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	restored := 0
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		var p store.Props
+		if err == nil {
+			err = json.Unmarshal(data, &p)
+		}
+		if err != nil {
+			config.Warnf("cannot restore the import job in %s: %v", file, err)
+			continue
+		}
+		restore(fromProps(p))
+		restored++
+	}
+	if restored > 0 {
+		config.Infof("restored %d import jobs", restored)
+	}
+
+	expire(time.Now())
+	go func() {
+		for now := range time.Tick(expireEvery) {
+			expire(now)
+		}
+	}()
+	return nil
+}
+
+// expire removes the jobs that finished longer than the configured retention before now, with their files
+func expire(now time.Time) {
+	before := now.Add(-config.Get().JobRetention)
+
+	mu.Lock()
+	var expired []string
+	for id, j := range jobs {
+		if j.FinishedAt != nil && j.FinishedAt.Before(before) {
+			delete(jobs, id)
+			expired = append(expired, id)
+		}
+	}
+	mu.Unlock()
+	if len(expired) == 0 {
+		return
+	}
+
+	saving.Lock()
+	defer saving.Unlock()
+	for _, id := range expired {
+		if err := os.Remove(filepath.Join(config.Get().JobDir, id+".json")); err != nil && !os.IsNotExist(err) {
+			config.Warnf("job %s: cannot remove its state: %v", id, err)
+		}
+		delete(saved, id)
+	}
+	config.Infof("removed %d import jobs that finished before %s", len(expired), before.Format(time.RFC3339))
+}
+
+// restore adds a job stored before a restart and queues it again if it didn't finish
+func restore(j *Job) {
+	mu.Lock()
+	jobs[j.ID] = j
+	restart := j.State == Queued || j.State == Running
+	if restart {
+		j.StartedAt, j.Tracks, j.BytesRead, j.Warnings, j.Diagnostics = nil, 0, 0, nil, nil
+		if _, err := os.Stat(j.file); err == nil {
+			j.State = Queued
+		} else {
+			finish(j, Failed, Error{Detail: "the server was restarted and the uploaded file is gone, import it again"})
+		}
+	}
+	p := j.props()
+	mu.Unlock()
+
+	persist(p)
+	if restart && j.State == Queued {
+		go run(j)
+	}
+}
+
+// Submit keeps a copy of the RailML file and queues its import, see graph.ImportLine.
+// If validate is strict or warn, the file is validated against the railML schema first, see schema.ValidateRailML.
+func Submit(o graph.ImportOptions, validate string, r io.Reader) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	dir := config.Get().JobDir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Job{}, err
+	}
+	file := filepath.Join(dir, id+".xml")
+	f, err := os.Create(file)
+	if err != nil {
+		return Job{}, err
+	}
+	size, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file)
+		return Job{}, err
+	}
+
+	j := &Job{
+		ID:        id,
+		State:     Queued,
+		Line:      o.Line,
+		Mode:      o.Mode,
+		Epsg:      o.Epsg,
+		Validate:  validate,
+		Fill:      o.FillPositions,
+		Source:    o.Source,
+		Size:      size,
+		CreatedAt: time.Now().UTC(),
+		file:      file,
+	}
+	mu.Lock()
+	jobs[id] = j
+	p := j.props()
+	snapshot := j.snapshot()
+	mu.Unlock()
+
+	persist(p)
+	go run(j)
+	return snapshot, nil
+}
+
+// Get returns the current state of the job.
+func Get(id string) (Job, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	j, ok := jobs[id]
+	if !ok {
+		return Job{}, &store.NotFoundError{Label: "Job", ID: id}
+	}
+	return j.snapshot(), nil
+}
+
+// Cancel stops the job. A queued job is cancelled right away, a running one as soon as its import notices,
+// which is before anything is written. Finished jobs can't be cancelled.
+func Cancel(id string) (Job, error) {
+	mu.Lock()
+	j, ok := jobs[id]
+	if !ok {
+		mu.Unlock()
+		return Job{}, &store.NotFoundError{Label: "Job", ID: id}
+	}
+
+	var p store.Props
+	switch j.State {
+	case Queued:
+		finish(j, Cancelled)
+		p = j.props()
+	case Running:
+		j.cancel()
+	default:
+		mu.Unlock()
+		return Job{}, &store.ConflictError{Msg: "job '" + id + "' is already " + j.State}
+	}
+	snapshot := j.snapshot()
+	mu.Unlock()
+
+	if p != nil {
+		os.Remove(j.file)
+		persist(p)
+	}
+	return snapshot, nil
+}
+
+// run waits for a free worker and imports the file of the job
+func run(j *Job) {
+	once.Do(func() {
+		slots = make(chan struct{}, config.Get().ImportWorkers)
+	})
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mu.Lock()
+	if j.State != Queued { // cancelled while waiting
+		mu.Unlock()
+		return
+	}
+	j.State = Running
+	j.cancel = cancel
+	started := time.Now().UTC()
+	j.StartedAt = &started
+	p := j.props()
+	mu.Unlock()
+	persist(p)
+	config.Infof("job %s: importing %s into line '%s'", j.ID, j.Source, j.Line)
+
+	tracks, err := importFile(ctx, j)
+
+	mu.Lock()
+	switch {
+	case err == nil:
+		j.Tracks = tracks
+		finish(j, Done)
+	case err == context.Canceled:
+		finish(j, Cancelled)
+	default:
+		finish(j, Failed, newErrors(err)...)
+	}
+	p = j.props()
+	mu.Unlock()
+
+	os.Remove(j.file)
+	persist(p)
+	config.Infof("job %s: %s after %s", j.ID, p["state"], time.Since(started))
+}
+
+// importFile validates the file if the job asks for it, counts its tracks, so that the progress can be told,
+// and then imports it
+func importFile(ctx context.Context, j *Job) (int, error) {
+	f, err := os.Open(j.file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if j.Validate != "" {
+		violations, err := schema.ValidateRailML(f)
+		if err != nil {
+			return 0, err
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if len(violations) > 0 && j.Validate == "strict" {
+			return 0, &violationsError{violations}
+		}
+		mu.Lock()
+		j.Warnings = violationErrors(violations)
+		mu.Unlock()
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+
+	total, err := utils.CountElements(f, "track")
+	if err != nil {
+		return 0, err
+	}
+	mu.Lock()
+	j.TotalTracks = total
+	mu.Unlock()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	graphUtils := graph.GraphUtils{}
+	return graphUtils.ImportLine(ctx, config.GetStore(), f, graph.ImportOptions{
+		Line:   j.Line,
+		Epsg:   j.Epsg,
+		Mode:   j.Mode,
+		Source: j.Source,
+		// the tolerance is the configured one at the time the job runs
+		LengthTolerance: config.Get().LengthTolerance,
+		FillPositions:   j.Fill,
+		Progress: func(read int64, tracks int) {
+			mu.Lock()
+			j.BytesRead, j.Tracks = read, tracks
+			mu.Unlock()
+		},
+		Diagnostics: func(findings []graph.Finding) {
+			mu.Lock()
+			j.Diagnostics = findings
+			mu.Unlock()
+			if len(findings) > 0 {
+				config.Warnf("job %s: line '%s' has %d findings, see its diagnostics", j.ID, j.Line, len(findings))
+			}
+		},
+	})
+}
+
+// finish ends the job in the given state, mu must be held
+func finish(j *Job, state string, errs ...Error) {
+	now := time.Now().UTC()
+	j.State = state
+	j.FinishedAt = &now
+	j.Errors = append(j.Errors, errs...)
+	j.cancel = nil
+}
+
+// violationsError fails a job that validates strictly
+type violationsError struct {
+	violations []schema.Violation
+}
+
+func (e *violationsError) Error() string {
+	return "the RailML file violates the railML schema"
+}
+
+// newErrors describes why an import failed
+func newErrors(err error) []Error {
+	switch e := err.(type) {
+	case *utils.ElementError:
+		return []Error{{Detail: e.Err.Error(), Element: e.Path, ID: e.ID, Line: e.Line}}
+	case *xml.SyntaxError:
+		return []Error{{Detail: "invalid RailML: " + e.Error(), Line: e.Line}}
+	case *violationsError:
+		return violationErrors(e.violations)
+	}
+	return []Error{{Detail: err.Error()}}
+}
+
+func violationErrors(violations []schema.Violation) []Error {
+	errs := []Error{}
+	for _, v := range violations {
+		errs = append(errs, Error{Detail: v.Detail, Element: v.Element, Line: v.Line, Column: v.Column})
+	}
+	return errs
+}
+
+// snapshot copies the job for a response, mu must be held
+func (j *Job) snapshot() Job {
+	c := *j
+	c.Errors = append([]Error(nil), j.Errors...)
+	c.Warnings = append([]Error(nil), j.Warnings...)
+	c.Diagnostics = append([]graph.Finding(nil), j.Diagnostics...)
+	if j.StartedAt != nil {
+		end := time.Now().UTC()
+		if j.FinishedAt != nil {
+			end = *j.FinishedAt
+		}
+		c.Elapsed = end.Sub(*j.StartedAt).Seconds()
+	}
+	return c
+}
+
+// props are the properties the job is stored with, mu must be held. Each call is a newer version of the job,
+// persist doesn't store an older one over it.
+// The progress of running jobs isn't stored, a restart runs them again anyway.
+func (j *Job) props() store.Props {
+	j.version++
+	p := store.Props{
+		"version":     j.version,
+		"id":          j.ID,
+		"state":       j.State,
+		"line":        j.Line,
+		"mode":        j.Mode,
+		"epsg":        j.Epsg,
+		"validate":    j.Validate,
+		"fill":        j.Fill,
+		"source":      j.Source,
+		"file":        j.file,
+		"size":        j.Size,
+		"tracks":      j.Tracks,
+		"totalTracks": j.TotalTracks,
+		"createdAt":   j.CreatedAt.Format(time.RFC3339Nano),
+	}
+	if j.StartedAt != nil {
+		p["startedAt"] = j.StartedAt.Format(time.RFC3339Nano)
+	}
+	if j.FinishedAt != nil {
+		p["finishedAt"] = j.FinishedAt.Format(time.RFC3339Nano)
+	}
+	if len(j.Errors) > 0 {
+		data, _ := json.Marshal(j.Errors)
+		p["errors"] = string(data)
+	}
+	if j.Warnings != nil {
+		data, _ := json.Marshal(j.Warnings)
+		p["warnings"] = string(data)
+	}
+	if j.Diagnostics != nil {
+		data, _ := json.Marshal(j.Diagnostics)
+		p["diagnostics"] = string(data)
+	}
+	return p
+}
+
+// fromProps reads a job stored by persist
+func fromProps(p store.Props) *Job {
+	str := func(k string) string {
+		s, _ := p[k].(string)
+		return s
+	}
+	num := func(k string) int64 {
+		switch v := p[k].(type) {
+		case int:
+			return int64(v)
+		case int64:
+			return v
+		case float64: // numbers read back from JSON
+			return int64(v)
+		}
+		return 0
+	}
+	tm := func(k string) *time.Time {
+		t, err := time.Parse(time.RFC3339Nano, str(k))
+		if err != nil {
+			return nil
+		}
+		return &t
+	}
+
+	j := &Job{
+		ID:          str("id"),
+		State:       str("state"),
+		Line:        str("line"),
+		Mode:        str("mode"),
+		Epsg:        str("epsg"),
+		Validate:    str("validate"),
+		Source:      str("source"),
+		Size:        num("size"),
+		Tracks:      int(num("tracks")),
+		TotalTracks: int(num("totalTracks")),
+		StartedAt:   tm("startedAt"),
+		FinishedAt:  tm("finishedAt"),
+		file:        str("file"),
+		version:     int(num("version")),
+	}
+	j.Fill, _ = p["fill"].(bool)
+	if t := tm("createdAt"); t != nil {
+		j.CreatedAt = *t
+	}
+	if e := str("errors"); e != "" {
+		json.Unmarshal([]byte(e), &j.Errors)
+	}
+	if w := str("warnings"); w != "" {
+		json.Unmarshal([]byte(w), &j.Warnings)
+	}
+	if d := str("diagnostics"); d != "" {
+		json.Unmarshal([]byte(d), &j.Diagnostics)
+	}
+	return j
+}
+
+// persist stores the properties of a job in the file <id>.json of the job dir, unless a newer version is stored already.
+// Failures are only logged, the job goes on in memory.
+func persist(p store.Props) {
+	id, _ := p["id"].(string)
+	version, _ := p["version"].(int)
+
+	saving.Lock()
+	defer saving.Unlock()
+	if version <= saved[id] {
+		return
+	}
+	err := writeFile(filepath.Join(config.Get().JobDir, id+".json"), p)
+	if err != nil {
+		config.Warnf("job %s: cannot store its state: %v", id, err)
+		return
+	}
+	saved[id] = version
+}
+
+// writeFile replaces the file with the JSON of the properties. It is written next to it first and then renamed,
+// so that the file is never left half written.
+func writeFile(file string, p store.Props) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	tmp := strings.TrimSuffix(file, ".json") + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// newID creates a random job id
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
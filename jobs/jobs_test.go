@@ -0,0 +1,310 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"Go-GoSAFE.converter/config"
+	"Go-GoSAFE.converter/graph"
+	"Go-GoSAFE.converter/store"
+)
+
+const line = `<railml><infrastructure id="inf1"><tracks><track id="tr1"><trackTopology>
+<trackBegin id="tb1" pos="0"><openEnd id="oe1"/></trackBegin><trackEnd id="te1" pos="100"><openEnd id="oe2"/></trackEnd>
+</trackTopology></track></tracks></infrastructure></railml>`
+
+func TestMain(m *testing.M) {
+	// the settings and the store are shared by the jobs running in the background, so they are set once
+	dir, err := ioutil.TempDir("", "gosafe-jobs-test")
+	if err != nil {
+		panic(err)
+	}
+	config.Get().JobDir = dir
+	config.CreateMemoryStore()
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// runs counts the calls of setup
+var runs int
+
+// setup forgets the jobs and returns a suffix for the line and job ids of the test, the store keeps the lines
+// of the tests run before
+func setup() string {
+	mu.Lock()
+	jobs = map[string]*Job{}
+	mu.Unlock()
+	saving.Lock()
+	saved = map[string]int{}
+	saving.Unlock()
+	runs++
+	return "-" + strconv.Itoa(runs)
+}
+
+// wait waits until the job is finished and stored so
+func wait(t *testing.T, id string) Job {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		j, err := Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.State != Queued && j.State != Running && stored(t, id)["state"] == j.State {
+			return j
+		}
+	}
+	t.Fatalf("job %s didn't finish", id)
+	return Job{}
+}
+
+// block takes all workers, so that jobs stay queued until the returned func is called
+func block() func() {
+	once.Do(func() {
+		slots = make(chan struct{}, config.Get().ImportWorkers)
+	})
+	for i := 0; i < cap(slots); i++ {
+		slots <- struct{}{}
+	}
+	return func() {
+		for i := 0; i < cap(slots); i++ {
+			<-slots
+		}
+	}
+}
+
+// stored reads the state of the job from its file in the job dir
+func stored(t *testing.T, id string) store.Props {
+	data, err := ioutil.ReadFile(filepath.Join(config.Get().JobDir, id+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p store.Props
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSubmit(t *testing.T) {
+	ln := "L" + setup()
+
+	j, err := Submit(graph.ImportOptions{Line: ln, Epsg: "4326", Mode: "fail", Source: "line.xml"}, "", strings.NewReader(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.State != Queued || j.Line != ln || j.Size != int64(len(line)) {
+		t.Errorf("the submitted job is %+v", j)
+	}
+	j = wait(t, j.ID)
+	if j.State != Done || j.Tracks != 1 || j.TotalTracks != 1 || j.StartedAt == nil || j.FinishedAt == nil || len(j.Errors) > 0 {
+		t.Errorf("the finished job is %+v", j)
+	}
+	if p := stored(t, j.ID); p["state"] != Done || p["tracks"] != 1.0 {
+		t.Errorf("the job is stored as %v", p)
+	}
+	if _, err := os.Stat(filepath.Join(config.Get().JobDir, j.ID+".xml")); !os.IsNotExist(err) {
+		t.Errorf("the uploaded file is kept: %v", err)
+	}
+	if lines, err := config.GetStore().FindNodes("Line", store.Props{"id": ln}); err != nil || len(lines) != 1 {
+		t.Errorf("%d lines %s imported: %v", len(lines), ln, err)
+	}
+
+	// the line is there already
+	j, err = Submit(graph.ImportOptions{Line: ln, Epsg: "4326", Mode: "fail"}, "", strings.NewReader(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j = wait(t, j.ID); j.State != Failed || len(j.Errors) != 1 {
+		t.Errorf("the job importing a line twice is %+v", j)
+	}
+
+	if _, err := Get("nope"); err == nil {
+		t.Error("got a job that doesn't exist")
+	} else if _, ok := err.(*store.NotFoundError); !ok {
+		t.Errorf("a job that doesn't exist: %v, want a NotFoundError", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ln := "L" + setup()
+	release := block()
+
+	j, err := Submit(graph.ImportOptions{Line: ln, Epsg: "4326", Mode: "fail"}, "", strings.NewReader(line))
+	if err != nil {
+		release()
+		t.Fatal(err)
+	}
+	c, err := Cancel(j.ID)
+	release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.State != Cancelled || c.FinishedAt == nil {
+		t.Errorf("the cancelled job is %+v", c)
+	}
+	if p := stored(t, j.ID); p["state"] != Cancelled {
+		t.Errorf("the cancelled job is stored as %v", p)
+	}
+	if _, err := os.Stat(filepath.Join(config.Get().JobDir, j.ID+".xml")); !os.IsNotExist(err) {
+		t.Errorf("the uploaded file of the cancelled job is kept: %v", err)
+	}
+	if _, err := Cancel(j.ID); err == nil {
+		t.Error("cancelled a job twice")
+	} else if _, ok := err.(*store.ConflictError); !ok {
+		t.Errorf("cancelling a job twice: %v, want a ConflictError", err)
+	}
+	if _, err := Cancel("nope"); err == nil {
+		t.Error("cancelled a job that doesn't exist")
+	}
+
+	// the worker doesn't run a job that was cancelled while it waited
+	time.Sleep(20 * time.Millisecond)
+	if g, _ := Get(j.ID); g.State != Cancelled || g.StartedAt != nil {
+		t.Errorf("the cancelled job ran: %+v", g)
+	}
+	if lines, _ := config.GetStore().FindNodes("Line", store.Props{"id": ln}); len(lines) > 0 {
+		t.Error("the cancelled job imported its line")
+	}
+
+	// a running job is stopped by its import
+	ctx, cancel := context.WithCancel(context.Background())
+	mu.Lock()
+	jobs["running"] = &Job{ID: "running", State: Running, cancel: cancel}
+	mu.Unlock()
+	if _, err := Cancel("running"); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != context.Canceled {
+		t.Error("the import of the running job goes on")
+	}
+}
+
+func TestPersistVersion(t *testing.T) {
+	id := "versions" + setup()
+
+	j := &Job{ID: id, State: Running, CreatedAt: time.Now().UTC()}
+	running := j.props()
+	j.State = Done
+	done := j.props()
+
+	// the states are stored out of order, the older one must not overwrite the newer one
+	persist(done)
+	persist(running)
+	if p := stored(t, id); p["state"] != Done || p["version"] != 2.0 {
+		t.Errorf("the job is stored as %v, want done at version 2", p)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	suffix := setup()
+	dir := config.Get().JobDir
+
+	write := func(id string, state string, upload bool, finished time.Time) {
+		id += suffix
+		file := filepath.Join(dir, id+".xml")
+		if upload {
+			if err := ioutil.WriteFile(file, []byte(line), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		started := time.Now().UTC().Add(-time.Minute)
+		j := &Job{ID: id, State: state, Line: "L-" + id, Mode: "fail", Epsg: "4326", CreatedAt: started,
+			StartedAt: &started, Tracks: 7, file: file}
+		if state == Done {
+			j.FinishedAt = &finished
+		}
+		if err := writeFile(filepath.Join(dir, id+".json"), j.props()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now().UTC()
+	write("running", Running, true, now)
+	write("queued", Queued, true, now)
+	write("gone", Running, false, now)
+	write("done", Done, false, now.Add(-time.Minute))
+	write("expired", Done, false, now.Add(-config.Get().JobRetention-time.Minute))
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken"+suffix+".json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Start(); err != nil {
+		t.Fatal(err)
+	}
+	// the interrupted jobs run again from the start
+	for _, id := range []string{"running" + suffix, "queued" + suffix} {
+		if j := wait(t, id); j.State != Done || j.Tracks != 1 {
+			t.Errorf("the restored %s job is %+v", id, j)
+		}
+		if lines, err := config.GetStore().FindNodes("Line", store.Props{"id": "L-" + id}); err != nil || len(lines) != 1 {
+			t.Errorf("the restored %s job imported %d lines: %v", id, len(lines), err)
+		}
+	}
+	if j, _ := Get("gone" + suffix); j.State != Failed || len(j.Errors) != 1 || !strings.Contains(j.Errors[0].Detail, "the uploaded file is gone") {
+		t.Errorf("the restored job without its file is %+v", j)
+	}
+	if p := stored(t, "gone"+suffix); p["state"] != Failed {
+		t.Errorf("the restored job without its file is stored as %v", p)
+	}
+	if j, _ := Get("done" + suffix); j.State != Done || j.Tracks != 7 {
+		t.Errorf("the restored finished job is %+v", j)
+	}
+	if _, err := Get("expired" + suffix); err == nil {
+		t.Error("restored a job that finished longer than the retention ago")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "expired"+suffix+".json")); !os.IsNotExist(err) {
+		t.Errorf("the file of the expired job is kept: %v", err)
+	}
+	if _, err := Get("broken" + suffix); err == nil {
+		t.Error("restored a broken job file")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	suffix := setup()
+	now := time.Now().UTC()
+	old := now.Add(-config.Get().JobRetention - time.Minute)
+	recent := now.Add(-time.Minute)
+
+	add := func(id, state string, finished *time.Time) string {
+		id += suffix
+		j := &Job{ID: id, State: state, CreatedAt: old, FinishedAt: finished}
+		mu.Lock()
+		jobs[id] = j
+		p := j.props()
+		mu.Unlock()
+		persist(p)
+		return id
+	}
+	expired := add("expired", Done, &old)
+	failed := add("failed", Failed, &old)
+	kept := add("kept", Done, &recent)
+	running := add("running", Running, nil)
+
+	expire(now)
+	for _, id := range []string{expired, failed} {
+		if _, err := Get(id); err == nil {
+			t.Errorf("the expired job %s is kept", id)
+		}
+		if _, err := os.Stat(filepath.Join(config.Get().JobDir, id+".json")); !os.IsNotExist(err) {
+			t.Errorf("the file of the expired job %s is kept: %v", id, err)
+		}
+	}
+	for _, id := range []string{kept, running} {
+		if _, err := Get(id); err != nil {
+			t.Errorf("the job %s was removed: %v", id, err)
+		}
+		if p := stored(t, id); p["id"] != id {
+			t.Errorf("the job %s is stored as %v", id, p)
+		}
+	}
+	mu.Lock()
+	delete(jobs, running) // it doesn't run
+	mu.Unlock()
+}
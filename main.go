@@ -1,11 +1,41 @@
 package main
 
 import (
+	"flag"
+	"log"
+	"strings"
+
 	"Go-GoSAFE.converter/config"
+	"Go-GoSAFE.converter/graph"
+	"Go-GoSAFE.converter/jobs"
+	"Go-GoSAFE.converter/schema"
 	"Go-GoSAFE.converter/server"
 )
 
 func main() {
-	config.CreateDBConnection()
+	path := flag.String("config", "", "path to a YAML config file, defaults to $GOSAFE_CONFIG")
+	flag.Parse()
+
+	if err := config.Load(*path); err != nil {
+		log.Fatal(err)
+	}
+	if dir := config.Get().SchemaDir; dir != "" {
+		if err := schema.Load(dir); err != nil {
+			log.Fatalf("schema dir %s: %v", dir, err)
+		}
+		config.Infof("validating files against the railML schemas of %s", strings.Join(schema.Versions(), ", "))
+	} else {
+		config.Warnf("no schema dir is set, files can't be validated")
+	}
+	if err := config.CreateStore(); err != nil {
+		config.Warnf("%v", err)
+	}
+	// with an unreachable database the constraints are created as soon as the connection is made
+	if err := graph.Constrain(config.GetStore()); err != nil {
+		config.Warnf("%v", err)
+	}
+	if err := jobs.Start(); err != nil {
+		config.Warnf("cannot restore import jobs: %v", err)
+	}
 	server.Start()
 }
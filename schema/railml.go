@@ -0,0 +1,207 @@
+package schema
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The schemas files are validated against are the railML.org XSDs of each version. They come under the licence of
+// railML.org and aren't part of the converter, Load reads them from a directory the operator puts them in.
+
+// DefaultVersion is the version files that don't state one are validated against
+const DefaultVersion = "2.2"
+
+var (
+	mu               sync.Mutex
+	railmlSchemas    = map[string]map[string]string{} // the documents of each version, by schemaLocation
+	railmlNamespaces = map[string]string{}            // the namespace the schema of each version is written with
+	compiled         = map[string]*Schema{}           // by version and namespace
+)
+
+var (
+	versionDir      = regexp.MustCompile(`^\d+\.\d+$`)
+	targetNamespace = regexp.MustCompile(`targetNamespace="([^"]*)"`)
+)
+
+// UnsupportedVersionError is returned for files of a railML version there is no schema for
+type UnsupportedVersionError struct {
+	Version string
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("railML %s isn't supported, the schemas of %s are loaded", e.Version, strings.Join(Versions(), ", "))
+}
+
+// NoSchemasError is returned when a file is to be validated but no schemas have been loaded, see Load
+type NoSchemasError struct{}
+
+func (e *NoSchemasError) Error() string {
+	return "files can't be validated, no railML schemas are loaded: set schema_dir to a directory with the railML.org XSDs"
+}
+
+// Load reads the schemas in dir, which has a directory for each railML version, e.g. 2.2/, with railML.xsd and the
+// documents it includes and imports, by their schemaLocation. Every schema is compiled, so that a broken one fails
+// here and not when the first file is validated. The schemas replace those loaded before.
+func Load(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	schemas := map[string]map[string]string{}
+	namespaces := map[string]string{}
+	comp := map[string]*Schema{}
+	for _, e := range entries {
+		if !e.IsDir() || !versionDir.MatchString(e.Name()) {
+			continue
+		}
+		version := e.Name()
+		files, err := readDocuments(filepath.Join(dir, version))
+		if err != nil {
+			return fmt.Errorf("railML %s: %v", version, err)
+		}
+		root, ok := files["railML.xsd"]
+		if !ok {
+			return fmt.Errorf("railML %s: there is no railML.xsd in %s", version, filepath.Join(dir, version))
+		}
+		ns := targetNamespace.FindStringSubmatch(root)
+		if ns == nil {
+			return fmt.Errorf("railML %s: railML.xsd has no targetNamespace", version)
+		}
+		s, err := Compile(files, "railML.xsd")
+		if err != nil {
+			return fmt.Errorf("railML %s: %v", version, err)
+		}
+		schemas[version] = files
+		namespaces[version] = ns[1]
+		comp[version+" "+ns[1]] = s
+	}
+	if len(schemas) == 0 {
+		return fmt.Errorf("there are no railML versions in %s, e.g. a directory 2.2 with railML.xsd", dir)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	railmlSchemas, railmlNamespaces, compiled = schemas, namespaces, comp
+	return nil
+}
+
+// readDocuments reads the XSD documents in dir and its subdirectories, by their path relative to dir
+func readDocuments(dir string) (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || filepath.Ext(path) != ".xsd" {
+			return err
+		}
+		text, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(text)
+		return nil
+	})
+	return files, err
+}
+
+// Versions returns the railML versions whose schemas are loaded
+func Versions() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	vs := []string{}
+	for v := range railmlSchemas {
+		vs = append(vs, v)
+	}
+	sort.Strings(vs)
+	return vs
+}
+
+// ValidateRailML validates a railML file against the schema of the version its root element states.
+// Malformed XML fails with an *xml.SyntaxError, versions there is no schema of with an *UnsupportedVersionError
+// and any file if no schemas are loaded with a *NoSchemasError.
+func ValidateRailML(r io.Reader) ([]Violation, error) {
+	if len(Versions()) == 0 {
+		return nil, &NoSchemasError{}
+	}
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	head, _ := br.Peek(64 * 1024) // the root start tag is near the top, and if it isn't, Validate finds what is wrong
+	version, ns := root(head)
+	if version == "" {
+		version = DefaultVersion
+	}
+	if parts := strings.SplitN(version, ".", 3); len(parts) == 3 {
+		version = parts[0] + "." + parts[1] // 2.4.1 is validated against the schema of 2.4
+	}
+	if !strings.HasPrefix(strings.TrimPrefix(strings.TrimPrefix(ns, "http://"), "https://"), "www.railml.org/schemas/") {
+		ns = ""
+	}
+
+	s, err := RailML(version, ns)
+	if err != nil {
+		return nil, err
+	}
+	return s.Validate(br)
+}
+
+// RailML returns the compiled schema of the railML version, with its elements in the given namespace instead of
+// the one the schema is written with, so that files with another namespace of railml.org are validated against it.
+// If ns is empty, the namespace of the schema is kept.
+func RailML(version, ns string) (*Schema, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	files, ok := railmlSchemas[version]
+	if !ok {
+		return nil, &UnsupportedVersionError{version}
+	}
+	if ns == "" {
+		ns = railmlNamespaces[version]
+	}
+	key := version + " " + ns
+	if s, ok := compiled[key]; ok {
+		return s, nil
+	}
+	docs := map[string]string{}
+	for name, text := range files {
+		docs[name] = strings.Replace(text, railmlNamespaces[version], ns, -1)
+	}
+	s, err := Compile(docs, "railML.xsd")
+	if err != nil {
+		return nil, fmt.Errorf("the schema of railML %s is broken: %v", version, err)
+	}
+	compiled[key] = s
+	return s, nil
+}
+
+// root returns the version and namespace of the root element in the head of a document, empty if they can't be read
+func root(head []byte) (version, ns string) {
+	dec := xml.NewDecoder(bytes.NewReader(head))
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return "", ""
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			for _, a := range se.Attr {
+				if a.Name.Space == "" && a.Name.Local == "version" {
+					version = strings.TrimSpace(a.Value)
+				}
+			}
+			return version, se.Name.Space
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package schema
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRailML is a small schema of railML files, with tracks that have an id, written with the namespace {ns}
+// and including its types from a subdirectory
+const testRailML = `<?xml version="1.0" encoding="UTF-8"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns="{ns}" targetNamespace="{ns}" elementFormDefault="qualified">
+	<xs:include schemaLocation="types/infrastructure.xsd"/>
+	<xs:element name="railml">
+		<xs:complexType>
+			<xs:sequence>
+				<xs:element name="infrastructure" type="tInfrastructure"/>
+			</xs:sequence>
+			<xs:attribute name="version" type="xs:string"/>
+		</xs:complexType>
+	</xs:element>
+</xs:schema>`
+
+const testInfrastructure = `<?xml version="1.0" encoding="UTF-8"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" elementFormDefault="qualified">
+	<xs:complexType name="tInfrastructure">
+		<xs:sequence>
+			<xs:element name="track" minOccurs="0" maxOccurs="unbounded">
+				<xs:complexType>
+					<xs:attribute name="id" type="xs:ID" use="required"/>
+				</xs:complexType>
+			</xs:element>
+		</xs:sequence>
+	</xs:complexType>
+</xs:schema>`
+
+// writeSchemas writes the documents, by their path, into a new directory and returns it
+func writeSchemas(t *testing.T, docs map[string]string) string {
+	dir := t.TempDir()
+	for name, text := range docs {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// unload forgets the loaded schemas after the test
+func unload(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		railmlSchemas, railmlNamespaces, compiled = map[string]map[string]string{}, map[string]string{}, map[string]*Schema{}
+	})
+}
+
+// loadTestSchemas loads testRailML as the schema of railML 2.2 and 2.3
+func loadTestSchemas(t *testing.T) {
+	unload(t)
+	dir := writeSchemas(t, map[string]string{
+		"2.2/railML.xsd":               strings.Replace(testRailML, "{ns}", "http://www.railml.org/schemas/2013", -1),
+		"2.2/types/infrastructure.xsd": testInfrastructure,
+		"2.3/railML.xsd":               strings.Replace(testRailML, "{ns}", "http://www.railml.org/schemas/2016", -1),
+		"2.3/types/infrastructure.xsd": testInfrastructure,
+		"README.md":                    "not a version",
+		"drafts/railML.xsd":            "not a version either",
+	})
+	if err := Load(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	loadTestSchemas(t)
+	if got := Versions(); !reflect.DeepEqual(got, []string{"2.2", "2.3"}) {
+		t.Errorf("loaded the versions %v, want 2.2 and 2.3", got)
+	}
+	if got := railmlNamespaces["2.3"]; got != "http://www.railml.org/schemas/2016" {
+		t.Errorf("the namespace of 2.3 is %s", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	unload(t)
+	root := strings.Replace(testRailML, "{ns}", "http://www.railml.org/schemas/2013", -1)
+	tests := []struct {
+		name string
+		dir  string
+		want string
+	}{
+		{"a missing directory", filepath.Join(t.TempDir(), "missing"), "no such file"},
+		{"no versions", writeSchemas(t, map[string]string{"railML.xsd": root}), "there are no railML versions"},
+		{"no railML.xsd", writeSchemas(t, map[string]string{"2.2/infrastructure.xsd": testInfrastructure}), "there is no railML.xsd"},
+		{"no targetNamespace", writeSchemas(t, map[string]string{"2.2/railML.xsd": testInfrastructure}), "railML.xsd has no targetNamespace"},
+		{"a missing include", writeSchemas(t, map[string]string{"2.2/railML.xsd": root}), "types/infrastructure.xsd is missing"},
+	}
+	for _, tt := range tests {
+		if err := Load(tt.dir); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want an error with %q", tt.name, err, tt.want)
+		}
+	}
+	if len(Versions()) != 0 {
+		t.Errorf("loaded %v from schemas that failed to load", Versions())
+	}
+}
+
+func TestValidateRailMLWithoutSchemas(t *testing.T) {
+	unload(t)
+	if _, err := ValidateRailML(strings.NewReader(`<railml version="2.2"/>`)); err == nil {
+		t.Error("validated a file without schemas")
+	} else if _, ok := err.(*NoSchemasError); !ok {
+		t.Errorf("validating without schemas: %v, want a NoSchemasError", err)
+	}
+}
+
+func TestValidateRailMLVersions(t *testing.T) {
+	loadTestSchemas(t)
+	doc := func(version, ns, track string) string {
+		return `<railml xmlns="` + ns + `"` + version + `><infrastructure>` + track + `</infrastructure></railml>`
+	}
+	track := `<track id="tr1"/>`
+	tests := []struct {
+		name string
+		doc  string
+		want []wantViolation
+	}{
+		{"2.2", doc(` version="2.2"`, "http://www.railml.org/schemas/2013", track), nil},
+		{"without a version", doc("", "http://www.railml.org/schemas/2013", track), nil},
+		{"2.3 in its namespace", doc(` version="2.3"`, "http://www.railml.org/schemas/2016", track), nil},
+		{"2.3 with a minor version in another railml.org namespace", doc(` version="2.3.1"`, "https://www.railml.org/schemas/2019", track), nil},
+		{"another namespace", doc(` version="2.3"`, "http://example.com/railml", track), []wantViolation{
+			{1, 1, "the root element railml isn't declared by the schema, it must be in the namespace http://www.railml.org/schemas/2016"},
+		}},
+		{"a track without an id", doc(` version="2.2"`, "http://www.railml.org/schemas/2013", `<track/>`), []wantViolation{
+			{1, 82, "track misses the required attribute id"},
+		}},
+	}
+	for _, tt := range tests {
+		vs, err := ValidateRailML(strings.NewReader(tt.doc))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		checkViolations(t, tt.name, vs, tt.want)
+	}
+
+	for _, v := range []string{"2.1", "2.4", "3.1"} {
+		_, err := ValidateRailML(strings.NewReader(doc(` version="`+v+`"`, "http://www.railml.org/schemas/2013", track)))
+		if e, ok := err.(*UnsupportedVersionError); !ok || e.Version != v {
+			t.Errorf("railML %s: %v, want an UnsupportedVersionError", v, err)
+		}
+	}
+	if _, err := ValidateRailML(strings.NewReader(`<railml version="2.2"><infrastructure>`)); err == nil {
+		t.Error("validated a file that ends early")
+	} else if _, ok := err.(*xml.SyntaxError); !ok {
+		t.Errorf("a file that ends early: %v, want an *xml.SyntaxError", err)
+	}
+}
+
+// TestValidateRailMLSamples validates the railML files in testdata/samples/<version>/, which must be valid, against the
+// railML.org schemas in GOSAFE_TEST_SCHEMA_DIR. Neither the schemas nor the samples are part of the tree, see
+// the README, the test is skipped without them.
+func TestValidateRailMLSamples(t *testing.T) {
+	dir := os.Getenv("GOSAFE_TEST_SCHEMA_DIR")
+	if dir == "" {
+		t.Skip("GOSAFE_TEST_SCHEMA_DIR isn't set to the railML.org schemas")
+	}
+	unload(t)
+	if err := Load(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	found := 0
+	for _, v := range Versions() {
+		files, err := filepath.Glob(filepath.Join("testdata", "samples", v, "*.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			found++
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vs, err := ValidateRailML(f)
+			f.Close()
+			if err != nil {
+				t.Errorf("%s: %v", file, err)
+			} else if len(vs) > 0 {
+				t.Errorf("%s isn't valid railML %s:\n%s", file, v, violationList(vs))
+			}
+		}
+	}
+	if found == 0 {
+		t.Skip("there are no railML samples in testdata/samples")
+	}
+}
@@ -0,0 +1,434 @@
+package schema
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/beevik/etree"
+)
+
+// simpleType is a built-in type, or a restriction, list or union of other simple types
+type simpleType struct {
+	name     xml.Name
+	base     *simpleType
+	check    func(v string) error // of built-in types
+	preserve bool                 // whitespace isn't collapsed
+
+	// facets
+	enum                 []string
+	patterns             []*regexp.Regexp
+	minIncl, maxIncl     *float64
+	minExcl, maxExcl     *float64
+	length, minLen       int // -1 if not restricted
+	maxLen               int
+	totalDigits, fracDig int
+
+	item    *simpleType   // of a list
+	members []*simpleType // of a union
+}
+
+func newSimpleType(name xml.Name) *simpleType {
+	return &simpleType{name: name, length: -1, minLen: -1, maxLen: -1, totalDigits: -1, fracDig: -1}
+}
+
+var (
+	ncName    = `[\pL_][\pL\pN_.\-\x{B7}]*`
+	reNCName  = regexp.MustCompile(`^` + ncName + `$`)
+	reQName   = regexp.MustCompile(`^(` + ncName + `:)?` + ncName + `$`)
+	reNmtoken = regexp.MustCompile(`^[\pL\pN_.\-:\x{B7}]+$`)
+	reName    = regexp.MustCompile(`^[\pL_:][\pL\pN_.\-:\x{B7}]*$`)
+	reDecimal = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+	reDouble  = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?$`)
+	reInteger = regexp.MustCompile(`^[+-]?\d+$`)
+	reLang    = regexp.MustCompile(`^[a-zA-Z]{1,8}(-[a-zA-Z0-9]{1,8})*$`)
+	reDate    = regexp.MustCompile(`^-?\d{4,}-\d{2}-\d{2}(Z|[+-]\d{2}:\d{2})?$`)
+	reTime    = regexp.MustCompile(`^\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})?$`)
+	reDT      = regexp.MustCompile(`^-?\d{4,}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})?$`)
+	reDur     = regexp.MustCompile(`^-?P((\d+Y)?(\d+M)?(\d+D)?)(T(\d+H)?(\d+M)?(\d+(\.\d+)?S)?)?$`)
+	reGYear   = regexp.MustCompile(`^-?\d{4,}(Z|[+-]\d{2}:\d{2})?$`)
+	reHex     = regexp.MustCompile(`^([0-9a-fA-F]{2})*$`)
+	reBase64  = regexp.MustCompile(`^[A-Za-z0-9+/ ]*=? ?=?$`)
+)
+
+// builtins are the XSD built-in simple types, by local name
+var builtins = map[string]*simpleType{}
+
+func init() {
+	match := func(re *regexp.Regexp, what string) func(string) error {
+		return func(v string) error {
+			if !re.MatchString(v) {
+				return fmt.Errorf("%q is not %s", v, what)
+			}
+			return nil
+		}
+	}
+	integer := func(min, max float64) func(string) error {
+		return func(v string) error {
+			if !reInteger.MatchString(v) {
+				return fmt.Errorf("%q is not an integer", v)
+			}
+			f, _ := strconv.ParseFloat(v, 64)
+			if f < min || f > max {
+				return fmt.Errorf("%s is out of range", v)
+			}
+			return nil
+		}
+	}
+	float := func(v string) error {
+		if v != "INF" && v != "-INF" && v != "NaN" && !reDouble.MatchString(v) {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		return nil
+	}
+	inf := math.Inf(1)
+
+	checks := map[string]func(string) error{
+		"anySimpleType":      nil,
+		"string":             nil,
+		"normalizedString":   nil,
+		"token":              nil,
+		"anyURI":             nil,
+		"boolean":            match(regexp.MustCompile(`^(true|false|1|0)$`), "a boolean"),
+		"decimal":            match(reDecimal, "a decimal number"),
+		"double":             float,
+		"float":              float,
+		"integer":            integer(-inf, inf),
+		"long":               integer(math.MinInt64, math.MaxInt64),
+		"int":                integer(math.MinInt32, math.MaxInt32),
+		"short":              integer(math.MinInt16, math.MaxInt16),
+		"byte":               integer(math.MinInt8, math.MaxInt8),
+		"nonNegativeInteger": integer(0, inf),
+		"positiveInteger":    integer(1, inf),
+		"nonPositiveInteger": integer(-inf, 0),
+		"negativeInteger":    integer(-inf, -1),
+		"unsignedLong":       integer(0, math.MaxUint64),
+		"unsignedInt":        integer(0, math.MaxUint32),
+		"unsignedShort":      integer(0, math.MaxUint16),
+		"unsignedByte":       integer(0, math.MaxUint8),
+		"date":               match(reDate, "a date"),
+		"time":               match(reTime, "a time"),
+		"dateTime":           match(reDT, "a date and time"),
+		"duration":           match(reDur, "a duration"),
+		"gYear":              match(reGYear, "a year"),
+		"language":           match(reLang, "a language tag"),
+		"Name":               match(reName, "an XML name"),
+		"NCName":             match(reNCName, "an XML name without a colon"),
+		"ID":                 match(reNCName, "a valid id"),
+		"IDREF":              match(reNCName, "a valid id reference"),
+		"ENTITY":             match(reNCName, "an entity name"),
+		"NMTOKEN":            match(reNmtoken, "a name token"),
+		"QName":              match(reQName, "a qualified name"),
+		"hexBinary":          match(reHex, "hex encoded binary data"),
+		"base64Binary":       match(reBase64, "base64 encoded binary data"),
+	}
+	for n, check := range checks {
+		t := newSimpleType(xml.Name{Space: xsdNS, Local: n})
+		t.check = check
+		t.preserve = n == "string" || n == "anySimpleType"
+		builtins[n] = t
+	}
+	for n, item := range map[string]string{"IDREFS": "IDREF", "NMTOKENS": "NMTOKEN", "ENTITIES": "ENTITY"} {
+		t := newSimpleType(xml.Name{Space: xsdNS, Local: n})
+		t.item, t.minLen = builtins[item], 1
+		builtins[n] = t
+	}
+}
+
+// simpleType returns the named simple type, built-in or declared
+func (c *compiler) simpleType(name xml.Name) (*simpleType, error) {
+	if name.Space == xsdNS {
+		if t, ok := builtins[name.Local]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("built-in type %s isn't supported", name.Local)
+	}
+	if t, ok := c.s.simpleTypes[name]; ok {
+		return t, nil
+	}
+	raw, ok := c.rawSimpleTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("simple type %s isn't declared", name.Local)
+	}
+	return c.compileSimpleType(raw.e, raw.doc, name)
+}
+
+func (c *compiler) compileSimpleType(e *etree.Element, d *document, name xml.Name) (*simpleType, error) {
+	for _, ch := range e.ChildElements() {
+		if ch.NamespaceURI() != xsdNS {
+			continue
+		}
+		switch ch.Tag {
+		case "restriction":
+			return c.compileRestriction(ch, d, name, nil)
+		case "list":
+			t := newSimpleType(name)
+			if name.Local != "" {
+				c.s.simpleTypes[name] = t
+			}
+			var err error
+			t.item, err = c.memberType(ch, d, ch.SelectAttrValue("itemType", ""))
+			return t, err
+		case "union":
+			t := newSimpleType(name)
+			if name.Local != "" {
+				c.s.simpleTypes[name] = t
+			}
+			for _, m := range strings.Fields(ch.SelectAttrValue("memberTypes", "")) {
+				mt, err := c.simpleType(qname(ch, d, m))
+				if err != nil {
+					return nil, err
+				}
+				t.members = append(t.members, mt)
+			}
+			for _, st := range ch.ChildElements() {
+				if !isXSD(st, "simpleType") {
+					continue
+				}
+				mt, err := c.compileSimpleType(st, d, xml.Name{})
+				if err != nil {
+					return nil, err
+				}
+				t.members = append(t.members, mt)
+			}
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("simple type %s has no restriction, list or union", name.Local)
+}
+
+// memberType returns the type named by the attribute, or the anonymous simple type inside e
+func (c *compiler) memberType(e *etree.Element, d *document, attr string) (*simpleType, error) {
+	if attr != "" {
+		return c.simpleType(qname(e, d, attr))
+	}
+	for _, ch := range e.ChildElements() {
+		if isXSD(ch, "simpleType") {
+			return c.compileSimpleType(ch, d, xml.Name{})
+		}
+	}
+	return builtins["anySimpleType"], nil
+}
+
+// compileRestriction compiles the facets of a restriction. base is the type restricted if it isn't named by the
+// restriction itself, which is the case for restrictions of simple content.
+func (c *compiler) compileRestriction(e *etree.Element, d *document, name xml.Name, base *simpleType) (*simpleType, error) {
+	t := newSimpleType(name)
+	if name.Local != "" {
+		c.s.simpleTypes[name] = t
+	}
+	if b := e.SelectAttrValue("base", ""); b != "" && base == nil {
+		var err error
+		if base, err = c.simpleType(qname(e, d, b)); err != nil {
+			return nil, err
+		}
+	}
+	for _, ch := range e.ChildElements() {
+		if isXSD(ch, "simpleType") {
+			var err error
+			if base, err = c.compileSimpleType(ch, d, xml.Name{}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if base == nil {
+		base = builtins["anySimpleType"]
+	}
+	t.base = base
+	t.preserve = base.preserve
+
+	number := func(v string) (*float64, error) {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("simple type %s: invalid facet value %q", name.Local, v)
+		}
+		return &f, nil
+	}
+	var err error
+	for _, f := range e.ChildElements() {
+		if f.NamespaceURI() != xsdNS {
+			continue
+		}
+		v := f.SelectAttrValue("value", "")
+		switch f.Tag {
+		case "enumeration":
+			t.enum = append(t.enum, v)
+		case "pattern":
+			re, perr := regexp.Compile("^(?:" + translatePattern(v) + ")$")
+			if perr != nil {
+				return nil, fmt.Errorf("simple type %s: unsupported pattern %q", name.Local, v)
+			}
+			t.patterns = append(t.patterns, re)
+		case "minInclusive":
+			t.minIncl, err = number(v)
+		case "maxInclusive":
+			t.maxIncl, err = number(v)
+		case "minExclusive":
+			t.minExcl, err = number(v)
+		case "maxExclusive":
+			t.maxExcl, err = number(v)
+		case "length":
+			t.length, err = strconv.Atoi(v)
+		case "minLength":
+			t.minLen, err = strconv.Atoi(v)
+		case "maxLength":
+			t.maxLen, err = strconv.Atoi(v)
+		case "totalDigits":
+			t.totalDigits, err = strconv.Atoi(v)
+		case "fractionDigits":
+			t.fracDig, err = strconv.Atoi(v)
+		case "whiteSpace":
+			t.preserve = v == "preserve"
+		}
+		if err != nil {
+			return nil, fmt.Errorf("simple type %s: invalid %s %q", name.Local, f.Tag, v)
+		}
+	}
+	return t, nil
+}
+
+// translatePattern turns an XSD regular expression into an RE2 one. They mostly agree, apart from the
+// multi-character escapes for XML names, which are approximated with Unicode classes.
+func translatePattern(p string) string {
+	r := strings.NewReplacer(
+		`\i`, `[\pL_:]`, `\I`, `[^\pL_:]`,
+		`\c`, `[\pL\pN_.\-:]`, `\C`, `[^\pL\pN_.\-:]`,
+	)
+	return r.Replace(p)
+}
+
+// validate checks a value against the type, returning why it isn't valid
+func (t *simpleType) validate(v string) error {
+	if !t.preserve {
+		v = strings.Join(strings.Fields(v), " ")
+	}
+	if t.base != nil {
+		if err := t.base.validate(v); err != nil {
+			return err
+		}
+	}
+	if t.check != nil {
+		if err := t.check(v); err != nil {
+			return err
+		}
+	}
+
+	n := utf8.RuneCountInString(v)
+	if t.item != nil {
+		for _, i := range strings.Fields(v) {
+			if err := t.item.validate(i); err != nil {
+				return err
+			}
+		}
+	}
+	if t.isList() { // the length facets of lists count their items
+		n = len(strings.Fields(v))
+	}
+	if len(t.members) > 0 {
+		var first error
+		for _, m := range t.members {
+			err := m.validate(v)
+			if err == nil {
+				first = nil
+				break
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		if first != nil {
+			return first
+		}
+	}
+
+	if len(t.enum) > 0 {
+		found := false
+		for _, e := range t.enum {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%q is not one of %s", v, strings.Join(t.enum, ", "))
+		}
+	}
+	for _, re := range t.patterns {
+		if !re.MatchString(v) {
+			return fmt.Errorf("%q doesn't match the pattern %s", v, strings.TrimSuffix(strings.TrimPrefix(re.String(), "^(?:"), ")$"))
+		}
+	}
+	if t.length >= 0 && n != t.length {
+		return fmt.Errorf("%q must have a length of %d", v, t.length)
+	}
+	if t.minLen >= 0 && n < t.minLen {
+		return fmt.Errorf("%q must have a length of at least %d", v, t.minLen)
+	}
+	if t.maxLen >= 0 && n > t.maxLen {
+		return fmt.Errorf("%q must have a length of at most %d", v, t.maxLen)
+	}
+
+	if t.minIncl != nil || t.maxIncl != nil || t.minExcl != nil || t.maxExcl != nil {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		switch {
+		case t.minIncl != nil && f < *t.minIncl:
+			return fmt.Errorf("%s is less than %v", v, *t.minIncl)
+		case t.maxIncl != nil && f > *t.maxIncl:
+			return fmt.Errorf("%s is greater than %v", v, *t.maxIncl)
+		case t.minExcl != nil && f <= *t.minExcl:
+			return fmt.Errorf("%s must be greater than %v", v, *t.minExcl)
+		case t.maxExcl != nil && f >= *t.maxExcl:
+			return fmt.Errorf("%s must be less than %v", v, *t.maxExcl)
+		}
+	}
+	if t.totalDigits >= 0 || t.fracDig >= 0 {
+		digits := strings.TrimLeft(v, "+-")
+		frac := ""
+		if i := strings.IndexByte(digits, '.'); i >= 0 {
+			digits, frac = digits[:i], strings.TrimRight(digits[i+1:], "0")
+		}
+		digits = strings.TrimLeft(digits, "0")
+		if t.fracDig >= 0 && len(frac) > t.fracDig {
+			return fmt.Errorf("%s must have at most %d fraction digits", v, t.fracDig)
+		}
+		if t.totalDigits >= 0 && len(digits)+len(frac) > t.totalDigits {
+			return fmt.Errorf("%s must have at most %d digits", v, t.totalDigits)
+		}
+	}
+	return nil
+}
+
+func (t *simpleType) isList() bool {
+	for ; t != nil; t = t.base {
+		if t.item != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isID and isIDRef tell whether values of the type are ids or references to them
+func (t *simpleType) isID() bool {
+	for ; t != nil; t = t.base {
+		if t.name == (xml.Name{Space: xsdNS, Local: "ID"}) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *simpleType) isIDRef() bool {
+	for ; t != nil; t = t.base {
+		if t.name == (xml.Name{Space: xsdNS, Local: "IDREF"}) || t.name == (xml.Name{Space: xsdNS, Local: "IDREFS"}) {
+			return true
+		}
+	}
+	return false
+}
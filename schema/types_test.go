@@ -0,0 +1,178 @@
+package schema
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+// typesSchema declares an element of every simple type the tests check
+const typesSchema = `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:t="urn:t" targetNamespace="urn:t">
+	<xs:element name="direction">
+		<xs:simpleType>
+			<xs:restriction base="xs:token">
+				<xs:enumeration value="up"/>
+				<xs:enumeration value="down"/>
+			</xs:restriction>
+		</xs:simpleType>
+	</xs:element>
+	<xs:element name="percent" type="t:tPercent"/>
+	<xs:element name="positive">
+		<xs:simpleType>
+			<xs:restriction base="xs:decimal">
+				<xs:minExclusive value="0"/>
+				<xs:totalDigits value="5"/>
+				<xs:fractionDigits value="2"/>
+			</xs:restriction>
+		</xs:simpleType>
+	</xs:element>
+	<xs:element name="code">
+		<xs:simpleType>
+			<xs:restriction base="xs:token">
+				<xs:pattern value="\i\c*"/>
+				<xs:maxLength value="5"/>
+			</xs:restriction>
+		</xs:simpleType>
+	</xs:element>
+	<xs:element name="coordinates" type="t:tCoordinates"/>
+	<xs:element name="speed">
+		<xs:simpleType>
+			<xs:union memberTypes="t:tPercent">
+				<xs:simpleType>
+					<xs:restriction base="xs:string">
+						<xs:enumeration value="unknown"/>
+					</xs:restriction>
+				</xs:simpleType>
+			</xs:union>
+		</xs:simpleType>
+	</xs:element>
+
+	<xs:simpleType name="tPercent">
+		<xs:restriction base="xs:integer">
+			<xs:minInclusive value="0"/>
+			<xs:maxInclusive value="100"/>
+		</xs:restriction>
+	</xs:simpleType>
+	<xs:simpleType name="tCoordinates">
+		<xs:restriction>
+			<xs:simpleType>
+				<xs:list itemType="xs:double"/>
+			</xs:simpleType>
+			<xs:length value="2"/>
+		</xs:restriction>
+	</xs:simpleType>
+</xs:schema>`
+
+func TestSimpleTypes(t *testing.T) {
+	s, err := Compile(map[string]string{"types.xsd": typesSchema}, "types.xsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		element string
+		value   string
+		valid   bool
+	}{
+		{"direction", "up", true},
+		{"direction", " down ", true}, // whitespace is collapsed, but in xs:strings
+		{"direction", "left", false},
+		{"percent", "100", true},
+		{"percent", "0", true},
+		{"percent", "101", false},
+		{"percent", "-1", false},
+		{"percent", "5.5", false},
+		{"positive", "0.01", true},
+		{"positive", "123.45", true},
+		{"positive", "0", false},
+		{"positive", "1.234", false},
+		{"positive", "1234.5", true},
+		{"positive", "12345.6", false},
+		{"positive", "1.50", true}, // trailing zeros aren't fraction digits
+		{"code", "sw_1", true},
+		{"code", "1sw", false},
+		{"code", "switch", false},
+		{"coordinates", "19.0 50.0", true},
+		{"coordinates", " 19.0\n 50.0 ", true},
+		{"coordinates", "19.0", false},
+		{"coordinates", "19.0 north", false},
+		{"speed", "80", true},
+		{"speed", "unknown", true},
+		{"speed", "fast", false},
+	}
+	for _, tt := range tests {
+		ed := s.elements[xml.Name{Space: "urn:t", Local: tt.element}]
+		if ed == nil || ed.simple == nil {
+			t.Fatalf("%s has no simple type", tt.element)
+		}
+		if err := ed.simple.validate(tt.value); (err == nil) != tt.valid {
+			t.Errorf("%s %q: %v, want valid %v", tt.element, tt.value, err, tt.valid)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []struct {
+		typ   string
+		value string
+		valid bool
+	}{
+		{"boolean", "true", true},
+		{"boolean", "0", true},
+		{"boolean", "yes", false},
+		{"decimal", "-.5", true},
+		{"decimal", "1e3", false},
+		{"double", "1e3", true},
+		{"double", "INF", true},
+		{"double", "infinity", false},
+		{"int", "2147483647", true},
+		{"int", "2147483648", false},
+		{"unsignedByte", "255", true},
+		{"unsignedByte", "256", false},
+		{"nonNegativeInteger", "-1", false},
+		{"date", "2013-05-01", true},
+		{"date", "2013-05-01Z", true},
+		{"date", "01.05.2013", false},
+		{"dateTime", "2013-05-01T10:00:00+02:00", true},
+		{"dateTime", "2013-05-01 10:00:00", false},
+		{"duration", "PT1H30M", true},
+		{"duration", "1h30m", false},
+		{"language", "pl", true},
+		{"language", "de-CH", true},
+		{"language", "polish language", false},
+		{"ID", "tr1", true},
+		{"ID", "1tr", false},
+		{"ID", "tr:1", false},
+		{"IDREFS", "tr1 tr2", true},
+		{"IDREFS", "", false},
+		{"QName", "rail:track", true},
+		{"NMTOKEN", "a:b-1", true},
+		{"NMTOKEN", "a b", false},
+		{"hexBinary", "0aFF", true},
+		{"hexBinary", "0aF", false},
+		{"string", " kept as it is ", true},
+	}
+	for _, tt := range tests {
+		if err := builtins[tt.typ].validate(tt.value); (err == nil) != tt.valid {
+			t.Errorf("xs:%s %q: %v, want valid %v", tt.typ, tt.value, err, tt.valid)
+		}
+	}
+}
+
+func TestIDTypes(t *testing.T) {
+	s, err := Compile(map[string]string{"main.xsd": `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:t="urn:t" targetNamespace="urn:t">
+		<xs:element name="id" type="t:tId"/>
+		<xs:element name="refs" type="t:tRefs"/>
+		<xs:simpleType name="tId"><xs:restriction base="xs:ID"/></xs:simpleType>
+		<xs:simpleType name="tRefs"><xs:list itemType="xs:IDREF"/></xs:simpleType>
+	</xs:schema>`}, "main.xsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := s.elements[xml.Name{Space: "urn:t", Local: "id"}].simple
+	refs := s.elements[xml.Name{Space: "urn:t", Local: "refs"}].simple
+	if !id.isID() || id.isIDRef() {
+		t.Error("a restriction of xs:ID isn't an id")
+	}
+	if !refs.isList() || !refs.item.isIDRef() {
+		t.Error("a list of xs:IDREF isn't a list of references")
+	}
+}
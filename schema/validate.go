@@ -0,0 +1,455 @@
+package schema
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Violation is a part of a document that doesn't conform to the schema
+type Violation struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Element string `json:"element,omitempty"` // path of the element, as in the errors of an import
+	Detail  string `json:"detail"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("line %d, column %d: %s: %s", v.Line, v.Column, v.Element, v.Detail)
+}
+
+// MaxViolations is the number of violations after which a validation gives up
+const MaxViolations = 1000
+
+// state is a state of the automaton a content model is compiled to. It is left either by reading an element
+// the declaration or wildcard of which matches, to next, or without reading anything, to any of eps.
+type state struct {
+	elem *elementDecl
+	any  *wildcard
+	next *state
+	eps  []*state
+}
+
+// maxUnroll bounds the copies made of a particle with a maxOccurs other than unbounded,
+// larger ones are treated as unbounded
+const maxUnroll = 50
+
+// compileContent compiles a content model into an automaton with a start and an accepting end state
+func compileContent(p *particle) (*state, *state) {
+	if p == nil {
+		s := &state{}
+		return s, s
+	}
+	start := &state{}
+	end := start
+	for i := 0; i < p.min && i < maxUnroll; i++ {
+		s, e := compileTerm(p)
+		end.eps = append(end.eps, s)
+		end = e
+	}
+	switch {
+	case p.max < 0 || p.max > maxUnroll:
+		s, e := compileTerm(p)
+		loop := &state{}
+		end.eps = append(end.eps, loop)
+		loop.eps = append(loop.eps, s)
+		e.eps = append(e.eps, loop)
+		end = loop
+	default:
+		skip := &state{}
+		for i := p.min; i < p.max; i++ {
+			s, e := compileTerm(p)
+			end.eps = append(end.eps, s, skip)
+			end = e
+		}
+		end.eps = append(end.eps, skip)
+		end = skip
+	}
+	return start, end
+}
+
+// compileTerm compiles a single occurrence of a particle
+func compileTerm(p *particle) (*state, *state) {
+	start, end := &state{}, &state{}
+	switch p.kind {
+	case elementParticle:
+		start.elem, start.next = p.elem, end
+	case anyParticle:
+		start.any, start.next = p.any, end
+	case sequenceParticle:
+		last := start
+		for _, c := range p.children {
+			s, e := compileContent(c)
+			last.eps = append(last.eps, s)
+			last = e
+		}
+		last.eps = append(last.eps, end)
+	case choiceParticle, allParticle:
+		// an all group is checked as a choice that may be repeated, so its elements may come in any order,
+		// but whether each of them occurs the right number of times isn't checked
+		for _, c := range p.children {
+			s, e := compileContent(c)
+			start.eps = append(start.eps, s)
+			e.eps = append(e.eps, end)
+		}
+		if p.kind == allParticle {
+			end.eps = append(end.eps, start)
+			start.eps = append(start.eps, end)
+		}
+	}
+	return start, end
+}
+
+// closure adds the states reachable without reading an element
+func closure(states []*state) []*state {
+	seen := map[*state]bool{}
+	var out []*state
+	var visit func(s *state)
+	visit = func(s *state) {
+		if seen[s] {
+			return
+		}
+		seen[s] = true
+		out = append(out, s)
+		for _, e := range s.eps {
+			visit(e)
+		}
+	}
+	for _, s := range states {
+		visit(s)
+	}
+	return out
+}
+
+// frame is an element being validated
+type frame struct {
+	name     xml.Name
+	path     string
+	decl     *elementDecl // nil if the content isn't checked
+	states   []*state     // where the content model of a complex type is at
+	text     bytes.Buffer
+	line     int
+	column   int
+	skipping bool // of elements matched by a skip wildcard or lax one without declaration
+}
+
+// validator checks a document token by token
+type validator struct {
+	s          *Schema
+	violations []Violation
+	ids        map[string]bool
+	refs       []idRef
+}
+
+type idRef struct {
+	id           string
+	line, column int
+	path         string
+}
+
+// Validate checks the document read from r against the schema. Violations are returned in document order,
+// at most MaxViolations of them. Malformed XML fails with an *xml.SyntaxError.
+func (s *Schema) Validate(r io.Reader) ([]Violation, error) {
+	pr := &positionReader{r: bufio.NewReader(r), line: 1, column: 1}
+	dec := xml.NewDecoder(pr)
+	v := &validator{s: s, ids: map[string]bool{}}
+	var stack []*frame
+
+	for len(v.violations) < MaxViolations {
+		// the decoder reads no further than the start of the next token, apart from a '<' it may have looked at
+		line, column := pr.line, pr.column-int(pr.read-dec.InputOffset())
+		t, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*xml.SyntaxError); !ok {
+				err = &xml.SyntaxError{Msg: err.Error(), Line: line}
+			}
+			return v.violations, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			f := &frame{name: t.Name, line: line, column: column, path: "/" + t.Name.Local}
+			if len(stack) == 0 {
+				f.decl = s.elements[t.Name]
+				if f.decl == nil {
+					v.add(f, "the root element %s isn't declared by the schema%s", describe(t.Name), s.namespaceHint(t.Name))
+					f.skipping = true
+				}
+			} else {
+				parent := stack[len(stack)-1]
+				f.path = parent.path + f.path
+				if parent.skipping {
+					f.skipping = true
+				} else {
+					v.child(parent, f)
+				}
+			}
+			if !f.skipping {
+				v.attributes(f, t.Attr)
+			}
+			stack = append(stack, f)
+		case xml.EndElement:
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !f.skipping {
+				v.end(f)
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+
+	for _, r := range v.refs {
+		if len(v.violations) >= MaxViolations { // ids further down weren't read either
+			break
+		}
+		if !v.ids[r.id] {
+			v.violations = append(v.violations, Violation{r.line, r.column, r.path, fmt.Sprintf("there is no element with the id %q", r.id)})
+		}
+	}
+	sort.SliceStable(v.violations, func(i, j int) bool {
+		a, b := v.violations[i], v.violations[j]
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+	return v.violations, nil
+}
+
+func (v *validator) add(f *frame, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{f.line, f.column, f.path, fmt.Sprintf(format, args...)})
+}
+
+// child moves the content model of the parent on by the child element and finds the declaration of the child
+func (v *validator) child(parent *frame, f *frame) {
+	if parent.decl == nil {
+		f.skipping = true
+		return
+	}
+	if parent.decl.complex == nil {
+		if parent.decl.simple != nil {
+			v.add(f, "%s may only contain text", describe(parent.name))
+		}
+		f.skipping = true // of xs:anyType
+		return
+	}
+
+	var next []*state
+	var match *state
+	for _, s := range parent.states {
+		if (s.elem != nil && s.elem.name == f.name) || (s.any != nil && s.any.allows(f.name.Space)) {
+			next = append(next, s.next)
+			if match == nil {
+				match = s
+			}
+		}
+	}
+	if match == nil {
+		v.add(f, "%s isn't allowed here, expected %s", describe(f.name), expected(parent.states, parent.decl.complex.end))
+		// the element is checked against its global declaration, if there is one, and skipped otherwise
+		f.decl = v.s.elements[f.name]
+		f.skipping = f.decl == nil
+		return
+	}
+	parent.states = closure(next)
+
+	switch {
+	case match.elem != nil:
+		f.decl = match.elem
+	case match.any.process == "skip":
+		f.skipping = true
+	default:
+		f.decl = v.s.elements[f.name]
+		if f.decl == nil {
+			if match.any.process == "strict" {
+				v.add(f, "%s isn't declared by the schema", describe(f.name))
+			}
+			f.skipping = true
+		}
+	}
+}
+
+// attributes checks the attributes of an element and starts its content model
+func (v *validator) attributes(f *frame, attrs []xml.Attr) {
+	ct := f.decl.complex
+	if ct != nil {
+		f.states = closure([]*state{ct.start})
+	}
+	seen := map[xml.Name]bool{}
+	for _, a := range attrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") || a.Name.Space == xsiNS {
+			continue
+		}
+		seen[a.Name] = true
+		var u *attrUse
+		if ct != nil {
+			u = ct.attrs[a.Name]
+		}
+		if u == nil {
+			if ct != nil && ct.anyAttr != nil && ct.anyAttr.allows(a.Name.Space) {
+				if ad := v.s.attributes[a.Name]; ad != nil && ct.anyAttr.process != "skip" {
+					v.value(f, "attribute "+describe(a.Name), ad.typ, a.Value)
+				}
+				continue
+			}
+			if f.decl.complex != nil || f.decl.simple != nil {
+				v.add(f, "attribute %s isn't allowed on %s", describe(a.Name), describe(f.name))
+			}
+			continue
+		}
+		v.value(f, "attribute "+describe(a.Name), u.typ, a.Value)
+	}
+	if ct == nil {
+		return
+	}
+	var missing []string
+	for name, u := range ct.attrs {
+		if u.required && !seen[name] {
+			missing = append(missing, describe(name))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		v.add(f, "%s misses the required attribute %s", describe(f.name), strings.Join(missing, ", "))
+	}
+}
+
+// value checks the value of an attribute or of simple content and records ids and references to them
+func (v *validator) value(f *frame, what string, t *simpleType, value string) {
+	if err := t.validate(value); err != nil {
+		v.add(f, "%s: %v", what, err)
+		return
+	}
+	switch {
+	case t.isID():
+		id := strings.TrimSpace(value)
+		if v.ids[id] {
+			v.add(f, "%s: the id %q is used more than once", what, id)
+		}
+		v.ids[id] = true
+	case t.isIDRef() || (t.item != nil && t.item.isIDRef()):
+		for _, id := range strings.Fields(value) {
+			v.refs = append(v.refs, idRef{id, f.line, f.column, f.path})
+		}
+	}
+}
+
+// end checks the text of an element and whether its content is complete
+func (v *validator) end(f *frame) {
+	ct := f.decl.complex
+	text := f.text.String()
+	switch {
+	case ct == nil && f.decl.simple != nil:
+		v.value(f, "the content of "+describe(f.name), f.decl.simple, text)
+	case ct == nil: // xs:anyType
+	case ct.simple != nil:
+		v.value(f, "the content of "+describe(f.name), ct.simple, text)
+	default:
+		if !ct.mixed && strings.TrimSpace(text) != "" {
+			v.add(f, "%s may not contain text", describe(f.name))
+		}
+		accepted := false
+		for _, s := range f.states {
+			if s == ct.end {
+				accepted = true
+			}
+		}
+		if !accepted {
+			v.add(f, "%s is incomplete, expected %s", describe(f.name), expected(f.states, ct.end))
+		}
+	}
+}
+
+// expected lists the elements that may come next
+func expected(states []*state, accept *state) string {
+	var names []string
+	seen := map[string]bool{}
+	add := func(n string) {
+		if !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	end := false
+	for _, s := range states {
+		switch {
+		case s.elem != nil:
+			add(describe(s.elem.name))
+		case s.any != nil:
+			add("an element of another namespace")
+		case s == accept:
+			end = true
+		}
+	}
+	if end {
+		add("the end of the element")
+	}
+	if len(names) == 0 {
+		return "nothing"
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return "one of " + strings.Join(names, ", ")
+}
+
+// namespaceHint tells the namespace an undeclared element should be in, if the schema declares it in another one
+func (s *Schema) namespaceHint(n xml.Name) string {
+	for name := range s.elements {
+		if name.Local == n.Local {
+			return ", it must be in the namespace " + name.Space
+		}
+	}
+	return ""
+}
+
+// describe names an element or attribute without its namespace, which the document most likely declares as the
+// default one anyway, unless it belongs to none or the xml namespace
+func describe(n xml.Name) string {
+	if n.Space == xmlNS {
+		return "xml:" + n.Local
+	}
+	return n.Local
+}
+
+// positionReader counts the lines and columns the XML decoder has read. Being an io.ByteReader,
+// the decoder reads from it byte by byte instead of through a buffer of its own.
+type positionReader struct {
+	r            *bufio.Reader
+	read         int64
+	line, column int
+}
+
+func (pr *positionReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	for _, b := range p[:n] {
+		pr.count(b)
+	}
+	return n, err
+}
+
+func (pr *positionReader) ReadByte() (byte, error) {
+	b, err := pr.r.ReadByte()
+	if err == nil {
+		pr.count(b)
+	}
+	return b, err
+}
+
+func (pr *positionReader) count(b byte) {
+	pr.read++
+	switch {
+	case b == '\n':
+		pr.line++
+		pr.column = 1
+	case b&0xC0 != 0x80: // continuation bytes of UTF-8 don't start a column
+		pr.column++
+	}
+}
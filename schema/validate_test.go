@@ -0,0 +1,150 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// wantViolation is a violation expected at a line and column, with a detail that contains the given text
+type wantViolation struct {
+	line, column int
+	detail       string
+}
+
+func checkViolations(t *testing.T, name string, got []Violation, want []wantViolation) {
+	if len(got) != len(want) {
+		t.Errorf("%s: %d violations, want %d:\n%s", name, len(got), len(want), violationList(got))
+		return
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Line != w.line || g.Column != w.column || !strings.Contains(g.Detail, w.detail) {
+			t.Errorf("%s: violation %d is %s, want line %d, column %d: %s", name, i, g, w.line, w.column, w.detail)
+		}
+	}
+}
+
+func violationList(vs []Violation) string {
+	var lines []string
+	for _, v := range vs {
+		lines = append(lines, v.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestValidate(t *testing.T) {
+	s := compileTestSchema(t)
+	tests := []struct {
+		name string
+		doc  string
+		want []wantViolation
+	}{
+		{"valid", `<doc xmlns="urn:t" xmlns:x="urn:x" version="1">
+	<head>AB1</head>
+	<item id="i1" length="3"><ref to="i2"/></item>
+	<item id="i2" name="second" x:color="red"/>
+	<b id="b1"/>
+	<tail unit="m">12.5</tail>
+	<x:extension><anything/></x:extension>
+</doc>`, nil},
+		{"content model", `<doc xmlns="urn:t" version="1">
+	<item id="i1"/>
+	<head>AB1</head>
+	<a>text</a><b id="b1"/>
+</doc>`, []wantViolation{
+			{2, 2, "item isn't allowed here, expected head"},
+			{4, 13, "b isn't allowed here"},
+		}},
+		{"too many", `<doc xmlns="urn:t" version="1"><head>AB1</head>
+<item id="i1"/><item id="i2"/><item id="i3"/><item id="i4"/>
+</doc>`, []wantViolation{
+			{2, 46, "item isn't allowed here"},
+		}},
+		{"incomplete", `<doc xmlns="urn:t" version="1">
+</doc>`, []wantViolation{
+			{1, 1, "doc is incomplete, expected head"},
+		}},
+		{"attributes", `<doc xmlns="urn:t" version="1"><head>AB1</head>
+  <item
+      id="i1"
+      length="-3"
+      color="red"/>
+  <b name="b"/>
+</doc>`, []wantViolation{
+			{2, 3, "attribute length: -3 is out of range"},
+			{2, 3, "attribute color isn't allowed on item"},
+			{6, 3, "b misses the required attribute id"},
+		}},
+		{"values", `<doc xmlns="urn:t" version="1">
+	<head>ab1</head>
+	<tail>12,5</tail>
+</doc>`, []wantViolation{
+			{2, 2, `the content of head: "ab1" doesn't match the pattern`},
+			{3, 2, `the content of tail: "12,5" is not a decimal number`},
+		}},
+		{"ids", `<doc xmlns="urn:t" version="1"><head>AB1</head>
+	<item id="i1"><ref to="b9"/></item>
+	<item id="i1"/>
+</doc>`, []wantViolation{
+			{2, 16, `there is no element with the id "b9"`},
+			{3, 2, `the id "i1" is used more than once`},
+		}},
+		{"text", `<doc xmlns="urn:t" version="1"><head>AB1</head>
+	<b id="b1">text</b>
+</doc>`, []wantViolation{
+			{2, 2, "b may not contain text"},
+		}},
+		{"unicode", `<doc xmlns="urn:t" version="1"><head>AB1</head>
+	<!-- zażółć gęślą jaźń --><b id="b1" name="Łódź"/><b id="b2"/>
+</doc>`, []wantViolation{
+			{2, 52, "b isn't allowed here"}, // a column is a character, not a byte
+		}},
+		{"root", `<doc version="1"><head>AB1</head></doc>`, []wantViolation{
+			{1, 1, "the root element doc isn't declared by the schema, it must be in the namespace urn:t"},
+		}},
+		{"missing version", `<?xml version="1.0" encoding="UTF-8"?>
+<doc xmlns="urn:t"><head>AB1</head></doc>`, []wantViolation{
+			{2, 1, "doc misses the required attribute version"},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := s.Validate(strings.NewReader(tt.doc))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		checkViolations(t, tt.name, got, tt.want)
+	}
+}
+
+func TestValidateSyntaxError(t *testing.T) {
+	s := compileTestSchema(t)
+	_, err := s.Validate(strings.NewReader("<doc xmlns=\"urn:t\" version=\"1\">\n<head>AB1</head>\n<item id=\"i1\">\n</doc>"))
+	se, ok := err.(*xml.SyntaxError)
+	if !ok {
+		t.Fatalf("got %v, want an *xml.SyntaxError", err)
+	}
+	if se.Line != 4 {
+		t.Errorf("the syntax error is on line %d, want 4", se.Line)
+	}
+}
+
+func TestValidateMaxViolations(t *testing.T) {
+	s := compileTestSchema(t)
+	var doc bytes.Buffer
+	doc.WriteString(`<doc xmlns="urn:t" version="1"><head>AB1</head>`)
+	for i := 0; i < MaxViolations+10; i++ {
+		fmt.Fprintf(&doc, "<b id=\"b%d\"/>\n", i)
+	}
+	doc.WriteString(`</doc>`)
+	got, err := s.Validate(strings.NewReader(doc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != MaxViolations {
+		t.Errorf("%d violations, want %d", len(got), MaxViolations)
+	}
+}
@@ -0,0 +1,662 @@
+package schema
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	xsdNS = "http://www.w3.org/2001/XMLSchema"
+	xsiNS = "http://www.w3.org/2001/XMLSchema-instance"
+	xmlNS = "http://www.w3.org/XML/1998/namespace"
+)
+
+// Schema is a compiled set of XSD documents. It supports the parts of XSD 1.0 railML uses: global and local
+// elements, named and anonymous complex and simple types, sequences, choices, all groups, element and attribute
+// wildcards, named groups and attribute groups, complex and simple content extensions and restrictions of simple
+// types by facets, lists and unions. Substitution groups, identity constraints, xsi:type and redefine aren't.
+// A compiled Schema is never changed, so it can validate any number of documents at the same time.
+type Schema struct {
+	elements     map[xml.Name]*elementDecl
+	complexTypes map[xml.Name]*complexType
+	simpleTypes  map[xml.Name]*simpleType
+	attributes   map[xml.Name]*attrDecl
+	groups       map[xml.Name]*particle
+	attrGroups   map[xml.Name]*attrGroup
+}
+
+type elementDecl struct {
+	name    xml.Name
+	complex *complexType // either this
+	simple  *simpleType  // or this, if both are nil the element may contain anything
+}
+
+type complexType struct {
+	name       xml.Name
+	attrs      map[xml.Name]*attrUse
+	anyAttr    *wildcard
+	content    *particle   // nil if the type has no child elements
+	simple     *simpleType // of simple content
+	mixed      bool
+	start, end *state // the content model as an automaton, see compileContent
+}
+
+type attrDecl struct {
+	name xml.Name
+	typ  *simpleType
+}
+
+type attrUse struct {
+	*attrDecl
+	required bool
+}
+
+type attrGroup struct {
+	attrs   []*attrUse
+	anyAttr *wildcard
+}
+
+// particle kinds
+const (
+	elementParticle = iota
+	sequenceParticle
+	choiceParticle
+	allParticle
+	anyParticle
+)
+
+type particle struct {
+	kind     int
+	min, max int // max is unbounded if it is negative
+	elem     *elementDecl
+	any      *wildcard
+	children []*particle
+}
+
+// wildcard is an xs:any or xs:anyAttribute
+type wildcard struct {
+	any     bool     // ##any
+	other   string   // ##other, the target namespace it excludes
+	list    []string // namespaces, "" for ##local
+	process string   // strict, lax or skip
+}
+
+func (w *wildcard) allows(ns string) bool {
+	if w.any {
+		return true
+	}
+	if w.list == nil {
+		return ns != "" && ns != w.other
+	}
+	for _, l := range w.list {
+		if l == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// compiler turns the documents of a schema into a Schema. Declarations are compiled when they are first referred to,
+// and registered before their content is, so that recursive ones end up pointing at each other.
+type compiler struct {
+	s     *Schema
+	files map[string]string
+	read  map[string]bool
+
+	// top-level declarations of all documents, by name
+	rawElements     map[xml.Name]*decl
+	rawComplexTypes map[xml.Name]*decl
+	rawSimpleTypes  map[xml.Name]*decl
+	rawAttributes   map[xml.Name]*decl
+	rawGroups       map[xml.Name]*decl
+	rawAttrGroups   map[xml.Name]*decl
+
+	types []*complexType // all compiled complex types, anonymous ones too
+}
+
+// decl is a top-level declaration together with the document it is declared in
+type decl struct {
+	e   *etree.Element
+	doc *document
+}
+
+// document holds the schema attributes local declarations need
+type document struct {
+	tns                      string
+	qualifiedElements        bool
+	qualifiedAttributes      bool
+	defaultNamespaceIncluded bool // chameleon include of a document without a targetNamespace
+}
+
+// Compile compiles the XSD document named root together with the documents it includes and imports.
+// files maps the schemaLocations of all documents to their content.
+func Compile(files map[string]string, root string) (*Schema, error) {
+	c := &compiler{
+		s: &Schema{
+			elements:     map[xml.Name]*elementDecl{},
+			complexTypes: map[xml.Name]*complexType{},
+			simpleTypes:  map[xml.Name]*simpleType{},
+			attributes:   map[xml.Name]*attrDecl{},
+			groups:       map[xml.Name]*particle{},
+			attrGroups:   map[xml.Name]*attrGroup{},
+		},
+		files:           files,
+		read:            map[string]bool{},
+		rawElements:     map[xml.Name]*decl{},
+		rawComplexTypes: map[xml.Name]*decl{},
+		rawSimpleTypes:  map[xml.Name]*decl{},
+		rawAttributes:   map[xml.Name]*decl{},
+		rawGroups:       map[xml.Name]*decl{},
+		rawAttrGroups:   map[xml.Name]*decl{},
+	}
+	if err := c.readDocument(root, ""); err != nil {
+		return nil, err
+	}
+
+	// xml:lang and friends, which schemas refer to without importing the schema of the xml namespace
+	for _, n := range []string{"lang", "space", "base", "id"} {
+		name := xml.Name{Space: xmlNS, Local: n}
+		if _, ok := c.rawAttributes[name]; !ok {
+			c.s.attributes[name] = &attrDecl{name: name, typ: builtins["string"]}
+		}
+	}
+
+	for name := range c.rawElements {
+		if _, err := c.element(name); err != nil {
+			return nil, err
+		}
+	}
+	for name := range c.rawComplexTypes {
+		if _, err := c.complexType(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range c.types {
+		t.start, t.end = compileContent(t.content)
+	}
+	return c.s, nil
+}
+
+// readDocument reads a schema document and the documents it includes and imports. An included document without
+// a targetNamespace takes the one of the including document.
+func (c *compiler) readDocument(location string, chameleon string) error {
+	key := location + "#" + chameleon
+	if c.read[key] {
+		return nil
+	}
+	c.read[key] = true
+
+	text, ok := c.files[location]
+	if !ok {
+		return fmt.Errorf("schema document %s is missing", location)
+	}
+	d := etree.NewDocument()
+	if err := d.ReadFromString(text); err != nil {
+		return fmt.Errorf("schema document %s: %v", location, err)
+	}
+	root := d.Root()
+	if root == nil || !isXSD(root, "schema") {
+		return fmt.Errorf("schema document %s has no xs:schema root", location)
+	}
+	doc := &document{
+		tns:                 root.SelectAttrValue("targetNamespace", ""),
+		qualifiedElements:   root.SelectAttrValue("elementFormDefault", "") == "qualified",
+		qualifiedAttributes: root.SelectAttrValue("attributeFormDefault", "") == "qualified",
+	}
+	if doc.tns == "" && chameleon != "" {
+		doc.tns = chameleon
+		doc.defaultNamespaceIncluded = true
+	}
+
+	for _, e := range root.ChildElements() {
+		if e.NamespaceURI() != xsdNS {
+			continue
+		}
+		name := xml.Name{Space: doc.tns, Local: e.SelectAttrValue("name", "")}
+		var m map[xml.Name]*decl
+		switch e.Tag {
+		case "include":
+			if err := c.readDocument(e.SelectAttrValue("schemaLocation", ""), doc.tns); err != nil {
+				return err
+			}
+		case "import":
+			if loc := e.SelectAttrValue("schemaLocation", ""); loc != "" {
+				if _, ok := c.files[loc]; ok {
+					if err := c.readDocument(loc, ""); err != nil {
+						return err
+					}
+				}
+			}
+		case "redefine", "override":
+			return fmt.Errorf("schema document %s: xs:%s isn't supported", location, e.Tag)
+		case "element":
+			m = c.rawElements
+		case "complexType":
+			m = c.rawComplexTypes
+		case "simpleType":
+			m = c.rawSimpleTypes
+		case "attribute":
+			m = c.rawAttributes
+		case "group":
+			m = c.rawGroups
+		case "attributeGroup":
+			m = c.rawAttrGroups
+		}
+		if m != nil {
+			if _, ok := m[name]; ok {
+				return fmt.Errorf("schema document %s: %s %s is declared twice", location, e.Tag, name.Local)
+			}
+			m[name] = &decl{e, doc}
+		}
+	}
+	return nil
+}
+
+func isXSD(e *etree.Element, tag string) bool {
+	return e.Tag == tag && e.NamespaceURI() == xsdNS
+}
+
+// qname resolves a QName attribute value of e against the namespace declarations in scope
+func qname(e *etree.Element, d *document, v string) xml.Name {
+	prefix, local := "", v
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		prefix, local = v[:i], v[i+1:]
+	}
+	if prefix == "xml" {
+		return xml.Name{Space: xmlNS, Local: local}
+	}
+	for a := e; a != nil; a = a.Parent() {
+		for _, at := range a.Attr {
+			if (prefix == "" && at.Space == "" && at.Key == "xmlns") || (prefix != "" && at.Space == "xmlns" && at.Key == prefix) {
+				if at.Value == "" && d.defaultNamespaceIncluded {
+					return xml.Name{Space: d.tns, Local: local}
+				}
+				return xml.Name{Space: at.Value, Local: local}
+			}
+		}
+	}
+	if d.defaultNamespaceIncluded && prefix == "" {
+		return xml.Name{Space: d.tns, Local: local}
+	}
+	return xml.Name{Local: local}
+}
+
+// element returns the global element declaration with the name
+func (c *compiler) element(name xml.Name) (*elementDecl, error) {
+	if ed, ok := c.s.elements[name]; ok {
+		return ed, nil
+	}
+	raw, ok := c.rawElements[name]
+	if !ok {
+		return nil, fmt.Errorf("element %s isn't declared", name.Local)
+	}
+	ed := &elementDecl{name: name}
+	c.s.elements[name] = ed
+	return ed, c.elementType(ed, raw.e, raw.doc)
+}
+
+// localElement compiles an element declaration inside a content model, or the reference to a global one
+func (c *compiler) localElement(e *etree.Element, d *document) (*elementDecl, error) {
+	if ref := e.SelectAttrValue("ref", ""); ref != "" {
+		return c.element(qname(e, d, ref))
+	}
+	name := xml.Name{Local: e.SelectAttrValue("name", "")}
+	if form := e.SelectAttrValue("form", ""); form == "qualified" || (form == "" && d.qualifiedElements) {
+		name.Space = d.tns
+	}
+	ed := &elementDecl{name: name}
+	return ed, c.elementType(ed, e, d)
+}
+
+// elementType sets the type of an element declaration, from its type attribute or its anonymous type
+func (c *compiler) elementType(ed *elementDecl, e *etree.Element, d *document) error {
+	var err error
+	if t := e.SelectAttrValue("type", ""); t != "" {
+		name := qname(e, d, t)
+		if name.Space == xsdNS && name.Local == "anyType" {
+			return nil
+		}
+		if _, ok := c.rawComplexTypes[name]; ok {
+			ed.complex, err = c.complexType(name)
+		} else {
+			ed.simple, err = c.simpleType(name)
+		}
+		return err
+	}
+	for _, ch := range e.ChildElements() {
+		switch {
+		case isXSD(ch, "complexType"):
+			ed.complex, err = c.anonymousComplexType(ch, d)
+		case isXSD(ch, "simpleType"):
+			ed.simple, err = c.compileSimpleType(ch, d, xml.Name{})
+		}
+	}
+	return err
+}
+
+// complexType returns the named complex type
+func (c *compiler) complexType(name xml.Name) (*complexType, error) {
+	if t, ok := c.s.complexTypes[name]; ok {
+		return t, nil
+	}
+	raw, ok := c.rawComplexTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("complex type %s isn't declared", name.Local)
+	}
+	t := &complexType{name: name, attrs: map[xml.Name]*attrUse{}}
+	c.s.complexTypes[name] = t
+	c.types = append(c.types, t)
+	return t, c.compileComplexType(t, raw.e, raw.doc)
+}
+
+func (c *compiler) anonymousComplexType(e *etree.Element, d *document) (*complexType, error) {
+	t := &complexType{attrs: map[xml.Name]*attrUse{}}
+	c.types = append(c.types, t)
+	return t, c.compileComplexType(t, e, d)
+}
+
+func (c *compiler) compileComplexType(t *complexType, e *etree.Element, d *document) error {
+	t.mixed = e.SelectAttrValue("mixed", "") == "true"
+	for _, ch := range e.ChildElements() {
+		if ch.NamespaceURI() != xsdNS {
+			continue
+		}
+		switch ch.Tag {
+		case "simpleContent", "complexContent":
+			if ch.SelectAttrValue("mixed", "") == "true" {
+				t.mixed = true
+			}
+			for _, der := range ch.ChildElements() {
+				if isXSD(der, "extension") || isXSD(der, "restriction") {
+					if err := c.derive(t, der, d, ch.Tag == "simpleContent"); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			if err := c.contentAndAttributes(t, ch, d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// derive compiles the extension or restriction of a base type. Restrictions of complex content
+// state the whole content model again, so only extensions inherit the content of their base type.
+func (c *compiler) derive(t *complexType, der *etree.Element, d *document, simpleContent bool) error {
+	base := qname(der, d, der.SelectAttrValue("base", ""))
+	extension := der.Tag == "extension"
+
+	if _, ok := c.rawComplexTypes[base]; ok {
+		bt, err := c.complexType(base)
+		if err != nil {
+			return err
+		}
+		for k, v := range bt.attrs {
+			t.attrs[k] = v
+		}
+		t.anyAttr = bt.anyAttr
+		t.simple = bt.simple
+		if extension {
+			t.content = bt.content
+		}
+	} else if base.Space == xsdNS && base.Local == "anyType" {
+		t.anyAttr = &wildcard{any: true, process: "lax"}
+	} else if simpleContent {
+		st, err := c.simpleType(base)
+		if err != nil {
+			return err
+		}
+		t.simple = st
+	}
+
+	if simpleContent && !extension {
+		// a restriction of simple content may restrict the base type by facets
+		st, err := c.compileRestriction(der, d, xml.Name{}, t.simple)
+		if err != nil {
+			return err
+		}
+		t.simple = st
+	}
+	for _, ch := range der.ChildElements() {
+		if err := c.contentAndAttributes(t, ch, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contentAndAttributes compiles a model group or an attribute declaration of a complex type
+func (c *compiler) contentAndAttributes(t *complexType, e *etree.Element, d *document) error {
+	if e.NamespaceURI() != xsdNS {
+		return nil
+	}
+	switch e.Tag {
+	case "sequence", "choice", "all", "group":
+		p, err := c.particle(e, d)
+		if err != nil || p == nil {
+			return err
+		}
+		if t.content != nil { // an extension appends its content to the one of the base type
+			p = &particle{kind: sequenceParticle, min: 1, max: 1, children: []*particle{t.content, p}}
+		}
+		t.content = p
+	case "attribute":
+		u, err := c.attribute(e, d)
+		if err != nil || u == nil {
+			return err
+		}
+		t.attrs[u.name] = u
+	case "attributeGroup":
+		g, err := c.attrGroup(qname(e, d, e.SelectAttrValue("ref", "")))
+		if err != nil {
+			return err
+		}
+		for _, u := range g.attrs {
+			t.attrs[u.name] = u
+		}
+		if g.anyAttr != nil {
+			t.anyAttr = g.anyAttr
+		}
+	case "anyAttribute":
+		t.anyAttr = newWildcard(e, d)
+	}
+	return nil
+}
+
+// particle compiles an element, model group, group reference or wildcard with its occurrence bounds
+func (c *compiler) particle(e *etree.Element, d *document) (*particle, error) {
+	p := &particle{min: 1, max: 1}
+	if v := e.SelectAttrValue("minOccurs", ""); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid minOccurs %q", v)
+		}
+		p.min = n
+	}
+	if v := e.SelectAttrValue("maxOccurs", ""); v == "unbounded" {
+		p.max = -1
+	} else if v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid maxOccurs %q", v)
+		}
+		p.max = n
+	}
+
+	var err error
+	switch e.Tag {
+	case "element":
+		p.kind = elementParticle
+		p.elem, err = c.localElement(e, d)
+	case "any":
+		p.kind = anyParticle
+		p.any = newWildcard(e, d)
+	case "group":
+		g, err := c.group(qname(e, d, e.SelectAttrValue("ref", "")))
+		if err != nil {
+			return nil, err
+		}
+		p.kind, p.children = sequenceParticle, []*particle{g}
+	case "sequence", "choice", "all":
+		p.kind = map[string]int{"sequence": sequenceParticle, "choice": choiceParticle, "all": allParticle}[e.Tag]
+		for _, ch := range e.ChildElements() {
+			if ch.NamespaceURI() != xsdNS || ch.Tag == "annotation" {
+				continue
+			}
+			cp, err := c.particle(ch, d)
+			if err != nil {
+				return nil, err
+			}
+			p.children = append(p.children, cp)
+		}
+	default:
+		return nil, nil
+	}
+	return p, err
+}
+
+// group returns the model group of a named group
+func (c *compiler) group(name xml.Name) (*particle, error) {
+	if g, ok := c.s.groups[name]; ok {
+		return g, nil
+	}
+	raw, ok := c.rawGroups[name]
+	if !ok {
+		return nil, fmt.Errorf("group %s isn't declared", name.Local)
+	}
+	for _, ch := range raw.e.ChildElements() {
+		if isXSD(ch, "sequence") || isXSD(ch, "choice") || isXSD(ch, "all") {
+			g, err := c.particle(ch, raw.doc)
+			if err != nil {
+				return nil, err
+			}
+			c.s.groups[name] = g
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("group %s has no model group", name.Local)
+}
+
+// attribute compiles a local attribute declaration or a reference to a global one, nil if it is prohibited
+func (c *compiler) attribute(e *etree.Element, d *document) (*attrUse, error) {
+	use := e.SelectAttrValue("use", "optional")
+	if use == "prohibited" {
+		return nil, nil
+	}
+	u := &attrUse{required: use == "required"}
+	if ref := e.SelectAttrValue("ref", ""); ref != "" {
+		ad, err := c.globalAttribute(qname(e, d, ref))
+		if err != nil {
+			return nil, err
+		}
+		u.attrDecl = ad
+		return u, nil
+	}
+
+	name := xml.Name{Local: e.SelectAttrValue("name", "")}
+	if form := e.SelectAttrValue("form", ""); form == "qualified" || (form == "" && d.qualifiedAttributes) {
+		name.Space = d.tns
+	}
+	typ, err := c.attributeType(e, d)
+	if err != nil {
+		return nil, err
+	}
+	u.attrDecl = &attrDecl{name: name, typ: typ}
+	return u, nil
+}
+
+func (c *compiler) globalAttribute(name xml.Name) (*attrDecl, error) {
+	if ad, ok := c.s.attributes[name]; ok {
+		return ad, nil
+	}
+	raw, ok := c.rawAttributes[name]
+	if !ok {
+		return nil, fmt.Errorf("attribute %s isn't declared", name.Local)
+	}
+	typ, err := c.attributeType(raw.e, raw.doc)
+	if err != nil {
+		return nil, err
+	}
+	ad := &attrDecl{name: name, typ: typ}
+	c.s.attributes[name] = ad
+	return ad, nil
+}
+
+func (c *compiler) attributeType(e *etree.Element, d *document) (*simpleType, error) {
+	if t := e.SelectAttrValue("type", ""); t != "" {
+		return c.simpleType(qname(e, d, t))
+	}
+	for _, ch := range e.ChildElements() {
+		if isXSD(ch, "simpleType") {
+			return c.compileSimpleType(ch, d, xml.Name{})
+		}
+	}
+	return builtins["anySimpleType"], nil
+}
+
+func (c *compiler) attrGroup(name xml.Name) (*attrGroup, error) {
+	if g, ok := c.s.attrGroups[name]; ok {
+		return g, nil
+	}
+	raw, ok := c.rawAttrGroups[name]
+	if !ok {
+		return nil, fmt.Errorf("attribute group %s isn't declared", name.Local)
+	}
+	g := &attrGroup{}
+	c.s.attrGroups[name] = g
+	for _, ch := range raw.e.ChildElements() {
+		if ch.NamespaceURI() != xsdNS {
+			continue
+		}
+		switch ch.Tag {
+		case "attribute":
+			u, err := c.attribute(ch, raw.doc)
+			if err != nil {
+				return nil, err
+			}
+			if u != nil {
+				g.attrs = append(g.attrs, u)
+			}
+		case "attributeGroup":
+			ng, err := c.attrGroup(qname(ch, raw.doc, ch.SelectAttrValue("ref", "")))
+			if err != nil {
+				return nil, err
+			}
+			g.attrs = append(g.attrs, ng.attrs...)
+			if ng.anyAttr != nil {
+				g.anyAttr = ng.anyAttr
+			}
+		case "anyAttribute":
+			g.anyAttr = newWildcard(ch, raw.doc)
+		}
+	}
+	return g, nil
+}
+
+func newWildcard(e *etree.Element, d *document) *wildcard {
+	w := &wildcard{process: e.SelectAttrValue("processContents", "strict")}
+	switch ns := e.SelectAttrValue("namespace", "##any"); ns {
+	case "##any":
+		w.any = true
+	case "##other":
+		w.other = d.tns
+	default:
+		w.list = []string{}
+		for _, n := range strings.Fields(ns) {
+			switch n {
+			case "##targetNamespace":
+				n = d.tns
+			case "##local":
+				n = ""
+			}
+			w.list = append(w.list, n)
+		}
+	}
+	return w
+}
@@ -0,0 +1,160 @@
+package schema
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// testSchema is a schema in two documents, the second one is included without a targetNamespace
+var testSchema = map[string]string{
+	"main.xsd": `<?xml version="1.0" encoding="UTF-8"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:t="urn:t" targetNamespace="urn:t" elementFormDefault="qualified">
+	<xs:include schemaLocation="types.xsd"/>
+
+	<xs:element name="doc">
+		<xs:complexType>
+			<xs:sequence>
+				<xs:element name="head" type="t:tCode"/>
+				<xs:element name="item" type="t:tItem" minOccurs="0" maxOccurs="3"/>
+				<xs:choice minOccurs="0">
+					<xs:element name="a" type="xs:string"/>
+					<xs:element name="b" type="t:tBase"/>
+				</xs:choice>
+				<xs:group ref="t:gTail"/>
+				<xs:any namespace="##other" processContents="lax" minOccurs="0" maxOccurs="unbounded"/>
+			</xs:sequence>
+			<xs:attribute name="version" type="xs:string" use="required"/>
+		</xs:complexType>
+	</xs:element>
+	<xs:element name="note" type="t:tBase"/>
+
+	<xs:group name="gTail">
+		<xs:sequence>
+			<xs:element name="tail" minOccurs="0">
+				<xs:complexType>
+					<xs:simpleContent>
+						<xs:extension base="xs:decimal">
+							<xs:attribute name="unit" type="xs:string"/>
+						</xs:extension>
+					</xs:simpleContent>
+				</xs:complexType>
+			</xs:element>
+		</xs:sequence>
+	</xs:group>
+</xs:schema>`,
+	"types.xsd": `<?xml version="1.0" encoding="UTF-8"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" elementFormDefault="qualified">
+	<xs:simpleType name="tCode">
+		<xs:restriction base="xs:string">
+			<xs:pattern value="[A-Z]{2}\d+"/>
+		</xs:restriction>
+	</xs:simpleType>
+	<xs:complexType name="tBase">
+		<xs:attribute name="id" type="xs:ID" use="required"/>
+		<xs:attributeGroup ref="aNamed"/>
+		<xs:anyAttribute namespace="##other" processContents="lax"/>
+	</xs:complexType>
+	<xs:complexType name="tItem">
+		<xs:complexContent>
+			<xs:extension base="tBase">
+				<xs:sequence>
+					<xs:element name="ref" minOccurs="0">
+						<xs:complexType>
+							<xs:attribute name="to" type="xs:IDREF" use="required"/>
+						</xs:complexType>
+					</xs:element>
+				</xs:sequence>
+				<xs:attribute name="length" type="xs:nonNegativeInteger"/>
+			</xs:extension>
+		</xs:complexContent>
+	</xs:complexType>
+	<xs:attributeGroup name="aNamed">
+		<xs:attribute name="name" type="xs:string"/>
+	</xs:attributeGroup>
+</xs:schema>`,
+}
+
+func compileTestSchema(t *testing.T) *Schema {
+	s, err := Compile(testSchema, "main.xsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCompile(t *testing.T) {
+	s := compileTestSchema(t)
+	for _, n := range []string{"doc", "note"} {
+		if s.elements[xml.Name{Space: "urn:t", Local: n}] == nil {
+			t.Errorf("the element %s isn't declared", n)
+		}
+	}
+	// the included document takes the namespace of the including one
+	for _, n := range []string{"tBase", "tItem"} {
+		if s.complexTypes[xml.Name{Space: "urn:t", Local: n}] == nil {
+			t.Errorf("the complex type %s isn't in the target namespace", n)
+		}
+	}
+	item := s.complexTypes[xml.Name{Space: "urn:t", Local: "tItem"}]
+	for _, a := range []string{"id", "name", "length"} {
+		if item.attrs[xml.Name{Local: a}] == nil {
+			t.Errorf("tItem has no attribute %s", a)
+		}
+	}
+	if item.anyAttr == nil || item.anyAttr.other != "urn:t" {
+		t.Errorf("tItem has the attribute wildcard %v, want the one of tBase", item.anyAttr)
+	}
+	if item.start == nil || item.end == nil {
+		t.Error("the content model of tItem isn't compiled")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	doc := func(body string) string {
+		return `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:t="urn:t" targetNamespace="urn:t">` + body + `</xs:schema>`
+	}
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"missing root", map[string]string{}, "main.xsd is missing"},
+		{"missing include", map[string]string{"main.xsd": doc(`<xs:include schemaLocation="other.xsd"/>`)}, "other.xsd is missing"},
+		{"malformed", map[string]string{"main.xsd": `<xs:schema`}, "schema document main.xsd"},
+		{"no schema", map[string]string{"main.xsd": `<schema/>`}, "has no xs:schema root"},
+		{"redefine", map[string]string{"main.xsd": doc(`<xs:redefine schemaLocation="x.xsd"/>`)}, "xs:redefine isn't supported"},
+		{"declared twice", map[string]string{"main.xsd": doc(`<xs:element name="a"/><xs:element name="a"/>`)}, "element a is declared twice"},
+		{"undeclared type", map[string]string{"main.xsd": doc(`<xs:element name="a" type="t:tMissing"/>`)}, "simple type tMissing isn't declared"},
+		{"undeclared group", map[string]string{"main.xsd": doc(`<xs:element name="a"><xs:complexType><xs:group ref="t:gMissing"/></xs:complexType></xs:element>`)}, "group gMissing isn't declared"},
+		{"unsupported built-in", map[string]string{"main.xsd": doc(`<xs:element name="a" type="xs:NOTATION"/>`)}, "built-in type NOTATION isn't supported"},
+		{"invalid maxOccurs", map[string]string{"main.xsd": doc(`<xs:element name="a"><xs:complexType><xs:sequence maxOccurs="many"/></xs:complexType></xs:element>`)}, `invalid maxOccurs "many"`},
+		{"invalid facet", map[string]string{"main.xsd": doc(`<xs:element name="a" type="t:s"/><xs:simpleType name="s"><xs:restriction base="xs:int"><xs:minInclusive value="low"/></xs:restriction></xs:simpleType>`)}, `invalid minInclusive "low"`},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.files, "main.xsd")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want an error with %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWildcardAllows(t *testing.T) {
+	tests := []struct {
+		w    wildcard
+		ns   string
+		want bool
+	}{
+		{wildcard{any: true}, "", true},
+		{wildcard{other: "urn:t"}, "urn:x", true},
+		{wildcard{other: "urn:t"}, "urn:t", false},
+		{wildcard{other: "urn:t"}, "", false},
+		{wildcard{list: []string{"", "urn:x"}}, "", true},
+		{wildcard{list: []string{"", "urn:x"}}, "urn:t", false},
+	}
+	for _, tt := range tests {
+		if got := tt.w.allows(tt.ns); got != tt.want {
+			t.Errorf("%+v allows %q: %v, want %v", tt.w, tt.ns, got, tt.want)
+		}
+	}
+}
@@ -1,19 +1,36 @@
 package server
 
 import (
+	"Go-GoSAFE.converter/config"
 	"Go-GoSAFE.converter/controllers"
 
 	"github.com/gin-gonic/gin"
 )
 
 func NewRouter() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	if config.Enabled("info") {
+		router.Use(gin.Logger())
+	}
+	router.Use(gin.Recovery(), controllers.LimitUploadSize(config.Get().MaxUploadSize))
 
 	v1 := router.Group("api/v1")
 	{
 		v1.POST("/import/railml", controllers.ImportRailml)
 		v1.POST("/export/railml", controllers.ExportRailml)
+		v1.POST("/validate/railml", controllers.ValidateRailml)
+
+		v1.GET("/lines", controllers.ListLines)
+		v1.GET("/lines/:id", controllers.GetLine)
+		v1.GET("/lines/:id/geojson", controllers.GetLineGeoJSON)
+		v1.GET("/lines/:id/route", controllers.GetLineRoute)
+		v1.GET("/lines/:id/diagnostics", controllers.GetLineDiagnostics)
+		v1.GET("/lines/:id/locate", controllers.LocateOnLine)
+		v1.DELETE("/lines/:id", controllers.DeleteLine)
+
+		v1.GET("/jobs/:id", controllers.GetJob)
+		v1.DELETE("/jobs/:id", controllers.CancelJob)
 	}
 
-	return router // listen and serve on 0.0.0.0:8080
+	return router // listen and serve on the configured address
 }
@@ -1,8 +1,28 @@
 package server
 
+import (
+	"Go-GoSAFE.converter/config"
+
+	"github.com/gin-gonic/gin"
+)
+
 func Start() {
+	c := config.Get()
+	if !config.Enabled("debug") {
+		gin.SetMode(gin.ReleaseMode)
+	}
 
 	r := NewRouter()
 
-	r.Run(":6060") // listen and serve on 0.0.0.0:6060
+	var err error
+	if c.TLS.Cert != "" {
+		config.Infof("listening on %s (https)", c.Listen)
+		err = r.RunTLS(c.Listen, c.TLS.Cert, c.TLS.Key)
+	} else {
+		config.Infof("listening on %s", c.Listen)
+		err = r.Run(c.Listen)
+	}
+	if err != nil {
+		config.Errorf("%v", err)
+	}
 }
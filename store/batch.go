@@ -0,0 +1,193 @@
+package store
+
+import "fmt"
+
+// Batch is a subgraph built up in memory and then written to a GraphStore at once, see GraphStore.Write.
+// It is a GraphStore itself, so the graph code can build and inspect it like any other store.
+// Node ids in a batch are local to it and change when the batch is written.
+//
+// Besides new nodes and relationships, a batch can refer to nodes that are already stored,
+// update their properties, and delete stored nodes and relationships. All of it happens in the same write.
+type Batch struct {
+	*MemoryStore
+	existing   map[int]int   // batch id -> store id of nodes that are already stored
+	stored     map[int]int   // store id -> batch id, the other way round
+	updates    map[int]Props // batch id -> new properties of an already stored node
+	deletes    []int         // store ids of nodes to delete
+	relDeletes []int         // store ids of relationships to delete
+	trees      []treeDelete  // stored subgraphs to delete
+}
+
+// NewBatch creates an empty batch.
+func NewBatch() *Batch {
+	return &Batch{
+		MemoryStore: NewMemoryStore(),
+		existing:    map[int]int{},
+		stored:      map[int]int{},
+		updates:     map[int]Props{},
+	}
+}
+
+// Existing adds a node that is already stored, so that new nodes can be related to it.
+// If props isn't nil, the stored properties are replaced with props when the batch is written.
+// Adding the same stored node twice returns the same batch node.
+func (b *Batch) Existing(n Node, props Props) (*Node, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id, ok := b.stored[n.ID]
+	if !ok {
+		p := n.Props
+		if props != nil {
+			p = props
+		}
+		id = b.createNode(p, n.Labels)
+		b.existing[id] = n.ID
+		b.stored[n.ID] = id
+	}
+	if props != nil {
+		b.updates[id] = copyProps(props)
+		b.nodes[id].Props = copyProps(props)
+	}
+
+	c := b.node(id)
+	return &c, nil
+}
+
+// Len returns the number of nodes in the batch, the stored ones it refers to included.
+func (b *Batch) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.nodes)
+}
+
+// SetProps replaces the properties of a node of the batch.
+func (b *Batch) SetProps(id int, props Props) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n, ok := b.nodes[id]
+	if !ok {
+		return fmt.Errorf("node %d does not exist", id)
+	}
+	n.Props = copyProps(props)
+	if _, ok := b.existing[id]; ok {
+		b.updates[id] = copyProps(props)
+	}
+	return nil
+}
+
+// Delete removes the stored node with the given store id, together with all its relationships, when the batch is written.
+func (b *Batch) Delete(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deletes = append(b.deletes, id)
+}
+
+// DeleteTree removes the stored node with the given store id and all stored nodes reachable from it, see
+// GraphStore.DeleteSubgraph, when the batch is written. The nodes don't have to be read before.
+func (b *Batch) DeleteTree(id int, relTypes ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trees = append(b.trees, treeDelete{id: id, relTypes: append([]string{}, relTypes...)})
+}
+
+// DeleteRelationship removes the stored relationship with the given store id when the batch is written.
+func (b *Batch) DeleteRelationship(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.relDeletes = append(b.relDeletes, id)
+}
+
+// batchContents is everything a GraphStore needs to write a batch
+type batchContents struct {
+	nodes      []Node         // new nodes, in creation order
+	rels       []Relationship // new relationships, in creation order
+	existing   map[int]int    // batch id -> store id
+	updates    map[int]Props  // batch id -> new properties
+	deletes    []int
+	relDeletes []int
+	trees      []treeDelete
+}
+
+// contents returns copies of everything the batch holds.
+func (b *Batch) contents() batchContents {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	c := batchContents{
+		existing:   map[int]int{},
+		updates:    map[int]Props{},
+		deletes:    append([]int{}, b.deletes...),
+		relDeletes: append([]int{}, b.relDeletes...),
+		trees:      append([]treeDelete{}, b.trees...),
+	}
+	for id := 0; id < b.nextNode; id++ {
+		if _, ok := b.nodes[id]; !ok {
+			continue
+		}
+		if sid, ok := b.existing[id]; ok {
+			c.existing[id] = sid
+			continue
+		}
+		c.nodes = append(c.nodes, b.node(id))
+	}
+	for id, p := range b.updates {
+		c.updates[id] = copyProps(p)
+	}
+	for id := 0; id < b.nextRel; id++ {
+		if _, ok := b.rels[id]; ok {
+			c.rels = append(c.rels, b.relationship(id))
+		}
+	}
+	return c
+}
+
+// empty reports whether writing the batch would change nothing
+func (c batchContents) empty() bool {
+	return len(c.nodes) == 0 && len(c.rels) == 0 && len(c.updates) == 0 && len(c.deletes) == 0 && len(c.relDeletes) == 0 && len(c.trees) == 0
+}
+
+// Add copies the nodes and relationships of the batch a into b, in the order they were created in a, so that parts
+// of a batch can be built on their own, e.g. concurrently. Nodes of a that are listed in ids aren't copied,
+// their relationships are made to the nodes of b they map to. Existing nodes, updates and deletes of a aren't copied.
+func (b *Batch) Add(a *Batch, ids map[int]int) error {
+	c := a.contents()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	copied := map[int]bool{}
+	for _, n := range c.nodes {
+		copied[n.ID] = true
+	}
+	for k, v := range ids {
+		if _, ok := b.nodes[v]; !ok {
+			return fmt.Errorf("node %d does not exist", v)
+		}
+		copied[k] = true
+	}
+	for _, r := range c.rels { // checked first, so that b is changed completely or not at all
+		if !copied[r.Start] || !copied[r.End] {
+			return fmt.Errorf("relationship %d connects a node that isn't copied", r.ID)
+		}
+	}
+
+	mapped := map[int]int{}
+	for k, v := range ids {
+		mapped[k] = v
+	}
+	for _, n := range c.nodes {
+		if _, ok := mapped[n.ID]; !ok {
+			mapped[n.ID] = b.createNode(n.Props, n.Labels)
+		}
+	}
+	for _, r := range c.rels {
+		b.relate(mapped[r.Start], r.Type, mapped[r.End], r.Props)
+	}
+	return nil
+}
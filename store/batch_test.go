@@ -0,0 +1,149 @@
+package store
+
+import "testing"
+
+func TestBatchExisting(t *testing.T) {
+	m := NewMemoryStore()
+	stored, _ := m.CreateNode(Props{"id": "L1", "v": "1"}, "Line")
+
+	b := NewBatch()
+	first, _ := b.Existing(*stored, nil)
+	second, _ := b.Existing(*stored, nil)
+	if first.ID != second.ID || b.Len() != 1 {
+		t.Errorf("adding the node twice gave %d and %d, %d nodes", first.ID, second.ID, b.Len())
+	}
+	if first.Props["v"] != "1" {
+		t.Errorf("the batch node has %v, want the stored properties", first.Props)
+	}
+	tr, _ := b.CreateNode(Props{"id": "tr1"}, "Track")
+	b.Relate(first.ID, "HAS_TRACK", tr.ID, nil)
+	if b.Len() != 2 {
+		t.Errorf("the batch has %d nodes, want 2", b.Len())
+	}
+	if err := m.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, _ := m.FindNodes("Line", nil)
+	if len(lines) != 1 || lines[0].Props["v"] != "1" {
+		t.Errorf("lines %v, want L1 unchanged", lines)
+	}
+	if nb, _ := m.Neighbours(stored.ID, "HAS_TRACK"); len(nb) != 1 {
+		t.Errorf("L1 has the tracks %v, want tr1", nb)
+	}
+}
+
+func TestBatchSetProps(t *testing.T) {
+	m := NewMemoryStore()
+	stored, _ := m.CreateNode(Props{"id": "L1", "v": "1"}, "Line")
+
+	b := NewBatch()
+	ln, _ := b.Existing(*stored, nil)
+	tr, _ := b.CreateNode(Props{"id": "tr1"}, "Track")
+	if err := b.SetProps(ln.ID, Props{"id": "L1", "v": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetProps(tr.ID, Props{"id": "tr1", "v": "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetProps(42, Props{}); err == nil {
+		t.Error("set the properties of a missing node")
+	}
+	if err := m.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines, _ := m.FindNodes("Line", Props{"v": "2"}); len(lines) != 1 {
+		t.Errorf("the properties of the stored node weren't updated")
+	}
+	if tracks, _ := m.FindNodes("Track", Props{"v": "3"}); len(tracks) != 1 {
+		t.Errorf("the properties of the new node weren't set")
+	}
+}
+
+func TestBatchAdd(t *testing.T) {
+	b := NewBatch()
+	ln, _ := b.CreateNode(Props{"id": "L1"}, "Line")
+
+	part := NewBatch()
+	placeholder, _ := part.CreateNode(Props{"id": "placeholder"}, "Line")
+	tr, _ := part.CreateNode(Props{"id": "tr1"}, "Track")
+	sw, _ := part.CreateNode(Props{"id": "sw1"}, "Switch")
+	part.Relate(placeholder.ID, "HAS_TRACK", tr.ID, nil)
+	part.Relate(tr.ID, "HAS_SWITCH", sw.ID, Props{"pos": "5"})
+
+	if err := b.Add(part, map[int]int{placeholder.ID: ln.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 3 {
+		t.Errorf("the batch has %d nodes, want L1, tr1 and sw1", b.Len())
+	}
+	g, err := b.Subgraph(ln.ID, "HAS_TRACK", "HAS_SWITCH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeIds(g.Nodes); !equal(got, []string{"L1", "tr1", "sw1"}) {
+		t.Errorf("added %v, want tr1 and sw1 below L1", got)
+	}
+	if len(g.Relationships) != 2 || g.Relationships[1].Props["pos"] != "5" {
+		t.Errorf("added the relationships %v", g.Relationships)
+	}
+}
+
+func TestBatchAddFails(t *testing.T) {
+	b := NewBatch()
+	b.CreateNode(Props{"id": "L1"}, "Line")
+
+	part := NewBatch()
+	ln, _ := part.CreateNode(Props{"id": "L1"}, "Line")
+	tr, _ := part.CreateNode(Props{"id": "tr1"}, "Track")
+	part.Relate(ln.ID, "HAS_TRACK", tr.ID, nil)
+
+	if err := b.Add(part, map[int]int{ln.ID: 42}); err == nil {
+		t.Error("added a part mapped to a missing node")
+	}
+
+	// existing nodes of a part aren't copied, so relationships to them can't be
+	stored := NewBatch()
+	existing, _ := stored.Existing(Node{ID: 7, Props: Props{"id": "L2"}}, nil)
+	tr2, _ := stored.CreateNode(Props{"id": "tr2"}, "Track")
+	stored.Relate(existing.ID, "HAS_TRACK", tr2.ID, nil)
+	if err := b.Add(stored, nil); err == nil {
+		t.Error("added a relationship to a node that isn't copied")
+	}
+	if b.Len() != 1 {
+		t.Errorf("failed adds left %d nodes, want L1 only", b.Len())
+	}
+}
+
+func TestBatchContents(t *testing.T) {
+	b := NewBatch()
+	if !b.contents().empty() {
+		t.Error("a new batch isn't empty")
+	}
+	ln, _ := b.Existing(Node{ID: 7, Labels: []string{"Line"}, Props: Props{"id": "L1"}}, nil)
+	if !b.contents().empty() {
+		t.Error("a batch that only refers to a stored node isn't empty")
+	}
+	a, _ := b.CreateNode(Props{"id": "a"}, "Node")
+	c, _ := b.CreateNode(Props{"id": "c"}, "Node")
+	b.Relate(ln.ID, "HAS", c.ID, nil)
+	b.Relate(ln.ID, "HAS", a.ID, nil)
+	b.Delete(8)
+	b.DeleteTree(9, "HAS")
+	b.DeleteRelationship(10)
+
+	cn := b.contents()
+	if got := nodeIds(cn.nodes); !equal(got, []string{"a", "c"}) {
+		t.Errorf("new nodes %v, want a and c in creation order", got)
+	}
+	if cn.existing[ln.ID] != 7 || len(cn.existing) != 1 {
+		t.Errorf("existing nodes %v, want L1", cn.existing)
+	}
+	if len(cn.rels) != 2 || cn.rels[0].End != c.ID || cn.rels[1].End != a.ID {
+		t.Errorf("relationships %v, want them in creation order", cn.rels)
+	}
+	if len(cn.deletes) != 1 || len(cn.trees) != 1 || cn.trees[0].relTypes[0] != "HAS" || len(cn.relDeletes) != 1 {
+		t.Errorf("deletes %v, trees %v, relationship deletes %v", cn.deletes, cn.trees, cn.relDeletes)
+	}
+}
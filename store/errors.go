@@ -0,0 +1,43 @@
+package store
+
+import (
+	"net"
+	"net/url"
+)
+
+// UnavailableError is returned when the graph database can't be reached.
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return "graph database unavailable: " + e.Err.Error()
+}
+
+// NotFoundError is returned when a node looked up by its RailML id doesn't exist.
+type NotFoundError struct {
+	Label string
+	ID    string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Label + " '" + e.ID + "' not found"
+}
+
+// ConflictError is returned when a write would break a uniqueness constraint.
+type ConflictError struct {
+	Msg string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Msg
+}
+
+// unavailable marks errors caused by an unreachable server, other errors are returned as they are.
+func unavailable(err error) error {
+	switch err.(type) {
+	case *url.Error, net.Error:
+		return &UnavailableError{Err: err}
+	}
+	return err
+}
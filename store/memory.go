@@ -0,0 +1,577 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a pure Go GraphStore that keeps the whole graph in memory.
+// It needs no database, so it can be used for one-off conversions and in CI.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	nextNode  int
+	nextRel   int
+	nodes     map[int]*Node
+	rels      map[int]*Relationship
+	adjacency map[int][]int // node id -> ids of all relationships touching it
+	unique    []constraint
+	keys      map[constraint]map[interface{}]int // value -> id of the node that has it, for each constraint
+}
+
+// NewMemoryStore creates an empty in-memory graph.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nodes:     map[int]*Node{},
+		rels:      map[int]*Relationship{},
+		adjacency: map[int][]int{},
+		keys:      map[constraint]map[interface{}]int{},
+	}
+}
+
+// NewMemoryStoreFrom creates an in-memory graph of the given subgraph that keeps its node and relationship ids,
+// so that a part of another store can be read at once and then be looked at node by node without a round trip each.
+func NewMemoryStoreFrom(g *Subgraph) *MemoryStore {
+	m := NewMemoryStore()
+	for _, n := range g.Nodes {
+		c := n
+		c.Labels = append([]string{}, n.Labels...)
+		c.Props = copyProps(n.Props)
+		m.nodes[n.ID] = &c
+		if n.ID >= m.nextNode {
+			m.nextNode = n.ID + 1
+		}
+	}
+	for _, r := range g.Relationships {
+		if _, ok := m.nodes[r.Start]; !ok {
+			continue
+		}
+		if _, ok := m.nodes[r.End]; !ok {
+			continue
+		}
+		c := r
+		c.Props = copyProps(r.Props)
+		m.rels[r.ID] = &c
+		if r.ID >= m.nextRel {
+			m.nextRel = r.ID + 1
+		}
+	}
+	// adjacency in the order the relationships were created, as if they had been created here
+	ids := make([]int, 0, len(m.rels))
+	for id := range m.rels {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		r := m.rels[id]
+		m.adjacency[r.Start] = append(m.adjacency[r.Start], id)
+		if r.End != r.Start {
+			m.adjacency[r.End] = append(m.adjacency[r.End], id)
+		}
+	}
+	return m
+}
+
+// CreateNode implements GraphStore.
+func (m *MemoryStore) CreateNode(props Props, labels ...string) (*Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.uniqueViolation([]Node{{Labels: labels, Props: props}}, nil); err != nil {
+		return nil, err
+	}
+
+	c := m.node(m.createNode(props, labels))
+	return &c, nil
+}
+
+// Relate implements GraphStore.
+func (m *MemoryStore) Relate(start int, relType string, end int, props Props) (*Relationship, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.nodes[start]; !ok {
+		return nil, fmt.Errorf("node %d does not exist", start)
+	}
+	if _, ok := m.nodes[end]; !ok {
+		return nil, fmt.Errorf("node %d does not exist", end)
+	}
+
+	c := m.relationship(m.relate(start, relType, end, props))
+	return &c, nil
+}
+
+// Write implements GraphStore. Everything is checked before the first change is made,
+// so the batch is always written as a whole or not at all.
+func (m *MemoryStore) Write(b *Batch) error {
+	tx, err := m.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Write(b); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Begin implements GraphStore. The batches are kept aside until the transaction is committed,
+// only the ids of their new nodes are handed out right away.
+func (m *MemoryStore) Begin() (Tx, error) {
+	return &memoryTx{m: m, nodes: map[int]*Node{}, updates: map[int]Props{}}, nil
+}
+
+// memoryTx is a transaction of a MemoryStore, everything in it is in store ids
+type memoryTx struct {
+	m          *MemoryStore
+	nodes      map[int]*Node // new nodes
+	order      []int         // ids of the new nodes, in creation order
+	rels       []Relationship
+	updates    map[int]Props // new properties of stored nodes
+	deletes    []int
+	relDeletes []int
+	trees      []treeDelete
+	ended      bool
+}
+
+// Write implements Tx.
+func (t *memoryTx) Write(b *Batch) (map[int]int, error) {
+	if t.ended {
+		return nil, errTxEnded
+	}
+	c := b.contents()
+
+	t.m.mu.Lock()
+	ids := map[int]int{} // batch id -> store id
+	for bid, sid := range c.existing {
+		_, stored := t.m.nodes[sid]
+		if _, ok := t.nodes[sid]; !ok && !stored {
+			t.m.mu.Unlock()
+			t.Rollback()
+			return nil, fmt.Errorf("node %d does not exist", sid)
+		}
+		ids[bid] = sid
+	}
+	for _, n := range c.nodes {
+		ids[n.ID] = t.m.nextNode
+		t.m.nextNode++
+	}
+	t.m.mu.Unlock()
+
+	for _, n := range c.nodes {
+		sn := n
+		sn.ID = ids[n.ID]
+		t.nodes[sn.ID] = &sn
+		t.order = append(t.order, sn.ID)
+	}
+	for bid, p := range c.updates {
+		if n, ok := t.nodes[ids[bid]]; ok {
+			n.Props = p
+		} else {
+			t.updates[ids[bid]] = p
+		}
+	}
+	for _, id := range c.deletes {
+		if _, ok := t.nodes[id]; ok {
+			delete(t.nodes, id)
+		} else {
+			t.deletes = append(t.deletes, id)
+		}
+	}
+	t.relDeletes = append(t.relDeletes, c.relDeletes...)
+	t.trees = append(t.trees, c.trees...)
+	for _, r := range c.rels {
+		r.Start, r.End = ids[r.Start], ids[r.End]
+		t.rels = append(t.rels, r)
+	}
+	return ids, nil
+}
+
+// Commit implements Tx. Everything is checked before the first change is made.
+func (t *memoryTx) Commit() error {
+	if t.ended {
+		return errTxEnded
+	}
+	t.ended = true
+	m := t.m
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deletes := append([]int{}, t.deletes...)
+	for _, d := range t.trees {
+		if _, ok := m.nodes[d.id]; ok {
+			deletes = append(deletes, m.reachable(d.id, d.relTypes)...)
+		}
+	}
+	replaced := map[int]bool{} // store ids of nodes whose current properties won't count any more
+	for _, id := range deletes {
+		replaced[id] = true
+	}
+	var candidates []Node // nodes as they will be after the commit
+	for _, id := range t.order {
+		if n, ok := t.nodes[id]; ok {
+			candidates = append(candidates, *n)
+		}
+	}
+	for id, p := range t.updates {
+		n, ok := m.nodes[id]
+		if !ok {
+			return fmt.Errorf("node %d does not exist", id)
+		}
+		if !replaced[id] {
+			replaced[id] = true
+			candidates = append(candidates, Node{Labels: n.Labels, Props: p})
+		}
+	}
+	if err := m.uniqueViolation(candidates, replaced); err != nil {
+		return err
+	}
+
+	for _, id := range t.relDeletes {
+		m.deleteRelationship(id)
+	}
+	for _, id := range deletes {
+		m.deleteNode(id)
+	}
+	for id, p := range t.updates {
+		if n, ok := m.nodes[id]; ok {
+			m.unindex(n)
+			n.Props = copyProps(p)
+			m.index(n)
+		}
+	}
+	for _, id := range t.order {
+		if n, ok := t.nodes[id]; ok {
+			m.putNode(id, n.Props, n.Labels)
+		}
+	}
+	for _, r := range t.rels {
+		if _, ok := m.nodes[r.Start]; !ok {
+			continue // related to a node deleted in the same transaction
+		}
+		if _, ok := m.nodes[r.End]; !ok {
+			continue
+		}
+		m.relate(r.Start, r.Type, r.End, r.Props)
+	}
+	return nil
+}
+
+// Rollback implements Tx.
+func (t *memoryTx) Rollback() error {
+	t.ended = true
+	return nil
+}
+
+// Unique implements GraphStore. Same as in Neo4j, the constraint can't be made if stored nodes break it already.
+func (m *MemoryStore) Unique(label string, property string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := constraint{label: label, property: property}
+	for _, u := range m.unique {
+		if u == c {
+			return nil
+		}
+	}
+	keys := map[interface{}]int{}
+	for id, n := range m.nodes {
+		v, ok := n.Props[property]
+		if !ok || !n.HasLabel(label) {
+			continue
+		}
+		if _, ok := keys[v]; ok {
+			return &ConflictError{Msg: fmt.Sprintf("cannot make %s.%s unique, there are two nodes with '%v'", label, property, v)}
+		}
+		keys[v] = id
+	}
+	m.unique = append(m.unique, c)
+	m.keys[c] = keys
+	return nil
+}
+
+// FindNodes implements GraphStore.
+func (m *MemoryStore) FindNodes(label string, props Props) ([]Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ns := []Node{}
+	for id := 0; id < m.nextNode; id++ { // keeps the creation order
+		n, ok := m.nodes[id]
+		if !ok || !n.HasLabel(label) || !matchesProps(n.Props, props) {
+			continue
+		}
+		ns = append(ns, m.node(id))
+	}
+	return ns, nil
+}
+
+// CountRelated implements GraphStore.
+func (m *MemoryStore) CountRelated(label string, relType string) ([]Counted, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cs := []Counted{}
+	for id := 0; id < m.nextNode; id++ {
+		n, ok := m.nodes[id]
+		if !ok || !n.HasLabel(label) {
+			continue
+		}
+		c := Counted{Node: m.node(id)}
+		for _, rid := range m.adjacency[id] {
+			if r := m.rels[rid]; r.Start == id && r.Type == relType {
+				c.Count++
+			}
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// Neighbours implements GraphStore.
+func (m *MemoryStore) Neighbours(id int, relTypes ...string) ([]Neighbour, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.nodes[id]; !ok {
+		return nil, fmt.Errorf("node %d does not exist", id)
+	}
+
+	nb := []Neighbour{}
+	for _, rid := range m.adjacency[id] {
+		r := m.rels[rid]
+		if !hasType(r.Type, relTypes) {
+			continue
+		}
+		other := r.End
+		if other == id {
+			other = r.Start
+		}
+		nb = append(nb, Neighbour{Relationship: m.relationship(rid), Node: m.node(other)})
+	}
+	return nb, nil
+}
+
+// Subgraph implements GraphStore.
+func (m *MemoryStore) Subgraph(id int, relTypes ...string) (*Subgraph, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.nodes[id]; !ok {
+		return nil, fmt.Errorf("node %d does not exist", id)
+	}
+
+	ids := m.reachable(id, relTypes)
+	in := map[int]bool{}
+	for _, n := range ids {
+		in[n] = true
+	}
+	sort.Ints(ids[1:])
+	g := &Subgraph{Nodes: []Node{}, Relationships: []Relationship{}}
+	var rids []int
+	for _, n := range ids {
+		g.Nodes = append(g.Nodes, m.node(n))
+		for _, rid := range m.adjacency[n] {
+			if r := m.rels[rid]; r.Start == n && in[r.End] {
+				rids = append(rids, rid)
+			}
+		}
+	}
+	sort.Ints(rids)
+	for _, rid := range rids {
+		g.Relationships = append(g.Relationships, m.relationship(rid))
+	}
+	return g, nil
+}
+
+// Filter implements GraphStore.
+func (m *MemoryStore) Filter(id int, f NodeFilter, relTypes ...string) ([]Filtered, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.nodes[id]; !ok {
+		return nil, fmt.Errorf("node %d does not exist", id)
+	}
+
+	via := map[int]int{} // node id -> id of the relationship it is reached by
+	ids := []int{id}
+	seen := map[int]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		for _, rid := range m.adjacency[ids[i]] {
+			r := m.rels[rid]
+			if r.Start != ids[i] || !hasType(r.Type, relTypes) || seen[r.End] {
+				continue
+			}
+			seen[r.End] = true
+			via[r.End] = rid
+			ids = append(ids, r.End)
+		}
+	}
+	ids = ids[1:]
+	sort.Ints(ids)
+
+	fs := []Filtered{}
+	for _, n := range ids {
+		if !f.selects(m.nodes[n]) {
+			continue
+		}
+		r := m.relationship(via[n])
+		fs = append(fs, Filtered{Node: m.node(n), Relationship: r, ParentID: m.nodes[r.Start].Props["id"]})
+	}
+	return fs, nil
+}
+
+// DeleteSubgraph implements GraphStore.
+func (m *MemoryStore) DeleteSubgraph(id int, relTypes ...string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.nodes[id]; !ok {
+		return 0, nil
+	}
+	ids := m.reachable(id, relTypes)
+	for _, n := range ids {
+		m.deleteNode(n)
+	}
+	return len(ids), nil
+}
+
+// reachable returns the id of the given node and of all nodes reachable from it over outgoing relationships
+// of the given types, the given node first. The caller must hold a lock.
+func (m *MemoryStore) reachable(id int, relTypes []string) []int {
+	ids := []int{id}
+	seen := map[int]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		for _, rid := range m.adjacency[ids[i]] {
+			r := m.rels[rid]
+			if r.Start != ids[i] || !hasType(r.Type, relTypes) || seen[r.End] {
+				continue
+			}
+			seen[r.End] = true
+			ids = append(ids, r.End)
+		}
+	}
+	return ids
+}
+
+// createNode stores a new node and returns its id. The caller must hold the write lock.
+func (m *MemoryStore) createNode(props Props, labels []string) int {
+	id := m.nextNode
+	m.nextNode++
+	m.putNode(id, props, labels)
+	return id
+}
+
+// putNode stores a new node with an id that has been handed out before. The caller must hold the write lock.
+func (m *MemoryStore) putNode(id int, props Props, labels []string) {
+	n := &Node{
+		ID:    id,
+		Props: copyProps(props),
+	}
+	for _, l := range labels {
+		if l != "" { // same as in Neo4j, a node can't carry an empty label
+			n.Labels = append(n.Labels, l)
+		}
+	}
+	m.nodes[id] = n
+	m.index(n)
+}
+
+// relate stores a new relationship between two existing nodes and returns its id. The caller must hold the write lock.
+func (m *MemoryStore) relate(start int, relType string, end int, props Props) int {
+	r := &Relationship{
+		ID:    m.nextRel,
+		Type:  relType,
+		Start: start,
+		End:   end,
+		Props: copyProps(props),
+	}
+	m.rels[r.ID] = r
+	m.nextRel++
+	m.adjacency[start] = append(m.adjacency[start], r.ID)
+	if end != start {
+		m.adjacency[end] = append(m.adjacency[end], r.ID)
+	}
+	return r.ID
+}
+
+// deleteNode removes a node with all its relationships. The caller must hold the write lock.
+func (m *MemoryStore) deleteNode(id int) {
+	for _, rid := range append([]int{}, m.adjacency[id]...) {
+		m.deleteRelationship(rid)
+	}
+	delete(m.adjacency, id)
+	if n, ok := m.nodes[id]; ok {
+		m.unindex(n)
+	}
+	delete(m.nodes, id)
+}
+
+// deleteRelationship removes a relationship. The caller must hold the write lock.
+func (m *MemoryStore) deleteRelationship(id int) {
+	r, ok := m.rels[id]
+	if !ok {
+		return
+	}
+	for _, n := range []int{r.Start, r.End} {
+		rids := m.adjacency[n][:0]
+		for _, rid := range m.adjacency[n] {
+			if rid != id {
+				rids = append(rids, rid)
+			}
+		}
+		m.adjacency[n] = rids
+	}
+	delete(m.rels, id)
+}
+
+// uniqueViolation checks whether storing the candidate nodes would break a uniqueness constraint.
+// Stored nodes listed in ignore are about to be deleted or updated and don't count. The caller must hold a lock.
+func (m *MemoryStore) uniqueViolation(candidates []Node, ignore map[int]bool) error {
+	for _, c := range m.unique {
+		seen := map[interface{}]bool{}
+		for _, n := range candidates {
+			v, ok := n.Props[c.property]
+			if !ok || !n.HasLabel(c.label) {
+				continue
+			}
+			id, stored := m.keys[c][v]
+			if seen[v] || stored && !ignore[id] {
+				return &ConflictError{Msg: fmt.Sprintf("%s with %s '%v' already exists", c.label, c.property, v)}
+			}
+			seen[v] = true
+		}
+	}
+	return nil
+}
+
+// index keeps the values the node has of the properties of uniqueness constraints. The caller must hold the write lock.
+func (m *MemoryStore) index(n *Node) {
+	for _, c := range m.unique {
+		if v, ok := n.Props[c.property]; ok && n.HasLabel(c.label) {
+			m.keys[c][v] = n.ID
+		}
+	}
+}
+
+// unindex forgets the values index kept of the node. The caller must hold the write lock.
+func (m *MemoryStore) unindex(n *Node) {
+	for _, c := range m.unique {
+		if v, ok := n.Props[c.property]; ok && n.HasLabel(c.label) && m.keys[c][v] == n.ID {
+			delete(m.keys[c], v)
+		}
+	}
+}
+
+// node returns a copy of the stored node, so callers can't modify the graph behind the store's back.
+func (m *MemoryStore) node(id int) Node {
+	n := m.nodes[id]
+	return Node{ID: n.ID, Labels: append([]string{}, n.Labels...), Props: copyProps(n.Props)}
+}
+
+// relationship returns a copy of the stored relationship.
+func (m *MemoryStore) relationship(id int) Relationship {
+	r := *m.rels[id]
+	r.Props = copyProps(r.Props)
+	return r
+}
@@ -0,0 +1,176 @@
+package store
+
+import "testing"
+
+func TestMemoryStore(t *testing.T) {
+	testGraphStore(t, func(t *testing.T) (GraphStore, func(string) string) {
+		return NewMemoryStore(), func(l string) string { return l }
+	})
+}
+
+func TestMemoryStoreCopies(t *testing.T) {
+	m := NewMemoryStore()
+	props := Props{"id": "a"}
+	n, _ := m.CreateNode(props, "Node", "")
+	props["id"] = "changed"
+	n.Props["id"] = "changed"
+	n.Labels[0] = "Changed"
+
+	found, _ := m.FindNodes("Node", nil)
+	if len(found) != 1 || found[0].Props["id"] != "a" {
+		t.Errorf("stored %v, want it as it was created", found)
+	}
+	if len(found[0].Labels) != 1 {
+		t.Errorf("stored the labels %q, want the empty one left out", found[0].Labels)
+	}
+}
+
+func TestMemoryStoreMissingNodes(t *testing.T) {
+	m := NewMemoryStore()
+	a, _ := m.CreateNode(Props{"id": "a"}, "Node")
+	if _, err := m.Relate(a.ID, "LINKS", 42, nil); err == nil {
+		t.Error("related to a missing node")
+	}
+	if _, err := m.Neighbours(42); err == nil {
+		t.Error("read the neighbours of a missing node")
+	}
+	if _, err := m.Subgraph(42); err == nil {
+		t.Error("read the subgraph of a missing node")
+	}
+	if _, err := m.Filter(42, NodeFilter{}); err == nil {
+		t.Error("filtered the subgraph of a missing node")
+	}
+
+	// a batch that refers to a missing node isn't written at all
+	b := NewBatch()
+	missing, _ := b.Existing(Node{ID: 42, Props: Props{"id": "missing"}}, nil)
+	c, _ := b.CreateNode(Props{"id": "c"}, "Node")
+	b.Relate(missing.ID, "LINKS", c.ID, nil)
+	if err := m.Write(b); err == nil {
+		t.Error("wrote a batch that refers to a missing node")
+	}
+	if found, _ := m.FindNodes("Node", nil); len(found) != 1 {
+		t.Errorf("stored %v, want a only", found)
+	}
+}
+
+func TestMemoryStoreTxWriteEnded(t *testing.T) {
+	m := NewMemoryStore()
+	tx, _ := m.Begin()
+	b := NewBatch()
+	b.Existing(Node{ID: 42}, Props{"id": "missing"})
+	if _, err := tx.Write(b); err == nil {
+		t.Fatal("wrote a batch that refers to a missing node")
+	}
+	// a failed write rolls the transaction back
+	if _, err := tx.Write(NewBatch()); err != errTxEnded {
+		t.Errorf("writing after a failed write: %v, want %v", err, errTxEnded)
+	}
+}
+
+// TestNewMemoryStoreFrom checks that a store made of a subgraph of another store keeps the ids and
+// the order of both, so that it can be looked at like the store it was read from
+func TestNewMemoryStoreFrom(t *testing.T) {
+	m := NewMemoryStore()
+	n := tree(t, m, func(l string) string { return l })
+	g, err := m.Subgraph(n["root"].ID, "HAS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewMemoryStoreFrom(g)
+
+	for _, id := range []string{"root", "c1", "c2", "c3"} {
+		want, _ := m.Neighbours(n[id].ID, "HAS", "REFERS")
+		got, err := c.Neighbours(n[id].ID, "HAS", "REFERS")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range want {
+			if want[i].Node.ID == n["o"].ID { // the HAS from o isn't part of the subgraph
+				want = append(want[:i], want[i+1:]...)
+				break
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s has the neighbours %v, want %v", id, got, want)
+			continue
+		}
+		for i := range got {
+			if got[i].Relationship.ID != want[i].Relationship.ID || got[i].Node.ID != want[i].Node.ID {
+				t.Errorf("%s has the neighbour %v, want %v", id, got[i], want[i])
+			}
+		}
+	}
+	if _, err := c.Neighbours(n["o"].ID); err == nil {
+		t.Error("o was copied")
+	}
+
+	// nodes created later don't take the ids of the copied ones
+	d, _ := c.CreateNode(Props{"id": "d"}, "Tree")
+	if d.ID <= n["c3"].ID {
+		t.Errorf("new node got the id %d", d.ID)
+	}
+
+	// the copy is a copy
+	g.Nodes[0].Props["id"] = "changed"
+	if found, _ := c.FindNodes("Tree", Props{"id": "root"}); len(found) != 1 {
+		t.Error("the copy changed with the subgraph")
+	}
+}
+
+func TestFilterOrder(t *testing.T) {
+	m := NewMemoryStore()
+	n := tree(t, m, func(l string) string { return l })
+	fs, err := m.Filter(n["root"].ID, NodeFilter{}, "HAS", "OTHER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Node
+	for _, f := range fs {
+		got = append(got, f.Node)
+	}
+	// o is reached after c1 and c3, but created before
+	if ids := nodeIds(got); !equal(ids, []string{"c1", "c2", "c3", "o"}) {
+		t.Errorf("filtered %v, want them in creation order", ids)
+	}
+}
+
+// TestMemoryStoreUniqueKeys checks that the values of the uniqueness constraints follow the nodes
+// through updates and deletes, and that a constraint stored nodes break already isn't made
+func TestMemoryStoreUniqueKeys(t *testing.T) {
+	m := NewMemoryStore()
+	a, _ := m.CreateNode(Props{"id": "a"}, "Node")
+	b, _ := m.CreateNode(Props{"id": "b"}, "Node")
+	if err := m.Unique("Node", "id"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a and b swap their ids
+	batch := NewBatch()
+	batch.Existing(*a, Props{"id": "b"})
+	batch.Existing(*b, Props{"id": "a"})
+	if err := m.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if _, err := m.CreateNode(Props{"id": id}, "Node"); !isConflict(err) {
+			t.Errorf("creating a second %s after the swap: %v, want a ConflictError", id, err)
+		}
+	}
+
+	if _, err := m.DeleteSubgraph(a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CreateNode(Props{"id": "b"}, "Node"); err != nil {
+		t.Errorf("creating b after deleting it: %v", err)
+	}
+
+	m.CreateNode(Props{"id": "x"}, "Other")
+	m.CreateNode(Props{"id": "x"}, "Other")
+	if err := m.Unique("Other", "id"); !isConflict(err) {
+		t.Errorf("making the ids of two x unique: %v, want a ConflictError", err)
+	}
+	if _, err := m.CreateNode(Props{"id": "x"}, "Other"); err != nil {
+		t.Errorf("the constraint that couldn't be made is checked: %v", err)
+	}
+}
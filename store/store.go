@@ -0,0 +1,211 @@
+package store
+
+import "errors"
+
+// Props is a set of node or relationship properties.
+type Props map[string]interface{}
+
+// Node is a single node read from or written to the graph.
+type Node struct {
+	ID     int
+	Labels []string
+	Props  Props
+}
+
+// HasLabel reports whether the node carries the given label.
+func (n *Node) HasLabel(label string) bool {
+	for _, l := range n.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// Relationship is a directed, typed edge between two nodes.
+type Relationship struct {
+	ID    int
+	Type  string
+	Start int
+	End   int
+	Props Props
+}
+
+// Neighbour is a node reached from another node over exactly one relationship.
+type Neighbour struct {
+	Relationship Relationship
+	Node         Node
+}
+
+// Subgraph is a part of a graph read at once, see GraphStore.Subgraph.
+type Subgraph struct {
+	Nodes         []Node         // the root first, then in the order they were created
+	Relationships []Relationship // every relationship between two of the nodes, in the order they were created
+}
+
+// BBoxProperty is the property that keeps the bounding box min x, min y, max x, max y of the geometry of a node,
+// so that stores can select nodes by where they are, see NodeFilter.
+const BBoxProperty = "geometryBBox"
+
+// NodeFilter selects nodes of a subgraph, see GraphStore.Filter.
+type NodeFilter struct {
+	Labels []string    // nodes with one of the labels, all if empty
+	Has    string      // nodes that have the property, all if empty
+	BBox   *[4]float64 // nodes whose BBoxProperty intersects the min x, min y, max x, max y box, and those without one
+}
+
+// Filtered is a node of a subgraph a NodeFilter selected, with the relationship it is reached by
+// and the id property of the node that relationship starts at, e.g. the RailML id of the track of an element.
+type Filtered struct {
+	Node         Node
+	Relationship Relationship
+	ParentID     interface{}
+}
+
+// Counted is a node with the number of its outgoing relationships of a type, see GraphStore.CountRelated.
+type Counted struct {
+	Node  Node
+	Count int
+}
+
+// GraphStore is the storage backend used by the importer and the exporter.
+// Everything the converter needs from a graph database goes through this interface,
+// so conversions can run against Neo4j or entirely in memory.
+type GraphStore interface {
+	// CreateNode creates a node with the given properties and labels.
+	CreateNode(props Props, labels ...string) (*Node, error)
+	// Relate creates the relationship (start)-[relType]->(end).
+	Relate(start int, relType string, end int, props Props) (*Relationship, error)
+	// FindNodes returns all nodes with the given label whose properties match all given props.
+	FindNodes(label string, props Props) ([]Node, error)
+	// CountRelated returns all nodes with the given label, each with the number of its outgoing relationships
+	// of the given type, in one read instead of one for every node.
+	CountRelated(label string, relType string) ([]Counted, error)
+	// Neighbours returns all nodes related to the given node in either direction.
+	// If no relationship types are given, relationships of every type are followed.
+	Neighbours(id int, relTypes ...string) ([]Neighbour, error)
+	// Subgraph returns the node with the given id and all nodes reachable from it over outgoing relationships
+	// of the given types, together with all relationships, of any type, between these nodes.
+	// If no relationship types are given, relationships of every type are followed.
+	Subgraph(id int, relTypes ...string) (*Subgraph, error)
+	// DeleteSubgraph deletes the nodes Subgraph returns, with all their relationships, and returns how many there were.
+	// Nothing is deleted if the node doesn't exist.
+	DeleteSubgraph(id int, relTypes ...string) (int, error)
+	// Filter returns the nodes Subgraph returns, but the given one, that the filter selects, in the order they
+	// were created. Only the selected nodes are read.
+	Filter(id int, f NodeFilter, relTypes ...string) ([]Filtered, error)
+	// Write stores the whole batch atomically: either all of its changes are written or none.
+	Write(b *Batch) error
+	// Begin starts a transaction for changes too large to be built up in a single batch, see Tx.
+	Begin() (Tx, error)
+	// Unique makes sure no two nodes with the given label have the same value of the property.
+	// Writes that would break it fail with a ConflictError.
+	Unique(label string, property string) error
+}
+
+// Tx is a write transaction batches are written to one after the other. Nothing written in it can be seen
+// before it is committed, and either all of it is stored or none. Nodes of a batch that has been written can be referred
+// to by later batches with Batch.Existing and the store ids Write returned.
+type Tx interface {
+	// Write writes the batch and returns the store ids of its nodes by their batch ids.
+	// If it fails, the transaction is rolled back.
+	Write(b *Batch) (map[int]int, error)
+	// Commit ends the transaction and stores everything written in it.
+	Commit() error
+	// Rollback ends the transaction and discards everything written in it. Nothing happens if it has ended already.
+	Rollback() error
+}
+
+// errTxEnded is returned when a transaction is used after it has been committed or rolled back
+var errTxEnded = errors.New("transaction has ended")
+
+// treeDelete is a stored subgraph a batch deletes, see Batch.DeleteTree
+type treeDelete struct {
+	id       int
+	relTypes []string
+}
+
+// constraint is a uniqueness constraint on a property of all nodes with a label
+type constraint struct {
+	label    string
+	property string
+}
+
+// copyProps returns a shallow copy of p, never nil.
+func copyProps(p Props) Props {
+	c := Props{}
+	for k, v := range p {
+		c[k] = v
+	}
+	return c
+}
+
+// matchesProps reports whether p contains every key:value pair of filter.
+func matchesProps(p Props, filter Props) bool {
+	for k, v := range filter {
+		if p[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// selects reports whether the filter selects the node
+func (f NodeFilter) selects(n *Node) bool {
+	if len(f.Labels) > 0 {
+		found := false
+		for _, l := range f.Labels {
+			found = found || n.HasLabel(l)
+		}
+		if !found {
+			return false
+		}
+	}
+	if _, ok := n.Props[f.Has]; f.Has != "" && !ok {
+		return false
+	}
+	if f.BBox == nil {
+		return true
+	}
+	b, ok := bbox(n.Props[BBoxProperty])
+	return !ok || b[0] <= f.BBox[2] && b[2] >= f.BBox[0] && b[1] <= f.BBox[3] && b[3] >= f.BBox[1]
+}
+
+// bbox reads a BBoxProperty, which is a []interface{} once it has been read from Neo4j
+func bbox(v interface{}) ([4]float64, bool) {
+	b := [4]float64{}
+	switch a := v.(type) {
+	case []float64:
+		if len(a) != 4 {
+			return b, false
+		}
+		copy(b[:], a)
+	case []interface{}:
+		if len(a) != 4 {
+			return b, false
+		}
+		for i := range a {
+			f, ok := a[i].(float64)
+			if !ok {
+				return b, false
+			}
+			b[i] = f
+		}
+	default:
+		return b, false
+	}
+	return b, true
+}
+
+// hasType reports whether t is one of types. An empty types list matches everything.
+func hasType(t string, types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, tt := range types {
+		if tt == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,603 @@
+package store
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// conformance are the tests every GraphStore has to pass. Every label they use goes through label,
+// so that tests against a database that is in use don't see other nodes.
+var conformance = []struct {
+	name string
+	test func(t *testing.T, s GraphStore, label func(string) string)
+}{
+	{"nodes and relationships", testNodesAndRelationships},
+	{"count related", testCountRelated},
+	{"subgraph", testSubgraph},
+	{"delete subgraph", testDeleteSubgraph},
+	{"filter", testFilter},
+	{"meshed", testMeshed},
+	{"write", testWrite},
+	{"write deletes", testWriteDeletes},
+	{"unique", testUnique},
+	{"rollback on conflict", testRollbackOnConflict},
+	{"transaction", testTransaction},
+	{"transaction rollback", testTransactionRollback},
+}
+
+// testGraphStore runs the conformance tests, each against a store open returns with the labels it uses there
+func testGraphStore(t *testing.T, open func(t *testing.T) (GraphStore, func(string) string)) {
+	for _, c := range conformance {
+		t.Run(c.name, func(t *testing.T) {
+			s, label := open(t)
+			c.test(t, s, label)
+		})
+	}
+}
+
+func testNodesAndRelationships(t *testing.T, s GraphStore, label func(string) string) {
+	a := mustCreate(t, s, Props{"id": "a", "kind": "x"}, label("Node"))
+	b := mustCreate(t, s, Props{"id": "b", "kind": "x"}, label("Node"), label("Other"))
+	mustCreate(t, s, Props{"id": "c", "kind": "y"}, label("Node"))
+	if !b.HasLabel(label("Other")) || b.Props["id"] != "b" {
+		t.Errorf("created %v", b)
+	}
+
+	r, err := s.Relate(a.ID, "LINKS", b.ID, Props{"w": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type != "LINKS" || r.Start != a.ID || r.End != b.ID || r.Props["w"] != "1" {
+		t.Errorf("related %v", r)
+	}
+
+	found, err := s.FindNodes(label("Node"), Props{"kind": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeIds(found); !sameSet(got, []string{"a", "b"}) {
+		t.Errorf("found %v, want a and b", got)
+	}
+	if found, _ := s.FindNodes(label("Other"), nil); len(found) != 1 || found[0].ID != b.ID {
+		t.Errorf("found %v by the second label, want b", found)
+	}
+
+	// either direction
+	for _, id := range []int{a.ID, b.ID} {
+		nb, err := s.Neighbours(id, "LINKS")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nb) != 1 || nb[0].Relationship.ID != r.ID || nb[0].Node.ID == id {
+			t.Errorf("neighbours of %d: %v", id, nb)
+		}
+	}
+	if nb, _ := s.Neighbours(a.ID, "OTHER"); len(nb) != 0 {
+		t.Errorf("neighbours over another type: %v", nb)
+	}
+	if nb, _ := s.Neighbours(a.ID); len(nb) != 1 {
+		t.Errorf("neighbours over every type: %v", nb)
+	}
+}
+
+func testCountRelated(t *testing.T, s GraphStore, label func(string) string) {
+	n := tree(t, s, label)
+	mustCreate(t, s, Props{"id": "other"}, label("Other"))
+
+	cs, err := s.CountRelated(label("Tree"), "HAS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, c := range cs {
+		got[c.Node.Props["id"].(string)] = c.Count
+	}
+	// only outgoing relationships count, and nodes without any are there with 0
+	want := map[string]int{"root": 2, "c1": 1, "c2": 0, "c3": 0, "o": 1}
+	if len(got) != len(want) {
+		t.Errorf("counted %v, want %v", got, want)
+	}
+	for id, w := range want {
+		if got[id] != w {
+			t.Errorf("counted %d HAS of %s, want %d", got[id], id, w)
+		}
+	}
+	if cs[0].Node.ID != n["root"].ID || !cs[0].Node.HasLabel(label("Tree")) {
+		t.Errorf("the first counted node is %v, want the root with its labels", cs[0].Node)
+	}
+
+	if cs, _ := s.CountRelated(label("Tree"), "NONE"); len(cs) != 5 || cs[0].Count != 0 {
+		t.Errorf("counted %v over a type there are no relationships of", cs)
+	}
+}
+
+// tree stores root -HAS-> c1 -HAS-> c2, root -HAS-> c3, c2 -REFERS-> c3 and root -OTHER-> o -HAS-> c1
+func tree(t *testing.T, s GraphStore, label func(string) string) map[string]Node {
+	n := map[string]Node{}
+	for _, id := range []string{"root", "c1", "c2", "c3", "o"} {
+		n[id] = *mustCreate(t, s, Props{"id": id}, label("Tree"))
+	}
+	for _, r := range [][3]string{{"root", "HAS", "c1"}, {"c1", "HAS", "c2"}, {"root", "HAS", "c3"}, {"c2", "REFERS", "c3"}, {"root", "OTHER", "o"}, {"o", "HAS", "c1"}} {
+		if _, err := s.Relate(n[r[0]].ID, r[1], n[r[2]].ID, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return n
+}
+
+func testSubgraph(t *testing.T, s GraphStore, label func(string) string) {
+	n := tree(t, s, label)
+	g, err := s.Subgraph(n["root"].ID, "HAS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeIds(g.Nodes); !equal(got, []string{"root", "c1", "c2", "c3"}) {
+		t.Errorf("nodes %v, want root, c1, c2 and c3 in creation order", got)
+	}
+	var rels []string
+	for _, r := range g.Relationships {
+		rels = append(rels, r.Type)
+	}
+	if !equal(rels, []string{"HAS", "HAS", "HAS", "REFERS"}) {
+		t.Errorf("relationships %v, want the three HAS and the REFERS between the nodes", rels)
+	}
+
+	g, err = s.Subgraph(n["c1"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeIds(g.Nodes); !equal(got, []string{"c1", "c2", "c3"}) {
+		t.Errorf("nodes over every type %v, want c1, c2 and c3", got)
+	}
+}
+
+func testDeleteSubgraph(t *testing.T, s GraphStore, label func(string) string) {
+	n := tree(t, s, label)
+	deleted, err := s.DeleteSubgraph(n["c1"].ID, "HAS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted %d nodes, want c1 and c2", deleted)
+	}
+	found, _ := s.FindNodes(label("Tree"), nil)
+	if got := nodeIds(found); !sameSet(got, []string{"root", "c3", "o"}) {
+		t.Errorf("left %v, want root, c3 and o", got)
+	}
+	if nb, _ := s.Neighbours(n["c3"].ID); len(nb) != 1 {
+		t.Errorf("c3 still has the relationships %v, want the one from root", nb)
+	}
+
+	if deleted, err := s.DeleteSubgraph(n["c1"].ID, "HAS"); err != nil || deleted != 0 {
+		t.Errorf("deleting again deleted %d: %v", deleted, err)
+	}
+}
+
+func testFilter(t *testing.T, s GraphStore, label func(string) string) {
+	b := NewBatch()
+	ln, _ := b.CreateNode(Props{"id": "L1"}, label("Line"))
+	tr, _ := b.CreateNode(Props{"id": "tr1", "geometry": "LINESTRING (0 0, 10 0)", BBoxProperty: []float64{0, 0, 10, 0}}, label("Track"))
+	sig, _ := b.CreateNode(Props{"id": "sig1", "geometry": "POINT (2 0)", BBoxProperty: []float64{2, 0, 2, 0}}, label("Signal"))
+	sw, _ := b.CreateNode(Props{"id": "sw1", "geometry": "POINT (8 0)"}, label("Switch"))
+	plain, _ := b.CreateNode(Props{"id": "sig2"}, label("Signal"))
+	ocp, _ := b.CreateNode(Props{"id": "ocp1", "geometry": "POINT (20 20)", BBoxProperty: []float64{20, 20, 20, 20}}, label("Ocp"))
+	other, _ := b.CreateNode(Props{"id": "x", "geometry": "POINT (2 0)"}, label("Signal"))
+	b.Relate(ln.ID, "HAS_TRACK", tr.ID, nil)
+	b.Relate(tr.ID, "HAS_ELEMENT", sig.ID, nil)
+	b.Relate(tr.ID, "HAS_ELEMENT", sw.ID, nil)
+	b.Relate(tr.ID, "HAS_ELEMENT", plain.ID, nil)
+	b.Relate(ln.ID, "HAS_OCP", ocp.ID, nil)
+	b.Relate(tr.ID, "OTHER", other.ID, nil)
+	ids := mustWrite(t, s, b)
+
+	tests := []struct {
+		name string
+		f    NodeFilter
+		want []string
+	}{
+		{"all", NodeFilter{}, []string{"tr1", "sig1", "sw1", "sig2", "ocp1"}},
+		{"labels", NodeFilter{Labels: []string{label("Signal"), label("Ocp")}}, []string{"sig1", "sig2", "ocp1"}},
+		{"has", NodeFilter{Has: "geometry"}, []string{"tr1", "sig1", "sw1", "ocp1"}},
+		{"box", NodeFilter{BBox: &[4]float64{1, -1, 3, 1}}, []string{"tr1", "sig1", "sw1", "sig2"}},
+		{"box and has", NodeFilter{Has: "geometry", BBox: &[4]float64{15, 15, 25, 25}}, []string{"sw1", "ocp1"}},
+		{"everything", NodeFilter{Labels: []string{label("Signal")}, Has: "geometry", BBox: &[4]float64{5, -1, 9, 1}}, []string{}},
+	}
+	for _, tt := range tests {
+		fs, err := s.Filter(ids[ln.ID], tt.f, "HAS_TRACK", "HAS_ELEMENT", "HAS_OCP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []Node
+		for _, f := range fs {
+			got = append(got, f.Node)
+		}
+		if !equal(nodeIds(got), tt.want) {
+			t.Errorf("%s: filtered %v, want %v", tt.name, nodeIds(got), tt.want)
+		}
+	}
+
+	fs, err := s.Filter(ids[ln.ID], NodeFilter{Labels: []string{label("Signal"), label("Track"), label("Ocp")}}, "HAS_TRACK", "HAS_ELEMENT", "HAS_OCP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fs {
+		parent, relType := "tr1", "HAS_ELEMENT"
+		switch f.Node.Props["id"] {
+		case "tr1":
+			parent, relType = "L1", "HAS_TRACK"
+		case "ocp1":
+			parent, relType = "L1", "HAS_OCP"
+		}
+		if f.ParentID != parent || f.Relationship.Type != relType || f.Relationship.End != f.Node.ID {
+			t.Errorf("%v is reached over %v from %v, want %s from %s", f.Node.Props["id"], f.Relationship, f.ParentID, relType, parent)
+		}
+	}
+}
+
+// testMeshed reads and deletes a ladder of 40 rungs whose nodes are related to both nodes of the next rung,
+// so that there are 2^40 paths from its root to the last rung. Stores must not enumerate them.
+func testMeshed(t *testing.T, s GraphStore, label func(string) string) {
+	const rungs = 40
+	b := NewBatch()
+	root, _ := b.CreateNode(Props{"id": "root"}, label("Mesh"))
+	prev := []*Node{root}
+	for i := 0; i < rungs; i++ {
+		var rung []*Node
+		for _, side := range []string{"l", "r"} {
+			n, _ := b.CreateNode(Props{"id": side + strconv.Itoa(i)}, label("Mesh"))
+			for _, p := range prev {
+				b.Relate(p.ID, "NEXT", n.ID, nil)
+			}
+			rung = append(rung, n)
+		}
+		prev = rung
+	}
+	ids := mustWrite(t, s, b)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g, err := s.Subgraph(ids[root.ID], "NEXT")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(g.Nodes) != 2*rungs+1 || len(g.Relationships) != 4*rungs-2 {
+			t.Errorf("the subgraph has %d nodes and %d relationships, want %d and %d", len(g.Nodes), len(g.Relationships), 2*rungs+1, 4*rungs-2)
+		}
+		fs, err := s.Filter(ids[root.ID], NodeFilter{}, "NEXT")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(fs) != 2*rungs {
+			t.Errorf("filtered %d nodes, want %d", len(fs), 2*rungs)
+		}
+		if deleted, err := s.DeleteSubgraph(ids[root.ID], "NEXT"); err != nil || deleted != 2*rungs+1 {
+			t.Errorf("deleted %d nodes, want %d: %v", deleted, 2*rungs+1, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("the meshed subgraph wasn't read and deleted within a minute")
+	}
+}
+
+func testWrite(t *testing.T, s GraphStore, label func(string) string) {
+	stored := mustCreate(t, s, Props{"id": "L1", "v": "1"}, label("Line"))
+
+	b := NewBatch()
+	ln, err := b.Existing(*stored, Props{"id": "L1", "v": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, _ := b.CreateNode(Props{"id": "tr1"}, label("Track"))
+	el, _ := b.CreateNode(Props{"id": "sig1"}, label("Signal"), label("Element"))
+	b.Relate(ln.ID, "HAS_TRACK", tr.ID, nil)
+	b.Relate(tr.ID, "HAS_ELEMENT", el.ID, Props{"pos": "5"})
+	ids := mustWrite(t, s, b)
+	if ids[ln.ID] != stored.ID || len(ids) != 3 {
+		t.Errorf("store ids %v, want the stored line and two new nodes", ids)
+	}
+
+	lines, _ := s.FindNodes(label("Line"), nil)
+	if len(lines) != 1 || lines[0].Props["v"] != "2" {
+		t.Errorf("lines %v, want the updated one", lines)
+	}
+	g, err := s.Subgraph(stored.ID, "HAS_TRACK", "HAS_ELEMENT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeIds(g.Nodes); !equal(got, []string{"L1", "tr1", "sig1"}) {
+		t.Errorf("stored %v, want L1, tr1 and sig1", got)
+	}
+	if len(g.Relationships) != 2 || g.Relationships[1].Props["pos"] != "5" {
+		t.Errorf("stored relationships %v", g.Relationships)
+	}
+	if !g.Nodes[2].HasLabel(label("Signal")) || !g.Nodes[2].HasLabel(label("Element")) {
+		t.Errorf("stored the labels %v", g.Nodes[2].Labels)
+	}
+
+	if err := s.Write(NewBatch()); err != nil {
+		t.Errorf("writing an empty batch: %v", err)
+	}
+}
+
+func testWriteDeletes(t *testing.T, s GraphStore, label func(string) string) {
+	n := tree(t, s, label)
+	single := mustCreate(t, s, Props{"id": "single"}, label("Tree"))
+	nb, _ := s.Neighbours(n["root"].ID, "OTHER")
+	if len(nb) != 1 {
+		t.Fatalf("root has the others %v", nb)
+	}
+
+	b := NewBatch()
+	b.DeleteTree(n["c1"].ID, "HAS")
+	b.Delete(single.ID)
+	b.DeleteRelationship(nb[0].Relationship.ID)
+	root, _ := b.Existing(n["root"], nil)
+	c4, _ := b.CreateNode(Props{"id": "c4"}, label("Tree"))
+	b.Relate(root.ID, "HAS", c4.ID, nil)
+	mustWrite(t, s, b)
+
+	found, _ := s.FindNodes(label("Tree"), nil)
+	if got := nodeIds(found); !sameSet(got, []string{"root", "c3", "o", "c4"}) {
+		t.Errorf("left %v, want root, c3, o and c4", got)
+	}
+	if nb, _ := s.Neighbours(n["c3"].ID, "REFERS"); len(nb) != 0 {
+		t.Errorf("c3 is still referred to by %v", nb)
+	}
+	if nb, _ := s.Neighbours(n["o"].ID); len(nb) != 0 {
+		t.Errorf("o is still related to %v", nb)
+	}
+	if nb, _ := s.Neighbours(n["root"].ID, "HAS"); len(nb) != 2 {
+		t.Errorf("root has %v, want c3 and c4", nb)
+	}
+}
+
+func testUnique(t *testing.T, s GraphStore, label func(string) string) {
+	l := label("Unique")
+	if err := s.Unique(l, "id"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unique(l, "id"); err != nil {
+		t.Fatalf("making it unique again: %v", err)
+	}
+	a := mustCreate(t, s, Props{"id": "a"}, l)
+	mustCreate(t, s, Props{"id": "a"}, label("NotUnique"))
+
+	if _, err := s.CreateNode(Props{"id": "a"}, l); !isConflict(err) {
+		t.Errorf("creating a second a: %v, want a ConflictError", err)
+	}
+
+	b := NewBatch()
+	b.CreateNode(Props{"id": "b"}, l)
+	b.CreateNode(Props{"id": "b"}, l)
+	if err := s.Write(b); !isConflict(err) {
+		t.Errorf("writing two b: %v, want a ConflictError", err)
+	}
+
+	// a node that gets another value frees its old one in the same write
+	b = NewBatch()
+	b.Existing(*a, Props{"id": "a2"})
+	b.CreateNode(Props{"id": "a"}, l)
+	mustWrite(t, s, b)
+	found, _ := s.FindNodes(l, nil)
+	if got := nodeIds(found); !sameSet(got, []string{"a", "a2"}) {
+		t.Errorf("stored %v, want a and a2", got)
+	}
+
+	// and so does a node that is deleted
+	b = NewBatch()
+	b.Delete(a.ID)
+	b.CreateNode(Props{"id": "a2"}, l)
+	mustWrite(t, s, b)
+	found, _ = s.FindNodes(l, Props{"id": "a2"})
+	if len(found) != 1 || found[0].ID == a.ID {
+		t.Errorf("stored %v, want the new a2", found)
+	}
+}
+
+func testRollbackOnConflict(t *testing.T, s GraphStore, label func(string) string) {
+	l := label("Unique")
+	if err := s.Unique(l, "id"); err != nil {
+		t.Fatal(err)
+	}
+	a := mustCreate(t, s, Props{"id": "a", "v": "1"}, l)
+	other := mustCreate(t, s, Props{"id": "other"}, label("Other"))
+
+	b := NewBatch()
+	b.Existing(*a, Props{"id": "a", "v": "2"})
+	b.Delete(other.ID)
+	c, _ := b.CreateNode(Props{"id": "c"}, l)
+	dup, _ := b.CreateNode(Props{"id": "a"}, l)
+	b.Relate(c.ID, "LINKS", dup.ID, nil)
+	if err := s.Write(b); !isConflict(err) {
+		t.Fatalf("writing a second a: %v, want a ConflictError", err)
+	}
+
+	found, _ := s.FindNodes(l, nil)
+	if len(found) != 1 || found[0].Props["v"] != "1" {
+		t.Errorf("stored %v, want a as it was", found)
+	}
+	if found, _ := s.FindNodes(label("Other"), nil); len(found) != 1 {
+		t.Errorf("deleted the other node")
+	}
+}
+
+func testTransaction(t *testing.T, s GraphStore, label func(string) string) {
+	tx, err := s.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	b := NewBatch()
+	ln, _ := b.CreateNode(Props{"id": "L1"}, label("Line"))
+	ids, err := tx.Write(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := s.FindNodes(label("Line"), nil); len(found) != 0 {
+		t.Errorf("%v can be seen before the commit", found)
+	}
+
+	// a later batch refers to the line by the id the first one got
+	b = NewBatch()
+	stored, _ := b.Existing(Node{ID: ids[ln.ID], Labels: []string{label("Line")}, Props: Props{"id": "L1"}}, nil)
+	tr, _ := b.CreateNode(Props{"id": "tr1"}, label("Track"))
+	b.Relate(stored.ID, "HAS_TRACK", tr.ID, nil)
+	more, err := tx.Write(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if more[stored.ID] != ids[ln.ID] {
+		t.Errorf("the line has the id %d in the second batch, want %d", more[stored.ID], ids[ln.ID])
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := s.Subgraph(ids[ln.ID], "HAS_TRACK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeIds(g.Nodes); !equal(got, []string{"L1", "tr1"}) {
+		t.Errorf("committed %v, want L1 and tr1", got)
+	}
+
+	if _, err := tx.Write(NewBatch()); err == nil {
+		t.Error("wrote to a committed transaction")
+	}
+	if err := tx.Commit(); err == nil {
+		t.Error("committed a transaction twice")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("rolling back a committed transaction: %v", err)
+	}
+}
+
+func testTransactionRollback(t *testing.T, s GraphStore, label func(string) string) {
+	stored := mustCreate(t, s, Props{"id": "L1", "v": "1"}, label("Line"))
+	tx, err := s.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBatch()
+	b.Existing(*stored, Props{"id": "L1", "v": "2"})
+	b.CreateNode(Props{"id": "tr1"}, label("Track"))
+	if _, err := tx.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	b = NewBatch()
+	b.Delete(stored.ID)
+	if _, err := tx.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := s.FindNodes(label("Line"), nil); len(found) != 1 || found[0].Props["v"] != "1" {
+		t.Errorf("lines %v, want L1 as it was", found)
+	}
+	if found, _ := s.FindNodes(label("Track"), nil); len(found) != 0 {
+		t.Errorf("stored %v after the rollback", found)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Error("committed a rolled back transaction")
+	}
+}
+
+func TestNodeFilterSelects(t *testing.T) {
+	box := &[4]float64{0, 0, 10, 10}
+	tests := []struct {
+		name  string
+		f     NodeFilter
+		props Props
+		want  bool
+	}{
+		{"no filter", NodeFilter{}, Props{}, true},
+		{"has", NodeFilter{Has: "geometry"}, Props{"geometry": "POINT (1 1)"}, true},
+		{"has not", NodeFilter{Has: "geometry"}, Props{}, false},
+		{"inside", NodeFilter{BBox: box}, Props{BBoxProperty: []float64{1, 1, 2, 2}}, true},
+		{"overlaps", NodeFilter{BBox: box}, Props{BBoxProperty: []float64{-5, -5, 0, 0}}, true},
+		{"around", NodeFilter{BBox: box}, Props{BBoxProperty: []float64{-5, -5, 15, 15}}, true},
+		{"beside", NodeFilter{BBox: box}, Props{BBoxProperty: []float64{11, 0, 12, 10}}, false},
+		{"below", NodeFilter{BBox: box}, Props{BBoxProperty: []float64{0, -3, 10, -1}}, false},
+		{"read from Neo4j", NodeFilter{BBox: box}, Props{BBoxProperty: []interface{}{1.0, 1.0, 2.0, 2.0}}, true},
+		{"read from Neo4j, beside", NodeFilter{BBox: box}, Props{BBoxProperty: []interface{}{11.0, 1.0, 12.0, 2.0}}, false},
+		{"without a box", NodeFilter{BBox: box}, Props{}, true},
+		{"malformed box", NodeFilter{BBox: box}, Props{BBoxProperty: []float64{11, 11}}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.f.selects(&Node{Props: tt.props}); got != tt.want {
+			t.Errorf("%s: selects %v = %v, want %v", tt.name, tt.props, got, tt.want)
+		}
+	}
+
+	f := NodeFilter{Labels: []string{"Signal", "Switch"}}
+	if !f.selects(&Node{Labels: []string{"Element", "Switch"}}) || f.selects(&Node{Labels: []string{"Track"}}) {
+		t.Error("the labels don't select nodes with one of them")
+	}
+}
+
+func mustCreate(t *testing.T, s GraphStore, props Props, labels ...string) *Node {
+	n, err := s.CreateNode(props, labels...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func mustWrite(t *testing.T, s GraphStore, b *Batch) map[int]int {
+	tx, err := s.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := tx.Write(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func isConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// nodeIds returns the id properties of the nodes
+func nodeIds(nodes []Node) []string {
+	ids := []string{}
+	for _, n := range nodes {
+		id, _ := n.Props["id"].(string)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameSet(a, b []string) bool {
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return equal(a, b)
+}
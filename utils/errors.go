@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/beevik/etree"
+)
+
+// ElementError tells which RailML element could not be converted and why.
+type ElementError struct {
+	Path string // absolute path of the element, e.g. /railml/infrastructure/tracks/track
+	ID   string // id attribute of the element, empty if it has none
+	Line int    // line of the element in the source file, 0 if unknown
+	Err  error
+
+	element *etree.Element
+}
+
+func (e *ElementError) Error() string {
+	msg := e.Path
+	if e.ID != "" {
+		msg += "[@id='" + e.ID + "']"
+	}
+	if e.Line > 0 {
+		msg += fmt.Sprintf(" (line %d)", e.Line)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+// Locate looks up the line of the failing element in the given line index.
+func (e *ElementError) Locate(lines Lines) {
+	if l, ok := lines[e.element]; ok {
+		e.Line = l
+	}
+}
+
+// elementError wraps err with the element that caused it.
+// Errors that already point to an element are returned as they are, the innermost element is the most precise.
+func elementError(e *etree.Element, err error) error {
+	if _, ok := err.(*ElementError); ok {
+		return err
+	}
+	return &ElementError{
+		Path:    e.GetPath(),
+		ID:      e.SelectAttrValue("id", ""),
+		Err:     err,
+		element: e,
+	}
+}
+
+// elementErrorf creates a new ElementError for the given element
+func elementErrorf(e *etree.Element, format string, a ...interface{}) error {
+	return elementError(e, fmt.Errorf(format, a...))
+}
+
+// CRSError tells that coordinates can't be transformed to or from the given CRS.
+type CRSError struct {
+	Epsg string
+	Err  error
+}
+
+func (e *CRSError) Error() string {
+	return fmt.Sprintf("cannot transform coordinates to or from epsg:%s: %v", e.Epsg, e.Err)
+}
+
+// Lines maps the elements of a parsed document to the line they start at in the source file.
+type Lines map[*etree.Element]int
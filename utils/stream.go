@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+
+	"github.com/beevik/etree"
+)
+
+// ElementStream reads a RailML file token by token and hands the elements it is asked for over, with all their
+// children, as soon as their end tag has been read. Apart from those, only the start tags of their ancestors are
+// kept in memory, so files of any size can be read.
+type ElementStream struct {
+	// Root is called with the root element, without its children, before any handler.
+	Root func(root *etree.Element) error
+	// Handlers are called with the elements of the tag, or of the "parent/tag", they are registered for. Tags are
+	// local names in Namespace, whatever prefix the file binds it to, so that extension elements of another namespace
+	// with the same local name aren't handled. Elements inside a handled element aren't handled again.
+	Handlers map[string]Handler
+	// Namespace is the namespace of the handled elements, the one of the root element if it is empty.
+	Namespace string
+	// Progress is called after every handled element with the number of bytes read so far.
+	Progress func(read int64)
+}
+
+// Handler gets a whole element together with the lines its descendants start at in the file. The element is attached
+// to copies of its ancestors, so that paths in errors are the same as in the whole document, and the handler may
+// keep it after it returned, for example to convert it on another goroutine.
+type Handler func(e *etree.Element, lines Lines) error
+
+// Read streams r through the handlers and returns the first error of a handler, with the line of the failing
+// element if it is an ElementError. Malformed XML fails with an *xml.SyntaxError.
+func (s *ElementStream) Read(r io.Reader) error {
+	cr := &countingReader{r: bufio.NewReader(r), line: 1}
+	dec := xml.NewDecoder(cr)
+
+	doc := etree.NewDocument()
+	var open []*etree.Element // the element tokens go to is the last one
+	var handled *etree.Element
+	var handler Handler
+	var lines Lines
+	ns := s.Namespace
+
+	for {
+		line := cr.line // the decoder reads no further than the start of the next token
+		t, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*xml.SyntaxError); !ok { // e.g. an unsupported encoding
+				err = &xml.SyntaxError{Msg: err.Error(), Line: line}
+			}
+			return err
+		}
+
+		parent := &doc.Element
+		if len(open) > 0 {
+			parent = open[len(open)-1]
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if len(open) == 0 && doc.Root() != nil {
+				return &xml.SyntaxError{Msg: "more than one root element", Line: line}
+			}
+			e := parent.CreateElement(qualifiedName(t.Name))
+			for _, a := range t.Attr {
+				e.CreateAttr(qualifiedName(a.Name), a.Value)
+			}
+			open = append(open, e)
+
+			if len(open) == 1 {
+				if ns == "" {
+					ns = e.NamespaceURI()
+				}
+				if s.Root != nil {
+					if err := s.Root(e); err != nil {
+						return err
+					}
+				}
+			}
+			if handled == nil {
+				if handler = s.handler(ns, parent, e); handler != nil {
+					handled = e
+					lines = Lines{}
+				}
+			}
+			if handled != nil {
+				lines[e] = line
+			}
+		case xml.EndElement:
+			if len(open) == 0 {
+				return &xml.SyntaxError{Msg: "unexpected end element </" + qualifiedName(t.Name) + ">", Line: line}
+			}
+			e := open[len(open)-1]
+			if e.FullTag() != qualifiedName(t.Name) {
+				return &xml.SyntaxError{Msg: "element <" + e.FullTag() + "> closed by </" + qualifiedName(t.Name) + ">", Line: line}
+			}
+			open = open[:len(open)-1]
+			if len(open) > 0 {
+				parent = open[len(open)-1]
+			} else {
+				parent = &doc.Element
+			}
+			if e == handled {
+				detach(e)
+				if err := handler(e, lines); err != nil {
+					if ee, ok := err.(*ElementError); ok {
+						ee.Locate(lines)
+					}
+					return err
+				}
+				handled, lines = nil, nil
+				if s.Progress != nil {
+					s.Progress(cr.read)
+				}
+			} else if handled == nil && e != doc.Root() {
+				parent.RemoveChild(e) // done with it, only the root is kept
+			}
+		case xml.CharData:
+			if handled != nil {
+				parent.CreateCharData(string(t))
+			}
+		case xml.Comment:
+			if handled != nil {
+				parent.CreateComment(string(t))
+			}
+		case xml.ProcInst:
+			if handled != nil {
+				parent.CreateProcInst(t.Target, string(t.Inst))
+			}
+		case xml.Directive:
+			if handled != nil {
+				parent.CreateDirective(string(t))
+			}
+		}
+	}
+
+	if len(open) > 0 {
+		return &xml.SyntaxError{Msg: "unexpected EOF, <" + open[len(open)-1].FullTag() + "> isn't closed", Line: cr.line}
+	}
+	if doc.Root() == nil {
+		return &xml.SyntaxError{Msg: "no root element", Line: cr.line}
+	}
+	if s.Progress != nil {
+		s.Progress(cr.read)
+	}
+	return nil
+}
+
+// detach moves the element from the document being read to copies of its ancestors
+func detach(e *etree.Element) {
+	var ancestors []*etree.Element
+	for a := e.Parent(); a != nil && a.Tag != ""; a = a.Parent() {
+		ancestors = append(ancestors, a)
+	}
+
+	var parent *etree.Element
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		c := etree.NewElement(ancestors[i].FullTag())
+		for _, a := range ancestors[i].Attr {
+			c.CreateAttr(a.FullKey(), a.Value)
+		}
+		if parent != nil {
+			parent.AddChild(c)
+		}
+		parent = c
+	}
+	if parent != nil {
+		parent.AddChild(e)
+	}
+}
+
+// handler returns the handler registered for the element, nil if there is none or the element isn't in namespace ns.
+// The prefixes are resolved against the ancestors of the element, which are still attached to it.
+func (s *ElementStream) handler(ns string, parent *etree.Element, e *etree.Element) Handler {
+	if e.NamespaceURI() != ns {
+		return nil
+	}
+	if parent.Tag != "" && parent.NamespaceURI() == ns {
+		if h, ok := s.Handlers[parent.Tag+"/"+e.Tag]; ok {
+			return h
+		}
+	}
+	return s.Handlers[e.Tag]
+}
+
+// qualifiedName returns the name with its namespace prefix, as etree expects it
+func qualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// countingReader counts the bytes and lines the XML decoder has read. Being an io.ByteReader,
+// the decoder reads from it byte by byte instead of through a buffer of its own.
+type countingReader struct {
+	r    *bufio.Reader
+	read int64
+	line int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	for _, b := range p[:n] {
+		if b == '\n' {
+			cr.line++
+		}
+	}
+	cr.read += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.read++
+		if b == '\n' {
+			cr.line++
+		}
+	}
+	return b, err
+}
+
+// CountElements counts the elements with the given tag in r, without building any of them.
+func CountElements(r io.Reader, tag string) (int, error) {
+	dec := xml.NewDecoder(bufio.NewReader(r))
+	n := 0
+	for {
+		t, err := dec.RawToken()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if se, ok := t.(xml.StartElement); ok && se.Name.Local == tag {
+			n++
+		}
+	}
+}
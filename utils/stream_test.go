@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+)
+
+const streamed = `<?xml version="1.0" encoding="UTF-8"?>
+<railml xmlns="http://www.railml.org/schemas/2013" version="2.2">
+  <metadata><name>x</name></metadata>
+  <infrastructure id="inf">
+    <tracks>
+      <track id="tr1">
+        <trackTopology>
+          <connections><track id="nested"/></connections>
+        </trackTopology>
+      </track>
+      <track id="tr2"/>
+    </tracks>
+  </infrastructure>
+</railml>`
+
+// handled records the ids, paths and lines of the elements handed over to it
+type handled struct {
+	ids, paths []string
+	lines      []int
+}
+
+func (h *handled) handle(e *etree.Element, lines Lines) error {
+	h.ids = append(h.ids, e.SelectAttrValue("id", e.Tag))
+	h.paths = append(h.paths, e.GetPath())
+	h.lines = append(h.lines, lines[e])
+	return nil
+}
+
+func TestElementStream(t *testing.T) {
+	var tracks, metadata handled
+	var root string
+	var progress []int64
+	s := ElementStream{
+		Root: func(r *etree.Element) error {
+			if len(tracks.ids) > 0 || len(metadata.ids) > 0 || len(r.ChildElements()) > 0 {
+				t.Error("the root is handed over after its children")
+			}
+			root = r.SelectAttrValue("version", "")
+			return nil
+		},
+		Handlers: map[string]Handler{
+			"track":           tracks.handle,
+			"railml/metadata": metadata.handle,
+			"metadata":        func(*etree.Element, Lines) error { return errors.New("the handler of the parent/tag counts first") },
+		},
+		Progress: func(read int64) { progress = append(progress, read) },
+	}
+	if err := s.Read(strings.NewReader(streamed)); err != nil {
+		t.Fatal(err)
+	}
+
+	if root != "2.2" {
+		t.Errorf("the root has version %q", root)
+	}
+	// the nested track is part of tr1, not handled on its own
+	if want := []string{"tr1", "tr2"}; !reflect.DeepEqual(tracks.ids, want) {
+		t.Errorf("the handled tracks are %v, want %v", tracks.ids, want)
+	}
+	if want := []string{"/railml/infrastructure/tracks/track", "/railml/infrastructure/tracks/track"}; !reflect.DeepEqual(tracks.paths, want) {
+		t.Errorf("the tracks are at %v, want %v", tracks.paths, want)
+	}
+	if want := []int{6, 11}; !reflect.DeepEqual(tracks.lines, want) {
+		t.Errorf("the tracks start at the lines %v, want %v", tracks.lines, want)
+	}
+	if want := []string{"metadata"}; !reflect.DeepEqual(metadata.ids, want) {
+		t.Errorf("the handled metadata are %v, want %v", metadata.ids, want)
+	}
+	if len(progress) != 4 || progress[3] != int64(len(streamed)) {
+		t.Errorf("the progress is %v, want 4 calls ending at %d", progress, len(streamed))
+	}
+}
+
+func TestElementStreamLines(t *testing.T) {
+	var e *etree.Element
+	var lines Lines
+	s := ElementStream{Handlers: map[string]Handler{
+		"track": func(tr *etree.Element, l Lines) error {
+			e, lines = tr, l
+			return nil
+		},
+	}}
+	if err := s.Read(strings.NewReader(streamed)); err != nil {
+		t.Fatal(err)
+	}
+	// the lines are the ones of tr2, handled last
+	if len(lines) != 1 || lines[e] != 11 {
+		t.Errorf("the lines of the last track are %v", lines)
+	}
+
+	s.Handlers["track"] = func(tr *etree.Element, l Lines) error {
+		if tr.SelectAttrValue("id", "") != "tr1" {
+			return nil
+		}
+		want := map[string]int{"tr1": 6, "trackTopology": 7, "connections": 8, "nested": 8}
+		got := map[string]int{}
+		for d, line := range l {
+			got[d.SelectAttrValue("id", d.Tag)] = line
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("the elements of tr1 start at the lines %v, want %v", got, want)
+		}
+		return elementError(tr.FindElement("trackTopology/connections/track"), errors.New("failed"))
+	}
+	err := s.Read(strings.NewReader(streamed))
+	if ee, ok := err.(*ElementError); !ok || ee.Line != 8 || ee.ID != "nested" {
+		t.Errorf("the error of the handler is %v, want it at the nested track on line 8", err)
+	}
+}
+
+func TestElementStreamNamespaces(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		ns   string
+		want []string
+	}{
+		{"default namespace", `<railml xmlns="urn:rail"><track id="a"/><tracks><track id="b"/></tracks></railml>`, "", []string{"a", "b"}},
+		{"prefixed namespace", `<rail:railml xmlns:rail="urn:rail"><rail:track id="a"/><rail:tracks><rail:track id="b"/></rail:tracks></rail:railml>`, "", []string{"a", "b"}},
+		{"prefix bound on the element", `<railml xmlns="urn:rail"><r:track xmlns:r="urn:rail" id="a"/></railml>`, "", []string{"a"}},
+		{"extension with the same local name", `<railml xmlns="urn:rail" xmlns:ext="urn:ext"><ext:track id="a"/><track id="b"/></railml>`, "", []string{"b"}},
+		{"default namespace changed on the element", `<railml xmlns="urn:rail"><track xmlns="urn:ext" id="a"/><track id="b"/></railml>`, "", []string{"b"}},
+		{"parent/tag of another namespace", `<railml xmlns="urn:rail" xmlns:ext="urn:ext"><ext:tracks><ocp id="a"/></ext:tracks><tracks><ocp id="b"/></tracks></railml>`, "", []string{"b"}},
+		{"namespace given", `<railml xmlns="urn:rail" xmlns:ext="urn:ext"><ext:track id="a"/><track id="b"/></railml>`, "urn:ext", []string{"a"}},
+	}
+	for _, tt := range tests {
+		var h handled
+		s := ElementStream{Namespace: tt.ns, Handlers: map[string]Handler{"track": h.handle, "tracks/ocp": h.handle}}
+		if err := s.Read(strings.NewReader(tt.xml)); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(h.ids, tt.want) {
+			t.Errorf("%s: handled %v, want %v", tt.name, h.ids, tt.want)
+		}
+	}
+}
+
+func TestElementStreamMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		line int
+		want string
+	}{
+		{"empty", "", 1, "no root element"},
+		{"unclosed", "<railml>\n<track id=\"a\"/>\n", 3, "<railml> isn't closed"},
+		{"mismatched end", "<railml>\n<tracks>\n</track>\n</railml>", 3, "element <tracks> closed by </track>"},
+		{"end without start", "<railml/>\n</railml>", 2, "unexpected end element </railml>"},
+		{"two roots", "<railml/>\n<railml/>", 2, "more than one root element"},
+		{"broken attribute", "<railml>\n<track id=a/>\n</railml>", 2, "unquoted or missing attribute value"},
+		{"unsupported encoding", `<?xml version="1.0" encoding="EBCDIC"?><railml/>`, 1, "EBCDIC"},
+	}
+	for _, tt := range tests {
+		var h handled
+		s := ElementStream{Handlers: map[string]Handler{"track": h.handle}}
+		err := s.Read(strings.NewReader(tt.xml))
+		se, ok := err.(*xml.SyntaxError)
+		if !ok {
+			t.Errorf("%s: %v, want an *xml.SyntaxError", tt.name, err)
+			continue
+		}
+		if se.Line != tt.line || !strings.Contains(se.Msg, tt.want) {
+			t.Errorf("%s: %q on line %d, want %q on line %d", tt.name, se.Msg, se.Line, tt.want, tt.line)
+		}
+	}
+}
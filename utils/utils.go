@@ -2,35 +2,71 @@ package utils
 
 import (
 	"bytes"
+	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
 
+	"Go-GoSAFE.converter/crs"
+	"Go-GoSAFE.converter/store"
+
 	"github.com/beevik/etree"
-	"github.com/jmcvetta/neoism"
-	"github.com/pebbe/go-proj-4/proj"
 )
 
 // Unknown is a simple helper
 const Unknown = "unknown"
 
+// Fragments is the property that keeps child elements the converter doesn't know, like <additionalName /> or
+// elements from railML extensions, as XML so that they can be exported again. It can't clash with an XML attribute,
+// like no other property that starts with #.
+const Fragments = "#fragments"
+
+// ContainerFragments returns the property of a track that keeps the child elements the converter doesn't know of
+// one of its containers, e.g. of <trackElements /> or <signals />, which have no node of their own.
+func ContainerFragments(tag string) string {
+	return Fragments + ":" + tag
+}
+
+// GeoMappingIds is the property of a track that keeps the ids of its <geoMapping />s, separated by spaces
+const GeoMappingIds = "#geoMappingIds"
+
+// Infrastructure is the prefix of the properties of a line that keep the attributes of its <infrastructure />,
+// e.g. #infrastructure:id
+const Infrastructure = "#infrastructure:"
+
+// Metadata is the prefix of the properties of a line that keep the <metadata /> of its file, its attributes and
+// its child elements as Fragments
+const Metadata = "#metadata:"
+
+// singular is the tag of the elements of a container, if it isn't the tag of the container without the s
+var singular = map[string]string{"bridges": "brigde"}
+
+// elementTag returns the tag of the elements of the container with the given tag, e.g. signal for signals
+func elementTag(container string) string {
+	if t, ok := singular[container]; ok {
+		return t
+	}
+	return strings.TrimSuffix(container, "s")
+}
+
 // Edge represents <trackEnd /> or <trackBegin /> of the particular track.
 type Edge struct {
 	Label      string
-	Properties neoism.Props
-	Container  neoism.Props
+	Properties store.Props
+	Container  store.Props
 }
 
 // Switch structure extracted from the <connections /> container
 type Switch struct {
-	Properties neoism.Props
-	Connection neoism.Props
+	Properties  store.Props
+	Connections []store.Props
 }
 
 // Crossing structure extracted from the <connections /> container
 type Crossing struct {
-	Properties neoism.Props
-	Connection neoism.Props
+	Properties  store.Props
+	Connections []store.Props
 }
 
 // TrackTopologies structure represents complete <trackTopology /> section.
@@ -39,135 +75,324 @@ type TrackTopologies struct {
 	End            Edge
 	Switch         []Switch
 	Crossing       []Crossing
-	CrossSection   []neoism.Props // TODO
-	MileageChanges []neoism.Props // TODO
+	CrossSection   []store.Props
+	MileageChanges []store.Props
 }
 
 // TrackElements structure represents complete <trackElements /> section.
 // Only <geoMappings /> from this section are not here because they belong directly to the track node.
 type TrackElements struct {
-	AxleWeightChanges        []neoism.Props
-	Bridges                  []neoism.Props
-	ClearanceGaugeChanges    []neoism.Props
-	ElectrificationChanges   []neoism.Props
-	GaugeChanges             []neoism.Props
-	GradientChanges          []neoism.Props
-	LevelCrossings           []neoism.Props
-	OperationModeChanges     []neoism.Props
-	OwnerChanges             []neoism.Props
-	PlatformEdges            []neoism.Props
-	PowerTransmissionChanges []neoism.Props
-	RadiusChanges            []neoism.Props
-	ServiceSections          []neoism.Props
-	SpeedChanges             []neoism.Props
-	TrackConditions          []neoism.Props
-	TrainProtectionChanges   []neoism.Props
-	Tunnels                  []neoism.Props
+	AxleWeightChanges        []store.Props
+	Bridges                  []store.Props
+	ClearanceGaugeChanges    []store.Props
+	ElectrificationChanges   []store.Props
+	GaugeChanges             []store.Props
+	GradientChanges          []store.Props
+	LevelCrossings           []store.Props
+	OperationModeChanges     []store.Props
+	OwnerChanges             []store.Props
+	PlatformEdges            []store.Props
+	PowerTransmissionChanges []store.Props
+	RadiusChanges            []store.Props
+	ServiceSections          []store.Props
+	SpeedChanges             []store.Props
+	TrackConditions          []store.Props
+	TrainProtectionChanges   []store.Props
+	Tunnels                  []store.Props
 }
 
 // OCSElements structure represents complete <ocsElements /> section.
 type OCSElements struct {
-	Signals                 []neoism.Props
-	TrainDetectionElements  []neoism.Props // TODO
-	Balises                 []neoism.Props
-	TrainProtectionElements []neoism.Props
-	StopPosts               []neoism.Props
-	Derailers               []neoism.Props
-	TrainRadioChanges       []neoism.Props
+	Signals                 []store.Props
+	TrainDetectors          []store.Props // <trainDetectionElements /> are split by their child type
+	TrackCircuitBorders     []store.Props
+	Balises                 []store.Props
+	TrainProtectionElements []store.Props
+	StopPosts               []store.Props
+	Derailers               []store.Props
+	TrainRadioChanges       []store.Props
 }
 
 // InfraAttributes structure represents complete <infraAttributes /> section.
 type InfraAttributes struct {
 	ID                string
-	AxleWeight        neoism.Props
-	Electrification   neoism.Props
-	EpsgCode          neoism.Props
-	Gauge             neoism.Props
-	ClearanceGauge    neoism.Props
-	OperationMode     neoism.Props
-	Owner             neoism.Props
-	PowerTransmission neoism.Props
-	Speeds            []neoism.Props
-	TrainRadio        neoism.Props
-	TrainProtection   neoism.Props
-	//GeneralInfraAttributes neoism.Props // TODO later
-}
-
-var wgs84, _ = proj.NewProj("+init=epsg:4326")
-
-// Converts <geoMapings /> section to the WKTLinestring.
-// If there are no coordinates, returns "unknown" string.
-// If epsg is different than 4326 (wgs84), coords are transformed
-func toWKTLinestring(t *etree.Element, epsg string) string {
+	AxleWeight        store.Props
+	Electrification   store.Props
+	EpsgCode          store.Props
+	Gauge             store.Props
+	ClearanceGauge    store.Props
+	OperationMode     store.Props
+	Owner             store.Props
+	PowerTransmission store.Props
+	Speeds            []store.Props
+	TrainRadio        store.Props
+	TrainProtection   store.Props
+	// <generalInfraAttributes /> is deeply nested, so it is kept as a tree
+	GeneralInfraAttributes *Nested
+	// the child elements the converter doesn't know of <infraAttributes /> and <speeds />, see Fragments
+	Fragments       string
+	SpeedsFragments string
+}
 
-	projection, err := proj.NewProj("+init=epsg:" + epsg)
-	defer projection.Close()
-	if err != nil {
-		panic(err)
-	}
+// Nested structure represents an element together with all its child elements, for sections without a flat structure.
+// The label is the capitalized tag name.
+type Nested struct {
+	Label      string
+	Properties store.Props
+	Children   []Nested
+}
 
-	var coordsArray bytes.Buffer
+// Ocp structure represents a single <ocp /> (operational or control point) from the <operationControlPoints /> section.
+type Ocp struct {
+	Properties      store.Props
+	PropOperational store.Props
+	PropService     store.Props
+}
+
+// Converts <geoMapings /> section to the WKTLinestring, a LINESTRING Z if the coords have heights.
+// If there are no coordinates, returns "unknown" string.
+// Coords are read in the axis order of their CRS, see coordTransformer, and transformed to wgs84 longitude, latitude.
+func toWKTLinestring(t *etree.Element, epsg string) (string, error) {
+
+	var coords []string
+	var points [][]float64
 	for _, g := range t.FindElements("trackElements/geoMappings/geoMapping/geoCoord") {
 		c := g.SelectAttrValue("coord", Unknown)
 		if c != Unknown {
-			if epsg != "4326" {
-				s := strings.Split(c, " ")
-				x, _ := strconv.ParseFloat(s[0], 64)
-				y, _ := strconv.ParseFloat(s[1], 64)
-				xt, yt, _ := proj.Transform2(projection, wgs84, x, y)
-				xts := strconv.FormatFloat(proj.RadToDeg(xt), 'f', 6, 64)
-				yts := strconv.FormatFloat(proj.RadToDeg(yt), 'f', 6, 64)
-				ct := xts + " " + yts
-				coordsArray.WriteString(ct + ",")
-			} else {
-				coordsArray.WriteString(c + ",")
+			transformer, err := coordTransformer(g, epsg)
+			if err != nil {
+				return Unknown, err
+			}
+			p, err := transformCoord(transformer, c)
+			if err != nil {
+				return Unknown, elementError(g, err)
 			}
+			if len(points) > 0 && len(p) != len(points[0]) {
+				return Unknown, elementErrorf(g, "coord %q has %d dimensions, the previous coords of the track have %d", c, len(p), len(points[0]))
+			}
+			points = append(points, p)
+			coords = append(coords, formatCoords([][]float64{p}, wktPrecision(transformer))[0]) // a track may mix CRSs
+		}
+	}
+
+	if len(coords) > 0 {
+		kind := "LINESTRING"
+		if len(points[0]) == 3 {
+			kind += " Z "
 		}
+		return kind + "(" + strings.Join(coords, ",") + ")", nil
 	}
 
-	cas := coordsArray.String()
+	return "unknown", nil
+}
 
-	if len(cas) > 0 {
-		wkt := "LINESTRING(" + cas[:len(cas)-1] + ")"
+// Converts <geoCoord /> section to the WKTPoint, a POINT Z if the coord has a height.
+// If there are no coordinates, returns "unknown" string.
+// The coord is read in the axis order of its CRS, see coordTransformer, and transformed to wgs84 longitude, latitude.
+func toWKTPoint(e *etree.Element, epsg string) (string, error) {
 
-		return wkt
+	c := e.SelectAttrValue("coord", Unknown)
+	if c != Unknown {
+		transformer, err := coordTransformer(e, epsg)
+		if err != nil {
+			return Unknown, err
+		}
+		p, err := transformCoord(transformer, c)
+		if err != nil {
+			return Unknown, elementError(e, err)
+		}
+		return toWKT("POINT", [][]float64{p}, wktPrecision(transformer)), nil
 	}
 
-	return "unknown"
+	return c, nil
 }
 
-// Converts <geoCoord /> section to the WKTPoint.
-// If there are no coordinates, returns "unknown" string.
-// If epsg is different than 4326 (wgs84), coords are transformed
-func toWKTPoint(e *etree.Element, epsg string) string {
+// coordTransformer returns the transformer to wgs84 for a <geoCoord />. Its CRS is the one of its own epsgCode,
+// else the given default of the track or file, which is "" if neither the file nor the import request declare one.
+func coordTransformer(g *etree.Element, epsg string) (*crs.Transformer, error) {
+	if code := g.SelectAttrValue("epsgCode", ""); code != "" {
+		epsg = NormalizeEpsg(code)
+	}
+	if epsg == "" {
+		return nil, elementErrorf(g, "the CRS of coord %q is unknown: the geoCoord has no epsgCode, "+
+			"there is no <epsgCode default=\"...\"/> in the infraAttributes and no epsg was given", g.SelectAttrValue("coord", ""))
+	}
+	transformer, err := crs.NewTransformer(epsg, "4326")
+	if err != nil {
+		return nil, elementError(g, err)
+	}
+	return transformer, nil
+}
 
-	projection, err := proj.NewProj("+init=epsg:" + epsg)
-	defer projection.Close()
+// NormalizeEpsg returns the code of an epsgCode attribute, "EPSG:2180" and "urn:ogc:def:crs:EPSG::2180" are read as "2180".
+func NormalizeEpsg(code string) string {
+	if i := strings.LastIndex(code, ":"); i >= 0 {
+		code = code[i+1:]
+	}
+	return strings.TrimSpace(code)
+}
+
+// CRSDefaults are the CRSs of coords without an epsgCode of their own.
+type CRSDefaults struct {
+	ByID     map[string]string // epsgCode default of the infraAttributes by their id
+	File     string            // the default all infraAttributes of the file agree on, "" if there is none or they differ
+	Fallback string            // the epsg of the import request, "" if there is none
+	differ   bool
+}
+
+// NewCRSDefaults creates CRSDefaults without any infraAttributes yet.
+func NewCRSDefaults(fallback string) *CRSDefaults {
+	return &CRSDefaults{ByID: map[string]string{}, Fallback: fallback}
+}
+
+// Add collects the <epsgCode default="..."/> of the infraAttributes in the given element.
+func (d *CRSDefaults) Add(e *etree.Element) {
+	for _, ec := range e.FindElements(".//infraAttributes/epsgCode[@default]") {
+		code := NormalizeEpsg(ec.SelectAttrValue("default", ""))
+		if id := ec.Parent().SelectAttrValue("id", ""); id != "" {
+			d.ByID[id] = code
+		}
+		d.differ = d.differ || (d.File != "" && d.File != code)
+		d.File = code
+	}
+	if d.differ {
+		d.File = ""
+	}
+}
+
+// Default returns the CRS of the file, or the fallback.
+func (d *CRSDefaults) Default() string {
+	if d.File != "" {
+		return d.File
+	}
+	return d.Fallback
+}
+
+// ForTrack returns the CRS of the coords of a track: the epsgCode default of the infraAttributes
+// it refers to with <infraAttrGroupRefs />, else the one of the file, else the fallback.
+func (d *CRSDefaults) ForTrack(t *etree.Element) string {
+	for _, r := range t.FindElements("infraAttrGroupRefs/infraAttrGroupRef") {
+		if code, ok := d.ByID[r.SelectAttrValue("ref", "")]; ok {
+			return code
+		}
+	}
+	return d.Default()
+}
+
+// Transforms a single "x y" or "x y z" coord string, in the axis order of the source CRS, to wgs84 longitude, latitude
+// and the untouched height
+func transformCoord(transformer *crs.Transformer, c string) ([]float64, error) {
+	p, err := parseCoord(c, transformer.From.NorthEast())
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if p[0], p[1], err = transformer.Transform(p[0], p[1]); err != nil {
+		return nil, fmt.Errorf("cannot transform coord %q: %v", c, err)
 	}
+	return p, nil
+}
 
-	c := e.SelectAttrValue("coord", Unknown)
-	if c != Unknown {
-		if epsg != "4326" {
-			s := strings.Split(c, " ")
-			x, _ := strconv.ParseFloat(s[0], 64)
-			y, _ := strconv.ParseFloat(s[1], 64)
-			xt, yt, _ := proj.Transform2(projection, wgs84, x, y)
-			xts := strconv.FormatFloat(proj.RadToDeg(xt), 'f', 6, 64)
-			yts := strconv.FormatFloat(proj.RadToDeg(yt), 'f', 6, 64)
-			wkt := "POINT(" + xts + " " + yts + ")"
+// parseCoord parses a "x y" or "x y z" coord, swapping the first two numbers if the CRS puts the latitude or northing first
+func parseCoord(c string, northEast bool) ([]float64, error) {
+	s := strings.Fields(c)
+	if len(s) != 2 && len(s) != 3 {
+		return nil, fmt.Errorf("invalid coord %q, expected two or three numbers", c)
+	}
+	p := make([]float64, len(s))
+	for i := range s {
+		v, err := strconv.ParseFloat(s[i], 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid coord %q, %q is not a number", c, s[i])
+		}
+		p[i] = v
+	}
+	if northEast {
+		p[0], p[1] = p[1], p[0]
+	}
+	return p, nil
+}
 
-			return wkt
+// wktPrecision is the number of decimals written by the transformer, coords that aren't transformed are kept as they are
+func wktPrecision(transformer *crs.Transformer) int {
+	if transformer.Identity() {
+		return -1
+	}
+	return 6
+}
 
-		} else {
-			wkt := "POINT(" + c + ")"
+// toWKT writes a POINT or LINESTRING of "x y" or "x y z" coords, heights are written as they are
+func toWKT(kind string, coords [][]float64, precision int) string {
+	if len(coords[0]) == 3 {
+		kind += " Z "
+	}
+	return kind + "(" + strings.Join(formatCoords(coords, precision), ",") + ")"
+}
 
-			return wkt
+// formatCoords writes the coords with the given number of decimals for x and y
+func formatCoords(coords [][]float64, precision int) []string {
+	formatted := make([]string, len(coords))
+	for i, p := range coords {
+		s := make([]string, len(p))
+		for j, v := range p {
+			if j < 2 {
+				s[j] = strconv.FormatFloat(v, 'f', precision, 64)
+			} else {
+				s[j] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
 		}
+		formatted[i] = strings.Join(s, " ")
+	}
+	return formatted
+}
+
+// ParseWKT splits a POINT or LINESTRING, as created by the import, into its "x y" or "x y z" coords.
+// The kind is returned without the Z.
+func ParseWKT(wkt string) (string, []string, error) {
+	open := strings.Index(wkt, "(")
+	if open < 0 || !strings.HasSuffix(wkt, ")") {
+		return "", nil, fmt.Errorf("invalid WKT %q", wkt)
+	}
+	kind := strings.TrimSuffix(strings.TrimSpace(wkt[:open]), " Z")
+	if kind != "POINT" && kind != "LINESTRING" {
+		return "", nil, fmt.Errorf("unsupported WKT geometry %q", kind)
 	}
 
-	return c
+	var coords []string
+	for _, c := range strings.Split(wkt[open+1:len(wkt)-1], ",") {
+		coords = append(coords, strings.TrimSpace(c))
+	}
+	return kind, coords, nil
+}
+
+// FromWGS84 transforms wgs84 "x y" or "x y z" coords to the given CRS, written in its axis order.
+// Coords of projected CRSs are written in metres with millimetre precision, geographic ones in degrees.
+func FromWGS84(coords []string, epsg string) ([]string, error) {
+	transformer, err := crs.NewTransformer("4326", epsg)
+	if err != nil {
+		return nil, &CRSError{Epsg: epsg, Err: err}
+	}
+	precision := 3
+	if transformer.Identity() {
+		precision = -1
+	} else if transformer.To.Geographic() {
+		precision = 6
+	}
+
+	transformed := make([][]float64, len(coords))
+	for i, c := range coords {
+		p, err := parseCoord(c, false)
+		if err != nil {
+			return nil, err
+		}
+		if p[0], p[1], err = transformer.Transform(p[0], p[1]); err != nil {
+			return nil, fmt.Errorf("cannot transform coord %q to epsg:%s: %v", c, epsg, err)
+		}
+		if transformer.To.NorthEast() {
+			p[0], p[1] = p[1], p[0]
+		}
+		transformed[i] = p
+	}
+	return formatCoords(transformed, precision), nil
 }
 
 // ElementsUtils - XML extraction utilities.
@@ -175,7 +400,7 @@ func toWKTPoint(e *etree.Element, epsg string) string {
 type ElementsUtils struct{}
 
 // Utility function, removes all unknown key:value pairs from the given map object
-func removeUnknown(m neoism.Props) {
+func removeUnknown(m store.Props) {
 	for k, v := range m {
 		if v == Unknown {
 			delete(m, k)
@@ -184,52 +409,229 @@ func removeUnknown(m neoism.Props) {
 }
 
 // Utility function to extract attributes
-func extractAttributes(e *etree.Element) neoism.Props {
-	props := neoism.Props{}
+func extractAttributes(e *etree.Element) store.Props {
+	props := store.Props{}
 
 	for _, p := range e.Attr {
-		props[p.Key] = p.Value
+		props[p.FullKey()] = p.Value // keeps the prefix, e.g. xml:lang
+	}
+
+	return props
+}
+
+// Utility function to keep all child elements with other tags than the known ones as XML
+func extractFragments(props store.Props, e *etree.Element, known ...string) error {
+	return extractFragmentsAs(props, Fragments, e, known...)
+}
+
+// extractFragmentsAs keeps the child elements with other tags than the known ones as XML in the given property
+func extractFragmentsAs(props store.Props, key string, e *etree.Element, known ...string) error {
+	return keepFragments(props, key, e, func(child *etree.Element) bool {
+		for _, tag := range known {
+			if child.Tag == tag {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// keepFragments keeps the child elements that aren't known as XML in the given property
+func keepFragments(props store.Props, key string, e *etree.Element, known func(*etree.Element) bool) error {
+	var fragments bytes.Buffer
+	for _, child := range e.ChildElements() {
+		if known(child) {
+			continue
+		}
+		doc := etree.NewDocument()
+		doc.SetRoot(child.Copy())
+		x, err := doc.WriteToString()
+		if err != nil {
+			return elementError(child, err)
+		}
+		fragments.WriteString(x)
+	}
+	if fragments.Len() > 0 {
+		props[key] = fragments.String()
 	}
+	return nil
+}
+
+// containerTags returns the tags of the containers with a field of their own in the given struct, e.g. signals for Signals
+func containerTags(v interface{}) []string {
+	t := reflect.TypeOf(v)
+	tags := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		n := t.Field(i).Name
+		tags = append(tags, strings.ToLower(n[:1])+n[1:])
+	}
+	return tags
+}
+
+// extractContainerFragments keeps the child elements the converter doesn't know of the containers of a track,
+// see ContainerFragments. Those are unknown containers, e.g. from railML extensions, and the elements of known
+// containers that aren't one of the elements the container is made for, like <nameGroup /> in <signals />.
+func extractContainerFragments(track store.Props, t *etree.Element) error {
+	containers := map[string][]string{ // container -> the containers it is made of
+		"trackTopology": {"trackBegin", "trackEnd", "mileageChanges", "connections", "crossSections"},
+		"trackElements": append(containerTags(TrackElements{}), "geoMappings"),
+		"ocsElements":   {"signals", "trainDetectionElements", "balises", "trainProtectionElements", "stopPosts", "derailers", "trainRadioChanges"},
+	}
+	elements := map[string][]string{ // container -> the elements it is made of, if they aren't the singular of it
+		"connections":            {"switch", "crossing"},
+		"trainDetectionElements": {"trainDetector", "trackCircuitBorder"},
+		"trackBegin":             nil, // see trackEdge
+		"trackEnd":               nil,
+		"geoMappings":            nil,
+	}
+	for tag, known := range containers {
+		c := t.SelectElement(tag)
+		if c == nil {
+			continue
+		}
+		if err := extractFragmentsAs(track, ContainerFragments(tag), c, known...); err != nil {
+			return err
+		}
+		for _, child := range c.ChildElements() {
+			k := false
+			for _, ct := range known {
+				k = k || child.Tag == ct
+			}
+			es, ok := elements[child.Tag]
+			if !k || ok && es == nil {
+				continue
+			}
+			if !ok {
+				es = []string{elementTag(child.Tag)}
+			}
+			if err := extractFragmentsAs(track, ContainerFragments(child.Tag), child, es...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// geoMappingIds returns the ids of the <geoMapping />s of the track, see GeoMappingIds
+func geoMappingIds(t *etree.Element) string {
+	var ids []string
+	for _, g := range t.FindElements("trackElements/geoMappings/geoMapping") {
+		if g.SelectElement("geoCoord") == nil || g.SelectElement("geoCoord").SelectAttr("coord") == nil {
+			continue // not part of the geometry
+		}
+		ids = append(ids, g.SelectAttrValue("id", ""))
+	}
+	return strings.Join(ids, " ")
+}
+
+// Utility function to extract an element with its attributes and all its descendants
+func extractNested(e *etree.Element) Nested {
+	n := Nested{Label: strings.Title(e.Tag), Properties: extractAttributes(e)}
+
+	for _, child := range e.ChildElements() {
+		n.Children = append(n.Children, extractNested(child))
+	}
+
+	return n
+}
 
+// GetNamespaces returns the namespace prefixes declared on the given element, e.g. the root with railML extensions
+func (eu *ElementsUtils) GetNamespaces(e *etree.Element) store.Props {
+	ns := store.Props{}
+
+	for _, a := range e.Attr {
+		if a.Space == "xmlns" {
+			ns[a.FullKey()] = a.Value
+		}
+	}
+
+	return ns
+}
+
+// GetRootAttributes returns the railML version, the namespace and the schema location of the root of the file,
+// so that a line is exported with the ones it was imported with
+func (eu *ElementsUtils) GetRootAttributes(e *etree.Element) store.Props {
+	props := store.Props{}
+
+	for _, a := range e.Attr {
+		switch {
+		case a.Space == "" && a.Key == "version":
+			props["railmlVersion"] = a.Value
+		case a.Space == "" && a.Key == "xmlns":
+			props["xmlns"] = a.Value
+		case a.Space != "" && a.Key == "schemaLocation":
+			props["xsi:schemaLocation"] = a.Value
+		}
+	}
+
+	return props
+}
+
+// GetInfrastructureAttributes returns the attributes of the <infrastructure /> the element is in,
+// with the Infrastructure prefix
+func (eu *ElementsUtils) GetInfrastructureAttributes(e *etree.Element) store.Props {
+	props := store.Props{}
+	for a := e; a != nil; a = a.Parent() {
+		if a.Tag == "infrastructure" {
+			for k, v := range extractAttributes(a) {
+				props[Infrastructure+k] = v
+			}
+			break
+		}
+	}
 	return props
 }
 
+// GetMetadata returns the attributes and the child elements of the <metadata />, with the Metadata prefix
+func (eu *ElementsUtils) GetMetadata(e *etree.Element) (store.Props, error) {
+	kept := extractAttributes(e)
+	if err := extractFragments(kept, e); err != nil {
+		return nil, err
+	}
+	props := store.Props{}
+	for k, v := range kept {
+		props[Metadata+k] = v
+	}
+	return props, nil
+}
+
 // GetTrackProperties creates track properties with valid railml properties and the geometry.
 // A <track> represents one of possibly multiple tracks (= "pair of rails") that make up a line.
-func (eu *ElementsUtils) GetTrackProperties(t *etree.Element, epsg string) neoism.Props {
+func (eu *ElementsUtils) GetTrackProperties(t *etree.Element, epsg string) (store.Props, error) {
 	track := extractAttributes(t)
-	geom := toWKTLinestring(t, epsg)
+	if err := extractFragments(track, t, "trackTopology", "trackElements", "ocsElements"); err != nil {
+		return nil, err
+	}
+	if err := extractContainerFragments(track, t); err != nil {
+		return nil, err
+	}
+	geom, err := toWKTLinestring(t, epsg)
+	if err != nil {
+		return nil, err
+	}
 	if geom != Unknown {
 		track["geometry"] = geom
+		if ids := geoMappingIds(t); strings.TrimSpace(ids) != "" {
+			track[GeoMappingIds] = ids
+		}
 	}
-	return track
+	return track, nil
 }
 
 // GetTrackTopologies extracts topology elements like begin, end, connections.
 // The element <trackTopology> works as a "container element" for several topology-related elements that "can't be touched in real life".
-func (eu *ElementsUtils) GetTrackTopologies(t *etree.Element, epsg string) TrackTopologies {
+func (eu *ElementsUtils) GetTrackTopologies(t *etree.Element, epsg string) (TrackTopologies, error) {
 	tt := TrackTopologies{}
-	for _, topologies := range t.SelectElement("trackTopology").ChildElements() { // <trackBegin />, <trackEnd />, <connections />
+	topology := t.SelectElement("trackTopology")
+	if topology == nil {
+		return tt, elementErrorf(t, "missing <trackTopology>")
+	}
+	for _, topologies := range topology.ChildElements() { // <trackBegin />, <trackEnd />, <mileageChanges />, <connections />, <crossSections />
 		if (topologies.Tag == "trackBegin") || (topologies.Tag == "trackEnd") { // TRACK BEGINS AND ENDS
-			te := Edge{}
-			props := neoism.Props{}
-			for _, child := range topologies.ChildElements() { // <bufferStop />, <openEnd />, <macroscopicNode />, <connection />, <geoCoord />
-
-				if child.Tag == "geoCoord" {
-					geom := toWKTPoint(child, epsg)
-					if geom != Unknown {
-						props["geometry"] = geom
-					}
-				} else {
-					attrs := extractAttributes(child)
-					for k, v := range attrs { // maybe it should be a separate function?
-						props[k] = v
-					}
-					te.Label = strings.Title(child.Tag)
-				}
+			te, err := trackEdge(topologies, epsg)
+			if err != nil {
+				return tt, err
 			}
-			te.Properties = props
-			te.Container = extractAttributes(topologies)
 
 			if topologies.Tag == "trackBegin" {
 				tt.Begin = te
@@ -242,65 +644,129 @@ func (eu *ElementsUtils) GetTrackTopologies(t *etree.Element, epsg string) Track
 			var crossings []Crossing
 			for _, child := range topologies.ChildElements() { // <switch />, <crossing />
 				props := extractAttributes(child)
-				con := neoism.Props{}
+				if err := extractFragments(props, child, "geoCoord", "connection"); err != nil {
+					return tt, err
+				}
+				var cons []store.Props
 				for _, nested := range child.ChildElements() { // <geoCoord />, <connection />
 					if nested.Tag == "geoCoord" {
-						geom := toWKTPoint(nested, epsg)
+						geom, err := toWKTPoint(nested, epsg)
+						if err != nil {
+							return tt, err
+						}
 						if geom != Unknown {
 							props["geometry"] = geom
 						}
 					} else if nested.Tag == "connection" {
-						con = extractAttributes(nested)
+						cons = append(cons, extractAttributes(nested))
 					}
 				}
 
 				if child.Tag == "switch" {
 					sw := Switch{}
 					sw.Properties = props
-					sw.Connection = con
+					sw.Connections = cons
 					switches = append(switches, sw)
 				} else if child.Tag == "crossing" {
 					cr := Crossing{}
 					cr.Properties = props
-					cr.Connection = con
+					cr.Connections = cons
 					crossings = append(crossings, cr)
 				}
 			}
 
 			tt.Switch = switches
 			tt.Crossing = crossings
-		}
 
-		// TODO <mileageChanges /> and <CrossSections />
-		// These are not the most common tags and we don't even have objects like that in our railml files.
+		} else if topologies.Tag == "mileageChanges" || topologies.Tag == "crossSections" { // KILOMETRE JUMPS AND CROSS SECTIONS
+			ae := []store.Props{}
+			ct := strings.TrimSuffix(topologies.Tag, "s")
+			for _, child := range topologies.ChildElements() {
+				if child.Tag == ct {
+					attr, err := placedElementProperties(child, epsg)
+					if err != nil {
+						return tt, err
+					}
+					ae = append(ae, attr)
+				}
+			}
 
+			if topologies.Tag == "mileageChanges" {
+				tt.MileageChanges = ae
+			} else {
+				tt.CrossSection = ae
+			}
+		}
 	}
 
-	return tt
+	return tt, nil
+}
+
+// edgeElements are the elements a track begins or ends with, the first one there is of them is its node
+var edgeElements = []string{"connection", "bufferStop", "openEnd", "macroscopicNode"}
+
+// trackEdge extracts a <trackBegin /> or <trackEnd /> with the element it is made of, e.g. a <connection />, and its
+// <geoCoord />. Other child elements, e.g. a <macroscopicNode /> next to a <connection />, are kept as its fragments,
+// and so are the child elements of the element.
+func trackEdge(e *etree.Element, epsg string) (Edge, error) {
+	te := Edge{Properties: store.Props{}, Container: extractAttributes(e)}
+	var node *etree.Element
+	for _, tag := range edgeElements {
+		if node = e.SelectElement(tag); node != nil {
+			break
+		}
+	}
+	if node != nil {
+		te.Properties = extractAttributes(node)
+		te.Label = strings.Title(node.Tag)
+		if err := extractFragments(te.Properties, node); err != nil {
+			return te, err
+		}
+	}
+	g := e.SelectElement("geoCoord")
+	if g != nil {
+		geom, err := toWKTPoint(g, epsg)
+		if err != nil {
+			return te, err
+		}
+		if geom != Unknown {
+			te.Properties["geometry"] = geom
+		}
+	}
+	err := keepFragments(te.Container, Fragments, e, func(child *etree.Element) bool {
+		return child == node || child == g
+	})
+	return te, err
 }
 
 // GetTrackElements extracts all track elements related to the given track.
 // The element <trackElements> works as a "container element" for elements which can be (more or less) "touched in real life".
-func (eu *ElementsUtils) GetTrackElements(t *etree.Element, epsg string) TrackElements {
+func (eu *ElementsUtils) GetTrackElements(t *etree.Element, epsg string) (TrackElements, error) {
 
 	te := TrackElements{}
 
 	elements := t.SelectElement("trackElements")
 
 	if elements == nil {
-		return te
+		return te, nil
 	}
 
 	for _, element := range elements.ChildElements() {
 		capitalized := strings.Title(element.Tag)
 		if capitalized != "GeoMappings" {
 			a := reflect.ValueOf(&te).Elem().FieldByName(capitalized) // grabs an array from the existing struct
-			ae := []neoism.Props{}
+			if !a.IsValid() {
+				continue // not a railML element we know, e.g. from an extension, kept by the track
+			}
+			ae := []store.Props{}
+			ct := elementTag(element.Tag)
 			for _, child := range element.ChildElements() {
-				attr := extractAttributes(child)
-				g := child.SelectElement("geoCoord")
-				if g != nil {
-					attr["geometry"] = toWKTPoint(g, epsg)
+				if child.Tag != ct { // other children are kept by the track, see extractContainerFragments
+					continue
+				}
+				attr, err := placedElementProperties(child, epsg)
+				if err != nil {
+					return te, err
 				}
 				ae = append(ae, attr)
 			}
@@ -308,38 +774,51 @@ func (eu *ElementsUtils) GetTrackElements(t *etree.Element, epsg string) TrackEl
 			a.Set(v)                 // the value can be set as a new struct property
 		}
 	}
-	return te
+	return te, nil
 }
 
 // GetOCSElements extracts all OCS elements related to the given track.
 // The element <ocsElements> works as a "container element" for operation and control system elements.
 // It doesn't group elements.
-func (eu *ElementsUtils) GetOCSElements(t *etree.Element, epsg string) OCSElements {
+func (eu *ElementsUtils) GetOCSElements(t *etree.Element, epsg string) (OCSElements, error) {
 
 	oe := OCSElements{}
 
 	elements := t.SelectElement("ocsElements")
 
 	if elements == nil {
-		return oe
+		return oe, nil
 	}
 
 	for _, element := range elements.ChildElements() {
 		capitalized := strings.Title(element.Tag)
 		if capitalized == "TrainDetectionElements" {
-			// has two possible childs <trackCircuitBorder />  and <trainDetector />,
-			// so for now it is skipped
+			// has two possible childs <trackCircuitBorder /> and <trainDetector />,
+			// each of them goes to its own array
+			for _, child := range element.ChildElements() {
+				a := reflect.ValueOf(&oe).Elem().FieldByName(strings.Title(child.Tag) + "s")
+				if !a.IsValid() {
+					continue
+				}
+				attr, err := placedElementProperties(child, epsg)
+				if err != nil {
+					return oe, err
+				}
+				a.Set(reflect.Append(a, reflect.ValueOf(attr)))
+			}
 			continue
 		}
 		a := reflect.ValueOf(&oe).Elem().FieldByName(capitalized) // grabs an array from the existing struct
-		ae := []neoism.Props{}
-		ct := strings.TrimSuffix(element.Tag, "s")
+		if !a.IsValid() {
+			continue // not a railML element we know, e.g. from an extension, kept by the track
+		}
+		ae := []store.Props{}
+		ct := elementTag(element.Tag)
 		for _, child := range element.ChildElements() {
-			if child.Tag == ct { // because there can be another child <nameGroup />
-				attr := extractAttributes(child)
-				g := child.SelectElement("geoCoord")
-				if g != nil {
-					attr["geometry"] = toWKTPoint(g, epsg)
+			if child.Tag == ct { // another child like <nameGroup /> is kept by the track, see extractContainerFragments
+				attr, err := placedElementProperties(child, epsg)
+				if err != nil {
+					return oe, err
 				}
 				ae = append(ae, attr)
 			}
@@ -349,40 +828,115 @@ func (eu *ElementsUtils) GetOCSElements(t *etree.Element, epsg string) OCSElemen
 
 	}
 
-	return oe
+	return oe, nil
 }
 
-func (eu *ElementsUtils) GetInfraAttributes(element *etree.Element) InfraAttributes {
-	ia := InfraAttributes{
-		ID: element.SelectAttrValue("id", "Unknown"),
+// placedElementProperties extracts attributes of a single element placed on the track together with its geometry
+// and its other child elements
+func placedElementProperties(e *etree.Element, epsg string) (store.Props, error) {
+	attr := extractAttributes(e)
+	if err := extractFragments(attr, e, "geoCoord"); err != nil {
+		return nil, err
 	}
+	g := e.SelectElement("geoCoord")
+	if g != nil {
+		geom, err := toWKTPoint(g, epsg)
+		if err != nil {
+			return nil, err
+		}
+		if geom != Unknown {
+			attr["geometry"] = geom
+		}
+	}
+	return attr, nil
+}
+
+// GetInfraAttributes extracts a single <infraAttributes /> element with all its attribute groups.
+func (eu *ElementsUtils) GetInfraAttributes(element *etree.Element) (InfraAttributes, error) {
+	ia := InfraAttributes{}
 
 	if element == nil {
-		return ia
+		return ia, nil
+	}
+	ia.ID = element.SelectAttrValue("id", "Unknown")
+
+	unknown := store.Props{}
+	if err := keepFragments(unknown, Fragments, element, func(ag *etree.Element) bool {
+		f, ok := reflect.TypeOf(ia).FieldByName(strings.Title(ag.Tag))
+		return ok && f.Type.Kind() != reflect.String
+	}); err != nil {
+		return ia, err
 	}
+	ia.Fragments, _ = unknown[Fragments].(string)
+
 	for _, ag := range element.ChildElements() {
 		capitalized := strings.Title(ag.Tag)
 		if capitalized == "GeneralInfraAttributes" {
 			// nested as a fuckin hell,
-			// so for now it is skipped
+			// so the whole sub-tree is extracted as it is
+			n := extractNested(ag)
+			ia.GeneralInfraAttributes = &n
 			continue
 		}
+		p := reflect.ValueOf(&ia).Elem().FieldByName(capitalized)
+		if !p.IsValid() || p.Kind() == reflect.String {
+			continue // not a railML element we know, e.g. from an extension, kept as a fragment
+		}
 		if capitalized == "Speeds" {
-			a := reflect.ValueOf(&ia).Elem().FieldByName(capitalized)
-			ae := []neoism.Props{}
+			ae := []store.Props{}
 			for _, child := range ag.ChildElements() {
+				if child.Tag != "speed" {
+					continue
+				}
 				attr := extractAttributes(child)
+				if err := extractFragments(attr, child); err != nil {
+					return ia, err
+				}
 				ae = append(ae, attr)
 			}
 			v := reflect.ValueOf(ae)
-			a.Set(v)
+			p.Set(v)
+			speeds := store.Props{}
+			if err := extractFragmentsAs(speeds, Fragments, ag, "speed"); err != nil {
+				return ia, err
+			}
+			ia.SpeedsFragments, _ = speeds[Fragments].(string)
 			continue
 		}
-		p := reflect.ValueOf(&ia).Elem().FieldByName(capitalized)
 		at := extractAttributes(ag)
+		if err := extractFragments(at, ag); err != nil {
+			return ia, err
+		}
 		va := reflect.ValueOf(at)
 		p.Set(va)
 
 	}
-	return ia
+	return ia, nil
+}
+
+// GetOcp extracts a single <ocp /> element with its geometry and its operational and service properties.
+// Other children like <propEquipment /> or <designator /> are kept as fragments.
+func (eu *ElementsUtils) GetOcp(o *etree.Element, epsg string) (Ocp, error) {
+	ocp := Ocp{Properties: extractAttributes(o)}
+	if err := extractFragments(ocp.Properties, o, "geoCoord", "propOperational", "propService"); err != nil {
+		return ocp, err
+	}
+
+	for _, child := range o.ChildElements() {
+		switch child.Tag {
+		case "geoCoord":
+			geom, err := toWKTPoint(child, epsg)
+			if err != nil {
+				return ocp, err
+			}
+			if geom != Unknown {
+				ocp.Properties["geometry"] = geom
+			}
+		case "propOperational":
+			ocp.PropOperational = extractAttributes(child)
+		case "propService":
+			ocp.PropService = extractAttributes(child)
+		}
+	}
+	return ocp, nil
 }
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+)
+
+// element parses the XML of a single element
+func element(t *testing.T, x string) *etree.Element {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(x); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Root()
+}
+
+func TestParseCoord(t *testing.T) {
+	tests := []struct {
+		coord     string
+		northEast bool
+		want      []float64
+	}{
+		{"19.5 50.25", false, []float64{19.5, 50.25}},
+		{"50.25 19.5", true, []float64{19.5, 50.25}}, // latitude first, as in EPSG:4326
+		{"  19.5\t50.25 ", false, []float64{19.5, 50.25}},
+		{"19.5 50.25 231.4", false, []float64{19.5, 50.25, 231.4}},
+		{"50.25 19.5 231.4", true, []float64{19.5, 50.25, 231.4}}, // the height stays last
+		{"5.6e5 2.4e5", false, []float64{560000, 240000}},
+	}
+	for _, tt := range tests {
+		got, err := parseCoord(tt.coord, tt.northEast)
+		if err != nil {
+			t.Errorf("%q: %v", tt.coord, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q, north east %v: %v, want %v", tt.coord, tt.northEast, got, tt.want)
+		}
+	}
+}
+
+func TestParseCoordErrors(t *testing.T) {
+	tests := []struct {
+		coord string
+		want  string
+	}{
+		{"", "expected two or three numbers"},
+		{"19.5", "expected two or three numbers"},
+		{"19.5 50.25 231.4 1", "expected two or three numbers"},
+		{"19,5 50,25", `"19,5" is not a number`},
+		{"19.5 north", `"north" is not a number`},
+		{"NaN 50.25", `"NaN" is not a number`},
+		{"19.5 +Inf", `"+Inf" is not a number`},
+	}
+	for _, tt := range tests {
+		_, err := parseCoord(tt.coord, false)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: %v, want an error with %s", tt.coord, err, tt.want)
+		}
+	}
+}
+
+func TestToWKT(t *testing.T) {
+	tests := []struct {
+		geoCoord string
+		epsg     string
+		want     string
+	}{
+		// coords in WGS 84 aren't transformed and kept as they are, in longitude, latitude order
+		{`<geoCoord coord="50.25 19.5"/>`, "4326", "POINT(19.5 50.25)"},
+		{`<geoCoord coord="50.25 19.5 231.4"/>`, "4326", "POINT Z (19.5 50.25 231.4)"},
+		{`<geoCoord coord="19.5 50.25" epsgCode="3857"/>`, "4326", "POINT(0.000175 0.000451)"},
+		// PUWG 1992 is northing, easting: the central meridian 19°E is at the easting 500000, where the northing is
+		// 0.9993 times the meridian arc less 5300000 m
+		{`<geoCoord coord="300000 500000 120"/>`, "2180", "POINT Z (19.000000 50.567052 120)"},
+		{`<geoCoord/>`, "4326", Unknown},
+	}
+	for _, tt := range tests {
+		got, err := toWKTPoint(element(t, tt.geoCoord), tt.epsg)
+		if err != nil {
+			t.Errorf("%s: %v", tt.geoCoord, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s in epsg:%s: %s, want %s", tt.geoCoord, tt.epsg, got, tt.want)
+		}
+	}
+
+	track := func(coords ...string) *etree.Element {
+		x := `<track id="tr1"><trackElements><geoMappings>`
+		for _, c := range coords {
+			x += `<geoMapping><geoCoord coord="` + c + `"/></geoMapping>`
+		}
+		return element(t, x+`</geoMappings></trackElements></track>`)
+	}
+	if got, err := toWKTLinestring(track("50 19 200", "50.1 19.1 210.5"), "4326"); err != nil || got != "LINESTRING Z (19 50 200,19.1 50.1 210.5)" {
+		t.Errorf("a track with heights: %s, %v", got, err)
+	}
+	if _, err := toWKTLinestring(track("50 19 200", "50.1 19.1"), "4326"); err == nil || !strings.Contains(err.Error(), "has 2 dimensions, the previous coords of the track have 3") {
+		t.Errorf("a track that mixes dimensions: %v", err)
+	}
+	_, err := toWKTLinestring(track("50 19", "50.1;19.1"), "4326")
+	if _, ok := err.(*ElementError); !ok || !strings.Contains(err.Error(), `invalid coord "50.1;19.1"`) {
+		t.Errorf("a track with a malformed coord: %v, want an ElementError", err)
+	}
+}
+
+func TestCRSDefaults(t *testing.T) {
+	groups := func(codes ...string) *etree.Element {
+		x := `<infraAttrGroups>`
+		for i, c := range codes {
+			x += `<infraAttributes id="ia` + string(rune('1'+i)) + `"><epsgCode default="` + c + `"/></infraAttributes>`
+		}
+		return element(t, x+`</infraAttrGroups>`)
+	}
+	track := func(refs ...string) *etree.Element {
+		x := `<track id="tr1"><infraAttrGroupRefs>`
+		for _, r := range refs {
+			x += `<infraAttrGroupRef ref="` + r + `"/>`
+		}
+		return element(t, x+`</infraAttrGroupRefs></track>`)
+	}
+
+	tests := []struct {
+		name     string
+		groups   []string // epsgCode defaults of the infraAttributes ia1, ia2, ...
+		fallback string
+		track    *etree.Element
+		want     string
+	}{
+		{"the infraAttributes the track refers to", []string{"4326", "urn:ogc:def:crs:EPSG::2180"}, "3857", track("ia2"), "2180"},
+		{"the first one it refers to that has a default", []string{"EPSG:2180"}, "3857", track("ia9", "ia1"), "2180"},
+		{"the one of the file", []string{"2180"}, "3857", track(), "2180"},
+		{"the one the file agrees on", []string{"2180", "2180"}, "3857", track("ia9"), "2180"},
+		{"the form if the infraAttributes differ", []string{"4326", "2180"}, "3857", track(), "3857"},
+		{"the form if the file has none", nil, "3857", track("ia1"), "3857"},
+		{"none at all", nil, "", track(), ""},
+	}
+	for _, tt := range tests {
+		d := NewCRSDefaults(tt.fallback)
+		if len(tt.groups) > 0 {
+			d.Add(groups(tt.groups...))
+		}
+		if got := d.ForTrack(tt.track); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCoordTransformer(t *testing.T) {
+	tests := []struct {
+		geoCoord string
+		epsg     string // the default of the track
+		want     string
+	}{
+		// the epsgCode of the geoCoord comes first
+		{`<geoCoord coord="300000 500000" epsgCode="2180"/>`, "4326", "2180"},
+		{`<geoCoord coord="300000 500000" epsgCode="EPSG:2180"/>`, "", "2180"},
+		{`<geoCoord coord="50 19"/>`, "4258", "4258"},
+	}
+	for _, tt := range tests {
+		tr, err := coordTransformer(element(t, tt.geoCoord), tt.epsg)
+		if err != nil {
+			t.Errorf("%s: %v", tt.geoCoord, err)
+			continue
+		}
+		if tr.From.Epsg != tt.want || tr.To.Epsg != "4326" {
+			t.Errorf("%s with the default %q: from epsg:%s to epsg:%s, want from epsg:%s", tt.geoCoord, tt.epsg, tr.From.Epsg, tr.To.Epsg, tt.want)
+		}
+	}
+
+	_, err := coordTransformer(element(t, `<geoCoord coord="50 19"/>`), "")
+	if err == nil || !strings.Contains(err.Error(), `the CRS of coord "50 19" is unknown`) {
+		t.Errorf("a geoCoord without any CRS: %v", err)
+	}
+	_, err = coordTransformer(element(t, `<geoCoord coord="50 19" epsgCode="99999"/>`), "4326")
+	if _, ok := err.(*ElementError); !ok || !strings.Contains(err.Error(), "unsupported EPSG code 99999") {
+		t.Errorf("a geoCoord with an unsupported CRS: %v, want an ElementError", err)
+	}
+}